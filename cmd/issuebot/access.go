@@ -0,0 +1,191 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// permissionRanks orders the permissions GitHub reports for a user on a
+// repository.
+var permissionRanks = map[string]int{
+	"none":  0,
+	"read":  1,
+	"write": 2,
+	"admin": 3,
+}
+
+// mayOverride reports whether the user with the given login may override the
+// check, with an override keyword in a commit message, a slash command, an
+// override label, or the "Create stub" button, according to the repository's
+// overrideAccess setting. If it is unset, users with write access may, unless
+// the repository allows external overrides.
+func (p pullRequest) mayOverride(ctx context.Context, cli *github.Client, login string) (bool, error) {
+	oa := p.cfg.overrideAccess()
+	if oa == nil && p.cfg.externalOverrides() {
+		return true, nil
+	}
+	return p.hasAccess(ctx, cli, login, oa.permission(), oa.teams())
+}
+
+// hasAccess reports whether the user with the given login has at least the
+// given permission on the repository, or is an active member of one of the
+// given teams, named as "org/team".
+func (p pullRequest) hasAccess(ctx context.Context, cli *github.Client, login, permission string, teams []string) (bool, error) {
+	if login == "" {
+		return false, nil // not a known GitHub user
+	}
+	owner, repo := p.repo.GetOwner().GetLogin(), p.repo.GetName()
+	perm, resp, err := retryCall(ctx, "GetPermissionLevel", func(ctx context.Context) (*github.RepositoryPermissionLevel, *github.Response, error) {
+		return cli.Repositories.GetPermissionLevel(ctx, owner, repo, login)
+	})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		// Not a collaborator.
+	} else if err != nil {
+		return false, fmt.Errorf("get permission of %s: %w", login, err)
+	} else if permissionRanks[perm.GetPermission()] >= permissionRanks[permission] {
+		return true, nil
+	}
+	for _, team := range teams {
+		org, slug, _ := strings.Cut(team, "/")
+		m, resp, err := retryCall(ctx, "GetTeamMembership", func(ctx context.Context) (*github.Membership, *github.Response, error) {
+			return cli.Teams.GetTeamMembershipBySlug(ctx, org, slug, login)
+		})
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		} else if err != nil {
+			return false, fmt.Errorf("get membership of %s in %s: %w", login, team, err)
+		} else if m.GetState() == "active" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// A deniedOverride records an override that was ignored because the user who
+// gave it may not override the check.
+type deniedOverride struct {
+	user     string // login of the user
+	override string // the override keyword or command
+}
+
+// overrideDeniedMarker is appended to the comment explaining a denied
+// override, containing a %s for the user and a %s for the override, so that
+// the same explanation is not posted twice.
+const overrideDeniedMarker = "\n\n<!-- issuebot:override-denied @%s %s -->"
+
+// postOverrideDenied adds a comment to the PR thread explaining that the
+// override d was ignored, and who may use it, unless it is already there.
+func (p pullRequest) postOverrideDenied(ctx context.Context, cli *github.Client, d deniedOverride) error {
+	owner, repoName, prNumber := p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber()
+	marker := fmt.Sprintf(overrideDeniedMarker, d.user, d.override)
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := retryCall(ctx, "ListComments", func(ctx context.Context) ([]*github.IssueComment, *github.Response, error) {
+			return cli.Issues.ListComments(ctx, owner, repoName, prNumber, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("list comments: %w", err)
+		}
+		for _, comment := range comments {
+			if strings.HasSuffix(comment.GetBody(), marker) {
+				return nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if *shadowMode {
+		p.logf("shadow: would explain ignored override %s by @%s", d.override, d.user)
+		return nil
+	}
+	data := p.data()
+	oa := p.cfg.overrideAccess()
+	data.User, data.Override = d.user, d.override
+	data.Permission, data.Teams = oa.permission(), oa.teams()
+	body, err := p.render(overrideDeniedTemplate, data)
+	if err != nil {
+		return err
+	}
+	body += marker
+	if _, _, err := createCall(ctx, "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+		return cli.Issues.CreateComment(ctx, owner, repoName, prNumber, &github.IssueComment{
+			Body: github.Ptr(body),
+		})
+	}, findComment(cli, owner, repoName, prNumber, body)); err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+	return nil
+}
+
+// checkOverrideLabels returns the disposition given by the override labels on
+// the PR (see overrideLabels), counting only those added by users who may
+// override the check, or prFailed if there are none. As with keywords, skip
+// takes precedence over accept. It also returns the first label that was
+// ignored, if any.
+func (p pullRequest) checkOverrideLabels(ctx context.Context, cli *github.Client) (pullRequestStatus, deniedOverride, error) {
+	status, denied := prFailed, deniedOverride{}
+	var labels []string
+	for _, l := range p.pr.Labels {
+		if p.cfg.overrideLabel(l.GetName()) != "" {
+			labels = append(labels, l.GetName())
+		}
+	}
+	if len(labels) == 0 {
+		return status, denied, nil
+	}
+
+	// Find who last added each label.
+	adders := make(map[string]string) // :: label → login
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		events, resp, err := retryCall(ctx, "ListIssueEvents", func(ctx context.Context) ([]*github.IssueEvent, *github.Response, error) {
+			return cli.Issues.ListIssueEvents(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), opts)
+		})
+		if err != nil {
+			return prFailed, denied, fmt.Errorf("list events: %w", err)
+		}
+		for _, e := range events {
+			if e.GetEvent() == "labeled" {
+				adders[e.GetLabel().GetName()] = e.GetActor().GetLogin()
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	for _, label := range labels {
+		login := adders[label]
+		ok, err := p.mayOverride(ctx, cli, login)
+		if err != nil {
+			return prFailed, denied, err
+		} else if !ok {
+			p.logf("ignoring override label %q added by @%s, who may not use it", label, login)
+			if denied.user == "" && login != "" {
+				denied = deniedOverride{user: login, override: "the " + label + " label"}
+			}
+			continue
+		}
+		disp := prCleanup
+		if p.cfg.overrideLabel(label) == overrideSkip {
+			disp = prSkipped
+		}
+		if status == prFailed || disp < status {
+			status = disp
+		}
+		p.logf("accept: override label %q added by @%s", label, login)
+	}
+	return status, denied, nil
+}
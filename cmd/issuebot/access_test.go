@@ -0,0 +1,200 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestMayOverride(t *testing.T) {
+	permissions := map[string]string{"alice": "admin", "bob": "write", "carol": "read"}
+	var posted []string
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case strings.HasPrefix(path, "/repos/o/r/collaborators/"):
+			user := strings.TrimSuffix(strings.TrimPrefix(path, "/repos/o/r/collaborators/"), "/permission")
+			perm, ok := permissions[user]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(github.RepositoryPermissionLevel{Permission: github.Ptr(perm)})
+		case path == "/orgs/o/members/erin":
+			w.WriteHeader(http.StatusNoContent)
+		case path == "/orgs/o/teams/triage/memberships/carol":
+			w.Write([]byte(`{"state": "active"}`))
+		case path == "/repos/o/r/issues/1/comments" && r.Method == "POST":
+			var c github.IssueComment
+			json.NewDecoder(r.Body).Decode(&c)
+			posted = append(posted, c.GetBody())
+			json.NewEncoder(w).Encode(c)
+		case path == "/repos/o/r/issues/1/comments":
+			var comments []*github.IssueComment
+			for _, body := range posted {
+				comments = append(comments, &github.IssueComment{Body: github.Ptr(body)})
+			}
+			json.NewEncoder(w).Encode(comments)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	newPR := func(config string) pullRequest {
+		cfg, err := parseRepoConfig([]byte(config))
+		if err != nil {
+			t.Fatalf("parseRepoConfig(%q): unexpected error: %v", config, err)
+		}
+		return pullRequest{
+			repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o"), Type: github.Ptr("Organization")}, FullName: github.Ptr("o/r")},
+			pr:   &github.PullRequest{Number: github.Ptr(1), User: &github.User{Login: github.Ptr("dave")}, AuthorAssociation: github.Ptr("CONTRIBUTOR")},
+			cfg:  cfg,
+		}
+	}
+	tests := []struct {
+		config string
+		login  string
+		want   bool
+	}{
+		{"", "alice", true},  // an admin
+		{"", "bob", true},    // a writer
+		{"", "carol", false}, // a reader
+		{"", "dave", false},  // an outsider
+		{"", "erin", false},  // an organization member without access
+		{"", "", false},
+		{"externalOverrides: true", "dave", true},
+		{"overrideAccess: {}", "alice", true},
+		{"overrideAccess: {}", "bob", true},
+		{"overrideAccess: {}", "carol", false},
+		{"overrideAccess: {}", "dave", false},
+		{"overrideAccess: {}", "", false},
+		{"overrideAccess: {permission: admin}", "bob", false},
+		{"overrideAccess: {permission: read}", "carol", true},
+		{"overrideAccess: {teams: [o/triage]}", "carol", true},
+	}
+	for _, tc := range tests {
+		got, err := newPR(tc.config).mayOverride(t.Context(), cli, tc.login)
+		if err != nil {
+			t.Errorf("mayOverride(%q, %q): unexpected error: %v", tc.config, tc.login, err)
+		} else if got != tc.want {
+			t.Errorf("mayOverride(%q, %q): got %v, want %v", tc.config, tc.login, got, tc.want)
+		}
+	}
+
+	// Slash commands follow the same rules, except that the PR's author may
+	// ask for a recheck.
+	p := newPR("overrideAccess: {teams: [o/triage]}")
+	for _, tc := range []struct {
+		login string
+		cmds  []command
+		want  bool
+	}{
+		{"dave", []command{{name: cmdRecheck}}, true},
+		{"dave", []command{{name: cmdSkip, arg: "typo"}}, false},
+		{"dave", []command{{name: cmdRecheck}, {name: cmdStub}}, false},
+		{"erin", []command{{name: cmdRecheck}}, false},
+		{"carol", []command{{name: cmdStub}}, true},
+	} {
+		if ok, err := p.mayCommand(t.Context(), cli, tc.login, tc.cmds); ok != tc.want || err != nil {
+			t.Errorf("mayCommand(%s, %v): got %v, %v; want %v, nil", tc.login, tc.cmds, ok, err, tc.want)
+		}
+	}
+
+	// A denied override is explained once.
+	for range 2 {
+		if err := p.postOverrideDenied(t.Context(), cli, deniedOverride{user: "dave", override: "skip-issuebot"}); err != nil {
+			t.Fatalf("postOverrideDenied: unexpected error: %v", err)
+		}
+	}
+	if len(posted) != 1 {
+		t.Fatalf("postOverrideDenied: posted %d comments, want 1", len(posted))
+	}
+	if want := "people with write access to this repository, or members of @o/triage"; !strings.Contains(posted[0], want) {
+		t.Errorf("postOverrideDenied: got %q, want it to contain %q", posted[0], want)
+	}
+
+	// Without overrideAccess, the explanation names the default permission.
+	posted = nil
+	if err := newPR("").postOverrideDenied(t.Context(), cli, deniedOverride{user: "dave", override: "skip-issuebot"}); err != nil {
+		t.Fatalf("postOverrideDenied: unexpected error: %v", err)
+	}
+	if want := "people with write access to this repository."; len(posted) != 1 || !strings.Contains(posted[0], want) {
+		t.Errorf("postOverrideDenied: got %q, want a comment containing %q", posted, want)
+	}
+
+	// Invalid settings are rejected.
+	for _, config := range []string{"overrideAccess: {permission: maintain}", "overrideAccess: {teams: [triage]}"} {
+		if _, err := parseRepoConfig([]byte(config)); err == nil {
+			t.Errorf("parseRepoConfig(%q): got nil error", config)
+		}
+	}
+}
+
+func TestCheckOverrideLabels(t *testing.T) {
+	var events []*github.IssueEvent
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; {
+		case path == "/repos/o/r/issues/1/events":
+			json.NewEncoder(w).Encode(events)
+		case path == "/repos/o/r/collaborators/alice/permission":
+			json.NewEncoder(w).Encode(github.RepositoryPermissionLevel{Permission: github.Ptr("write")})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	cfg, err := parseRepoConfig([]byte("overrideLabels: {no-issue: accept, needs-stub: skip}\noverrideAccess: {}"))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	labeled := func(login, label string) *github.IssueEvent {
+		return &github.IssueEvent{
+			Event: github.Ptr("labeled"),
+			Actor: &github.User{Login: github.Ptr(login)},
+			Label: &github.Label{Name: github.Ptr(label)},
+		}
+	}
+	tests := []struct {
+		name       string
+		labels     []string
+		events     []*github.IssueEvent
+		want       pullRequestStatus
+		wantDenied string
+	}{
+		{"none", nil, nil, prFailed, ""},
+		{"other label", []string{"bug"}, []*github.IssueEvent{labeled("alice", "bug")}, prFailed, ""},
+		{"accept", []string{"no-issue"}, []*github.IssueEvent{labeled("alice", "no-issue")}, prCleanup, ""},
+		{"skip wins", []string{"no-issue", "needs-stub"}, []*github.IssueEvent{labeled("alice", "no-issue"), labeled("alice", "needs-stub")}, prSkipped, ""},
+		{"outsider", []string{"no-issue"}, []*github.IssueEvent{labeled("mallory", "no-issue")}, prFailed, "mallory"},
+		{"relabeled", []string{"no-issue"}, []*github.IssueEvent{labeled("alice", "no-issue"), labeled("mallory", "no-issue")}, prFailed, "mallory"},
+	}
+	for _, tc := range tests {
+		events = tc.events
+		pr := &github.PullRequest{Number: github.Ptr(1)}
+		for _, l := range tc.labels {
+			pr.Labels = append(pr.Labels, &github.Label{Name: github.Ptr(l)})
+		}
+		p := pullRequest{
+			repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+			pr:   pr,
+			cfg:  cfg,
+		}
+		got, denied, err := p.checkOverrideLabels(t.Context(), cli)
+		if err != nil {
+			t.Errorf("checkOverrideLabels(%s): unexpected error: %v", tc.name, err)
+			continue
+		}
+		if got != tc.want || denied.user != tc.wantDenied {
+			t.Errorf("checkOverrideLabels(%s): got %v, %q; want %v, %q", tc.name, got, denied.user, tc.want, tc.wantDenied)
+		}
+	}
+
+	if _, err := parseRepoConfig([]byte("overrideLabels: {no-issue: ignore}")); err == nil {
+		t.Error("parseRepoConfig(overrideLabels: ignore): got nil error")
+	}
+}
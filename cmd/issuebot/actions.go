@@ -0,0 +1,153 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const actionsUsage = `Usage: issuebot actions
+
+Check the pull request of the GitHub Actions workflow run this is a step of,
+as the server would check it when notified by a webhook, and exit with a
+non-zero status if it fails. The result is also written to the job summary.
+
+The event is read from $GITHUB_EVENT_PATH, and must be a pull_request or
+pull_request_target event. GitHub is accessed using $GITHUB_TOKEN, which
+needs read access to contents and pull requests, and write access to issues
+and pull requests if the repository files stub issues or issuebot should
+comment on the PR.
+`
+
+// runActions implements the actions subcommand, and returns the process exit
+// code.
+func runActions(args []string) int {
+	fs := flag.NewFlagSet("actions", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), actionsUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	pr, repo, err := readActionsEvent(os.Getenv("GITHUB_EVENT_NAME"), os.Getenv("GITHUB_EVENT_PATH"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+		return 2
+	}
+	cli, err := toolClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+	passed, summary, err := checkActionsPull(ctx, cli, pr, repo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+		return 1
+	}
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		if err := appendFile(path, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "issuebot: writing job summary: %v\n", err)
+		}
+	}
+	fmt.Print(summary)
+	if !passed {
+		return 1
+	}
+	return 0
+}
+
+// readActionsEvent reads the webhook payload of the event of the given name
+// from the file at path, and returns the PR and repository it is for.
+func readActionsEvent(name, path string) (*github.PullRequest, *github.Repository, error) {
+	if path == "" {
+		return nil, nil, errors.New("$GITHUB_EVENT_PATH is not set; not running in GitHub Actions?")
+	}
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	event, err := github.ParseWebHook(name, payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse %s event: %w", name, err)
+	}
+	switch e := event.(type) {
+	case *github.PullRequestEvent:
+		return e.GetPullRequest(), e.GetRepo(), nil
+	case *github.PullRequestTargetEvent:
+		return e.GetPullRequest(), e.GetRepo(), nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported event %q, want pull_request or pull_request_target", name)
+	}
+}
+
+// checkActionsPull checks pr in repo for the actions subcommand. It reports
+// whether the check passed, and returns a summary of the result in Markdown.
+func checkActionsPull(ctx context.Context, cli *github.Client, pr *github.PullRequest, repo *github.Repository) (bool, string, error) {
+	p := pullRequest{repo: repo, pr: pr}
+	cfg, err := fetchRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return false, "", fmt.Errorf("load config: %w", err)
+	}
+	p.cfg = cfg
+	if pr.GetDraft() && cfg.skipDrafts() {
+		p.logf("skipping draft until it is ready for review")
+		return true, actionsSummary("not checked", p.statusDescription(draftStatusTemplate)), nil
+	}
+	status, err := p.evaluate(ctx, cli)
+	if err != nil {
+		return false, "", err
+	}
+	switch {
+	case status != prFailed:
+		p.logf("accept (%v)", status)
+		return true, actionsSummary(fmt.Sprintf("passed (%v)", status), ""), nil
+	case cfg.advisory():
+		p.logf("reject (advisory)")
+		if err := p.postAdvisoryComment(ctx, cli); err != nil {
+			p.logf("error adding advisory comment (continuing): %v", err)
+		}
+		return true, actionsSummary("passed (advisory)", p.statusDescription(advisoryStatusTemplate)), nil
+	default:
+		p.logf("reject")
+		return false, actionsSummary("failed", p.failureDescription()), nil
+	}
+}
+
+// actionsSummary returns a job summary in Markdown giving the result of a
+// check and an optional explanation.
+func actionsSummary(result, explanation string) string {
+	s := fmt.Sprintf("### %s: %s\n", *statusContext, result)
+	if explanation != "" {
+		s += "\n" + explanation + "\n"
+	}
+	return s
+}
+
+// appendFile appends text to the file at path, creating it if necessary.
+func appendFile(path, text string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
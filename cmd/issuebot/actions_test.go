@@ -0,0 +1,82 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunActions(t *testing.T) {
+	var message string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/o/r/pulls/1/commits":
+			fmt.Fprintf(w, `[{"sha":%q,"commit":{"message":%q}}]`, fakeSHA(message), message)
+		case r.URL.Path == "/repos/o/r/issues/1/comments":
+			fmt.Fprint(w, "[]")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	event := filepath.Join(dir, "event.json")
+	if err := os.WriteFile(event, []byte(`{
+  "action": "opened",
+  "pull_request": {"number": 1, "additions": 500, "deletions": 0, "user": {"login": "alice"}, "head": {"sha": "abc"}},
+  "repository": {"name": "r", "full_name": "o/r", "owner": {"login": "o"}}
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	summary := filepath.Join(dir, "summary.md")
+	t.Setenv("GITHUB_EVENT_NAME", "pull_request")
+	t.Setenv("GITHUB_EVENT_PATH", event)
+	t.Setenv("GITHUB_API_URL", srv.URL)
+	t.Setenv("GITHUB_STEP_SUMMARY", summary)
+	t.Setenv("GITHUB_TOKEN", "")
+
+	tests := []struct {
+		message string
+		code    int
+		want    string
+	}{
+		{"Fix the frobnicator\n\nFixes #12", 0, "passed (linked)"},
+		{"Fix the frobnicator", 1, "failed"},
+	}
+	for _, tc := range tests {
+		message = tc.message
+		os.Remove(summary)
+		if got := runActions(nil); got != tc.code {
+			t.Errorf("runActions(%q): got exit code %d, want %d", tc.message, got, tc.code)
+		}
+		got, err := os.ReadFile(summary)
+		if err != nil {
+			t.Fatalf("reading summary: %v", err)
+		}
+		if !strings.Contains(string(got), tc.want) {
+			t.Errorf("runActions(%q): got summary %q, want it to contain %q", tc.message, got, tc.want)
+		}
+	}
+}
+
+func TestReadActionsEvent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "event.json")
+	if err := os.WriteFile(path, []byte(`{"ref":"refs/heads/main"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readActionsEvent("push", path); err == nil {
+		t.Error("readActionsEvent(push): got nil error, want unsupported event")
+	}
+	if _, _, err := readActionsEvent("pull_request", ""); err == nil {
+		t.Error("readActionsEvent with no path: got nil error, want error")
+	}
+}
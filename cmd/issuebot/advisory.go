@@ -0,0 +1,66 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// advisoryCommentRE is used to recognize advisory PR comments, by the
+// default text or by advisoryCommentMarker.
+var advisoryCommentRE = regexp.MustCompile(`(?i)IssueBot here\..*This repository does not require one|<!-- issuebot:advisory -->`)
+
+// advisoryCommentMarker is appended to advisory comments, so they can be
+// recognized even when the comment template is customized.
+const advisoryCommentMarker = "\n\n<!-- issuebot:advisory -->"
+
+// postAdvisoryComment adds the advisory comment to the PR thread, unless it is
+// already there.
+func (p pullRequest) postAdvisoryComment(ctx context.Context, cli *github.Client) error {
+	owner := p.repo.GetOwner().GetLogin()
+	repoName := p.repo.GetName()
+	prNumber := p.pr.GetNumber()
+
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := retryCall(ctx, "ListComments", func(ctx context.Context) ([]*github.IssueComment, *github.Response, error) {
+			return cli.Issues.ListComments(ctx, owner, repoName, prNumber, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("list comments: %w", err)
+		}
+		for _, comment := range comments {
+			if advisoryCommentRE.MatchString(comment.GetBody()) {
+				return nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if *shadowMode {
+		p.logf("shadow: would add advisory comment")
+		return nil
+	}
+	body, err := p.render(advisoryCommentTemplate, p.data())
+	if err != nil {
+		return err
+	}
+	body += advisoryCommentMarker
+	if _, _, err := createCall(ctx, "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+		return cli.Issues.CreateComment(ctx, owner, repoName, prNumber, &github.IssueComment{
+			Body: github.Ptr(body),
+		})
+	}, findComment(cli, owner, repoName, prNumber, body)); err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+	return nil
+}
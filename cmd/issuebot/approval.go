@@ -0,0 +1,111 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// mayApprove reports whether the user with the given login may accept the PR
+// with an approving review (see approvalPhrase). If the repository sets
+// overrideAccess, that decides; otherwise the user needs write access.
+func (p pullRequest) mayApprove(ctx context.Context, cli *github.Client, login string) (bool, error) {
+	if oa := p.cfg.overrideAccess(); oa != nil {
+		return p.hasAccess(ctx, cli, login, oa.permission(), oa.Teams)
+	}
+	return p.hasAccess(ctx, cli, login, "write", nil)
+}
+
+// checkApproval returns prCleanup if a reviewer who may approve the PR
+// without an issue has approved it with the repository's approvalPhrase, and
+// prFailed otherwise. Only each reviewer's latest approval, change request,
+// or dismissal counts, so a dismissed approval no longer accepts the PR. It
+// also returns the first approval that was ignored, if any.
+func (p pullRequest) checkApproval(ctx context.Context, cli *github.Client) (pullRequestStatus, deniedOverride, error) {
+	phrase := strings.ToLower(p.cfg.approvalPhrase())
+	if phrase == "" {
+		return prFailed, deniedOverride{}, nil
+	}
+	latest := make(map[string]*github.PullRequestReview) // :: login → review
+	var reviewers []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		reviews, resp, err := retryCall(ctx, "ListReviews", func(ctx context.Context) ([]*github.PullRequestReview, *github.Response, error) {
+			return cli.PullRequests.ListReviews(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), opts)
+		})
+		if err != nil {
+			return prFailed, deniedOverride{}, fmt.Errorf("list reviews: %w", err)
+		}
+		for _, r := range reviews {
+			switch r.GetState() {
+			case "APPROVED", "CHANGES_REQUESTED", "DISMISSED":
+				login := r.GetUser().GetLogin()
+				if _, ok := latest[login]; !ok {
+					reviewers = append(reviewers, login)
+				}
+				latest[login] = r
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	var denied deniedOverride
+	for _, login := range reviewers {
+		r := latest[login]
+		if r.GetState() != "APPROVED" || !strings.Contains(strings.ToLower(r.GetBody()), phrase) {
+			continue
+		}
+		ok, err := p.mayApprove(ctx, cli, login)
+		if err != nil {
+			return prFailed, denied, err
+		} else if !ok {
+			p.logf("ignoring approval by @%s, who may not approve without an issue", login)
+			if denied.user == "" {
+				denied = deniedOverride{user: login, override: "an approval without an issue"}
+			}
+			continue
+		}
+		p.logf("accept: approved without an issue by @%s", login)
+		return prCleanup, deniedOverride{}, nil
+	}
+	return prFailed, denied, nil
+}
+
+// handleReviewEvent handles a review of a PR that may approve it without an
+// issue, or dismiss such an approval, by checking the PR again.
+func handleReviewEvent(ctx context.Context, e *github.PullRequestReviewEvent) error {
+	repo := e.GetRepo()
+	if !repoEnabled(repo.GetFullName()) {
+		return nil
+	}
+	cfg, err := loadRepoConfig(ctx, apiClient(), repo)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	} else if cfg.approvalPhrase() == "" {
+		return nil
+	}
+	pr := e.GetPullRequest()
+	if e.GetAction() == "submitted" && !strings.Contains(strings.ToLower(e.GetReview().GetBody()), strings.ToLower(cfg.approvalPhrase())) {
+		return nil // an ordinary review
+	}
+	p := pullRequest{repo: repo, pr: pr, cfg: cfg}
+	p.logf("review %s by @%s, checking again", e.GetAction(), e.GetReview().GetUser().GetLogin())
+	undebounce(pr, repo)
+	err = checkPullRequest(ctx, pr, repo)
+	if errors.Is(err, errShuttingDown) {
+		return err
+	} else if settleCheck(pr, repo, err, "") {
+		return nil // rescheduled or deferred
+	}
+	return err
+}
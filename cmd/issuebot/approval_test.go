@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestCheckApproval(t *testing.T) {
+	permissions := map[string]string{"alice": "write", "carol": "read"}
+	var reviews []*github.PullRequestReview
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		switch {
+		case path == "/repos/o/r/pulls/1/reviews":
+			json.NewEncoder(w).Encode(reviews)
+		case strings.HasPrefix(path, "/repos/o/r/collaborators/"):
+			user := strings.TrimSuffix(strings.TrimPrefix(path, "/repos/o/r/collaborators/"), "/permission")
+			perm, ok := permissions[user]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(github.RepositoryPermissionLevel{Permission: github.Ptr(perm)})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	cfg, err := parseRepoConfig([]byte(`approvalPhrase: "issuebot: approved without issue"`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	review := func(login, state, body string) *github.PullRequestReview {
+		return &github.PullRequestReview{
+			User:  &github.User{Login: github.Ptr(login)},
+			State: github.Ptr(state),
+			Body:  github.Ptr(body),
+		}
+	}
+	tests := []struct {
+		name       string
+		reviews    []*github.PullRequestReview
+		want       pullRequestStatus
+		wantDenied string
+	}{
+		{"none", nil, prFailed, ""},
+		{"plain approval", []*github.PullRequestReview{review("alice", "APPROVED", "LGTM")}, prFailed, ""},
+		{"approved", []*github.PullRequestReview{review("alice", "APPROVED", "Typo fix. Issuebot: approved without issue")}, prCleanup, ""},
+		{"comment", []*github.PullRequestReview{review("alice", "COMMENTED", "issuebot: approved without issue")}, prFailed, ""},
+		{"dismissed", []*github.PullRequestReview{review("alice", "APPROVED", "issuebot: approved without issue"), review("alice", "DISMISSED", "")}, prFailed, ""},
+		{"later comment", []*github.PullRequestReview{review("alice", "APPROVED", "issuebot: approved without issue"), review("alice", "COMMENTED", "nit")}, prCleanup, ""},
+		{"reader", []*github.PullRequestReview{review("carol", "APPROVED", "issuebot: approved without issue")}, prFailed, "carol"},
+		{"outsider", []*github.PullRequestReview{review("mallory", "APPROVED", "issuebot: approved without issue"), review("alice", "APPROVED", "issuebot: approved without issue")}, prCleanup, ""},
+	}
+	for _, tc := range tests {
+		reviews = tc.reviews
+		p := pullRequest{
+			repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+			pr:   &github.PullRequest{Number: github.Ptr(1)},
+			cfg:  cfg,
+		}
+		got, denied, err := p.checkApproval(t.Context(), cli)
+		if err != nil {
+			t.Errorf("checkApproval(%s): unexpected error: %v", tc.name, err)
+			continue
+		}
+		if got != tc.want || denied.user != tc.wantDenied {
+			t.Errorf("checkApproval(%s): got %v, %q; want %v, %q", tc.name, got, denied.user, tc.want, tc.wantDenied)
+		}
+	}
+}
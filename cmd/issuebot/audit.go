@@ -0,0 +1,339 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const auditUsage = `Usage: issuebot audit [-n count] [-branch name | -prs] [-format text|csv|json] [-v] owner/repo ...
+
+Classify the latest commits on the default branch of each repository (or the
+given branch) as issuebot would, and report those that do not link to an
+issue, with a summary of how each commit passed or failed. This measures how
+well the history follows the policy, including commits that landed before
+issuebot was installed.
+
+With -prs, classify the latest merged pull requests instead, checking each
+as the server would (nothing is changed on GitHub). Comparing the results
+before and after a repository began enforcing the check shows its effect.
+
+The text report lists only what does not link to an issue; the CSV and JSON
+reports list everything classified, with its date, for further analysis.
+
+GitHub is accessed as "issuebot help" describes.
+`
+
+// runAudit implements the audit subcommand, and returns the process exit
+// code.
+func runAudit(args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	count := fs.Int("n", 100, "number of commits or PRs to classify in each repository")
+	branch := fs.String("branch", "", "branch to audit (default: the repository's default branch)")
+	prs := fs.Bool("prs", false, "classify merged PRs, rather than commits on a branch")
+	format := fs.String("format", "text", "report format: text, csv, or json")
+	verbose := fs.Bool("v", false, "log how each commit or PR is classified")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), auditUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || *count <= 0 || (*prs && *branch != "") {
+		fs.Usage()
+		return 2
+	}
+	switch *format {
+	case "text", "csv", "json":
+	default:
+		fmt.Fprintf(os.Stderr, "invalid format %q, want text, csv, or json\n", *format)
+		return 2
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard) // the checker logs each decision
+	}
+	*shadowMode = true // classifying PRs must not file stubs or comment
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	cli, err := toolClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+		return 2
+	}
+	status := 0
+	var all []auditEntry
+	for _, name := range fs.Args() {
+		repo, err := getRepo(ctx, cli, name)
+		var entries []auditEntry
+		if err == nil && *prs {
+			entries, err = auditPulls(ctx, cli, repo, *count)
+		} else if err == nil {
+			entries, err = auditBranch(ctx, cli, repo, cmp.Or(*branch, repo.GetDefaultBranch()), *count)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			status = 1
+			continue
+		}
+		if *format == "text" {
+			writeAuditReport(os.Stdout, repo.GetFullName(), entries)
+		}
+		all = append(all, entries...)
+	}
+	switch *format {
+	case "csv":
+		err = writeAuditCSV(os.Stdout, all)
+	case "json":
+		err = writeAuditJSON(os.Stdout, all)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "writing report: %v\n", err)
+		return 1
+	}
+	return status
+}
+
+// An auditEntry is the classification of a commit or merged PR by the audit
+// subcommand.
+type auditEntry struct {
+	repo    string // full name of the repository, owner/repo
+	sha     string // the commit, or the commit that merged the PR
+	number  int    // PR number, or 0 for a commit
+	date    time.Time
+	author  string // GitHub login, or the name in the commit if unknown
+	subject string // first line of the commit message, or PR title
+	status  pullRequestStatus
+}
+
+// auditBranch classifies the latest count commits on the given branch of repo,
+// newest first.
+func auditBranch(ctx context.Context, cli *github.Client, repo *github.Repository, branch string, count int) ([]auditEntry, error) {
+	cfg, err := fetchRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	var entries []auditEntry
+	opts := &github.CommitsListOptions{SHA: branch, ListOptions: github.ListOptions{PerPage: min(count, 100)}}
+	for len(entries) < count {
+		commits, resp, err := retryCall(ctx, "ListCommits", func(ctx context.Context) ([]*github.RepositoryCommit, *github.Response, error) {
+			return cli.Repositories.ListCommits(ctx, owner, name, opts)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list commits: %w", err)
+		}
+		for _, c := range commits {
+			if len(entries) == count {
+				break
+			}
+			status, err := classifyCommit(ctx, cli, pullRequest{repo: repo, pr: new(github.PullRequest), cfg: cfg}, c)
+			if err != nil {
+				return nil, err
+			}
+			subject, _, _ := strings.Cut(c.GetCommit().GetMessage(), "\n")
+			entries = append(entries, auditEntry{
+				repo:    repo.GetFullName(),
+				sha:     c.GetSHA(),
+				date:    c.GetCommit().GetCommitter().GetDate().Time,
+				author:  cmp.Or(c.GetAuthor().GetLogin(), c.GetCommit().GetAuthor().GetName()),
+				subject: subject,
+				status:  status,
+			})
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return entries, nil
+}
+
+// classifyCommit returns the disposition of a commit landed on a branch of
+// p.repo, judged by itself as if it were the only commit of a PR.
+func classifyCommit(ctx context.Context, cli *github.Client, p pullRequest, c *github.RepositoryCommit) (pullRequestStatus, error) {
+	msg := c.GetCommit().GetMessage()
+	status := p.checkCommitMessage(msg)
+	if status == prFailed {
+		status = p.checkOverride(msg)
+	}
+	if bot := p.checkCommitMetadata(c); bot > status {
+		status = bot
+	}
+	if status > prSkipped {
+		return status, nil
+	}
+	// Listed commits lack diff stats, so fetch the commit to size it.
+	full, _, err := retryCall(ctx, "GetCommit", func(ctx context.Context) (*github.RepositoryCommit, *github.Response, error) {
+		return cli.Repositories.GetCommit(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), c.GetSHA(), nil)
+	})
+	if err != nil {
+		return prFailed, fmt.Errorf("get commit %s: %w", c.GetSHA(), err)
+	}
+	if p.cfg.diffSize(full) < p.cfg.minDiff() {
+		return prSmall, nil
+	}
+	return status, nil
+}
+
+// auditPulls classifies the count most recently merged PRs in repo, checking
+// each as the server would, newest first. It must be run in shadow mode.
+func auditPulls(ctx context.Context, cli *github.Client, repo *github.Repository, count int) ([]auditEntry, error) {
+	cfg, err := fetchRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+
+	// GitHub cannot list PRs by when they were merged, but merging a PR
+	// updates it, so recently merged PRs are among the recently updated ones.
+	var merged []*github.PullRequest
+	opts := &github.PullRequestListOptions{
+		State:       "closed",
+		Sort:        "updated",
+		Direction:   "desc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for len(merged) < count {
+		pulls, resp, err := retryCall(ctx, "ListPulls", func(ctx context.Context) ([]*github.PullRequest, *github.Response, error) {
+			return cli.PullRequests.List(ctx, owner, name, opts)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list PRs: %w", err)
+		}
+		for _, pr := range pulls {
+			if pr.MergedAt != nil {
+				merged = append(merged, pr)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	slices.SortStableFunc(merged, func(a, b *github.PullRequest) int {
+		return b.GetMergedAt().Compare(a.GetMergedAt().Time)
+	})
+	merged = merged[:min(count, len(merged))]
+
+	entries := make([]auditEntry, 0, len(merged))
+	for _, pr := range merged {
+		status, err := pullRequest{repo: repo, pr: pr, cfg: cfg}.evaluate(ctx, cli)
+		if err != nil {
+			return nil, fmt.Errorf("PR #%d: %w", pr.GetNumber(), err)
+		}
+		entries = append(entries, auditEntry{
+			repo:    repo.GetFullName(),
+			sha:     pr.GetMergeCommitSHA(),
+			number:  pr.GetNumber(),
+			date:    pr.GetMergedAt().Time,
+			author:  pr.GetUser().GetLogin(),
+			subject: pr.GetTitle(),
+			status:  status,
+		})
+	}
+	return entries, nil
+}
+
+// writeAuditReport writes the commits or PRs in entries that do not link to
+// an issue (including those that skipped the check), and a summary of all of
+// them, to w.
+func writeAuditReport(w io.Writer, repo string, entries []auditEntry) {
+	counts := make([]int, len(statusNames))
+	what := "commits"
+	if len(entries) != 0 && entries[0].number != 0 {
+		what = "PRs"
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s: %d %s\n", repo, len(entries), what)
+	for _, e := range entries {
+		counts[e.status]++
+		if e.status > prCleanup {
+			continue
+		}
+		id := fmt.Sprintf("%.12s", e.sha)
+		if e.number != 0 {
+			id = "#" + strconv.Itoa(e.number)
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", id, e.status, e.author, e.subject)
+	}
+	tw.Flush()
+	if len(entries) == 0 {
+		return
+	}
+	var parts []string
+	for s, n := range counts {
+		if n != 0 {
+			parts = append(parts, fmt.Sprintf("%s %d (%.0f%%)", pullRequestStatus(s), n, 100*float64(n)/float64(len(entries))))
+		}
+	}
+	fmt.Fprintf(w, "  summary: %s\n", strings.Join(parts, ", "))
+}
+
+// auditRecord is the form of an auditEntry in CSV and JSON reports.
+type auditRecord struct {
+	Repo        string    `json:"repo"`
+	Commit      string    `json:"commit"`
+	PR          int       `json:"pr,omitempty"`
+	Date        time.Time `json:"date"`
+	Author      string    `json:"author"`
+	Subject     string    `json:"subject"`
+	Disposition string    `json:"disposition"`
+}
+
+func (e auditEntry) record() auditRecord {
+	return auditRecord{
+		Repo:        e.repo,
+		Commit:      e.sha,
+		PR:          e.number,
+		Date:        e.date.UTC(),
+		Author:      e.author,
+		Subject:     e.subject,
+		Disposition: e.status.String(),
+	}
+}
+
+// writeAuditCSV writes entries to w as CSV, with a header row.
+func writeAuditCSV(w io.Writer, entries []auditEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"repo", "commit", "pr", "date", "author", "subject", "disposition"})
+	for _, e := range entries {
+		r := e.record()
+		pr := ""
+		if r.PR != 0 {
+			pr = strconv.Itoa(r.PR)
+		}
+		cw.Write([]string{r.Repo, r.Commit, pr, r.Date.Format(time.RFC3339), r.Author, r.Subject, r.Disposition})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeAuditJSON writes entries to w as a JSON array.
+func writeAuditJSON(w io.Writer, entries []auditEntry) error {
+	records := make([]auditRecord, len(entries))
+	for i, e := range entries {
+		records[i] = e.record()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestAuditBranch(t *testing.T) {
+	commits := []*github.RepositoryCommit{
+		{SHA: github.Ptr("aaaa"), Commit: &github.Commit{Message: github.Ptr("Fix the frobnicator\n\nFixes #12")}},
+		{SHA: github.Ptr("bbbb"), Commit: &github.Commit{Message: github.Ptr("Tweak the frobnicator"), Author: &github.CommitAuthor{Name: github.Ptr("Jo")}}},
+		{SHA: github.Ptr("cccc"), Commit: &github.Commit{Message: github.Ptr("Typo")}},
+		{SHA: github.Ptr("dddd"), Commit: &github.Commit{Message: github.Ptr("Rewrite it\n\nskip-issuebot")}, Author: &github.User{Login: github.Ptr("kim")}},
+	}
+	stats := map[string]int{"bbbb": 500, "cccc": 1, "dddd": 500}
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/o/r/commits":
+			if r.URL.Query().Get("sha") != "main" {
+				t.Errorf("ListCommits: got sha %q, want %q", r.URL.Query().Get("sha"), "main")
+			}
+			json.NewEncoder(w).Encode(commits)
+		case strings.HasPrefix(r.URL.Path, "/repos/o/r/commits/"):
+			sha := strings.TrimPrefix(r.URL.Path, "/repos/o/r/commits/")
+			n := stats[sha]
+			fmt.Fprintf(w, `{"sha":%q,"stats":{"additions":%d,"deletions":0,"total":%d}}`, sha, n, n)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	repo := &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")}
+
+	entries, err := auditBranch(t.Context(), cli, repo, "main", 3)
+	if err != nil {
+		t.Fatalf("auditBranch: unexpected error: %v", err)
+	}
+	want := []pullRequestStatus{prLinked, prFailed, prSmall}
+	if len(entries) != len(want) {
+		t.Fatalf("auditBranch: got %d entries, want %d", len(entries), len(want))
+	}
+	for i, e := range entries {
+		if e.status != want[i] {
+			t.Errorf("auditBranch: commit %s: got %v, want %v", e.sha, e.status, want[i])
+		}
+	}
+
+	entries, err = auditBranch(t.Context(), cli, repo, "main", 10)
+	if err != nil {
+		t.Fatalf("auditBranch: unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	writeAuditReport(&buf, "o/r", entries)
+	got := buf.String()
+	for _, s := range []string{"o/r: 4 commits", "bbbb", "Jo", "Tweak the frobnicator", "dddd", "kim", "linked 1 (25%)"} {
+		if !strings.Contains(got, s) {
+			t.Errorf("writeAuditReport: got %q, want it to contain %q", got, s)
+		}
+	}
+	for _, s := range []string{"aaaa", "cccc"} {
+		if strings.Contains(got, s) {
+			t.Errorf("writeAuditReport: got %q, want it not to list %q", got, s)
+		}
+	}
+}
+
+func TestAuditPulls(t *testing.T) {
+	defer func(old bool) { *shadowMode = old }(*shadowMode)
+	*shadowMode = true
+
+	messages := map[int]string{1: "Fix the frobnicator\n\nFixes #12", 2: "Tweak the frobnicator"}
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		switch {
+		case r.URL.Path == "/repos/o/r/pulls":
+			if r.URL.Query().Get("state") != "closed" {
+				t.Errorf("ListPulls: got state %q, want closed", r.URL.Query().Get("state"))
+			}
+			fmt.Fprint(w, `[
+  {"number": 3, "title": "Abandoned"},
+  {"number": 1, "title": "Fix it", "merged_at": "2024-01-01T00:00:00Z", "merge_commit_sha": "aaaa", "user": {"login": "kim"}},
+  {"number": 2, "title": "Tweak it", "merged_at": "2024-02-01T00:00:00Z", "merge_commit_sha": "bbbb", "user": {"login": "jo"}}
+]`)
+		case sscanf(r.URL.Path, "/repos/o/r/pulls/%d/commits", &n):
+			fmt.Fprintf(w, `[{"sha":"c%d","commit":{"message":%q}}]`, n, messages[n])
+		case sscanf(r.URL.Path, "/repos/o/r/pulls/%d/files", &n):
+			// Lists of PRs do not give their size.
+			fmt.Fprint(w, `[{"filename":"frob.go","changes":500}]`)
+		case strings.HasSuffix(r.URL.Path, "/comments"):
+			fmt.Fprint(w, "[]")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	repo := &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")}
+
+	entries, err := auditPulls(t.Context(), cli, repo, 10)
+	if err != nil {
+		t.Fatalf("auditPulls: unexpected error: %v", err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("#%d %s %v", e.number, e.sha, e.status))
+	}
+	want := []string{"#2 bbbb failed", "#1 aaaa linked"} // newest first, unmerged skipped
+	if !slices.Equal(got, want) {
+		t.Errorf("auditPulls: got %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := writeAuditCSV(&buf, entries); err != nil {
+		t.Fatalf("writeAuditCSV: %v", err)
+	}
+	const wantCSV = `repo,commit,pr,date,author,subject,disposition
+o/r,bbbb,2,2024-02-01T00:00:00Z,jo,Tweak it,failed
+o/r,aaaa,1,2024-01-01T00:00:00Z,kim,Fix it,linked
+`
+	if buf.String() != wantCSV {
+		t.Errorf("writeAuditCSV: got %q, want %q", buf.String(), wantCSV)
+	}
+
+	buf.Reset()
+	if err := writeAuditJSON(&buf, entries); err != nil {
+		t.Fatalf("writeAuditJSON: %v", err)
+	}
+	var records []auditRecord
+	if err := json.Unmarshal(buf.Bytes(), &records); err != nil {
+		t.Fatalf("writeAuditJSON: invalid JSON %q: %v", buf.String(), err)
+	}
+	if len(records) != 2 || records[0].PR != 2 || records[0].Disposition != "failed" {
+		t.Errorf("writeAuditJSON: got %+v, want PR 2 failed first", records)
+	}
+}
+
+// sscanf reports whether s matches format, storing the values it scans in
+// args.
+func sscanf(s, format string, args ...any) bool {
+	n, err := fmt.Sscanf(s, format, args...)
+	return err == nil && n == len(args)
+}
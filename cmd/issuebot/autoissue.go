@@ -6,9 +6,14 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/http"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 
 	"github.com/google/go-github/v72/github"
 )
@@ -18,58 +23,112 @@ import (
 // been filed for a PR.
 const issuebotStubLabel = "issuebot-stub"
 
-// issueTitleTemplate is the string template for stub issue titles, containing
-// a %d for the associated PR number.
-const issueTitleTemplate = "Placeholder issue for PR #%d"
+// issueCommentRE is used to recognize issuebot PR comments with the default
+// text, which name the stub issue.
+var issueCommentRE = regexp.MustCompile(`(?i)IssueBot here\..*I have filed issue (#\d+) for you`)
 
-// issueCommentTemplate is the string template for the PR thread comment,
-// containing a %d for the associated stub issue number.
-const issueCommentTemplate = ":robot: IssueBot here. I noticed none of the commits on this PR has an issue attached. I have filed issue #%d for you. Please update it at your convenience."
+// stubMarkerRE matches the marker added to every stub comment (see
+// stubCommentMarker), so that customized comments are recognized too. The
+// submatch is the stub issue reference, e.g., "#123" or "PROJ-123".
+var stubMarkerRE = regexp.MustCompile(`<!-- issuebot:stub (\S+) -->`)
 
-// issueCommentRE is used to recognize issuebot PR comments.
-var issueCommentRE = regexp.MustCompile(`(?i)IssueBot here\..*I have filed issue #(\d+) for you`)
+// stubCommentMarker is appended to stub comments on PRs, containing a %v for
+// the stub issue reference. It is not visible in the rendered comment.
+const stubCommentMarker = "\n\n<!-- issuebot:stub %v -->"
+
+// stubLinkLine is appended to the PR description to link the stub issue, if
+// the repository's linkStubs setting asks for it, containing a %d for the
+// issue number. stubLinkRE matches it.
+const stubLinkLine = "\n\nCloses #%d <!-- issuebot:stub-link -->"
+
+var stubLinkRE = regexp.MustCompile(`(?m)^Closes #(\d+) <!-- issuebot:stub-link -->\r?$`)
 
 // checkStubIssue checks whether the specified pull request already has a stub
 // issue created by the bot. If so, it returns the issue number > 0; otherwise
 // it returns 0.
+//
+// Stubs are found with the Search API, by their label and the PR number in
+// their title or body, so that a stub is found even if its title has been
+// changed.
 func (p pullRequest) checkStubIssue(ctx context.Context, cli *github.Client) (int, error) {
-	owner := p.repo.GetOwner().GetLogin()
-	repoName := p.repo.GetName()
-	prNumber := p.pr.GetNumber()
-
-	issues, _, err := cli.Issues.ListByRepo(ctx, owner, repoName, &github.IssueListByRepoOptions{
-		Assignee: p.pr.GetUser().GetLogin(),
-		Labels:   []string{issuebotStubLabel},
-		State:    "open",
-	})
-	if err != nil {
-		return 0, fmt.Errorf("list issues: %w", err)
-	}
-
-	wantTitle := fmt.Sprintf(issueTitleTemplate, prNumber)
-	for _, issue := range issues {
-		if issue.GetTitle() == wantTitle {
-			return issue.GetNumber(), nil
-		}
+	n, err := p.searchStubIssue(ctx, cli)
+	if n > 0 || err != nil {
+		return n, err
 	}
 
 	// If a PR gets updated twice within a short span of time, a stub issue may
 	// not show up in search results by the time we get the second ping.  To
-	// reduce the likelihood that we create duplicate issues, check for the PR
-	// comment too before reporting a missing issue.
-	comments, _, err := cli.Issues.ListComments(ctx, owner, repoName, prNumber, nil)
+	// reduce the likelihood that we create duplicate issues, check for the
+	// link in the PR description and the PR comment too before reporting a
+	// missing issue.
+	if m := stubLinkRE.FindStringSubmatch(p.pr.GetBody()); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n, nil
+	}
+	ref, err := p.findStubComment(ctx, cli)
+	if num, ok := strings.CutPrefix(ref, "#"); ok {
+		n, _ := strconv.Atoi(num)
+		return n, nil
+	}
+	return 0, err
+}
+
+// searchStubIssue searches for an open stub issue filed for the PR, and
+// returns its number, or 0 if there is none.
+func (p pullRequest) searchStubIssue(ctx context.Context, cli *github.Client) (int, error) {
+	wantTitle, err := p.render(stubTitleTemplate, p.data())
 	if err != nil {
-		return 0, fmt.Errorf("list comments: %w", err)
+		return 0, err
 	}
-	for _, comment := range comments {
-		m := issueCommentRE.FindStringSubmatch(comment.GetBody())
-		if m != nil {
-			num, _ := strconv.Atoi(m[1])
-			return num, nil
+	q := fmt.Sprintf(`repo:%s is:issue is:open label:%s "PR #%d" in:title,body`, p.repo.GetFullName(), issuebotStubLabel, p.pr.GetNumber())
+	opts := &github.SearchOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		res, resp, err := retryCall(ctx, "SearchIssues", func(ctx context.Context) (*github.IssuesSearchResult, *github.Response, error) {
+			return cli.Search.Issues(ctx, q, opts)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("search issues: %w", err)
+		}
+		// Search matches words loosely, so check that each result is really
+		// the PR's stub, by the marker in its body or else by its title.
+		for _, issue := range res.Issues {
+			if pr := parseStubBody(issue.GetBody()).pr; pr == p.pr.GetNumber() || (pr == 0 && issue.GetTitle() == wantTitle) {
+				return issue.GetNumber(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return 0, nil
 		}
+		opts.ListOptions.Page = resp.NextPage
 	}
+}
 
-	return 0, nil
+// findStubComment looks for a comment on the PR announcing a stub issue, and
+// returns the reference to the issue it names (e.g., "#123" or "PROJ-123"),
+// or "" if there is none. Comments are recognized by their marker, or, for
+// comments posted before markers were added, by the default text, if a bot
+// posted them.
+func (p pullRequest) findStubComment(ctx context.Context, cli *github.Client) (string, error) {
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := retryCall(ctx, "ListComments", func(ctx context.Context) ([]*github.IssueComment, *github.Response, error) {
+			return cli.Issues.ListComments(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), opts)
+		})
+		if err != nil {
+			return "", fmt.Errorf("list comments: %w", err)
+		}
+		for _, comment := range comments {
+			if m := stubMarkerRE.FindStringSubmatch(comment.GetBody()); m != nil {
+				return m[1], nil
+			} else if m := issueCommentRE.FindStringSubmatch(comment.GetBody()); m != nil && comment.GetUser().GetType() == "Bot" {
+				return m[1], nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return "", nil
+		}
+		opts.ListOptions.Page = resp.NextPage
+	}
 }
 
 // createStubIssue creates a new "placeholder" issue for the specified PR in
@@ -79,30 +138,415 @@ func (p pullRequest) checkStubIssue(ctx context.Context, cli *github.Client) (in
 // If an issue is successfully created, its number > 0 is returned whether or
 // not there is a subsequent error in commenting on the PR.
 func (p pullRequest) createStubIssue(ctx context.Context, cli *github.Client) (int, error) {
+	if *shadowMode {
+		p.logf("shadow: would create stub issue")
+		return 0, nil
+	}
 	owner := p.repo.GetOwner().GetLogin()
 	repoName := p.repo.GetName()
-	prNumber := p.pr.GetNumber()
 
 	// Create a stub issue to link to the PR.
-	prAuthor := p.pr.GetUser().GetLogin()
+	data := p.data()
+	title, err := p.render(stubTitleTemplate, data)
+	if err != nil {
+		return 0, err
+	}
+	body, err := p.render(stubBodyTemplate, data)
+	if err != nil {
+		return 0, err
+	}
+	if *draftURL != "" && p.cfg.draftStubs() {
+		if draft, err := p.draftStub(ctx, cli); err != nil {
+			p.logf("error drafting stub issue (using default body): %v", err)
+		} else if draft != "" {
+			data.Draft = draft
+			if body, err = p.render(stubDraftTemplate, data); err != nil {
+				return 0, err
+			}
+		}
+	}
+	assignees, err := p.stubAssignees(ctx, cli)
+	if err != nil {
+		p.logf("error finding stub assignees (assigning author): %v", err)
+		assignees = []string{data.Author}
+	}
 	labels := []string{issuebotStubLabel}
-	issue, _, err := cli.Issues.Create(ctx, owner, repoName, &github.IssueRequest{
-		Title:    github.Ptr(fmt.Sprintf(issueTitleTemplate, prNumber)),
-		Assignee: github.Ptr(prAuthor),
-		Body: github.Ptr(fmt.Sprintf("TODO(@%s): Add details about PR #%d",
-			prAuthor, prNumber)),
-		Labels: &labels,
-	})
+	if st := p.cfg.stubTemplate(); st != nil {
+		text, tl, err := p.stubFromTemplate(ctx, cli, st, data)
+		if err != nil {
+			p.logf("error filling in issue template %s (using default body): %v", st.Name, err)
+		} else {
+			body += "\n\n" + text
+			labels = append(labels, tl...)
+		}
+	}
+	req := &github.IssueRequest{
+		Title:     github.Ptr(title),
+		Assignees: &assignees,
+		Body:      github.Ptr(body + stubBodyMarker(data.Number, body)),
+		Labels:    &labels,
+	}
+	if p.cfg.syncStubs() {
+		// Give triage something to go on.
+		for _, l := range p.pr.Labels {
+			labels = append(labels, l.GetName())
+		}
+		if m := p.pr.GetMilestone(); m != nil {
+			req.Milestone = m.Number
+		}
+	}
+	issue, _, err := createCall(ctx, "CreateIssue", func(ctx context.Context) (*github.Issue, *github.Response, error) {
+		return cli.Issues.Create(ctx, owner, repoName, req)
+	}, findIssue(cli, owner, repoName, req))
 	if err != nil {
 		return 0, fmt.Errorf("creating issue: %w", err)
 	}
 	issueNumber := issue.GetNumber()
 
 	// Add a comment to the PR thread indicating what we did.
-	if _, _, err := cli.Issues.CreateComment(ctx, owner, repoName, prNumber, &github.IssueComment{
-		Body: github.Ptr(fmt.Sprintf(issueCommentTemplate, issueNumber)),
-	}); err != nil {
+	data.Issue = issueNumber
+	if err := p.postStubComment(ctx, cli, issueRef{Number: issueNumber}, data); err != nil {
 		p.logf("error adding comment (continuing): %v", err)
 	}
+	if err := p.addToStubProject(ctx, cli, issue); err != nil {
+		p.logf("error adding stub issue to project (continuing): %v", err)
+	}
+	if p.cfg.linkStubs() {
+		if err := p.linkStubIssue(ctx, cli, issueNumber); err != nil {
+			p.logf("error linking stub issue (continuing): %v", err)
+		}
+	}
 	return issueNumber, nil
 }
+
+// maxAssignees is the most users GitHub allows to be assigned to an issue.
+const maxAssignees = 10
+
+// stubAssignees returns the logins of the users to assign the PR's stub
+// issue to, according to the repository's stubAssignee settings.
+func (p pullRequest) stubAssignees(ctx context.Context, cli *github.Client) ([]string, error) {
+	who := p.cfg.stubAssignee(p.pr.GetAuthorAssociation())
+	if who == stubAssignAuthor {
+		return []string{p.pr.GetUser().GetLogin()}, nil
+	}
+	org, team, ok := strings.Cut(who, "/")
+	if !ok {
+		return []string{who}, nil
+	}
+	members, _, err := retryCall(ctx, "ListTeamMembers", func(ctx context.Context) ([]*github.User, *github.Response, error) {
+		return cli.Teams.ListTeamMembersBySlug(ctx, org, team, &github.TeamListTeamMembersOptions{
+			ListOptions: github.ListOptions{PerPage: maxAssignees},
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list members of %s: %w", who, err)
+	} else if len(members) == 0 {
+		return nil, fmt.Errorf("team %s has no members", who)
+	}
+	var logins []string
+	for _, m := range members {
+		logins = append(logins, m.GetLogin())
+	}
+	return logins, nil
+}
+
+// linkStubIssue adds a closing keyword for the stub issue n to the PR
+// description, which links them in GitHub's Development sidebar. The
+// description is fetched again first, since the one in the webhook may be
+// out of date, and an edit made since must not be overwritten.
+func (p pullRequest) linkStubIssue(ctx context.Context, cli *github.Client, n int) error {
+	pr, _, err := retryCall(ctx, "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+		return cli.PullRequests.Get(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber())
+	})
+	if err != nil {
+		return fmt.Errorf("get PR: %w", err)
+	}
+	if stubLinkRE.MatchString(pr.GetBody()) {
+		return nil
+	}
+	body := strings.TrimRight(pr.GetBody(), "\r\n") + fmt.Sprintf(stubLinkLine, n)
+	_, _, err = retryCall(ctx, "EditPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+		return cli.PullRequests.Edit(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), &github.PullRequest{
+			Body: github.Ptr(body),
+		})
+	})
+	return err
+}
+
+// postStubComment adds a comment to the PR thread announcing the stub issue
+// ref, which was filed with the given message data.
+func (p pullRequest) postStubComment(ctx context.Context, cli *github.Client, ref trackerRef, data messageData) error {
+	data.Ref = ref.String()
+	comment, err := p.render(stubCommentTemplate, data)
+	if err != nil {
+		return err
+	}
+	comment += fmt.Sprintf(stubCommentMarker, ref)
+	_, _, err = createCall(ctx, "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+		return cli.Issues.CreateComment(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), &github.IssueComment{
+			Body: github.Ptr(comment),
+		})
+	}, findComment(cli, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), comment))
+	return err
+}
+
+// staleStubLabel is added to stub issues whose PR was closed without being
+// merged, if the repository asks for that rather than closing them.
+const staleStubLabel = "stale-stub"
+
+// openStub returns the stub issue filed in GitHub for the PR, if it is still
+// open and labeled as a stub. Otherwise it returns nil.
+func (p pullRequest) openStub(ctx context.Context, cli *github.Client) (*github.Issue, error) {
+	ref, err := p.findStubComment(ctx, cli)
+	if err != nil {
+		return nil, err
+	}
+	num, ok := strings.CutPrefix(ref, "#")
+	if !ok {
+		return nil, nil // no stub, or not in GitHub
+	}
+	n, _ := strconv.Atoi(num)
+	issue, _, err := retryCall(ctx, "GetIssue", func(ctx context.Context) (*github.Issue, *github.Response, error) {
+		return cli.Issues.Get(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), n)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get issue: %w", err)
+	}
+	if issue.GetState() != "open" || !slices.ContainsFunc(issue.Labels, func(l *github.Label) bool {
+		return l.GetName() == issuebotStubLabel
+	}) {
+		return nil, nil
+	}
+	return issue, nil
+}
+
+// placeholderStub returns the stub issue filed in GitHub for the PR, if it is
+// still an open, unedited placeholder. Otherwise it returns nil.
+func (p pullRequest) placeholderStub(ctx context.Context, cli *github.Client) (*github.Issue, error) {
+	issue, err := p.openStub(ctx, cli)
+	if issue == nil {
+		return nil, err
+	}
+
+	if ok, err := p.isPlaceholder(issue); !ok {
+		// Someone has made it a real issue.
+		return nil, err
+	}
+	return issue, nil
+}
+
+// isPlaceholder reports whether the title and body of the stub issue filed
+// for the PR are still as issuebot wrote them.
+func (p pullRequest) isPlaceholder(issue *github.Issue) (bool, error) {
+	data := p.data()
+	title, err := p.render(stubTitleTemplate, data)
+	if err != nil || issue.GetTitle() != title {
+		return false, err
+	}
+	sb := parseStubBody(issue.GetBody())
+	if sb.sum != "" {
+		return stubBodySum(sb.text) == sb.sum, nil
+	}
+	// Stubs filed before their bodies were summed had the default body.
+	body, err := p.render(stubBodyTemplate, data)
+	if err != nil {
+		return false, err
+	}
+	return sb.text == body, nil
+}
+
+// stubBodyMarker returns the marker appended to the body text of a stub issue
+// filed for PR number pr. It is not visible in the rendered issue. It records
+// the PR, and a checksum of text so that edits to the stub can be detected.
+func stubBodyMarker(pr int, text string) string {
+	return fmt.Sprintf("\n\n<!-- issuebot:stub-for #%d %s -->", pr, stubBodySum(text))
+}
+
+// stubBodySum returns the checksum of the body text of a stub issue.
+func stubBodySum(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:8])
+}
+
+var stubBodyMarkerRE = regexp.MustCompile(`\n\n<!-- issuebot:stub-for #(\d+)(?: ([0-9a-f]+))? -->$`)
+
+// A stubBody is the body of a stub issue, parsed by parseStubBody.
+type stubBody struct {
+	text string // the body without its marker
+	pr   int    // the number of the PR it was filed for, or 0 if unknown
+	sum  string // the checksum of text when the stub was filed, or ""
+}
+
+// parseStubBody parses the body of a stub issue, which may have a marker
+// (see stubBodyMarker).
+func parseStubBody(body string) stubBody {
+	m := stubBodyMarkerRE.FindStringSubmatchIndex(body)
+	if m == nil {
+		return stubBody{text: body}
+	}
+	sb := stubBody{text: body[:m[0]]}
+	sb.pr, _ = strconv.Atoi(body[m[2]:m[3]])
+	if m[4] >= 0 {
+		sb.sum = body[m[4]:m[5]]
+	}
+	return sb
+}
+
+// closeStubIssue closes the stub issue filed for the PR, if it is still a
+// placeholder (see placeholderStub), with a comment saying that the PR now
+// links to the issue link (which may be "" if it is not known).
+func (p pullRequest) closeStubIssue(ctx context.Context, cli *github.Client, link string) error {
+	issue, err := p.placeholderStub(ctx, cli)
+	if issue == nil {
+		return err
+	}
+	data := p.data()
+	data.Issue, data.Ref, data.Link = issue.GetNumber(), fmt.Sprintf("#%d", issue.GetNumber()), link
+	return p.closeStub(ctx, cli, issue, stubClosedTemplate, data)
+}
+
+// closeStub closes the stub issue with a comment rendered from the named
+// template.
+func (p pullRequest) closeStub(ctx context.Context, cli *github.Client, issue *github.Issue, name string, data messageData) error {
+	n := issue.GetNumber()
+	if *shadowMode {
+		p.logf("shadow: would close stub issue #%d", n)
+		return nil
+	}
+	owner := p.repo.GetOwner().GetLogin()
+	repoName := p.repo.GetName()
+	comment, err := p.render(name, data)
+	if err != nil {
+		return err
+	}
+	if _, _, err := createCall(ctx, "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+		return cli.Issues.CreateComment(ctx, owner, repoName, n, &github.IssueComment{Body: github.Ptr(comment)})
+	}, findComment(cli, owner, repoName, n, comment)); err != nil {
+		return fmt.Errorf("comment on stub issue: %w", err)
+	}
+	if _, _, err := retryCall(ctx, "EditIssue", func(ctx context.Context) (*github.Issue, *github.Response, error) {
+		return cli.Issues.Edit(ctx, owner, repoName, n, &github.IssueRequest{
+			State:       github.Ptr("closed"),
+			StateReason: github.Ptr("not_planned"),
+		})
+	}); err != nil {
+		return fmt.Errorf("close stub issue: %w", err)
+	}
+	p.logf("closed stub issue #%d", n)
+	return nil
+}
+
+// retireAbandonedStub handles pr, which was closed without being merged, by
+// closing or labeling its stub issue, as the repository's abandonedStubs
+// setting says.
+func retireAbandonedStub(ctx context.Context, pr *github.PullRequest, repo *github.Repository) error {
+	p := pullRequest{repo: repo, pr: pr}
+	if !repoEnabled(repo.GetFullName()) {
+		return nil
+	}
+	if err := beginCheck(); err != nil {
+		return err
+	}
+	defer endCheck()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	cli := apiClient()
+	cfg, err := loadRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	p.cfg = cfg
+	if cfg.abandonedStubs() == abandonKeep || cfg.stubTracker() != trackerGitHub {
+		return nil
+	}
+
+	issue, err := p.placeholderStub(ctx, cli)
+	if issue == nil {
+		return err
+	}
+	switch cfg.abandonedStubs() {
+	case abandonClose:
+		data := p.data()
+		data.Issue, data.Ref = issue.GetNumber(), fmt.Sprintf("#%d", issue.GetNumber())
+		return p.closeStub(ctx, cli, issue, stubAbandonedTemplate, data)
+	case abandonLabel:
+		if *shadowMode {
+			p.logf("shadow: would label stub issue #%d %s", issue.GetNumber(), staleStubLabel)
+			return nil
+		}
+		_, _, err := retryCall(ctx, "AddLabelsToIssue", func(ctx context.Context) ([]*github.Label, *github.Response, error) {
+			return cli.Issues.AddLabelsToIssue(ctx, repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber(), []string{staleStubLabel})
+		})
+		if err != nil {
+			return fmt.Errorf("label stub issue: %w", err)
+		}
+		p.logf("labeled stub issue #%d %s", issue.GetNumber(), staleStubLabel)
+	}
+	return nil
+}
+
+// syncStubIssue copies the change to the labels or milestone of the PR
+// described by e onto its stub issue, if the repository's syncStubs setting
+// asks for it.
+func syncStubIssue(ctx context.Context, e *github.PullRequestEvent) error {
+	p := pullRequest{repo: e.GetRepo(), pr: e.GetPullRequest()}
+	if !repoEnabled(p.repo.GetFullName()) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	cli := apiClient()
+	cfg, err := loadRepoConfig(ctx, cli, p.repo)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	p.cfg = cfg
+	if !cfg.syncStubs() || cfg.stubTracker() != trackerGitHub {
+		return nil
+	}
+	issue, err := p.openStub(ctx, cli)
+	if issue == nil {
+		return err
+	}
+	owner, repoName, n := p.repo.GetOwner().GetLogin(), p.repo.GetName(), issue.GetNumber()
+	if *shadowMode {
+		p.logf("shadow: would sync stub issue #%d (%s)", n, e.GetAction())
+		return nil
+	}
+
+	switch label := e.GetLabel().GetName(); e.GetAction() {
+	case "labeled":
+		_, _, err = retryCall(ctx, "AddLabelsToIssue", func(ctx context.Context) ([]*github.Label, *github.Response, error) {
+			return cli.Issues.AddLabelsToIssue(ctx, owner, repoName, n, []string{label})
+		})
+	case "unlabeled":
+		if label == issuebotStubLabel {
+			return nil
+		}
+		_, _, err = retryCall(ctx, "RemoveLabelForIssue", func(ctx context.Context) (struct{}, *github.Response, error) {
+			resp, err := cli.Issues.RemoveLabelForIssue(ctx, owner, repoName, n, label)
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				err = nil // not on the stub issue
+			}
+			return struct{}{}, resp, err
+		})
+	case "milestoned", "demilestoned":
+		if m := p.pr.GetMilestone(); m != nil {
+			_, _, err = retryCall(ctx, "EditIssue", func(ctx context.Context) (*github.Issue, *github.Response, error) {
+				return cli.Issues.Edit(ctx, owner, repoName, n, &github.IssueRequest{Milestone: m.Number})
+			})
+		} else {
+			_, _, err = retryCall(ctx, "RemoveMilestone", func(ctx context.Context) (*github.Issue, *github.Response, error) {
+				return cli.Issues.RemoveMilestone(ctx, owner, repoName, n)
+			})
+		}
+	default:
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("sync stub issue #%d (%s): %w", n, e.GetAction(), err)
+	}
+	p.logf("synced stub issue #%d (%s)", n, e.GetAction())
+	return nil
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestCloseStubIssue(t *testing.T) {
+	var (
+		stub     github.Issue
+		comments []string
+		closed   bool
+	)
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/issues/1/comments":
+			w.Write([]byte(`[{"body": "IssueBot here. I have filed issue #7 for you.\n\n<!-- issuebot:stub #7 -->"}]`))
+		case "/repos/o/r/issues/7/comments":
+			var c github.IssueComment
+			json.NewDecoder(r.Body).Decode(&c)
+			comments = append(comments, c.GetBody())
+			json.NewEncoder(w).Encode(c)
+		case "/repos/o/r/issues/7":
+			if r.Method == "PATCH" {
+				var req github.IssueRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				closed = req.GetState() == "closed"
+			}
+			json.NewEncoder(w).Encode(stub)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(1)},
+	}
+	stubLabel := []*github.Label{{Name: github.Ptr(issuebotStubLabel)}}
+	title, body := github.Ptr("Placeholder issue for PR #1"), github.Ptr("TODO(@): Add details about PR #1")
+	tests := []struct {
+		name string
+		stub github.Issue
+		want bool
+	}{
+		{"placeholder", github.Issue{State: github.Ptr("open"), Title: title, Body: body, Labels: stubLabel}, true},
+		{"closed", github.Issue{State: github.Ptr("closed"), Title: title, Body: body, Labels: stubLabel}, false},
+		{"retitled", github.Issue{State: github.Ptr("open"), Title: github.Ptr("Frobnicator is broken"), Body: body, Labels: stubLabel}, false},
+		{"edited", github.Issue{State: github.Ptr("open"), Title: title, Body: github.Ptr("The frobnicator is broken."), Labels: stubLabel}, false},
+		{"unlabeled", github.Issue{State: github.Ptr("open"), Title: title, Body: body}, false},
+	}
+	for _, tc := range tests {
+		stub, comments, closed = tc.stub, nil, false
+		stub.Number = github.Ptr(7)
+		if err := p.closeStubIssue(t.Context(), cli, "#3"); err != nil {
+			t.Errorf("closeStubIssue(%s): unexpected error: %v", tc.name, err)
+		} else if closed != tc.want {
+			t.Errorf("closeStubIssue(%s): closed %v, want %v", tc.name, closed, tc.want)
+		}
+		if tc.want && (len(comments) != 1 || !strings.Contains(comments[0], "now links to #3")) {
+			t.Errorf("closeStubIssue(%s): comments %q, want one mentioning #3", tc.name, comments)
+		}
+	}
+}
+
+func TestLinkStubIssue(t *testing.T) {
+	var current, body string // the description on GitHub, and the one written
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/o/r/pulls/1" {
+			http.NotFound(w, r)
+			return
+		}
+		pr := github.PullRequest{Number: github.Ptr(1), Body: github.Ptr(current)}
+		if r.Method == "PATCH" {
+			json.NewDecoder(r.Body).Decode(&pr)
+			body = pr.GetBody()
+		}
+		json.NewEncoder(w).Encode(pr)
+	}))
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(1), Body: github.Ptr("Fix the frobnicator.\r\n")},
+	}
+
+	// The author has edited the description since the webhook.
+	current = "Fix the frobnicator, finally.\r\n"
+	if err := p.linkStubIssue(t.Context(), cli, 7); err != nil {
+		t.Fatalf("linkStubIssue: unexpected error: %v", err)
+	}
+	if want := "Fix the frobnicator, finally.\n\nCloses #7 <!-- issuebot:stub-link -->"; body != want {
+		t.Errorf("linkStubIssue: body %q, want %q", body, want)
+	}
+
+	// A PR that is already linked is left alone.
+	current, body = body, ""
+	if err := p.linkStubIssue(t.Context(), cli, 7); err != nil || body != "" {
+		t.Errorf("linkStubIssue: got body %q, err %v; want no edit", body, err)
+	}
+}
+
+func TestSyncStubsOnCreate(t *testing.T) {
+	var got github.IssueRequest
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/issues":
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(`{"number": 7}`))
+		case "/repos/o/r/issues/1/comments":
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	cfg, err := parseRepoConfig([]byte(`syncStubs: true`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr: &github.PullRequest{
+			Number:    github.Ptr(1),
+			Labels:    []*github.Label{{Name: github.Ptr("area/dns")}},
+			Milestone: &github.Milestone{Number: github.Ptr(3)},
+		},
+		cfg: cfg,
+	}
+	if n, err := p.createStubIssue(t.Context(), cli); n != 7 || err != nil {
+		t.Fatalf("createStubIssue: got %d, %v; want 7, nil", n, err)
+	}
+	if want := []string{issuebotStubLabel, "area/dns"}; !slices.Equal(got.GetLabels(), want) {
+		t.Errorf("stub labels: got %q, want %q", got.GetLabels(), want)
+	}
+	if got.GetMilestone() != 3 {
+		t.Errorf("stub milestone: got %d, want 3", got.GetMilestone())
+	}
+}
+
+func TestCheckStubIssue(t *testing.T) {
+	var (
+		results  []*github.Issue
+		comments []*github.IssueComment
+		query    string
+	)
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search/issues":
+			query = r.URL.Query().Get("q")
+			json.NewEncoder(w).Encode(github.IssuesSearchResult{Issues: results})
+		case "/repos/o/r/issues/1/comments":
+			json.NewEncoder(w).Encode(comments)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	stub := func(num, pr int, title string) *github.Issue {
+		body := fmt.Sprintf("TODO(@alice): Add details about PR #%d", pr)
+		return &github.Issue{Number: github.Ptr(num), Title: github.Ptr(title), Body: github.Ptr(body + stubBodyMarker(pr, body))}
+	}
+	comment := func(login, kind, body string) *github.IssueComment {
+		return &github.IssueComment{User: &github.User{Login: github.Ptr(login), Type: github.Ptr(kind)}, Body: github.Ptr(body)}
+	}
+	tests := []struct {
+		name     string
+		prBody   string
+		results  []*github.Issue
+		comments []*github.IssueComment
+		want     int
+	}{
+		{"none", "", nil, nil, 0},
+		{"found", "", []*github.Issue{stub(12, 11, "Placeholder issue for PR #11"), stub(7, 1, "Placeholder issue for PR #1")}, nil, 7},
+		{"retitled", "", []*github.Issue{stub(7, 1, "The frobnicator is broken")}, nil, 7},
+		{"unmarked", "", []*github.Issue{{Number: github.Ptr(7), Title: github.Ptr("Placeholder issue for PR #1")}}, nil, 7},
+		{"other PR", "", []*github.Issue{stub(12, 11, "Placeholder issue for PR #11")}, nil, 0},
+		{"description", "Fix it.\n\nCloses #8 <!-- issuebot:stub-link -->", nil, nil, 8},
+		{"marker comment", "", nil, []*github.IssueComment{comment("bob", "User", "Done"), comment("issuebot[bot]", "Bot", "Filed.\n\n<!-- issuebot:stub #9 -->")}, 9},
+		{"legacy comment", "", nil, []*github.IssueComment{comment("issuebot[bot]", "Bot", ":robot: IssueBot here. I noticed none of the commits on this PR has an issue attached. I have filed issue #9 for you.")}, 9},
+		{"quoted comment", "", nil, []*github.IssueComment{comment("bob", "User", "> IssueBot here. I have filed issue #9 for you.")}, 0},
+	}
+	for _, tc := range tests {
+		results, comments = tc.results, tc.comments
+		p := pullRequest{
+			repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+			pr:   &github.PullRequest{Number: github.Ptr(1), Body: github.Ptr(tc.prBody), User: &github.User{Login: github.Ptr("alice")}},
+		}
+		got, err := p.checkStubIssue(t.Context(), cli)
+		if err != nil {
+			t.Errorf("checkStubIssue(%s): unexpected error: %v", tc.name, err)
+		} else if got != tc.want {
+			t.Errorf("checkStubIssue(%s): got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+	if want := `repo:o/r is:issue is:open label:issuebot-stub "PR #1" in:title,body`; query != want {
+		t.Errorf("search query: got %q, want %q", query, want)
+	}
+}
+
+func TestStubAssignees(t *testing.T) {
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/orgs/o/teams/triage/members":
+			w.Write([]byte(`[{"login": "carol"}, {"login": "dave"}]`))
+		case "/orgs/o/teams/empty/members":
+			w.Write([]byte(`[]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	tests := []struct {
+		config      string
+		association string
+		want        []string
+		err         bool
+	}{
+		{"", "MEMBER", []string{"alice"}, false},
+		{"", "CONTRIBUTOR", []string{"alice"}, false},
+		{"stubAssignee: bob", "MEMBER", []string{"bob"}, false},
+		{"stubAssignee: '@o/triage'", "MEMBER", []string{"carol", "dave"}, false},
+		{"externalStubAssignee: o/triage", "COLLABORATOR", []string{"alice"}, false},
+		{"externalStubAssignee: o/triage", "FIRST_TIME_CONTRIBUTOR", []string{"carol", "dave"}, false},
+		{"{stubAssignee: bob, externalStubAssignee: author}", "NONE", []string{"alice"}, false},
+		{"stubAssignee: o/empty", "MEMBER", nil, true},
+		{"stubAssignee: o/missing", "MEMBER", nil, true},
+	}
+	for _, tc := range tests {
+		cfg, err := parseRepoConfig([]byte(tc.config))
+		if err != nil {
+			t.Fatalf("parseRepoConfig(%q): unexpected error: %v", tc.config, err)
+		}
+		p := pullRequest{
+			repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+			pr:   &github.PullRequest{Number: github.Ptr(1), User: &github.User{Login: github.Ptr("alice")}, AuthorAssociation: github.Ptr(tc.association)},
+			cfg:  cfg,
+		}
+		got, err := p.stubAssignees(t.Context(), cli)
+		if (err != nil) != tc.err {
+			t.Errorf("stubAssignees(%q, %s): got error %v, want error %v", tc.config, tc.association, err, tc.err)
+		} else if !slices.Equal(got, tc.want) {
+			t.Errorf("stubAssignees(%q, %s): got %q, want %q", tc.config, tc.association, got, tc.want)
+		}
+	}
+
+	for _, config := range []string{"stubAssignee: o/t/x", "externalStubAssignee: 'a b'"} {
+		if _, err := parseRepoConfig([]byte(config)); err == nil {
+			t.Errorf("parseRepoConfig(%q): got nil error", config)
+		}
+	}
+}
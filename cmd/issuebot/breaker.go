@@ -0,0 +1,187 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const (
+	// breakerThreshold is the number of consecutive transient failures from
+	// the GitHub API that cause the circuit breaker to trip.
+	breakerThreshold = 5
+
+	// breakerProbeInterval is how often we probe the GitHub API while the
+	// circuit breaker is open, to find out whether it has recovered.
+	breakerProbeInterval = 30 * time.Second
+)
+
+// errBreakerOpen is reported for GitHub API calls that were not attempted
+// because the circuit breaker is open.
+var errBreakerOpen = errors.New("GitHub API circuit breaker is open")
+
+// The states of the circuit breaker.
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // calls are allowed
+	breakerOpen                         // calls are blocked
+	breakerHalfOpen                     // one call is allowed, to probe the API
+)
+
+var breaker = struct {
+	sync.Mutex
+	now      func() time.Time // the clock, which tests replace
+	state    breakerState
+	failures int                      // consecutive transient failures
+	openedAt time.Time                // when the breaker last opened
+	deferred map[string]deferredCheck // :: string repo#PR → deferred check
+}{
+	now:      time.Now,
+	deferred: make(map[string]deferredCheck),
+}
+
+// A deferredCheck is a check of a PR that waits for the circuit breaker to
+// close, with the webhook delivery whose event queue entry it owns, if any.
+type deferredCheck struct {
+	p  pullRequest
+	id string
+}
+
+// probeBreaker is started in a goroutine when the circuit breaker trips, to
+// probe the GitHub API until it recovers even if no other calls are made.
+// Tests replace it.
+var probeBreaker func()
+
+func init() {
+	probeBreaker = probeUntilHealthy
+}
+
+// breakerAllow reports errBreakerOpen if the circuit breaker is open, and
+// otherwise nil. Once breakerProbeInterval has passed since the breaker
+// opened, it lets one call through as a probe, reporting probe true, and
+// blocks the rest until that call's outcome is recorded by breakerRecord or
+// given up by breakerAbandon.
+func breakerAllow() (probe bool, err error) {
+	breaker.Lock()
+	defer breaker.Unlock()
+	switch breaker.state {
+	case breakerOpen:
+		if breaker.now().Sub(breaker.openedAt) < breakerProbeInterval {
+			return false, errBreakerOpen
+		}
+		breaker.state = breakerHalfOpen
+		return true, nil
+	case breakerHalfOpen:
+		return false, errBreakerOpen
+	}
+	return false, nil
+}
+
+// breakerAbandon gives up the probe that breakerAllow let through, when its
+// outcome will not be recorded, e.g. because its caller went away. The
+// breaker opens again as it was, so that the next call may probe at once.
+func breakerAbandon() {
+	breaker.Lock()
+	defer breaker.Unlock()
+	if breaker.state == breakerHalfOpen {
+		breaker.state = breakerOpen
+	}
+}
+
+// breakerRecord updates the circuit breaker with the outcome of a GitHub API
+// call that returned resp and err. Transient failures count toward tripping
+// the breaker, and reopen it if the call was a probe; any other outcome
+// resets the count and closes the breaker, running any checks that were
+// deferred while it was open.
+func breakerRecord(resp *github.Response, err error) {
+	breaker.Lock()
+	if err == nil || !isTransient(resp, err) {
+		breaker.failures = 0
+		if breaker.state == breakerClosed {
+			breaker.Unlock()
+			return
+		}
+		breaker.state = breakerClosed
+		pending := breaker.deferred
+		breaker.deferred = make(map[string]deferredCheck)
+		breaker.Unlock()
+
+		log.Printf("GitHub API circuit breaker closed; running %d deferred checks", len(pending))
+		go func() {
+			for _, dc := range pending {
+				recheck(rootCtx, dc.p.pr, dc.p.repo, dc.id)
+			}
+		}()
+		return
+	}
+	defer breaker.Unlock()
+	switch breaker.state {
+	case breakerClosed:
+		breaker.failures++
+		if breaker.failures >= breakerThreshold {
+			log.Printf("GitHub API circuit breaker tripped after %d consecutive failures", breaker.failures)
+			breaker.state, breaker.openedAt = breakerOpen, breaker.now()
+			breakerTrips.Add(1)
+			go probeBreaker()
+		}
+	case breakerHalfOpen:
+		log.Printf("GitHub API probe failed (breaker remains open): %v", err)
+		breaker.state, breaker.openedAt = breakerOpen, breaker.now()
+	}
+}
+
+// deferCheck records that the check of pr should be run once the circuit
+// breaker closes, taking over the event queue entry of the webhook delivery
+// id, if any. Only the most recent request for each PR is kept; the queue
+// entry of one it replaces is removed, since the new one covers the PR, unless
+// the new one has none.
+func deferCheck(pr *github.PullRequest, repo *github.Repository, id string) {
+	p := pullRequest{repo: repo, pr: pr}
+	p.logf("GitHub API unavailable, deferring check")
+	key := fmt.Sprintf("%s#%d", repo.GetFullName(), pr.GetNumber())
+
+	breaker.Lock()
+	old := breaker.deferred[key]
+	if id == "" {
+		id = old.id
+	}
+	breaker.deferred[key] = deferredCheck{p: p, id: id}
+	breaker.Unlock()
+	if old.id != id {
+		dequeueEvent(old.id)
+	}
+}
+
+// probeUntilHealthy periodically probes the GitHub API, when the circuit
+// breaker allows it, until the breaker closes.
+func probeUntilHealthy() {
+	for {
+		time.Sleep(breakerProbeInterval)
+		if rootCtx.Err() != nil {
+			return
+		}
+		breaker.Lock()
+		closed := breaker.state == breakerClosed
+		breaker.Unlock()
+		if closed {
+			return
+		}
+		if _, err := breakerAllow(); err != nil {
+			continue // another call is probing
+		}
+		ctx, cancel := context.WithTimeout(rootCtx, apiCallTimeout)
+		_, resp, err := apiClient().RateLimit.Get(ctx)
+		cancel()
+		breakerRecord(resp, err)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// fakeBreaker gives the circuit breaker a fake clock, which the returned
+// function advances, and no prober. The breaker is closed again when the test
+// ends.
+func fakeBreaker(t *testing.T) (advance func(time.Duration)) {
+	now := time.Now()
+	breaker.Lock()
+	oldNow := breaker.now
+	breaker.now = func() time.Time { return now }
+	breaker.Unlock()
+	oldProbe := probeBreaker
+	probeBreaker = func() {}
+	t.Cleanup(func() {
+		breaker.Lock()
+		defer breaker.Unlock()
+		breaker.now, breaker.state, breaker.failures = oldNow, breakerClosed, 0
+		probeBreaker = oldProbe
+	})
+	return func(d time.Duration) {
+		breaker.Lock()
+		defer breaker.Unlock()
+		now = now.Add(d)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	advance := fakeBreaker(t)
+	failure := &github.Response{Response: &http.Response{StatusCode: http.StatusBadGateway}}
+	errFailure := errors.New("bad gateway")
+	state := func() breakerState {
+		breaker.Lock()
+		defer breaker.Unlock()
+		return breaker.state
+	}
+
+	// Failures short of the threshold, or broken up by a success, leave the
+	// breaker closed.
+	for range breakerThreshold - 1 {
+		breakerRecord(failure, errFailure)
+	}
+	breakerRecord(nil, nil)
+	for range breakerThreshold - 1 {
+		breakerRecord(failure, errFailure)
+	}
+	if got := state(); got != breakerClosed {
+		t.Fatalf("after %d failures: got state %v, want closed", breakerThreshold-1, got)
+	}
+	if _, err := breakerAllow(); err != nil {
+		t.Fatalf("breakerAllow when closed: %v", err)
+	}
+
+	trips := breakerTrips.Value()
+	breakerRecord(failure, errFailure)
+	if got := state(); got != breakerOpen {
+		t.Fatalf("after %d failures: got state %v, want open", breakerThreshold, got)
+	}
+	if got := breakerTrips.Value() - trips; got != 1 {
+		t.Errorf("breaker trips: got %d, want 1", got)
+	}
+	if _, err := breakerAllow(); !errors.Is(err, errBreakerOpen) {
+		t.Errorf("breakerAllow when open: got %v, want %v", err, errBreakerOpen)
+	}
+
+	// After the probe interval, one call is let through.
+	advance(breakerProbeInterval)
+	if _, err := breakerAllow(); err != nil {
+		t.Fatalf("breakerAllow after %v: %v", breakerProbeInterval, err)
+	}
+	if got := state(); got != breakerHalfOpen {
+		t.Fatalf("probing: got state %v, want half-open", got)
+	}
+	if _, err := breakerAllow(); !errors.Is(err, errBreakerOpen) {
+		t.Errorf("breakerAllow when half-open: got %v, want %v", err, errBreakerOpen)
+	}
+
+	// A failed probe reopens the breaker for another interval.
+	breakerRecord(failure, errFailure)
+	if got := state(); got != breakerOpen {
+		t.Fatalf("after a failed probe: got state %v, want open", got)
+	}
+	advance(breakerProbeInterval / 2)
+	if _, err := breakerAllow(); !errors.Is(err, errBreakerOpen) {
+		t.Errorf("breakerAllow soon after a failed probe: got %v, want %v", err, errBreakerOpen)
+	}
+	advance(breakerProbeInterval / 2)
+	if _, err := breakerAllow(); err != nil {
+		t.Fatalf("breakerAllow after another %v: %v", breakerProbeInterval, err)
+	}
+
+	// A successful probe closes it.
+	breakerRecord(nil, nil)
+	if got := state(); got != breakerClosed {
+		t.Fatalf("after a successful probe: got state %v, want closed", got)
+	}
+	if _, err := breakerAllow(); err != nil {
+		t.Errorf("breakerAllow after closing: %v", err)
+	}
+}
+
+func TestBreakerProbeCancelled(t *testing.T) {
+	advance := fakeBreaker(t)
+	failure := &github.Response{Response: &http.Response{StatusCode: http.StatusBadGateway}}
+	for range breakerThreshold {
+		breakerRecord(failure, errors.New("bad gateway"))
+	}
+	advance(breakerProbeInterval)
+
+	// The probe's caller goes away before it returns.
+	ctx, cancel := context.WithCancel(t.Context())
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		http.Error(w, "oops", http.StatusBadGateway)
+	}))
+	if _, _, err := retryCall(ctx, "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+		return cli.PullRequests.Get(ctx, "o", "r", 1)
+	}); err == nil {
+		t.Fatalf("retryCall: got no error, want one")
+	}
+
+	// The breaker is open as before, so the next call probes at once.
+	breaker.Lock()
+	state := breaker.state
+	breaker.Unlock()
+	if state != breakerOpen {
+		t.Errorf("after an abandoned probe: got state %v, want open", state)
+	}
+	if probe, err := breakerAllow(); !probe || err != nil {
+		t.Errorf("breakerAllow after an abandoned probe: got %v, %v; want a probe", probe, err)
+	}
+}
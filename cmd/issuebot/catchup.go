@@ -0,0 +1,123 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v72/github"
+)
+
+// getGitHubAppClient returns a GitHub API client that authenticates as the
+// app itself rather than as an installation. Some APIs, such as the webhook
+// delivery log, are only available to the app.
+func getGitHubAppClient() (*github.Client, error) {
+	tr, err := ghinstallation.NewAppsTransport(http.DefaultTransport, appId, appPrivateKey())
+	if err != nil {
+		return nil, err
+	}
+	return github.NewClient(&http.Client{Transport: tr}), nil
+}
+
+// catchUpEvents are the webhook events that processWebhook acts on, whose
+// failed deliveries catchUpDeliveries has redelivered.
+var catchUpEvents = []string{
+	"pull_request",
+	"pull_request_review",
+	"issue_comment",
+	"check_run",
+	"check_suite",
+	"merge_group",
+	"push",
+	"installation",
+	"installation_repositories",
+}
+
+// catchUpDeliveries scans the app's webhook delivery log, using appClient,
+// which is authenticated as the app, for deliveries of catchUpEvents in the
+// last window that never received a successful response from us, and asks
+// GitHub to redeliver them.
+func catchUpDeliveries(ctx context.Context, appClient *github.Client, window time.Duration) error {
+	// Deliveries are listed newest first. A single event (GUID) may have been
+	// delivered several times; it needs no further attention if any of those
+	// deliveries succeeded.
+	cutoff := time.Now().Add(-window)
+	succeeded := make(map[string]bool) // :: GUID → some delivery succeeded
+	failed := make(map[string]int64)   // :: GUID → latest failed delivery ID
+	opts := &github.ListCursorOptions{PerPage: 100}
+scan:
+	for {
+		ds, resp, err := retryCall(ctx, "ListHookDeliveries", func(ctx context.Context) ([]*github.HookDelivery, *github.Response, error) {
+			return appClient.Apps.ListHookDeliveries(ctx, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("list deliveries: %w", err)
+		}
+		for _, d := range ds {
+			if d.GetDeliveredAt().Before(cutoff) {
+				break scan
+			}
+			if !slices.Contains(catchUpEvents, d.GetEvent()) || d.GetInstallationID() != appInstall {
+				continue
+			}
+			guid := d.GetGUID()
+			if code := d.GetStatusCode(); code >= 200 && code < 300 {
+				succeeded[guid] = true
+			} else if _, ok := failed[guid]; !ok {
+				failed[guid] = d.GetID()
+			}
+		}
+		if resp.Cursor == "" {
+			break
+		}
+		opts.Cursor = resp.Cursor
+	}
+
+	var nr int
+	for guid, id := range failed {
+		if succeeded[guid] {
+			continue
+		} else if *shadowMode {
+			log.Printf("shadow: would request redelivery of %q", guid)
+			continue
+		}
+		if _, _, err := retryCall(ctx, "RedeliverHookDelivery", func(ctx context.Context) (*github.HookDelivery, *github.Response, error) {
+			return appClient.Apps.RedeliverHookDelivery(ctx, id)
+		}); err != nil {
+			log.Printf("error requesting redelivery of %q (continuing): %v", guid, err)
+			continue
+		}
+		nr++
+		deliveriesRequested.Add(1)
+	}
+	log.Printf("Catch-up: requested redelivery of %d missed events", nr)
+	return nil
+}
+
+// runCatchUp runs catchUpDeliveries once, and then repeatedly at the given
+// interval if it is positive.
+func runCatchUp(window, interval time.Duration) {
+	for {
+		if appClient, err := getGitHubAppClient(); err != nil {
+			log.Printf("Catch-up failed: app client: %v", err)
+		} else if err := catchUpDeliveries(rootCtx, appClient, window); err != nil {
+			log.Printf("Catch-up failed: %v", err)
+		}
+		if interval <= 0 {
+			return
+		}
+		select {
+		case <-rootCtx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
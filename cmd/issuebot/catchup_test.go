@@ -0,0 +1,87 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestCatchUpDeliveries(t *testing.T) {
+	defer func(v int64) { appInstall = v }(appInstall)
+	appInstall = 2
+
+	now := time.Now()
+	delivery := func(id int64, guid, event string, code int, age time.Duration, install int64) *github.HookDelivery {
+		return &github.HookDelivery{
+			ID:             github.Ptr(id),
+			GUID:           github.Ptr(guid),
+			Event:          github.Ptr(event),
+			StatusCode:     github.Ptr(code),
+			DeliveredAt:    &github.Timestamp{Time: now.Add(-age)},
+			InstallationID: github.Ptr(install),
+		}
+	}
+	// Newest first, as GitHub lists them, over two pages.
+	pages := [][]*github.HookDelivery{{
+		delivery(10, "pr", "pull_request", 500, time.Minute, 2),
+		delivery(9, "comment", "issue_comment", 502, 2*time.Minute, 2),
+		delivery(8, "push", "push", 200, 3*time.Minute, 2), // a later success
+		delivery(7, "push", "push", 503, 4*time.Minute, 2),
+		delivery(6, "other", "check_run", 500, 5*time.Minute, 3), // another installation
+	}, {
+		delivery(5, "ping", "ping", 500, 6*time.Minute, 2), // not acted on
+		delivery(4, "review", "pull_request_review", 0, 7*time.Minute, 2),
+		delivery(3, "review", "pull_request_review", 500, 8*time.Minute, 2), // an older failure
+		delivery(2, "old", "pull_request", 500, 2*time.Hour, 2),             // before the window
+	}}
+	var redelivered []int64
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/app/hook/deliveries":
+			page := 0
+			if r.FormValue("cursor") == "next" {
+				page = 1
+			} else {
+				w.Header().Set("Link", fmt.Sprintf(`<%s?cursor=next>; rel="next"`, r.URL.Path))
+			}
+			json.NewEncoder(w).Encode(pages[page])
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/app/hook/deliveries/"):
+			id, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/app/hook/deliveries/"), "/attempts"), 10, 64)
+			redelivered = append(redelivered, id)
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	if err := catchUpDeliveries(t.Context(), cli, time.Hour); err != nil {
+		t.Fatalf("catchUpDeliveries: %v", err)
+	}
+	slices.Sort(redelivered)
+	if want := []int64{4, 9, 10}; !slices.Equal(redelivered, want) {
+		t.Errorf("catchUpDeliveries: redelivered %v, want %v", redelivered, want)
+	}
+
+	// In shadow mode, nothing is redelivered.
+	defer func(v bool) { *shadowMode = v }(*shadowMode)
+	*shadowMode = true
+	redelivered = nil
+	if err := catchUpDeliveries(t.Context(), cli, time.Hour); err != nil {
+		t.Fatalf("catchUpDeliveries in shadow mode: %v", err)
+	}
+	if len(redelivered) != 0 {
+		t.Errorf("catchUpDeliveries in shadow mode: redelivered %v, want none", redelivered)
+	}
+}
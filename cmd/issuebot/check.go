@@ -0,0 +1,118 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/tailscale/setec/client/setec"
+)
+
+const checkUsage = `Usage: issuebot check [-post] owner/repo#N ...
+
+Check each pull request, given as owner/repo#N or by its URL, as the server
+would check it when notified by a webhook. How the check reached its decision
+is logged to stderr, and the decision is printed to stdout. The exit status
+is non-zero if any PR fails the check.
+
+By default nothing is changed on GitHub. With -post, the commit status is
+posted, and stub issues and comments are filed as the server would.
+
+GitHub is accessed as "issuebot help" describes.
+`
+
+// runCheck implements the check subcommand, and returns the process exit
+// code.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	post := fs.Bool("post", false, "post the commit status, and file stub issues and comments, as the server would")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), checkUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	var refs []issueRef
+	for _, arg := range fs.Args() {
+		r := parseIssueRefs(arg)
+		if len(r) != 1 || r[0].Repo == "" {
+			fmt.Fprintf(os.Stderr, "invalid pull request %q, want owner/repo#N\n", arg)
+			return 2
+		}
+		refs = append(refs, r[0])
+	}
+
+	cli, err := toolClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+		return 2
+	}
+	clientUpdater = setec.StaticUpdater(cli)
+	*shadowMode = !*post
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout*time.Duration(len(refs)))
+	defer cancel()
+	status := 0
+	for _, ref := range refs {
+		passed, err := checkOnePull(ctx, cli, ref, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", ref, err)
+			status = 1
+		} else if !passed {
+			status = 1
+		}
+	}
+	return status
+}
+
+// checkOnePull checks the PR ref for the check subcommand, and writes the
+// decision to w. It reports whether the PR passed.
+func checkOnePull(ctx context.Context, cli *github.Client, ref issueRef, w io.Writer) (bool, error) {
+	owner, name, _ := strings.Cut(ref.Repo, "/")
+	pr, _, err := retryCall(ctx, "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+		return cli.PullRequests.Get(ctx, owner, name, ref.Number)
+	})
+	if err != nil {
+		return false, fmt.Errorf("get PR: %w", err)
+	}
+	repo := pr.GetBase().GetRepo()
+	cfg, err := fetchRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return false, fmt.Errorf("load config: %w", err)
+	}
+	p := pullRequest{repo: repo, pr: pr, cfg: cfg}
+	if pr.GetDraft() && cfg.skipDrafts() {
+		fmt.Fprintf(w, "%v: not checked: %s\n", ref, p.statusDescription(draftStatusTemplate))
+		return true, p.annotateCommitStatus(ctx, pr.GetHead().GetSHA(), "pending", p.statusDescription(draftStatusTemplate))
+	}
+	status, err := p.evaluate(ctx, cli)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case status != prFailed:
+		fmt.Fprintf(w, "%v: passed (%v)\n", ref, status)
+	case cfg.advisory():
+		fmt.Fprintf(w, "%v: passed (advisory): %s\n", ref, p.statusDescription(advisoryStatusTemplate))
+	default:
+		fmt.Fprintf(w, "%v: failed: %s\n", ref, p.failureDescription())
+	}
+	if err := p.reportStatus(ctx, cli, status); err != nil {
+		return false, err
+	}
+	return status != prFailed || cfg.advisory(), nil
+}
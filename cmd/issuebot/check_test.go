@@ -0,0 +1,55 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckOnePull(t *testing.T) {
+	defer func(old bool) { *shadowMode = old }(*shadowMode)
+	*shadowMode = true
+
+	var message string
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/o/r/pulls/1":
+			fmt.Fprint(w, `{"number":1,"additions":500,"deletions":0,"head":{"sha":"abc"},"base":{"repo":{"name":"r","full_name":"o/r","owner":{"login":"o"}}}}`)
+		case r.URL.Path == "/repos/o/r/pulls/1/commits":
+			fmt.Fprintf(w, `[{"sha":%q,"commit":{"message":%q}}]`, fakeSHA(message), message)
+		case r.URL.Path == "/repos/o/r/issues/1/comments":
+			fmt.Fprint(w, "[]")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	tests := []struct {
+		message string
+		passed  bool
+		want    string
+	}{
+		{"Fix the frobnicator\n\nFixes #12", true, "o/r#1: passed (linked)\n"},
+		{"Fix the frobnicator", false, "o/r#1: failed: "},
+	}
+	for _, tc := range tests {
+		message = tc.message
+		var out strings.Builder
+		passed, err := checkOnePull(t.Context(), cli, issueRef{Repo: "o/r", Number: 1}, &out)
+		if err != nil {
+			t.Errorf("checkOnePull(%q): unexpected error: %v", tc.message, err)
+			continue
+		}
+		if passed != tc.passed {
+			t.Errorf("checkOnePull(%q): got passed=%v, want %v", tc.message, passed, tc.passed)
+		}
+		if got := out.String(); !strings.HasPrefix(got, tc.want) {
+			t.Errorf("checkOnePull(%q): got %q, want prefix %q", tc.message, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// Identifiers of the actions a failing check run offers (see --check-runs).
+// GitHub limits identifiers to 20 characters.
+const (
+	actionRecheck = "recheck" // check the PR again
+	actionStub    = "stub"    // file a stub issue, like the stub command
+)
+
+// postCheckRun reports the result of a check as a completed check run on
+// headSHA, with the given conclusion ("success" or "failure", or "pending"
+// for a check deferred until later, which is reported as neutral) and
+// optional description. A failing check run offers buttons to check the PR
+// again, and to file a stub issue for it if the repository has them.
+func (p pullRequest) postCheckRun(ctx context.Context, headSHA, conclusion, description string) error {
+	var title string
+	switch conclusion {
+	case "success":
+		title = "Passed"
+	case "pending":
+		title, conclusion = "Not checked yet", "neutral"
+	default:
+		title = "No linked issue"
+	}
+	summary := description
+	if summary == "" {
+		summary = title
+	}
+	opts := github.CreateCheckRunOptions{
+		Name:       *statusContext,
+		HeadSHA:    headSHA,
+		Status:     github.Ptr("completed"),
+		Conclusion: github.Ptr(conclusion),
+		Output: &github.CheckRunOutput{
+			Title:   github.Ptr(title),
+			Summary: github.Ptr(summary),
+		},
+	}
+	if conclusion == "failure" {
+		opts.Actions = append(opts.Actions, &github.CheckRunAction{
+			Label:       "Re-check",
+			Description: "Check this PR again",
+			Identifier:  actionRecheck,
+		})
+		if p.cfg.stubIssues() && *enableStubIssues {
+			opts.Actions = append(opts.Actions, &github.CheckRunAction{
+				Label:       "Create stub",
+				Description: "File a stub issue for this PR",
+				Identifier:  actionStub,
+			})
+		}
+	}
+	_, _, err := retryCall(ctx, "CreateCheckRun", func(ctx context.Context) (*github.CheckRun, *github.Response, error) {
+		return apiClient().Checks.CreateCheckRun(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), opts)
+	})
+	if err != nil {
+		return fmt.Errorf("postCheckRun: %w", err)
+	}
+	return nil
+}
+
+// checkRunConclusion returns the conclusion of the latest check run posted by
+// this instance of issuebot on the commit sha in repo, or "" if there is none.
+func checkRunConclusion(ctx context.Context, repo *github.Repository, sha string) (string, error) {
+	runs, _, err := retryCall(ctx, "ListCheckRunsForRef", func(ctx context.Context) (*github.ListCheckRunsResults, *github.Response, error) {
+		return apiClient().Checks.ListCheckRunsForRef(ctx, repo.GetOwner().GetLogin(), repo.GetName(), sha, &github.ListCheckRunsOptions{
+			CheckName: statusContext,
+			AppID:     github.Ptr(appId),
+			Filter:    github.Ptr("latest"),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	var latest *github.CheckRun
+	for _, run := range runs.CheckRuns {
+		if latest == nil || run.GetID() > latest.GetID() {
+			latest = run
+		}
+	}
+	return latest.GetConclusion(), nil
+}
+
+// stubRequestMarker is appended to the comment recording a request for a stub
+// issue made with the "Create stub" button, containing a %s for the user who
+// asked and a %s for the signature of the request (see signStubRequest).
+const stubRequestMarker = "\n\n<!-- issuebot:stub-requested-by @%s %s -->"
+
+// stubRequestRE matches stubRequestMarker. The submatches are the user and
+// the signature.
+var stubRequestRE = regexp.MustCompile(`<!-- issuebot:stub-requested-by @(\S+) ([0-9a-f]{16}) -->\s*$`)
+
+// signStubRequest returns the signature of a request by the user with the
+// given login for a stub issue for the PR, made with secret. The signature
+// shows that the request was recorded by issuebot, which checked that the
+// user may ask for a stub, and not written by someone else.
+func (p pullRequest) signStubRequest(secret []byte, login string) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s#%d@%s", p.repo.GetFullName(), p.pr.GetNumber(), login)
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// stubRequester reports whether comment records a request for a stub issue
+// for the PR made with the "Create stub" button, and if so, who made it.
+func (p pullRequest) stubRequester(comment *github.IssueComment) (string, bool) {
+	m := stubRequestRE.FindStringSubmatch(comment.GetBody())
+	if m == nil {
+		return "", false
+	}
+	for _, secret := range [][]byte{githubWebhookSecret(), previousWebhookSecret()} {
+		if len(secret) != 0 && hmac.Equal([]byte(m[2]), []byte(p.signStubRequest(secret, m[1]))) {
+			return m[1], true
+		}
+	}
+	return "", false
+}
+
+// postStubRequest records a request by the user with the given login for a
+// stub issue for the PR, as a comment giving the stub command on their
+// behalf, so that later checks honor it too (see checkCommands).
+func (p pullRequest) postStubRequest(ctx context.Context, cli *github.Client, login string) error {
+	if *shadowMode {
+		p.logf("shadow: would record stub request by @%s", login)
+		return nil
+	}
+	data := p.data()
+	data.User = login
+	body, err := p.render(stubRequestedTemplate, data)
+	if err != nil {
+		return err
+	}
+	body += "\n\n/issuebot " + cmdStub + fmt.Sprintf(stubRequestMarker, login, p.signStubRequest(githubWebhookSecret(), login))
+	if _, _, err := createCall(ctx, "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+		return cli.Issues.CreateComment(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), &github.IssueComment{
+			Body: github.Ptr(body),
+		})
+	}, findComment(cli, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), body)); err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+	return nil
+}
+
+// checkRunPull returns the open PR in repo whose head is headSHA, or nil if
+// there is none. The PRs GitHub lists for a check run or suite, if any, are
+// given by prs.
+func checkRunPull(ctx context.Context, cli *github.Client, repo *github.Repository, headSHA string, prs []*github.PullRequest) (*github.PullRequest, error) {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	var number int
+	if len(prs) != 0 {
+		number = prs[0].GetNumber()
+	} else {
+		// GitHub does not list PRs from forks, so look for them by commit.
+		prs, _, err := retryCall(ctx, "ListPullRequestsWithCommit", func(ctx context.Context) ([]*github.PullRequest, *github.Response, error) {
+			return cli.PullRequests.ListPullRequestsWithCommit(ctx, owner, name, headSHA, nil)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("list PRs with commit: %w", err)
+		}
+		for _, pr := range prs {
+			if pr.GetState() == "open" {
+				number = pr.GetNumber()
+				break
+			}
+		}
+		if number == 0 {
+			return nil, nil
+		}
+	}
+	pr, _, err := retryCall(ctx, "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+		return cli.PullRequests.Get(ctx, owner, name, number)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get PR: %w", err)
+	} else if pr.GetState() != "open" {
+		return nil, nil
+	}
+	return pr, nil
+}
+
+// handleCheckRunAction handles a click on one of the buttons of a failing
+// check run (see postCheckRun), by checking the PR again. A request for a stub
+// issue is recorded first, if the user who clicked may make it.
+func handleCheckRunAction(ctx context.Context, e *github.CheckRunEvent) error {
+	repo := e.GetRepo()
+	if !repoEnabled(repo.GetFullName()) {
+		return nil
+	}
+	cli := apiClient()
+	pr, err := checkRunPull(ctx, cli, repo, e.GetCheckRun().GetHeadSHA(), e.GetCheckRun().PullRequests)
+	if err != nil {
+		return err
+	} else if pr == nil {
+		ctxLogf(ctx, "check run %d in %s: no open PR, ignoring action", e.GetCheckRun().GetID(), repo.GetFullName())
+		return nil
+	}
+	cfg, err := loadRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	p := pullRequest{repo: repo, pr: pr, cfg: cfg}
+	user := e.GetSender().GetLogin()
+	switch action := e.GetRequestedAction().Identifier; action {
+	case actionRecheck:
+		p.logf("re-check requested by @%s", user)
+	case actionStub:
+		if !cfg.stubIssues() || !*enableStubIssues {
+			p.logf("ignoring stub request by @%s: stub issues are off", user)
+			return nil
+		}
+		if ok, err := p.mayOverride(ctx, cli, user); err != nil {
+			return err
+		} else if !ok {
+			p.logf("ignoring stub request by @%s, who may not make it", user)
+			return p.postOverrideDenied(ctx, cli, deniedOverride{user: user, override: `the "Create stub" button`})
+		}
+		p.logf("stub requested by @%s", user)
+		if err := p.postStubRequest(ctx, cli, user); err != nil {
+			return fmt.Errorf("record stub request: %w", err)
+		}
+	default:
+		p.logf("ignoring unknown check run action %q", action)
+		return nil
+	}
+	undebounce(pr, repo)
+	err = checkPullRequest(ctx, pr, repo)
+	if errors.Is(err, errShuttingDown) {
+		return err
+	} else if settleCheck(pr, repo, err, "") {
+		return nil // rescheduled or deferred
+	}
+	return err
+}
+
+// handleRerequest handles a request, made with the "Re-run" buttons of the
+// Checks tab, to run the check of the open PR in repo whose head is headSHA
+// again. The PRs GitHub lists for the check run or suite are given by prs.
+func handleRerequest(ctx context.Context, repo *github.Repository, headSHA string, prs []*github.PullRequest, user string) error {
+	if !repoEnabled(repo.GetFullName()) {
+		return nil
+	}
+	pr, err := checkRunPull(ctx, apiClient(), repo, headSHA, prs)
+	if err != nil {
+		return err
+	} else if pr == nil {
+		ctxLogf(ctx, "%s@%s: no open PR, ignoring re-run", repo.GetFullName(), headSHA)
+		return nil
+	}
+	pullRequest{repo: repo, pr: pr}.logf("re-run requested by @%s", user)
+	undebounce(pr, repo)
+	err = checkPullRequest(ctx, pr, repo)
+	if errors.Is(err, errShuttingDown) {
+		return err
+	} else if settleCheck(pr, repo, err, "") {
+		return nil // rescheduled or deferred
+	}
+	return err
+}
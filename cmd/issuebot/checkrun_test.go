@@ -0,0 +1,103 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/tailscale/setec/client/setec"
+)
+
+func TestStubRequester(t *testing.T) {
+	defer func(s, prev setec.Secret) { githubWebhookSecret, previousWebhookSecret = s, prev }(githubWebhookSecret, previousWebhookSecret)
+	githubWebhookSecret, previousWebhookSecret = setec.StaticSecret("current"), setec.StaticSecret("previous")
+
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(1), User: &github.User{Login: github.Ptr("alice")}},
+	}
+	other := pullRequest{repo: p.repo, pr: &github.PullRequest{Number: github.Ptr(2)}}
+	request := func(p pullRequest, secret, login string) string {
+		return "/issuebot stub" + fmt.Sprintf(stubRequestMarker, login, p.signStubRequest([]byte(secret), login))
+	}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"signed", request(p, "current", "bob"), "bob"},
+		{"previous secret", request(p, "previous", "bob"), "bob"},
+		{"no marker", "/issuebot stub", ""},
+		{"wrong secret", request(p, "guess", "bob"), ""},
+		{"other PR", request(other, "current", "bob"), ""},
+		{"other user", fmt.Sprintf("/issuebot stub"+stubRequestMarker, "mallory", p.signStubRequest([]byte("current"), "bob")), ""},
+	}
+	for _, tc := range tests {
+		got, ok := p.stubRequester(&github.IssueComment{Body: github.Ptr(tc.body)})
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("stubRequester(%s): got %q, %v; want %q", tc.name, got, ok, tc.want)
+		}
+	}
+
+	// A signed request counts as a stub command, though issuebot posted it.
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/o/r/issues/1/comments" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]*github.IssueComment{{
+			User:              &github.User{Login: github.Ptr("issuebot[bot]"), Type: github.Ptr("Bot")},
+			AuthorAssociation: github.Ptr("NONE"),
+			Body:              github.Ptr(request(p, "current", "bob")),
+		}})
+	}))
+	if got, err := p.checkCommands(t.Context(), cli); err != nil || got != prSkipped {
+		t.Errorf("checkCommands: got %v, %v; want %v", got, err, prSkipped)
+	}
+}
+
+func TestCheckRunPull(t *testing.T) {
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/commits/abc/pulls":
+			json.NewEncoder(w).Encode([]*github.PullRequest{
+				{Number: github.Ptr(1), State: github.Ptr("closed")},
+				{Number: github.Ptr(2), State: github.Ptr("open")},
+			})
+		case "/repos/o/r/commits/def/pulls":
+			json.NewEncoder(w).Encode([]*github.PullRequest{})
+		case "/repos/o/r/pulls/2":
+			json.NewEncoder(w).Encode(github.PullRequest{Number: github.Ptr(2), State: github.Ptr("open")})
+		case "/repos/o/r/pulls/3":
+			json.NewEncoder(w).Encode(github.PullRequest{Number: github.Ptr(3), State: github.Ptr("closed")})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	repo := &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")}
+	tests := []struct {
+		name string
+		sha  string
+		prs  []*github.PullRequest
+		want int
+	}{
+		{"listed", "abc", []*github.PullRequest{{Number: github.Ptr(2)}}, 2},
+		{"listed closed", "abc", []*github.PullRequest{{Number: github.Ptr(3)}}, 0},
+		{"fork", "abc", nil, 2},
+		{"no PR", "def", nil, 0},
+	}
+	for _, tc := range tests {
+		pr, err := checkRunPull(t.Context(), cli, repo, tc.sha, tc.prs)
+		if err != nil {
+			t.Errorf("checkRunPull(%s): unexpected error: %v", tc.name, err)
+		} else if got := pr.GetNumber(); got != tc.want {
+			t.Errorf("checkRunPull(%s): got PR %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
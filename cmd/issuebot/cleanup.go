@@ -0,0 +1,136 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const cleanupStubsUsage = `Usage: issuebot cleanup-stubs [-dry-run] owner/repo ...
+
+Find the open stub issues in each repository whose pull requests have been
+merged or closed, and close those that are still unedited placeholders. Stubs
+that have been edited are reported, but left open.
+
+GitHub is accessed as "issuebot help" describes, with permission to comment
+on and close issues (unless -dry-run is given).
+`
+
+// runCleanupStubs implements the cleanup-stubs subcommand, and returns the
+// process exit code.
+func runCleanupStubs(args []string) int {
+	fs := flag.NewFlagSet("cleanup-stubs", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report orphaned stubs, but do not close them")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), cleanupStubsUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	cli, err := toolClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+		return 2
+	}
+	status := 0
+	for _, name := range fs.Args() {
+		repo, err := getRepo(ctx, cli, name)
+		if err == nil {
+			err = cleanupStubs(ctx, cli, os.Stdout, repo, *dryRun)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+// stubTitlePRRE matches the PR number in the default title of stub issues,
+// for stubs filed before they were marked with it (see stubBodyMarker).
+var stubTitlePRRE = regexp.MustCompile(`\bPR #(\d+)\b`)
+
+// stubPRNumber returns the number of the PR for which the stub issue was
+// filed, or 0 if it cannot be determined.
+func stubPRNumber(issue *github.Issue) int {
+	if n := parseStubBody(issue.GetBody()).pr; n != 0 {
+		return n
+	}
+	if m := stubTitlePRRE.FindStringSubmatch(issue.GetTitle()); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+	return 0
+}
+
+// cleanupStubs closes the open stub issues in repo whose PRs are no longer
+// open, if they are still placeholders, and reports what it does to w. If
+// dryRun is true, it only reports.
+func cleanupStubs(ctx context.Context, cli *github.Client, w io.Writer, repo *github.Repository, dryRun bool) error {
+	cfg, err := fetchRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	stubs, err := listOpenStubs(ctx, cli, repo, time.Now())
+	if err != nil {
+		return fmt.Errorf("list stubs: %w", err)
+	}
+	for _, issue := range stubs {
+		name := fmt.Sprintf("%s#%d", repo.GetFullName(), issue.GetNumber())
+		prNum := stubPRNumber(issue)
+		if prNum == 0 {
+			fmt.Fprintf(w, "%s: cannot tell which PR it is for; skipped\n", name)
+			continue
+		}
+		pr, _, err := retryCall(ctx, "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+			return cli.PullRequests.Get(ctx, repo.GetOwner().GetLogin(), repo.GetName(), prNum)
+		})
+		if err != nil {
+			return fmt.Errorf("get PR #%d: %w", prNum, err)
+		}
+		if pr.GetState() == "open" {
+			continue
+		}
+		state := "closed"
+		if pr.GetMerged() {
+			state = "merged"
+		}
+		p := pullRequest{repo: repo, pr: pr, cfg: cfg}
+		if ok, err := p.isPlaceholder(issue); err != nil {
+			return err
+		} else if !ok {
+			fmt.Fprintf(w, "%s: PR #%d %s, but the stub has been edited; left open\n", name, prNum, state)
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(w, "%s: PR #%d %s; would close\n", name, prNum, state)
+			continue
+		}
+		data := p.data()
+		data.Issue, data.Ref = issue.GetNumber(), fmt.Sprintf("#%d", issue.GetNumber())
+		if err := p.closeStub(ctx, cli, issue, stubAbandonedTemplate, data); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s: PR #%d %s; closed\n", name, prNum, state)
+	}
+	return nil
+}
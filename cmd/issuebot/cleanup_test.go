@@ -0,0 +1,84 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestCleanupStubs(t *testing.T) {
+	stub := func(num, pr int, author, edited string) *github.Issue {
+		body := fmt.Sprintf("TODO(@%s): Add details about PR #%d", author, pr)
+		marker := stubBodyMarker(pr, body)
+		if edited != "" {
+			body = edited
+		}
+		return &github.Issue{
+			Number: github.Ptr(num),
+			Title:  github.Ptr(fmt.Sprintf("Placeholder issue for PR #%d", pr)),
+			Body:   github.Ptr(body + marker),
+		}
+	}
+	stubs := []*github.Issue{
+		stub(10, 1, "alice", ""),                     // PR open
+		stub(11, 2, "alice", ""),                     // PR merged
+		stub(12, 3, "bob", ""),                       // PR closed
+		stub(13, 4, "bob", "The frobnicator broke."), // PR closed, stub edited
+		{Number: github.Ptr(14), Title: github.Ptr("Placeholder issue for PR #3"), Body: github.Ptr("TODO(@bob): Add details about PR #3")}, // no marker
+	}
+	pulls := map[string]string{
+		"1": `{"number": 1, "state": "open", "user": {"login": "alice"}}`,
+		"2": `{"number": 2, "state": "closed", "merged": true, "user": {"login": "alice"}}`,
+		"3": `{"number": 3, "state": "closed", "user": {"login": "bob"}}`,
+		"4": `{"number": 4, "state": "closed", "user": {"login": "bob"}}`,
+	}
+	var closed []string
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; {
+		case path == "/repos/o/r/issues" && r.Method == "GET":
+			json.NewEncoder(w).Encode(stubs)
+		case strings.HasPrefix(path, "/repos/o/r/pulls/"):
+			w.Write([]byte(pulls[strings.TrimPrefix(path, "/repos/o/r/pulls/")]))
+		case strings.HasSuffix(path, "/comments"):
+			w.Write([]byte(`{}`))
+		case strings.HasPrefix(path, "/repos/o/r/issues/") && r.Method == "PATCH":
+			closed = append(closed, strings.TrimPrefix(path, "/repos/o/r/issues/"))
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	repo := &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")}
+
+	var out strings.Builder
+	if err := cleanupStubs(t.Context(), cli, &out, repo, true); err != nil {
+		t.Fatalf("cleanupStubs (dry run): unexpected error: %v", err)
+	}
+	want := `o/r#11: PR #2 merged; would close
+o/r#12: PR #3 closed; would close
+o/r#13: PR #4 closed, but the stub has been edited; left open
+o/r#14: PR #3 closed; would close
+`
+	if got := out.String(); got != want {
+		t.Errorf("cleanupStubs (dry run): got\n%s\nwant\n%s", got, want)
+	}
+	if len(closed) != 0 {
+		t.Errorf("cleanupStubs (dry run): closed %v", closed)
+	}
+
+	out.Reset()
+	if err := cleanupStubs(t.Context(), cli, &out, repo, false); err != nil {
+		t.Fatalf("cleanupStubs: unexpected error: %v", err)
+	}
+	if got, want := strings.Join(closed, ","), "11,12,14"; got != want {
+		t.Errorf("cleanupStubs: closed %s, want %s", got, want)
+	}
+}
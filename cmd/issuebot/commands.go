@@ -0,0 +1,207 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// Slash commands, given on a line of their own in a PR comment, e.g.,
+// "/issuebot skip typo fixes only".
+const (
+	cmdRecheck = "recheck" // check the PR again
+	cmdSkip    = "skip"    // accept the PR without an issue; the rest of the line gives the reason
+	cmdStub    = "stub"    // accept the PR and file a stub issue for it, like skip-issuebot
+)
+
+// commandRE matches a slash command line. The submatches are the command name
+// and its argument, if any.
+var commandRE = regexp.MustCompile(`(?m)^/issuebot[ \t]+(\S+)[ \t]*(.*?)[ \t]*\r?$`)
+
+// A command is a slash command given in a PR comment.
+type command struct {
+	name string // cmdRecheck, cmdSkip, or cmdStub
+	arg  string // the rest of the line, e.g., the reason for a skip
+}
+
+// parseCommands returns the valid slash commands in the body of a comment.
+// A skip command must give a reason.
+func parseCommands(body string) []command {
+	var cmds []command
+	for _, m := range commandRE.FindAllStringSubmatch(body, -1) {
+		c := command{name: strings.ToLower(m[1]), arg: m[2]}
+		switch {
+		case c.name == cmdRecheck, c.name == cmdStub:
+		case c.name == cmdSkip && c.arg != "":
+		default:
+			continue
+		}
+		cmds = append(cmds, c)
+	}
+	return cmds
+}
+
+// mayCommand reports whether the user with the given login may give cmds for
+// the PR. Those who may override the check may give any command; the PR's
+// own author may also ask for it to be checked again.
+func (p pullRequest) mayCommand(ctx context.Context, cli *github.Client, login string, cmds []command) (bool, error) {
+	if login == p.pr.GetUser().GetLogin() && !slices.ContainsFunc(cmds, func(c command) bool { return c.name != cmdRecheck }) {
+		return true, nil
+	}
+	return p.mayOverride(ctx, cli, login)
+}
+
+// checkCommands returns the best disposition given by skip and stub commands
+// in the comments on the PR, or prFailed if there are none.
+func (p pullRequest) checkCommands(ctx context.Context, cli *github.Client) (pullRequestStatus, error) {
+	status := prFailed
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := retryCall(ctx, "ListComments", func(ctx context.Context) ([]*github.IssueComment, *github.Response, error) {
+			return cli.Issues.ListComments(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), opts)
+		})
+		if err != nil {
+			return prFailed, fmt.Errorf("list comments: %w", err)
+		}
+		for _, comment := range comments {
+			cmds := parseCommands(comment.GetBody())
+			if len(cmds) == 0 {
+				continue
+			}
+			if login, ok := p.stubRequester(comment); ok {
+				p.logf("accept: stub requested by @%s", login)
+			} else if ok, err := p.mayCommand(ctx, cli, comment.GetUser().GetLogin(), cmds); err != nil {
+				return prFailed, err
+			} else if !ok {
+				continue
+			}
+			for _, c := range cmds {
+				switch {
+				case c.name == cmdSkip && status < prCleanup:
+					p.logf("accept: skipped by @%s: %s", comment.GetUser().GetLogin(), c.arg)
+					status = prCleanup
+				case c.name == cmdStub && status < prSkipped:
+					status = prSkipped
+				}
+			}
+		}
+		if resp.NextPage == 0 {
+			return status, nil
+		}
+		opts.ListOptions.Page = resp.NextPage
+	}
+}
+
+// Reactions with which issuebot acknowledges a comment giving commands.
+const (
+	cmdWorkingReaction  = "eyes" // while the commands are handled
+	cmdDoneReaction     = "+1"   // once they have been
+	cmdRejectedReaction = "-1"   // if they were ignored, or failed
+)
+
+// handleCommandEvent handles a comment on a PR that gives slash commands, by
+// checking the PR again. Skip and stub commands take effect in the check (see
+// checkCommands). The comment gets a reaction while this is going on, and
+// another once it is done, so that its author knows the commands were seen.
+func handleCommandEvent(ctx context.Context, e *github.IssueCommentEvent) error {
+	repo := e.GetRepo()
+	if !repoEnabled(repo.GetFullName()) {
+		return nil
+	}
+	cli := apiClient()
+	working := reactToComment(ctx, cli, e, cmdWorkingReaction)
+	done, err := runCommands(ctx, cli, e)
+	if errors.Is(err, errShuttingDown) {
+		return err // leave the working reaction until the event is redelivered
+	}
+	reaction := cmdRejectedReaction
+	if done && err == nil {
+		reaction = cmdDoneReaction
+	}
+	reactToComment(ctx, cli, e, reaction)
+	unreactToComment(ctx, cli, e, working)
+	return err
+}
+
+// runCommands checks the PR again for the commands in e, and reports whether
+// they were carried out; commands that were ignored are not.
+func runCommands(ctx context.Context, cli *github.Client, e *github.IssueCommentEvent) (bool, error) {
+	repo := e.GetRepo()
+	pr, _, err := retryCall(ctx, "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+		return cli.PullRequests.Get(ctx, repo.GetOwner().GetLogin(), repo.GetName(), e.GetIssue().GetNumber())
+	})
+	if err != nil {
+		return false, fmt.Errorf("get PR: %w", err)
+	}
+	cfg, err := loadRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return false, fmt.Errorf("load config: %w", err)
+	}
+	p := pullRequest{repo: repo, pr: pr, cfg: cfg}
+	user := e.GetComment().GetUser().GetLogin()
+	cmds := parseCommands(e.GetComment().GetBody())
+	if ok, err := p.mayCommand(ctx, cli, user, cmds); err != nil {
+		return false, err
+	} else if !ok {
+		p.logf("ignoring commands from @%s, who may not use them", user)
+		return false, p.postOverrideDenied(ctx, cli, deniedOverride{user: user, override: "/issuebot " + cmds[0].name})
+	} else if pr.GetState() != "open" {
+		p.logf("ignoring commands on a closed PR")
+		return false, nil
+	}
+	p.logf("commands from @%s, checking again", user)
+	undebounce(pr, repo)
+	err = checkPullRequest(ctx, pr, repo)
+	if errors.Is(err, errShuttingDown) {
+		return false, err
+	} else if settleCheck(pr, repo, err, "") {
+		return true, nil // rescheduled or deferred
+	}
+	return err == nil, err
+}
+
+// reactToComment adds the given reaction to the comment in e, and returns its
+// ID, or 0 if it could not be added. Errors are logged, since reactions are
+// only a courtesy.
+func reactToComment(ctx context.Context, cli *github.Client, e *github.IssueCommentEvent, content string) int64 {
+	if *shadowMode {
+		ctxLogf(ctx, "shadow: would react %q to comment %d on %s#%d", content, e.GetComment().GetID(), e.GetRepo().GetFullName(), e.GetIssue().GetNumber())
+		return 0
+	}
+	r, _, err := retryCall(ctx, "CreateIssueCommentReaction", func(ctx context.Context) (*github.Reaction, *github.Response, error) {
+		return cli.Reactions.CreateIssueCommentReaction(ctx, e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName(), e.GetComment().GetID(), content)
+	})
+	if err != nil {
+		ctxLogf(ctx, "error reacting to comment %d on %s#%d (continuing): %v", e.GetComment().GetID(), e.GetRepo().GetFullName(), e.GetIssue().GetNumber(), err)
+		return 0
+	}
+	return r.GetID()
+}
+
+// unreactToComment removes the reaction with the given ID, made by
+// reactToComment, from the comment in e.
+func unreactToComment(ctx context.Context, cli *github.Client, e *github.IssueCommentEvent, id int64) {
+	if id == 0 {
+		return
+	}
+	_, _, err := retryCall(ctx, "DeleteIssueCommentReaction", func(ctx context.Context) (struct{}, *github.Response, error) {
+		resp, err := cli.Reactions.DeleteIssueCommentReaction(ctx, e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName(), e.GetComment().GetID(), id)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			err = nil // already gone
+		}
+		return struct{}{}, resp, err
+	})
+	if err != nil {
+		ctxLogf(ctx, "error removing reaction from comment %d on %s#%d (continuing): %v", e.GetComment().GetID(), e.GetRepo().GetFullName(), e.GetIssue().GetNumber(), err)
+	}
+}
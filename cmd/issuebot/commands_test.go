@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestParseCommands(t *testing.T) {
+	tests := []struct {
+		body string
+		want []command
+	}{
+		{"/issuebot recheck", []command{{cmdRecheck, ""}}},
+		{"Sorry!\r\n/issuebot Recheck \r\n", []command{{"recheck", ""}}},
+		{"/issuebot skip typo fixes only", []command{{cmdSkip, "typo fixes only"}}},
+		{"/issuebot skip", nil}, // no reason
+		{"/issuebot stub\n/issuebot recheck", []command{{cmdStub, ""}, {cmdRecheck, ""}}},
+		{"/issuebot frobnicate", nil},
+		{"Try /issuebot recheck", nil},
+		{"> /issuebot recheck", nil},
+		{"/issuebotrecheck", nil},
+	}
+	for _, tc := range tests {
+		if got := parseCommands(tc.body); !slices.Equal(got, tc.want) {
+			t.Errorf("parseCommands(%q): got %+v, want %+v", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestCheckCommands(t *testing.T) {
+	var comments []*github.IssueComment
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/issues/1/comments":
+			json.NewEncoder(w).Encode(comments)
+		case "/repos/o/r/collaborators/bob/permission", "/repos/o/r/collaborators/carol/permission":
+			json.NewEncoder(w).Encode(github.RepositoryPermissionLevel{Permission: github.Ptr("write")})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	comment := func(login, association, body string) *github.IssueComment {
+		return &github.IssueComment{
+			User:              &github.User{Login: github.Ptr(login)},
+			AuthorAssociation: github.Ptr(association),
+			Body:              github.Ptr(body),
+		}
+	}
+	tests := []struct {
+		name     string
+		comments []*github.IssueComment
+		want     pullRequestStatus
+	}{
+		{"none", nil, prFailed},
+		{"recheck", []*github.IssueComment{comment("alice", "NONE", "/issuebot recheck")}, prFailed},
+		{"author stub", []*github.IssueComment{comment("alice", "NONE", "/issuebot stub")}, prFailed},
+		{"writer stub", []*github.IssueComment{comment("carol", "COLLABORATOR", "/issuebot stub")}, prSkipped},
+		{"writer skip", []*github.IssueComment{comment("bob", "MEMBER", "/issuebot skip release notes")}, prCleanup},
+		{"skip wins", []*github.IssueComment{comment("bob", "MEMBER", "/issuebot skip docs"), comment("carol", "COLLABORATOR", "/issuebot stub")}, prCleanup},
+		{"member without access", []*github.IssueComment{comment("erin", "MEMBER", "/issuebot skip because")}, prFailed},
+		{"outsider", []*github.IssueComment{comment("mallory", "CONTRIBUTOR", "/issuebot skip because")}, prFailed},
+	}
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(1), User: &github.User{Login: github.Ptr("alice")}},
+	}
+	for _, tc := range tests {
+		comments = tc.comments
+		got, err := p.checkCommands(t.Context(), cli)
+		if err != nil {
+			t.Errorf("checkCommands(%s): unexpected error: %v", tc.name, err)
+		} else if got != tc.want {
+			t.Errorf("checkCommands(%s): got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestReactToComment(t *testing.T) {
+	var reactions []string
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/repos/o/r/issues/comments/7/reactions":
+			var req struct{ Content string }
+			json.NewDecoder(r.Body).Decode(&req)
+			reactions = append(reactions, req.Content)
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(github.Reaction{ID: github.Ptr(int64(len(reactions))), Content: github.Ptr(req.Content)})
+		case r.Method == "DELETE" && r.URL.Path == "/repos/o/r/issues/comments/7/reactions/1":
+			reactions[0] = ""
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	e := &github.IssueCommentEvent{
+		Repo:    &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		Issue:   &github.Issue{Number: github.Ptr(1)},
+		Comment: &github.IssueComment{ID: github.Ptr(int64(7))},
+	}
+	id := reactToComment(t.Context(), cli, e, cmdWorkingReaction)
+	if id != 1 {
+		t.Fatalf("reactToComment: got ID %d, want 1", id)
+	}
+	reactToComment(t.Context(), cli, e, cmdDoneReaction)
+	unreactToComment(t.Context(), cli, e, id)
+	if want := []string{"", cmdDoneReaction}; !slices.Equal(reactions, want) {
+		t.Errorf("reactions: got %q, want %q", reactions, want)
+	}
+}
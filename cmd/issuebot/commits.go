@@ -0,0 +1,253 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/go-github/v72/github"
+	"golang.org/x/sync/errgroup"
+	"tailscale.com/util/lru"
+)
+
+// A commitResult is the outcome of checking one commit of a pull request.
+type commitResult struct {
+	commit *github.RepositoryCommit // looked up in full, if a policy needs it
+	size   int                      // lines changed, if a policy needs it
+	disp   pullRequestStatus        // from the message and overrides
+	bot    pullRequestStatus        // from the metadata (prBot or prFailed)
+	denied deniedOverride           // an override in the message that was ignored
+	logs   []string                 // logged while checking, replayed on a cache hit
+
+	// volatile is whether the result may change though the commit does not:
+	// an issue it links to was not acceptable, or its author was not allowed
+	// to override the check. Such results are not cached.
+	volatile bool
+}
+
+// final reports whether r settles the check of the PR, so that later
+// commits need not be checked (unless a policy needs them all).
+func (r *commitResult) final() bool {
+	return r.disp > prSkipped || r.bot > prSkipped
+}
+
+// overrideAccessCache records whether users may override the check, by
+// login, while the commits of a PR are checked.
+type overrideAccessCache struct {
+	sync.Mutex
+	m map[string]bool // :: login → whether they may override
+}
+
+// checkCommits checks the commits of the PR, up to --commit-concurrency of
+// them at once. If scanAll is false, it stops starting new checks once a
+// commit settles the check of the PR, and the results of the commits after
+// the first that does are nil. Commits before it are always checked, so that
+// the results are the same as checking the commits in order.
+func (p pullRequest) checkCommits(ctx context.Context, client *github.Client, commits []*github.RepositoryCommit, scanAll bool) ([]*commitResult, error) {
+	results := make([]*commitResult, len(commits))
+	allowed := &overrideAccessCache{m: make(map[string]bool)}
+	var first atomic.Int64 // index of the first commit that settles the check
+	first.Store(int64(len(commits)))
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(*commitConcurrency, 1))
+	for i, commit := range commits {
+		if !scanAll && int64(i) > first.Load() {
+			break
+		}
+		g.Go(func() error {
+			if !scanAll && int64(i) > first.Load() {
+				return nil
+			}
+			cctx, sp := startSpan(gctx, "check commit", spanInternal, "git.commit.sha", commit.GetSHA())
+			key := p.commitCacheKey(commit, scanAll)
+			r, ok := cachedCommitResult(key)
+			sp.set("issuebot.cached", ok)
+			if !ok {
+				// Collect the lines logged for the commit, so that they
+				// reach the PR's trail again when the result is reused.
+				cp := p
+				cp.trail = new(checkTrail)
+				var err error
+				if r, err = cp.checkCommit(cctx, client, commit, scanAll, allowed); err != nil {
+					sp.finish(err)
+					return err
+				}
+				r.logs = cp.trail.lines
+				for _, line := range r.logs {
+					p.trail.add(line)
+				}
+				cacheCommitResult(key, r)
+			} else {
+				for _, line := range r.logs {
+					p.logf("%s", line)
+				}
+			}
+			sp.set("issuebot.disposition", max(r.disp, r.bot).String())
+			sp.finish(nil)
+			results[i] = r
+			if r.final() {
+				for {
+					f := first.Load()
+					if int64(i) >= f || first.CompareAndSwap(f, int64(i)) {
+						break
+					}
+				}
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// checkCommit checks one commit of the PR: its message, with the issues it
+// links to if the repository verifies them, any override in it and whether
+// its author may use it, and its metadata.
+func (p pullRequest) checkCommit(ctx context.Context, client *github.Client, commit *github.RepositoryCommit, scanAll bool, allowed *overrideAccessCache) (*commitResult, error) {
+	cfg := p.cfg
+	if cfg == nil {
+		cfg = new(repoConfig)
+	}
+	r := &commitResult{commit: commit}
+
+	// A policy sees the size of each commit. Without diff stats, or to leave
+	// out excluded files, look the commit up in full. Otherwise the size of
+	// the whole PR is found by evaluate, if it is needed.
+	if scanAll && (commit.Stats == nil || len(cfg.DiffExcludePaths) != 0) {
+		full, _, err := retryCall(ctx, "GetCommit", func(ctx context.Context) (*github.RepositoryCommit, *github.Response, error) {
+			return client.Repositories.GetCommit(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), commit.GetSHA(), nil)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("get commit %s: %w", commit.GetSHA(), err)
+		}
+		r.commit = full
+	}
+	if scanAll {
+		r.size = cfg.diffSize(r.commit)
+	}
+
+	// Check the commit message for tags, and if the repository requires it,
+	// that the issues it links to are acceptable.
+	msg := r.commit.GetCommit().GetMessage()
+	disp := p.checkCommitMessage(msg)
+	if disp == prLinked && cfg.verifiesLinks() {
+		ok, err := p.verifyLinks(ctx, client, msg)
+		if err != nil {
+			return nil, fmt.Errorf("check links: %w", err)
+		} else if !ok {
+			r.volatile = true
+			disp = p.checkOverride(msg)
+		}
+	} else if disp == prBackport && cfg.verifyBackports() {
+		ok, err := p.checkBackport(ctx, client, msg)
+		if err != nil {
+			return nil, fmt.Errorf("check backport: %w", err)
+		} else if !ok {
+			r.volatile = true
+			disp = p.checkOverride(msg)
+		}
+	}
+	// Overrides count only if their author may use them.
+	if disp == prSkipped || disp == prCleanup {
+		login := r.commit.GetAuthor().GetLogin()
+		if login == "" {
+			login = p.pr.GetUser().GetLogin()
+		}
+		allowed.Lock()
+		ok, seen := allowed.m[login]
+		allowed.Unlock()
+		if !seen {
+			var err error
+			if ok, err = p.mayOverride(ctx, client, login); err != nil {
+				return nil, fmt.Errorf("check override access: %w", err)
+			}
+			allowed.Lock()
+			allowed.m[login] = ok
+			allowed.Unlock()
+		}
+		if !ok {
+			_, kw := cfg.override(msg)
+			p.logf("ignoring override %s by @%s, who may not use it", kw, login)
+			r.denied = deniedOverride{user: login, override: kw}
+			r.volatile = true
+			disp = prFailed
+		}
+	}
+	r.disp = disp
+
+	// Check commit metadata for well-known bots.
+	r.bot = p.checkCommitMetadata(r.commit)
+	return r, nil
+}
+
+// A commitCacheKey identifies the result of checking a commit of a PR. Since
+// the PR's author stands in for a commit author who is not a GitHub user,
+// results are kept per PR. The repository's settings are compared by
+// identity, so results are not reused once they are fetched again.
+type commitCacheKey struct {
+	repo    string
+	pr      int
+	sha     string
+	cfg     *repoConfig
+	scanAll bool // whether the result has the commit's size
+}
+
+// commitCache holds the results of checking commits, which are immutable, so
+// that they are not checked again when a PR is checked again, e.g., after
+// more commits are pushed to it.
+var commitCache struct {
+	sync.Mutex
+	c lru.Cache[commitCacheKey, *commitResult]
+}
+
+func (p pullRequest) commitCacheKey(commit *github.RepositoryCommit, scanAll bool) commitCacheKey {
+	return commitCacheKey{
+		repo:    p.repo.GetFullName(),
+		pr:      p.pr.GetNumber(),
+		sha:     commit.GetSHA(),
+		cfg:     p.cfg,
+		scanAll: scanAll,
+	}
+}
+
+// cachedCommitResult returns the cached result of checking a commit, if any.
+func cachedCommitResult(key commitCacheKey) (*commitResult, bool) {
+	if key.sha == "" {
+		return nil, false
+	}
+	commitCache.Lock()
+	defer commitCache.Unlock()
+	r, ok := commitCache.c.GetOk(key)
+	if ok {
+		commitCacheHits.Add(1)
+	}
+	return r, ok
+}
+
+// cacheCommitResult caches r, the result of checking a commit, unless it is
+// volatile or the cache is disabled by --commit-cache-size.
+func cacheCommitResult(key commitCacheKey, r *commitResult) {
+	if key.sha == "" || r.volatile || *commitCacheSize <= 0 {
+		return
+	}
+	if r.commit.Files != nil {
+		// The size is already known; keep the cache small.
+		c := *r.commit
+		c.Files = nil
+		rc := *r
+		rc.commit = &c
+		r = &rc
+	}
+	commitCache.Lock()
+	defer commitCache.Unlock()
+	commitCache.c.MaxEntries = *commitCacheSize
+	commitCache.c.Set(key, r)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestCheckCommits(t *testing.T) {
+	defer func(n int) { *commitConcurrency = n }(*commitConcurrency)
+
+	var commits []*github.RepositoryCommit
+	for i, msg := range []string{"Tweak it", "Fix it\n\nFixes #1", "Tweak it more", "Fix it again\n\nFixes #2"} {
+		commits = append(commits, &github.RepositoryCommit{
+			SHA:    github.Ptr(string(rune('a' + i))),
+			Commit: &github.Commit{Message: github.Ptr(msg)},
+			Stats:  &github.CommitStats{Total: github.Ptr(10)},
+		})
+	}
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(1)},
+	}
+	for _, n := range []int{1, 4} {
+		*commitConcurrency = n
+
+		// The commits before the first linked one are always checked; none
+		// after it are needed.
+		results, err := p.checkCommits(context.Background(), nil, commits, false)
+		if err != nil {
+			t.Fatalf("checkCommits (concurrency %d): unexpected error: %v", n, err)
+		}
+		if results[0] == nil || results[0].disp != prFailed {
+			t.Errorf("checkCommits (concurrency %d): got result %v for commit a, want failed", n, results[0])
+		}
+		if results[1] == nil || results[1].disp != prLinked {
+			t.Errorf("checkCommits (concurrency %d): got result %v for commit b, want linked", n, results[1])
+		}
+
+		// With scanAll, every commit is checked, with its size.
+		results, err = p.checkCommits(context.Background(), nil, commits, true)
+		if err != nil {
+			t.Fatalf("checkCommits (concurrency %d, all): unexpected error: %v", n, err)
+		}
+		for i, r := range results {
+			if r == nil || r.size != 10 {
+				t.Errorf("checkCommits (concurrency %d, all): got result %v for commit %d, want size 10", n, r, i)
+			}
+		}
+	}
+}
+
+func TestCommitCache(t *testing.T) {
+	cli := newFakeGitHub(t, http.NotFoundHandler()) // no one is a collaborator
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("cache"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/cache")},
+		pr:   &github.PullRequest{Number: github.Ptr(1), User: &github.User{Login: github.Ptr("alice")}},
+	}
+	commit := func(msg string) []*github.RepositoryCommit {
+		return []*github.RepositoryCommit{{SHA: github.Ptr(fakeSHA(msg)), Commit: &github.Commit{Message: github.Ptr(msg)}}}
+	}
+	tests := []struct {
+		message string
+		cached  bool
+		log     string // a line the check logs, cached or not
+	}{
+		{"Fix it\n\nFixes #1", true, ""},
+		{"Tweak it", true, ""},
+		{"Revert \"Tweak it\"", true, "accept: found revert commit"},
+		{"Tweak it\n\nskip-issuebot", false, "ignoring override skip-issuebot by @alice, who may not use it"},
+	}
+	for _, tc := range tests {
+		for i := range 2 {
+			before := commitCacheHits.Value()
+			p.trail = new(checkTrail)
+			if _, err := p.checkCommits(context.Background(), cli, commit(tc.message), false); err != nil {
+				t.Fatalf("checkCommits(%q): unexpected error: %v", tc.message, err)
+			}
+			hit := commitCacheHits.Value() > before
+			if want := i == 1 && tc.cached; hit != want {
+				t.Errorf("checkCommits(%q) #%d: got cache hit %v, want %v", tc.message, i+1, hit, want)
+			}
+			if tc.log != "" && !slices.Contains(p.trail.lines, tc.log) {
+				t.Errorf("checkCommits(%q) #%d: trail %q, want %q", tc.message, i+1, p.trail.lines, tc.log)
+			}
+		}
+	}
+}
@@ -0,0 +1,992 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+	"sigs.k8s.io/yaml"
+)
+
+// repoConfigPath is the path of the per-repository configuration file, in
+// the default branch of the repository.
+const repoConfigPath = ".github/issuebot.yml"
+
+// orgConfigRepo is the name of the repository holding the organization-wide
+// default configuration, at repoConfigPath. Settings in a repository's own
+// configuration file override these defaults.
+const orgConfigRepo = ".github"
+
+// repoConfigTTL is how long a repository configuration is cached before we
+// fetch it again.
+const repoConfigTTL = 10 * time.Minute
+
+// defaultMinDiff is the default minimum diff size (in lines) for which an
+// issue link is required.
+const defaultMinDiff = 5
+
+// defaultStubReminderAfter is the default time after which the assignee of a
+// stub issue that is still a placeholder is reminded to fill it in.
+const defaultStubReminderAfter = 7 * 24 * time.Hour
+
+// defaultLinkVerbs are the words that, at the start of a line in a commit
+// message, introduce a link to an issue.
+var defaultLinkVerbs = []string{"close", "closes", "closed", "fix", "fixes", "fixed",
+	"resolve", "resolves", "resolved", "updates", "for"}
+
+// Override dispositions, which say what effect an override keyword has when
+// it appears in a commit message.
+const (
+	overrideSkip   = "skip"   // accept the PR, and file a stub issue
+	overrideAccept = "accept" // accept the PR outright
+	overrideOff    = "off"    // no effect; used to disable a default keyword
+)
+
+// Names of issue trackers, for the stubTracker setting.
+const (
+	trackerGitHub = "github"
+	trackerJira   = "jira" // in the first of the jiraProjects
+)
+
+// Check modes, which say what happens when a PR fails the check.
+const (
+	modeEnforce  = "enforce"  // post a failing status (the default)
+	modeAdvisory = "advisory" // post a passing status and an explanatory comment
+)
+
+// What to do with a stub issue when its PR is closed without being merged, for
+// the abandonedStubs setting.
+const (
+	abandonClose = "close" // close the stub issue (the default)
+	abandonLabel = "label" // add the stale-stub label
+	abandonKeep  = "keep"  // leave it alone
+)
+
+// Settings of stubIssues.
+const (
+	stubsOff      stubsMode = "off"      // accept skip-issuebot without a stub
+	stubsOn       stubsMode = "on"       // file a stub, but accept the PR anyway if that fails
+	stubsRequired stubsMode = "required" // fail the PR if no stub can be filed
+)
+
+// stubAssignAuthor is the stubAssignee setting that assigns stub issues to
+// the author of the PR, which is the default.
+const stubAssignAuthor = "author"
+
+// stubAssigneeRE matches valid stubAssignee settings: stubAssignAuthor, a
+// user's login, or a team as "org/team", optionally preceded by "@".
+var stubAssigneeRE = regexp.MustCompile(`^@?[A-Za-z0-9][A-Za-z0-9-]*(?:/[A-Za-z0-9][A-Za-z0-9_.-]*)?$`)
+
+// defaultOverrides maps the default override keywords to their dispositions.
+var defaultOverrides = map[string]string{
+	"skip-issuebot": overrideSkip,
+	"#cleanup":      overrideAccept,
+}
+
+// A repoConfig holds the settings for a single repository. A nil *repoConfig
+// is valid, and provides the default settings.
+type repoConfig struct {
+	// MinDiff is the smallest total diff size, in lines, for which a PR must
+	// link to an issue. If unset, defaultMinDiff is used.
+	MinDiff *int `json:"minDiff,omitempty"`
+
+	// Overrides maps keywords that override the check when they appear in a
+	// commit message to their dispositions (overrideSkip, overrideAccept, or
+	// overrideOff). These are combined with defaultOverrides, and take
+	// precedence over them.
+	Overrides map[string]string `json:"overrides,omitempty"`
+
+	// OverrideLabels maps labels that override the check when they are on
+	// the PR to their dispositions (overrideSkip or overrideAccept). A label
+	// counts only if whoever added it may override the check (see
+	// OverrideAccess).
+	OverrideLabels map[string]string `json:"overrideLabels,omitempty"`
+
+	// ApprovalPhrase, if set, is a phrase (e.g., "issuebot: approved without
+	// issue") that accepts the PR without an issue when it appears in an
+	// approving review by someone with write access to the repository (or,
+	// if OverrideAccess is set, someone it allows). Matching is
+	// case-insensitive.
+	ApprovalPhrase string `json:"approvalPhrase,omitempty"`
+
+	// LinkVerbs, if set, replaces defaultLinkVerbs as the words that introduce
+	// an issue link. Matching is case-insensitive.
+	LinkVerbs []string `json:"linkVerbs,omitempty"`
+
+	// LenientLinks, if true, accepts a link verb followed by an issue
+	// reference anywhere in a line (e.g., "This updates #123 to fix the
+	// race"), rather than only at the start of a line.
+	LenientLinks bool `json:"lenientLinks,omitempty"`
+
+	// StrictLinks, if true, requires a line beginning with a link verb to
+	// contain an actual issue reference (see parseIssueRefs). Otherwise any
+	// "#" or "github.com" after the verb is accepted.
+	StrictLinks bool `json:"strictLinks,omitempty"`
+
+	// LinkChecks, if set, are additional requirements that a linked GitHub
+	// issue must meet. Setting it implies StrictLinks.
+	LinkChecks *linkChecks `json:"linkChecks,omitempty"`
+
+	// JiraProjects are the keys of Jira projects whose issue keys (e.g.,
+	// "PROJ-123") link to issues. If --jira-url is set, the keys are checked
+	// against the Jira instance.
+	JiraProjects []string `json:"jiraProjects,omitempty"`
+
+	// ClosingIssues, if true, accepts a PR if GitHub shows an issue linked
+	// to it in its Development sidebar, e.g., because its description says
+	// "Fixes #123", or the issue was linked manually.
+	ClosingIssues bool `json:"closingIssues,omitempty"`
+
+	// VerifyBackports, if true, accepts a commit cherry-picked with
+	// "git cherry-pick -x" only if the original commit links to an issue.
+	// Otherwise the "(cherry picked from commit ...)" line is enough.
+	VerifyBackports bool `json:"verifyBackports,omitempty"`
+
+	// TimelineLinks, if true, accepts a PR whose timeline shows that an
+	// issue was linked to it manually, or that an issue mentions it.
+	TimelineLinks bool `json:"timelineLinks,omitempty"`
+
+	// PRDescription, if true, accepts a PR whose title or description links
+	// to an issue, as a commit message would, even if none of its commits
+	// do. This suits repositories that squash PRs when merging them.
+	PRDescription bool `json:"prDescription,omitempty"`
+
+	// LinkURLs, if set, are the URLs of issues that count as links, given as
+	// glob patterns (see matchGlob) for the host and path, without the
+	// scheme. For GitHub, patterns are matched against "github.com/owner/repo"
+	// for both issue URLs and owner/repo#N references. For example,
+	// ["github.com/tailscale/*", "tracker.example.com/**"]. If unset, any
+	// GitHub repository is allowed, and other URLs are not links.
+	LinkURLs []string `json:"linkURLs,omitempty"`
+
+	// LinkRepos, if set, are the only GitHub repositories whose issues count
+	// as links, given as glob patterns (see matchGlob) for "owner/repo", e.g.,
+	// ["tailscale/corp"]. Unlike linkURLs, this applies to the repository
+	// itself, so that a public repository can require its issues to be
+	// tracked privately. It implies strictLinks.
+	LinkRepos []string `json:"linkRepos,omitempty"`
+
+	// LinkPatterns are additional regular expressions (RE2 syntax) for
+	// references to issues in other trackers, e.g., `\bb/\d+`. A match
+	// anywhere in a commit message counts as a link.
+	LinkPatterns []string `json:"linkPatterns,omitempty"`
+
+	// StubTracker is the tracker in which stub issues are filed,
+	// trackerGitHub or trackerJira. If unset, trackerGitHub is used.
+	StubTracker string `json:"stubTracker,omitempty"`
+
+	// LinkTrailers are git trailer keys (e.g., "Issue" or "Bug") whose values
+	// link to issues, as in "Bug: tailscale/corp#456". Matching is
+	// case-insensitive.
+	LinkTrailers []string `json:"linkTrailers,omitempty"`
+
+	// ExemptPaths are glob patterns (see matchGlob) for files that do not
+	// require an issue link. A PR that only changes such files is accepted.
+	ExemptPaths []string `json:"exemptPaths,omitempty"`
+
+	// DiffExcludePaths are glob patterns (see matchGlob) for files whose
+	// changes do not count toward the diff size, e.g., generated or vendored
+	// code.
+	DiffExcludePaths []string `json:"diffExcludePaths,omitempty"`
+
+	// Policy is a list of rules that decide the disposition of a PR, after
+	// the built-in rules have run. See policyRule.
+	Policy []*policyRule `json:"policy,omitempty"`
+
+	// MaxCommits, if set, overrides --max-commits for the repository.
+	MaxCommits *int `json:"maxCommits,omitempty"`
+
+	// DebounceInterval, if set, overrides --debounce-interval for the
+	// repository.
+	DebounceInterval *duration `json:"debounceInterval,omitempty"`
+
+	// StubIssues says whether to create stub issues for PRs that use
+	// skip-issuebot: stubsOff, stubsOn, or stubsRequired. If unset, the
+	// --enable-stub-issues flag decides between stubsOn and stubsOff.
+	StubIssues *stubsMode `json:"stubIssues,omitempty"`
+
+	// SyncStubs, if true, copies the labels and milestone of a PR onto its
+	// stub issue when the stub is filed, and keeps them in step as the PR's
+	// labels and milestone change.
+	SyncStubs bool `json:"syncStubs,omitempty"`
+
+	// DraftStubs, if true, has the language model at --draft-url draft the
+	// body of new stub issues from the PR, for its author to edit.
+	DraftStubs bool `json:"draftStubs,omitempty"`
+
+	// StubReminderAfter is how long a stub issue may go untouched while it is
+	// still a placeholder before its assignee is reminded to fill it in, when
+	// issuebot is run with --stub-reminder-interval. Zero disables reminders.
+	// If unset, defaultStubReminderAfter is used.
+	StubReminderAfter *duration `json:"stubReminderAfter,omitempty"`
+
+	// StubEscalation, if set, says how to escalate stub issues that are
+	// still placeholders long after they were filed, when issuebot is run
+	// with --stub-reminder-interval.
+	StubEscalation *stubEscalation `json:"stubEscalation,omitempty"`
+
+	// StubProject, if set, is a GitHub project to which new stub issues are
+	// added.
+	StubProject *stubProject `json:"stubProject,omitempty"`
+
+	// StubTemplate, if set, is an issue template whose contents are used for
+	// the body of stub issues, after the stub-body message.
+	StubTemplate *stubTemplate `json:"stubTemplate,omitempty"`
+
+	// LinkStubs, if true, adds "Closes #N" for a new stub issue to the PR
+	// description, so that GitHub shows the stub in the PR's Development
+	// sidebar and closes it when the PR is merged. (GitHub has no API to link
+	// an issue to a PR otherwise.)
+	LinkStubs bool `json:"linkStubs,omitempty"`
+
+	// StubAssignee says whom stub issues are assigned to: stubAssignAuthor,
+	// a user's login, or a team as "org/team", whose members are assigned.
+	// If unset, stubAssignAuthor is used.
+	StubAssignee string `json:"stubAssignee,omitempty"`
+
+	// ExternalStubAssignee, if set, takes the place of StubAssignee for PRs
+	// by authors who are not owners, members, or collaborators of the
+	// repository.
+	ExternalStubAssignee string `json:"externalStubAssignee,omitempty"`
+
+	// AbandonedStubs says what to do with a stub issue when its PR is closed
+	// without being merged: abandonClose, abandonLabel, or abandonKeep. Only
+	// stubs that are still unedited placeholders are affected. If unset,
+	// abandonClose is used.
+	AbandonedStubs string `json:"abandonedStubs,omitempty"`
+
+	// OverrideAccess, if set, says who may use override keywords, slash
+	// commands, override labels, and approval phrases. Otherwise only users
+	// with write access to the repository may, except that the PR's author
+	// may always ask for it to be checked again.
+	OverrideAccess *overrideAccess `json:"overrideAccess,omitempty"`
+
+	// ExternalOverrides, if true and OverrideAccess is unset, lets anyone use
+	// override keywords, including contributors from outside the
+	// organization.
+	ExternalOverrides bool `json:"externalOverrides,omitempty"`
+
+	// Mode is the check mode for the repository, modeEnforce or modeAdvisory.
+	// If unset, modeEnforce is used.
+	Mode string `json:"mode,omitempty"`
+
+	// AuditSquashMerges, if true, checks the commit that merges a PR, and
+	// comments on the PR if it does not link to an issue although a commit
+	// on the PR did, as happens when a squash merge drops the link.
+	AuditSquashMerges bool `json:"auditSquashMerges,omitempty"`
+
+	// SkipDrafts, if true, defers the check of a draft PR until it is ready
+	// for review. Until then it gets a pending status (or, with check runs, a
+	// neutral one) instead of a failing one.
+	SkipDrafts bool `json:"skipDrafts,omitempty"`
+
+	// Locale selects the message catalog used for the repository's stub
+	// issues, comments, and statuses (see loadMessageTemplates). If unset, or
+	// if there is no catalog for it, the default messages are used.
+	Locale string `json:"locale,omitempty"`
+
+	lenientRE *regexp.Regexp   // compiled from LinkVerbs, if LenientLinks is set
+	jiraRE    *regexp.Regexp   // compiled from JiraProjects, if set
+	linkREs   []*regexp.Regexp // compiled from LinkPatterns
+}
+
+// linkChecks are requirements on the issues that commits link to. A commit
+// is considered linked if any of the issues it refers to meets them.
+type linkChecks struct {
+	// RequireOpen requires the issue to be open.
+	RequireOpen bool `json:"requireOpen,omitempty"`
+
+	// IssuesOnly requires the reference to be to an issue, not a pull
+	// request.
+	IssuesOnly bool `json:"issuesOnly,omitempty"`
+
+	// NotSelf requires the reference not to be to the pull request itself.
+	NotSelf bool `json:"notSelf,omitempty"`
+}
+
+// A stubEscalation says what to do about stub issues that are still
+// placeholders some time after they were filed. Each stub is escalated once,
+// with a comment on the stub issue, and with whichever of the other actions
+// are set.
+type stubEscalation struct {
+	// After is the age at which a stub is escalated.
+	After duration `json:"after"`
+
+	// Label, if set, is added to the stub issue.
+	Label string `json:"label,omitempty"`
+
+	// CC are users or teams to mention in the comment on the stub issue,
+	// e.g., "@tailscale/triage".
+	CC []string `json:"cc,omitempty"`
+
+	// CommentOnPR, if true, also comments on the PR the stub was filed for.
+	CommentOnPR bool `json:"commentOnPR,omitempty"`
+}
+
+// A stubProject names a GitHub project (the kind introduced in 2022, "Projects
+// v2") and how stub issues are filed in it.
+type stubProject struct {
+	// Owner is the login of the user or organization that owns the project.
+	// If unset, the repository's owner is used.
+	Owner string `json:"owner,omitempty"`
+
+	// Number is the project number, as in its URL.
+	Number int `json:"number"`
+
+	// Field and Status, if Status is set, name a single-select field of the
+	// project and the option to set it to for new stubs, e.g., "Triage". If
+	// unset, Field is "Status".
+	Field  string `json:"field,omitempty"`
+	Status string `json:"status,omitempty"`
+}
+
+// An overrideAccess says who may override the check. For an override keyword
+// in a commit message, that is the author of the commit, if GitHub knows
+// them, or else the author of the PR.
+type overrideAccess struct {
+	// Permission is the least permission on the repository that allows a
+	// user to override the check: "read", "write", or "admin". If unset,
+	// "write" is used.
+	Permission string `json:"permission,omitempty"`
+
+	// Teams are teams, as "org/team", whose members may override the check
+	// whatever their permission.
+	Teams []string `json:"teams,omitempty"`
+}
+
+func (oa *overrideAccess) permission() string {
+	if oa == nil || oa.Permission == "" {
+		return "write"
+	}
+	return oa.Permission
+}
+
+func (oa *overrideAccess) teams() []string {
+	if oa == nil {
+		return nil
+	}
+	return oa.Teams
+}
+
+// A stubTemplate names one of the repository's issue templates, and how to
+// fill it in for a stub issue.
+type stubTemplate struct {
+	// Name is the file name of the template in .github/ISSUE_TEMPLATE,
+	// e.g., "bug_report.md" or "bug.yml" (an issue form). If the repository
+	// has no such template, the organization's .github repository is tried.
+	Name string `json:"name"`
+
+	// Fields are text/template values for the fields of an issue form, by
+	// field id, executed with the same data as the message templates. Fields
+	// not listed here get the form's default value, if any.
+	Fields map[string]string `json:"fields,omitempty"`
+
+	fields map[string]*template.Template // compiled from Fields
+}
+
+// parseRepoConfig parses one or more layers of configuration files. Each
+// layer overrides the settings given in the layers before it; settings that a
+// layer does not mention are inherited. Unknown fields are reported as errors,
+// so that typos do not go unnoticed.
+func parseRepoConfig(layers ...[]byte) (*repoConfig, error) {
+	var cfg repoConfig
+	for _, data := range layers {
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// validate reports an error if c contains invalid settings. Errors about a
+// particular setting are reported as a *fieldError.
+func (c *repoConfig) validate() error {
+	for label, disp := range c.OverrideLabels {
+		switch disp {
+		case overrideSkip, overrideAccept:
+		default:
+			return &fieldError{[]string{"overrideLabels", label}, fmt.Errorf("override label %q: invalid disposition %q", label, disp)}
+		}
+	}
+	for kw, disp := range c.Overrides {
+		switch disp {
+		case overrideSkip, overrideAccept, overrideOff:
+		default:
+			return &fieldError{[]string{"overrides", kw}, fmt.Errorf("override %q: invalid disposition %q", kw, disp)}
+		}
+		if kw == "" {
+			return &fieldError{[]string{"overrides"}, errors.New("empty override keyword")}
+		}
+	}
+	switch c.AbandonedStubs {
+	case "", abandonClose, abandonLabel, abandonKeep:
+	default:
+		return &fieldError{[]string{"abandonedStubs"}, fmt.Errorf("invalid abandonedStubs %q", c.AbandonedStubs)}
+	}
+	switch c.Mode {
+	case "", modeEnforce, modeAdvisory:
+	default:
+		return &fieldError{[]string{"mode"}, fmt.Errorf("invalid mode %q", c.Mode)}
+	}
+	for i, rule := range c.Policy {
+		if err := rule.compile(); err != nil {
+			return &fieldError{[]string{"policy", strconv.Itoa(i)}, fmt.Errorf("policy rule %d: %w", i+1, err)}
+		}
+	}
+	if c.LenientLinks {
+		c.lenientRE = lenientLinkRE(c.linkVerbs())
+	}
+	for i, proj := range c.JiraProjects {
+		if !jiraProjectRE.MatchString(proj) {
+			return &fieldError{[]string{"jiraProjects", strconv.Itoa(i)}, fmt.Errorf("invalid Jira project key %q", proj)}
+		}
+	}
+	if len(c.JiraProjects) != 0 {
+		c.jiraRE = jiraKeyRE(c.JiraProjects)
+	}
+	c.linkREs = nil
+	for i, pat := range c.LinkPatterns {
+		re, err := regexp.Compile(pat)
+		if err != nil {
+			return &fieldError{[]string{"linkPatterns", strconv.Itoa(i)}, fmt.Errorf("link pattern %q: %w", pat, err)}
+		} else if re.MatchString("") {
+			return &fieldError{[]string{"linkPatterns", strconv.Itoa(i)}, fmt.Errorf("link pattern %q matches the empty string", pat)}
+		}
+		c.linkREs = append(c.linkREs, re)
+	}
+	if c.StubEscalation != nil && c.StubEscalation.After <= 0 {
+		return &fieldError{[]string{"stubEscalation", "after"}, errors.New("stubEscalation requires a positive after")}
+	}
+	if c.StubProject != nil && c.StubProject.Number <= 0 {
+		return &fieldError{[]string{"stubProject", "number"}, fmt.Errorf("invalid project number %d", c.StubProject.Number)}
+	}
+	if st := c.StubTemplate; st != nil {
+		if ext := path.Ext(st.Name); strings.Contains(st.Name, "/") || (ext != ".md" && ext != ".yml" && ext != ".yaml") {
+			return &fieldError{[]string{"stubTemplate", "name"}, fmt.Errorf("invalid issue template name %q", st.Name)}
+		}
+		st.fields = make(map[string]*template.Template)
+		for id, text := range st.Fields {
+			t, err := template.New(id).Option("missingkey=error").Parse(text)
+			if err != nil {
+				return &fieldError{[]string{"stubTemplate", "fields", id}, fmt.Errorf("field %s: %w", id, err)}
+			}
+			st.fields[id] = t
+		}
+	}
+	if oa := c.OverrideAccess; oa != nil {
+		if oa.Permission != "" && permissionRanks[oa.Permission] == 0 {
+			return &fieldError{[]string{"overrideAccess", "permission"}, fmt.Errorf("invalid permission %q, want read, write, or admin", oa.Permission)}
+		}
+		for i, team := range oa.Teams {
+			if strings.Count(team, "/") != 1 || !stubAssigneeRE.MatchString(team) {
+				return &fieldError{[]string{"overrideAccess", "teams", strconv.Itoa(i)}, fmt.Errorf("invalid team %q, want org/team", team)}
+			}
+		}
+	}
+	if c.StubIssues != nil {
+		switch *c.StubIssues {
+		case stubsOff, stubsOn, stubsRequired:
+		default:
+			return &fieldError{[]string{"stubIssues"}, fmt.Errorf("invalid stubIssues %q, want off, on, or required", *c.StubIssues)}
+		}
+	}
+	for _, f := range []struct{ name, who string }{
+		{"stubAssignee", c.StubAssignee},
+		{"externalStubAssignee", c.ExternalStubAssignee},
+	} {
+		if f.who != "" && !stubAssigneeRE.MatchString(f.who) {
+			return &fieldError{[]string{f.name}, fmt.Errorf("invalid %s %q, want author, a user, or org/team", f.name, f.who)}
+		}
+	}
+	for i, pat := range c.LinkRepos {
+		if strings.Count(pat, "/") != 1 {
+			return &fieldError{[]string{"linkRepos", strconv.Itoa(i)}, fmt.Errorf("invalid repository pattern %q, want owner/repo", pat)}
+		}
+	}
+	switch c.StubTracker {
+	case "", trackerGitHub:
+	case trackerJira:
+		if len(c.JiraProjects) == 0 {
+			return &fieldError{[]string{"stubTracker"}, errors.New("stub tracker jira requires jiraProjects")}
+		}
+	default:
+		return &fieldError{[]string{"stubTracker"}, fmt.Errorf("unknown tracker %q", c.StubTracker)}
+	}
+	return nil
+}
+
+// lenientLinkRE returns a regexp matching any of verbs, as a whole word,
+// followed by an issue reference. The first subexpression is the reference.
+func lenientLinkRE(verbs []string) *regexp.Regexp {
+	quoted := make([]string, len(verbs))
+	for i, v := range verbs {
+		quoted[i] = regexp.QuoteMeta(v)
+	}
+	return regexp.MustCompile(`(?i)(?:^|[^\w])(?:` + strings.Join(quoted, "|") + `):?\s+(` +
+		urlRE.String() + `|(?:[\w.-]+/[\w.-]+)?#\d+\b|[A-Z][A-Z0-9_]*-\d+\b)`)
+}
+
+// A fieldError is an error in the value of a particular setting.
+type fieldError struct {
+	// path locates the setting: each element is a mapping key, or the
+	// index of an element of a sequence.
+	path []string
+	err  error
+}
+
+func (e *fieldError) Error() string { return e.err.Error() }
+func (e *fieldError) Unwrap() error { return e.err }
+
+func (c *repoConfig) minDiff() int {
+	if c == nil || c.MinDiff == nil {
+		return defaultMinDiff
+	}
+	return *c.MinDiff
+}
+
+// override reports the disposition of message based on the override keywords
+// it contains, along with the keyword responsible. If message contains no
+// override keywords, it returns prFailed and "". If keywords with different
+// dispositions are present, overrideSkip takes precedence.
+func (c *repoConfig) override(message string) (pullRequestStatus, string) {
+	overrides := maps.Clone(defaultOverrides)
+	if c != nil {
+		maps.Copy(overrides, c.Overrides)
+	}
+	status, keyword := prFailed, ""
+	for _, kw := range slices.Sorted(maps.Keys(overrides)) {
+		if !strings.Contains(message, kw) {
+			continue
+		}
+		switch overrides[kw] {
+		case overrideSkip:
+			return prSkipped, kw
+		case overrideAccept:
+			if status == prFailed {
+				status, keyword = prCleanup, kw
+			}
+		}
+	}
+	return status, keyword
+}
+
+// overrideLabel returns the disposition of the PR label with the given name,
+// or "" if it is not an override label.
+func (c *repoConfig) overrideLabel(name string) string {
+	if c == nil {
+		return ""
+	}
+	return c.OverrideLabels[name]
+}
+
+func (c *repoConfig) linkVerbs() []string {
+	if c == nil || c.LinkVerbs == nil {
+		return defaultLinkVerbs
+	}
+	verbs := make([]string, len(c.LinkVerbs))
+	for i, v := range c.LinkVerbs {
+		verbs[i] = strings.ToLower(v)
+	}
+	return verbs
+}
+
+// diffSize returns the number of changed lines in commit, not counting files
+// that match DiffExcludePaths.
+func (c *repoConfig) diffSize(commit *github.RepositoryCommit) int {
+	if c == nil || len(c.DiffExcludePaths) == 0 {
+		return commit.GetStats().GetTotal()
+	}
+	return c.filesDiffSize(commit.Files)
+}
+
+// filesDiffSize returns the number of lines changed in files, not counting
+// files excluded by diffExcludePaths.
+func (c *repoConfig) filesDiffSize(files []*github.CommitFile) int {
+	var n int
+	for _, f := range files {
+		if c == nil || !matchAnyGlob(c.DiffExcludePaths, f.GetFilename()) {
+			n += f.GetChanges()
+		}
+	}
+	return n
+}
+
+// maxCommits returns the most commits of a PR that are scanned.
+func (c *repoConfig) maxCommits() int {
+	if c == nil || c.MaxCommits == nil || *c.MaxCommits <= 0 {
+		return *maxCommits
+	}
+	return *c.MaxCommits
+}
+
+func (c *repoConfig) debounceInterval() time.Duration {
+	if c == nil || c.DebounceInterval == nil {
+		return *debounceInterval
+	}
+	return time.Duration(*c.DebounceInterval)
+}
+
+// stubIssues reports whether to create stub issues for PRs that use
+// skip-issuebot.
+func (c *repoConfig) stubIssues() bool {
+	return c.stubsMode() != stubsOff
+}
+
+// stubsMode returns the repository's stubIssues setting.
+func (c *repoConfig) stubsMode() stubsMode {
+	if c == nil || c.StubIssues == nil {
+		if *enableStubIssues {
+			return stubsOn
+		}
+		return stubsOff
+	}
+	return *c.StubIssues
+}
+
+// stubReminderAfter returns how long a stub issue may stay a placeholder
+// before its assignee is reminded to fill it in.
+func (c *repoConfig) stubReminderAfter() time.Duration {
+	if c == nil || c.StubReminderAfter == nil {
+		return defaultStubReminderAfter
+	}
+	return time.Duration(*c.StubReminderAfter)
+}
+
+func (c *repoConfig) syncStubs() bool {
+	return c != nil && c.SyncStubs
+}
+
+func (c *repoConfig) draftStubs() bool {
+	return c != nil && c.DraftStubs
+}
+
+func (c *repoConfig) approvalPhrase() string {
+	if c == nil {
+		return ""
+	}
+	return c.ApprovalPhrase
+}
+
+func (c *repoConfig) externalOverrides() bool {
+	return c != nil && c.ExternalOverrides
+}
+
+func (c *repoConfig) overrideAccess() *overrideAccess {
+	if c == nil {
+		return nil
+	}
+	return c.OverrideAccess
+}
+
+func (c *repoConfig) stubTemplate() *stubTemplate {
+	if c == nil {
+		return nil
+	}
+	return c.StubTemplate
+}
+
+func (c *repoConfig) linkStubs() bool {
+	return c != nil && c.LinkStubs
+}
+
+// stubAssignee returns whom to assign the stub issue for a PR to, given the
+// author's association with the repository (e.g., "MEMBER"): stubAssignAuthor,
+// a user's login, or a team as "org/team".
+func (c *repoConfig) stubAssignee(association string) string {
+	if c == nil {
+		return stubAssignAuthor
+	}
+	who := c.StubAssignee
+	switch association {
+	case "OWNER", "MEMBER", "COLLABORATOR":
+	default:
+		if c.ExternalStubAssignee != "" {
+			who = c.ExternalStubAssignee
+		}
+	}
+	if who == "" {
+		return stubAssignAuthor
+	}
+	return strings.TrimPrefix(who, "@")
+}
+
+func (c *repoConfig) abandonedStubs() string {
+	if c == nil || c.AbandonedStubs == "" {
+		return abandonClose
+	}
+	return c.AbandonedStubs
+}
+
+// advisory reports whether failures in the repository are advisory, rather
+// than blocking the PR.
+func (c *repoConfig) advisory() bool {
+	return c != nil && c.Mode == modeAdvisory
+}
+
+func (c *repoConfig) auditSquashMerges() bool {
+	return c != nil && c.AuditSquashMerges
+}
+
+func (c *repoConfig) skipDrafts() bool {
+	return c != nil && c.SkipDrafts
+}
+
+func (c *repoConfig) locale() string {
+	if c == nil {
+		return ""
+	}
+	return c.Locale
+}
+
+func (c *repoConfig) strictLinks() bool {
+	return c != nil && (c.StrictLinks || len(c.LinkRepos) != 0 || c.verifiesLinks())
+}
+
+// verifiesLinks reports whether the issues that commits link to must be
+// verified, rather than accepted as written.
+func (c *repoConfig) verifiesLinks() bool {
+	return c != nil && (c.LinkChecks != nil || (c.jiraRE != nil && *jiraURL != ""))
+}
+
+func (c *repoConfig) stubTracker() string {
+	if c == nil || c.StubTracker == "" {
+		return trackerGitHub
+	}
+	return c.StubTracker
+}
+
+func (c *repoConfig) linkChecks() linkChecks {
+	if c == nil || c.LinkChecks == nil {
+		return linkChecks{}
+	}
+	return *c.LinkChecks
+}
+
+func (c *repoConfig) closingIssues() bool {
+	return c != nil && c.ClosingIssues
+}
+
+func (c *repoConfig) verifyBackports() bool {
+	return c != nil && c.VerifyBackports
+}
+
+func (c *repoConfig) timelineLinks() bool {
+	return c != nil && c.TimelineLinks
+}
+
+func (c *repoConfig) prDescription() bool {
+	return c != nil && c.PRDescription
+}
+
+func (c *repoConfig) linkURLs() []string {
+	if c == nil {
+		return nil
+	}
+	return c.LinkURLs
+}
+
+// allowedURL reports whether the URL u, given as host and path, is permitted
+// by the repository's linkURLs setting. Matching is case-insensitive.
+func (c *repoConfig) allowedURL(u string) bool {
+	if len(c.linkURLs()) == 0 {
+		return true
+	}
+	u = strings.ToLower(u)
+	for _, pat := range c.LinkURLs {
+		if strings.Contains(pat, "/") && matchGlob(strings.ToLower(pat), u) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedRepo reports whether issues in the GitHub repository repo count as
+// links from commits in the repository self. If linkRepos is set, it decides;
+// otherwise self is always allowed, and others must be allowed by linkURLs.
+func (c *repoConfig) allowedRepo(repo, self string) bool {
+	if c != nil && len(c.LinkRepos) != 0 {
+		repo = strings.ToLower(repo)
+		return slices.ContainsFunc(c.LinkRepos, func(pat string) bool {
+			return matchGlob(strings.ToLower(pat), repo)
+		})
+	}
+	return strings.EqualFold(repo, self) || c.allowedURL("github.com/"+repo)
+}
+
+// isLinkTrailer reports whether key is one of the repository's link trailers.
+func (c *repoConfig) isLinkTrailer(key string) bool {
+	return c != nil && slices.ContainsFunc(c.LinkTrailers, func(k string) bool { return strings.EqualFold(k, key) })
+}
+
+// A stubsMode is a setting of stubIssues. In configuration files, true and
+// false mean stubsOn and stubsOff.
+type stubsMode string
+
+func (m *stubsMode) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*m = stubsOff
+		if b {
+			*m = stubsOn
+		}
+		return nil
+	}
+	return json.Unmarshal(data, (*string)(m))
+}
+
+// A duration is a time.Duration that is encoded in configuration files as a
+// string, e.g., "30s".
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+var repoConfigCache = struct {
+	sync.Mutex
+	m map[string]cachedConfig // :: string owner/repo → config
+}{
+	m: make(map[string]cachedConfig),
+}
+
+type cachedConfig struct {
+	cfg     *repoConfig
+	fetched time.Time
+}
+
+// loadRepoConfig returns the configuration for repo, fetching it from GitHub
+// if it is not already cached. A repository without a configuration file, or
+// with an invalid one, gets the default settings.
+func loadRepoConfig(ctx context.Context, cli *github.Client, repo *github.Repository) (*repoConfig, error) {
+	key := repo.GetFullName()
+	repoConfigCache.Lock()
+	cc, ok := repoConfigCache.m[key]
+	repoConfigCache.Unlock()
+	if ok && time.Since(cc.fetched) < repoConfigTTL {
+		return cc.cfg, nil
+	}
+
+	cfg, err := fetchRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return nil, err
+	}
+	repoConfigCache.Lock()
+	defer repoConfigCache.Unlock()
+	repoConfigCache.m[key] = cachedConfig{cfg: cfg, fetched: time.Now()}
+	return cfg, nil
+}
+
+// fetchRepoConfig reads and parses the configuration for repo, layering its
+// own configuration file over the organization defaults.
+func fetchRepoConfig(ctx context.Context, cli *github.Client, repo *github.Repository) (*repoConfig, error) {
+	owner := repo.GetOwner().GetLogin()
+	orgText, err := fetchConfigText(ctx, cli, owner, orgConfigRepo)
+	if err != nil {
+		return nil, err
+	}
+	repoText, err := fetchConfigText(ctx, cli, owner, repo.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if orgText == "" && repoText == "" {
+		return nil, nil // no config, use defaults
+	}
+	cfg, err := parseRepoConfig([]byte(orgText), []byte(repoText))
+	if err != nil {
+		log.Printf("invalid config for %s (using defaults): %v", repo.GetFullName(), err)
+		return nil, nil
+	}
+	return cfg, nil
+}
+
+// fetchConfigText returns the text of the configuration file in the specified
+// repository, or "" if it does not have one.
+func fetchConfigText(ctx context.Context, cli *github.Client, owner, repo string) (string, error) {
+	fc, resp, err := getContents(ctx, cli, owner, repo, repoConfigPath)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("get %s/%s: %w", repo, repoConfigPath, err)
+	}
+	text, err := fc.GetContent()
+	if err != nil {
+		return "", fmt.Errorf("decode %s/%s: %w", repo, repoConfigPath, err)
+	}
+	return text, nil
+}
+
+// getContents fetches the contents of the file at path in the default branch
+// of the specified repository.
+func getContents(ctx context.Context, cli *github.Client, owner, repo, path string) (*github.RepositoryContent, *github.Response, error) {
+	return retryCall(ctx, "GetContents", func(ctx context.Context) (*github.RepositoryContent, *github.Response, error) {
+		fc, _, resp, err := cli.Repositories.GetContents(ctx, owner, repo, path, nil)
+		return fc, resp, err
+	})
+}
+
+// pushTouchesConfig reports whether the push event e changed the
+// configuration file on the default branch of its repository.
+func pushTouchesConfig(e *github.PushEvent) bool {
+	if e.GetRef() != "refs/heads/"+e.GetRepo().GetDefaultBranch() {
+		return false
+	}
+	for _, c := range e.Commits {
+		for _, files := range [][]string{c.Added, c.Modified, c.Removed} {
+			if slices.Contains(files, repoConfigPath) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// invalidateRepoConfig discards cached configuration for the repository with
+// the given owner and name. If the repository is the organization default
+// config repository, the configuration of all the owner's repositories is
+// discarded.
+func invalidateRepoConfig(owner, name string) {
+	repoConfigCache.Lock()
+	defer repoConfigCache.Unlock()
+	if name != orgConfigRepo {
+		delete(repoConfigCache.m, owner+"/"+name)
+		return
+	}
+	for key := range repoConfigCache.m {
+		if strings.HasPrefix(key, owner+"/") {
+			delete(repoConfigCache.m, key)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseRepoConfig(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte(`
+minDiff: 20
+overrides:
+  "#trivial": accept
+  "no-issue:": skip
+  "#cleanup": "off"
+stubIssues: false
+debounceInterval: 1m30s
+mode: advisory
+`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	if got := cfg.minDiff(); got != 20 {
+		t.Errorf("minDiff: got %d, want 20", got)
+	}
+	if cfg.stubIssues() {
+		t.Error("stubIssues: got true, want false")
+	}
+	if got, want := cfg.debounceInterval(), 90*time.Second; got != want {
+		t.Errorf("debounceInterval: got %v, want %v", got, want)
+	}
+	if !cfg.advisory() {
+		t.Error("advisory: got false, want true")
+	}
+	overrideTests := []struct {
+		message string
+		status  pullRequestStatus
+	}{
+		{"x\nskip-issuebot", prSkipped},          // default
+		{"x\nno-issue: typo", prSkipped},         // added
+		{"x\n#trivial", prCleanup},               // added
+		{"x\n#cleanup", prFailed},                // disabled
+		{"x\n#trivial skip-issuebot", prSkipped}, // skip wins
+		{"x\nnothing to see here", prFailed},
+	}
+	for _, tc := range overrideTests {
+		if got, _ := cfg.override(tc.message); got != tc.status {
+			t.Errorf("override(%q): got %v, want %v", tc.message, got, tc.status)
+		}
+	}
+
+	// Invalid dispositions are rejected.
+	if _, err := parseRepoConfig([]byte("overrides: {\"#x\": maybe}\n")); err == nil {
+		t.Error("parseRepoConfig: got nil error for invalid disposition")
+	}
+
+	// Invalid modes are rejected.
+	if _, err := parseRepoConfig([]byte("mode: lenient\n")); err == nil {
+		t.Error("parseRepoConfig: got nil error for invalid mode")
+	}
+
+	// So are invalid abandonedStubs settings.
+	if _, err := parseRepoConfig([]byte("abandonedStubs: delete\n")); err == nil {
+		t.Error("parseRepoConfig: got nil error for invalid abandonedStubs")
+	}
+
+	// stubIssues takes off, on, or required, or a boolean.
+	stubTests := []struct {
+		config string
+		want   stubsMode
+	}{
+		{"stubIssues: true", stubsOn},
+		{"stubIssues: false", stubsOff},
+		{"stubIssues: on", stubsOn},
+		{"stubIssues: off", stubsOff},
+		{"stubIssues: \"off\"", stubsOff},
+		{"stubIssues: required", stubsRequired},
+	}
+	for _, tc := range stubTests {
+		cfg, err := parseRepoConfig([]byte(tc.config))
+		if err != nil {
+			t.Errorf("parseRepoConfig(%q): unexpected error: %v", tc.config, err)
+		} else if got := cfg.stubsMode(); got != tc.want {
+			t.Errorf("stubsMode(%q): got %q, want %q", tc.config, got, tc.want)
+		}
+	}
+	if _, err := parseRepoConfig([]byte("stubIssues: always\n")); err == nil {
+		t.Error("parseRepoConfig: got nil error for invalid stubIssues")
+	}
+
+	// Unknown fields are rejected.
+	if _, err := parseRepoConfig([]byte("minDif: 20\n")); err == nil {
+		t.Error("parseRepoConfig: got nil error for unknown field")
+	}
+
+	// Later layers override earlier ones, and inherit what they do not set.
+	cfg, err = parseRepoConfig([]byte("minDiff: 20\nstubIssues: false\n"), []byte("minDiff: 3\n"))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	if got := cfg.minDiff(); got != 3 {
+		t.Errorf("layered minDiff: got %d, want 3", got)
+	}
+	if cfg.stubIssues() {
+		t.Error("layered stubIssues: got true, want false")
+	}
+
+	// A nil config has the default settings.
+	var zero *repoConfig
+	if got := zero.minDiff(); got != defaultMinDiff {
+		t.Errorf("nil minDiff: got %d, want %d", got, defaultMinDiff)
+	}
+	if zero.advisory() {
+		t.Error("nil advisory: got true, want false")
+	}
+	if got := zero.abandonedStubs(); got != abandonClose {
+		t.Errorf("nil abandonedStubs: got %q, want %q", got, abandonClose)
+	}
+}
+
+func TestLoadDaemonConfig(t *testing.T) {
+	old := *listenAddr
+	t.Cleanup(func() { *listenAddr = old })
+	t.Setenv("ISSUEBOT_TEST_PORT", "9999")
+
+	path := filepath.Join(t.TempDir(), "config.hujson")
+	if err := os.WriteFile(path, []byte(`{
+  // Comments and trailing commas are allowed.
+  "listen": ":${ISSUEBOT_TEST_PORT}",
+}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadDaemonConfig(path); err != nil {
+		t.Fatalf("loadDaemonConfig: unexpected error: %v", err)
+	}
+	if got, want := *listenAddr, ":9999"; got != want {
+		t.Errorf("listen: got %q, want %q", got, want)
+	}
+
+	// Unknown settings are rejected.
+	if err := os.WriteFile(path, []byte(`{"no-such-flag": true}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadDaemonConfig(path); err == nil {
+		t.Error("loadDaemonConfig: got nil error for unknown setting")
+	}
+}
+
+func TestLinkVerbs(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte(`linkVerbs: [Fixes, Ref, See]`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	tests := []struct {
+		commit string
+		result pullRequestStatus
+	}{
+		{"custom verb\nRef #1", prLinked},
+		{"custom verb\nsee #1", prLinked},
+		{"default verb\nFixes #1", prLinked},
+		{"removed default verb\nFor #1", prFailed},
+		{"removed default verb\nUpdates #1", prFailed},
+	}
+	for _, tc := range tests {
+		p := pullRequest{cfg: cfg}
+		if got := p.checkCommitMessage(tc.commit); got != tc.result {
+			t.Errorf("checkCommitMessage(%q): got %v, want %v", tc.commit, got, tc.result)
+		}
+	}
+}
+
+func TestLenientLinks(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte(`lenientLinks: true`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	tests := []struct {
+		commit string
+		result pullRequestStatus
+	}{
+		{"x\nThis updates #123 to fix the race", prLinked},
+		{"x\nWe should fix: tailscale/corp#5 soon", prLinked},
+		{"x\nIt fixes https://github.com/o/r/issues/9.", prLinked},
+		{"x\nUpdates #1", prLinked},
+		{"x\nThe prefix #1 is not a verb", prFailed},
+		{"x\nThis prefixes #1 with a verb", prFailed},
+		{"x\nThe fixture #1 is broken", prFailed},
+	}
+	for _, tc := range tests {
+		p := pullRequest{cfg: cfg}
+		if got := p.checkCommitMessage(tc.commit); got != tc.result {
+			t.Errorf("checkCommitMessage(%q): got %v, want %v", tc.commit, got, tc.result)
+		}
+	}
+}
+
+func TestStrictLinks(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte(`strictLinks: true`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	tests := []struct {
+		commit string
+		loose  pullRequestStatus
+		strict pullRequestStatus
+	}{
+		{"x\nFixes #nothing-whatsoever", prLinked, prFailed},
+		{"x\nUpdates github.com to be more fabulous", prLinked, prFailed},
+		{"x\nUpdates #123", prLinked, prLinked},
+		{"x\nUpdates https://github.com/o/r/issues/1", prLinked, prLinked},
+	}
+	for _, tc := range tests {
+		if got := (pullRequest{}).checkCommitMessage(tc.commit); got != tc.loose {
+			t.Errorf("loose checkCommitMessage(%q): got %v, want %v", tc.commit, got, tc.loose)
+		}
+		if got := (pullRequest{cfg: cfg}).checkCommitMessage(tc.commit); got != tc.strict {
+			t.Errorf("strict checkCommitMessage(%q): got %v, want %v", tc.commit, got, tc.strict)
+		}
+	}
+}
+
+func TestMatchRepo(t *testing.T) {
+	const patterns = "tailscale/*, Example/Widget"
+	tests := []struct {
+		name  string
+		match bool
+	}{
+		{"tailscale/tailscale", true},
+		{"Tailscale/corp", true},
+		{"example/widget", true},
+		{"example/gadget", false},
+		{"tailscale", false},
+		{"other/tailscale", false},
+	}
+	for _, tc := range tests {
+		if got := matchRepo(patterns, tc.name); got != tc.match {
+			t.Errorf("matchRepo(%q): got %v, want %v", tc.name, got, tc.match)
+		}
+	}
+	if matchRepo("", "tailscale/tailscale") {
+		t.Error("matchRepo with no patterns: got true, want false")
+	}
+}
+
+func TestConfigErrorLine(t *testing.T) {
+	tests := []struct {
+		config string
+		line   int
+	}{
+		{"minDiff: 3\nstubIsues: true\n", 2},                                   // unknown field
+		{"minDiff: 3\npolicy:\n  - when: 'true'\n    rollout: x\n", 4},         // wrong type
+		{"mode: advisory\noverrides:\n  \"#a\": accept\n  \"#b\": maybe\n", 4}, // invalid value
+		{"policy:\n  - when: 'true'\n    status: bot\n  - when: 'x +'\n    status: bot\n", 4},
+		{"mode: strict\n", 1},
+		{"minDiff: [\n", 1}, // syntax error
+	}
+	for _, tc := range tests {
+		_, err := parseRepoConfig([]byte(tc.config))
+		if err == nil {
+			t.Errorf("parseRepoConfig(%q): got nil error", tc.config)
+			continue
+		}
+		if got := configErrorLine([]byte(tc.config), err); got != tc.line {
+			t.Errorf("configErrorLine(%q, %v): got %d, want %d", tc.config, err, got, tc.line)
+		}
+	}
+}
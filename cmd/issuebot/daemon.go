@@ -0,0 +1,129 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tailscale/hujson"
+)
+
+// loadDaemonConfig reads the HuJSON configuration file at path, and applies
+// its settings to the flags that were not set explicitly on the command line.
+// The file contains a single object whose keys are flag names, for example:
+//
+//	{
+//	  "app-id": 12345,
+//	  "app-install": 67890,
+//	  "use-secrets-service": "https://secrets.example.com",
+//	  "bot-author-regexp": "^noreply\\+([-\\w]+)@example.com$",
+//	  "queue-dir": "${STATE_DIRECTORY}/queue",
+//	}
+//
+// References to environment variables in string values are expanded.
+func loadDaemonConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data, err = hujson.Standardize(data)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	var settings map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&settings); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	// Flags given on the command line take precedence over the file.
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for _, name := range slices.Sorted(maps.Keys(settings)) {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		} else if explicit[name] {
+			continue
+		}
+		val, err := settingString(settings[name])
+		if err != nil {
+			return fmt.Errorf("%s: setting %q: %w", path, name, err)
+		}
+		if err := f.Value.Set(val); err != nil {
+			return fmt.Errorf("%s: setting %q: %w", path, name, err)
+		}
+	}
+	return nil
+}
+
+// settingString converts a value from the daemon configuration file into the
+// string form accepted by the corresponding flag.
+func settingString(v any) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return os.ExpandEnv(t), nil
+	case json.Number:
+		return t.String(), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
+
+// flagOrEnvInt64 returns v if it is nonzero, and otherwise the value of the
+// named environment variable, which must be an integer.
+func flagOrEnvInt64(v int64, env string) (int64, error) {
+	if v != 0 {
+		return v, nil
+	}
+	s := os.Getenv(env)
+	if s == "" {
+		return 0, fmt.Errorf("%s is not set", env)
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %s as integer: %q", env, s)
+	}
+	return n, nil
+}
+
+// repoEnabled reports whether pull requests in the repository with the given
+// full name (owner/repo) should be checked, according to --allow-repos and
+// --deny-repos. The deny list takes precedence.
+func repoEnabled(fullName string) bool {
+	if matchRepo(*denyRepos, fullName) {
+		return false
+	}
+	return *allowRepos == "" || matchRepo(*allowRepos, fullName)
+}
+
+// matchRepo reports whether fullName matches any of the comma-separated glob
+// patterns (see path.Match) in patterns. Matching is case-insensitive, since
+// GitHub owner and repository names are.
+func matchRepo(patterns, fullName string) bool {
+	for pat := range strings.SplitSeq(patterns, ",") {
+		pat = strings.TrimSpace(pat)
+		if pat == "" {
+			continue
+		}
+		if ok, _ := path.Match(strings.ToLower(pat), strings.ToLower(fullName)); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -12,36 +12,82 @@ import (
 	"github.com/google/go-github/v72/github"
 )
 
-// debounceInterval is how long after we've checked a PR that we are willing to
-// check it again, to avoid duplicate stubbing.
-const debounceInterval = 5 * time.Second
-
 var debounceCache = struct {
 	sync.Mutex
-	m map[string]time.Time // :: string repo#PR → last checked
+	m map[string]time.Time // :: string repo#PR → end of debounce period
 }{
 	m: make(map[string]time.Time),
 }
 
 // debounce reports whether checking the given pull request on repo should be
-// skipped because we just checked it recently.
-func debounce(pr *github.PullRequest, repo *github.Repository) bool {
+// skipped because we checked it within the last interval.
+func debounce(pr *github.PullRequest, repo *github.Repository, interval time.Duration) bool {
 	debounceCache.Lock()
 	defer debounceCache.Unlock()
 
 	// Clean out stale cache entries.
 	now := time.Now()
-	for old, then := range debounceCache.m {
-		if now.Sub(then) > debounceInterval {
+	for old, until := range debounceCache.m {
+		if now.After(until) {
 			delete(debounceCache.m, old)
 		}
 	}
 
 	key := fmt.Sprintf("%s#%d", repo.GetFullName(), pr.GetNumber())
-	when, ok := debounceCache.m[key]
-	if !ok {
-		debounceCache.m[key] = now
-		return false
+	if _, ok := debounceCache.m[key]; ok {
+		return true
+	}
+	debounceCache.m[key] = now.Add(interval)
+	return false
+}
+
+// undebounce forgets that the given pull request was checked recently, so
+// that the next check of it goes ahead.
+func undebounce(pr *github.PullRequest, repo *github.Repository) {
+	debounceCache.Lock()
+	defer debounceCache.Unlock()
+	delete(debounceCache.m, fmt.Sprintf("%s#%d", repo.GetFullName(), pr.GetNumber()))
+}
+
+// deliveryRetention is how long we remember webhook delivery IDs that we have
+// already handled, so that redeliveries of the same event can be ignored.
+const deliveryRetention = time.Hour
+
+var deliveryCache = struct {
+	sync.Mutex
+	m map[string]time.Time // :: delivery ID → first seen
+}{
+	m: make(map[string]time.Time),
+}
+
+// seenDelivery reports whether the webhook delivery with the given ID has
+// already been handled, and records it as handled if not. Unlike debounce,
+// this is keyed by the delivery rather than the PR, so distinct events for
+// the same PR are not affected.
+func seenDelivery(id string) bool {
+	deliveryCache.Lock()
+	defer deliveryCache.Unlock()
+
+	// Clean out stale cache entries.
+	now := time.Now()
+	for old, then := range deliveryCache.m {
+		if now.Sub(then) > deliveryRetention {
+			delete(deliveryCache.m, old)
+		}
+	}
+
+	if _, ok := deliveryCache.m[id]; ok {
+		return true
 	}
-	return now.Sub(when) <= debounceInterval
+	deliveryCache.m[id] = now
+	return false
+}
+
+// forgetDelivery removes the delivery with the given ID from the cache, so
+// that a redelivery of it will be handled. This is used when handling the
+// delivery failed.
+func forgetDelivery(id string) {
+	deliveryCache.Lock()
+	defer deliveryCache.Unlock()
+	delete(deliveryCache.m, id)
 }
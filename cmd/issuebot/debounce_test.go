@@ -0,0 +1,99 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/tailscale/setec/client/setec"
+)
+
+func TestSeenDelivery(t *testing.T) {
+	const id, other = "test-delivery-1", "test-delivery-2"
+	t.Cleanup(func() {
+		forgetDelivery(id)
+		forgetDelivery(other)
+	})
+
+	if seenDelivery(id) {
+		t.Fatalf("seenDelivery(%s): got true for a new delivery", id)
+	}
+	if !seenDelivery(id) {
+		t.Errorf("seenDelivery(%s): got false for a duplicate delivery", id)
+	}
+	if seenDelivery(other) {
+		t.Errorf("seenDelivery(%s): got true for another new delivery", other)
+	}
+
+	// A delivery that failed is accepted again, once.
+	forgetDelivery(id)
+	if seenDelivery(id) {
+		t.Errorf("seenDelivery(%s) after forgetDelivery: got true, want false", id)
+	}
+	if !seenDelivery(id) {
+		t.Errorf("seenDelivery(%s) again: got false, want true", id)
+	}
+	if !seenDelivery(other) {
+		t.Errorf("seenDelivery(%s) after forgetting %s: got false, want true", other, id)
+	}
+
+	// Deliveries are remembered only for deliveryRetention.
+	deliveryCache.Lock()
+	deliveryCache.m[id] = time.Now().Add(-deliveryRetention - time.Minute)
+	deliveryCache.Unlock()
+	if seenDelivery(id) {
+		t.Errorf("seenDelivery(%s) after %v: got true, want false", id, deliveryRetention)
+	}
+}
+
+func TestDuplicateDelivery(t *testing.T) {
+	old := githubWebhookSecret
+	t.Cleanup(func() { githubWebhookSecret = old })
+	githubWebhookSecret = setec.StaticSecret("current")
+	const id = "test-delivery-3"
+	t.Cleanup(func() { forgetDelivery(id) })
+
+	const body = `{"zen":"Approachable is better than simple.","hook_id":42}`
+	h := hmac.New(sha256.New, []byte("current"))
+	h.Write([]byte(body))
+	deliver := func() int {
+		req := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(github.EventTypeHeader, "ping")
+		req.Header.Set(github.DeliveryIDHeader, id)
+		req.Header.Set(github.SHA256SignatureHeader, "sha256="+hex.EncodeToString(h.Sum(nil)))
+		rec := httptest.NewRecorder()
+		handleWebhook(rec, req)
+		return rec.Code
+	}
+
+	pingsBefore, dupsBefore := pings.Value(), duplicateHooks.Value()
+	for range 2 {
+		if code := deliver(); code != http.StatusOK {
+			t.Fatalf("delivery: got status %d, want %d", code, http.StatusOK)
+		}
+	}
+	if n := pings.Value() - pingsBefore; n != 1 {
+		t.Errorf("pings handled: got %d, want 1", n)
+	}
+	if n := duplicateHooks.Value() - dupsBefore; n != 1 {
+		t.Errorf("duplicates counted: got %d, want 1", n)
+	}
+
+	// Once forgotten, as after a failure, a redelivery is handled.
+	forgetDelivery(id)
+	deliver()
+	if n := pings.Value() - pingsBefore; n != 2 {
+		t.Errorf("pings handled after forgetDelivery: got %d, want 2", n)
+	}
+}
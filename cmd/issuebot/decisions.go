@@ -0,0 +1,91 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"slices"
+	"sync"
+
+	"tailscale.com/util/lru"
+)
+
+// reusableActions are the pull_request event actions that do not change the
+// commits of a PR. When one of them triggers a check, the last decision about
+// the PR is reused if nothing it depends on has changed since.
+var reusableActions = []string{
+	"labeled", "unlabeled",
+	"assigned", "unassigned",
+	"review_requested", "review_request_removed",
+	"milestoned", "demilestoned",
+	"edited",
+}
+
+// A decision is the disposition of a PR when it was last checked, with what
+// it was decided from.
+type decision struct {
+	headSHA string
+	cfg     *repoConfig // compared by identity, like commitCacheKey
+	inputs  [sha256.Size]byte
+	status  pullRequestStatus
+}
+
+// decisionCache holds the last decision about each PR, by repo#PR.
+var decisionCache struct {
+	sync.Mutex
+	c lru.Cache[string, *decision]
+}
+
+// decisionInputs returns a digest of the parts of p other than its commits
+// that its disposition may depend on: those a policy sees, and the title and
+// description, which may link to an issue.
+func (p pullRequest) decisionInputs() [sha256.Size]byte {
+	pr := p.pr
+	var labels []string
+	for _, l := range pr.Labels {
+		labels = append(labels, l.GetName())
+	}
+	slices.Sort(labels)
+	h := sha256.New()
+	fmt.Fprintf(h, "%q %q %q %v %q %q", labels, pr.GetMilestone().GetTitle(), pr.GetBase().GetRef(), pr.GetDraft(), pr.GetTitle(), pr.GetBody())
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func (p pullRequest) decisionKey() string {
+	return fmt.Sprintf("%s#%d", p.repo.GetFullName(), p.pr.GetNumber())
+}
+
+// lastDecision returns the disposition of p when it was last checked, if
+// its head commit, settings, and decision inputs are the same now.
+func (p pullRequest) lastDecision() (pullRequestStatus, bool) {
+	decisionCache.Lock()
+	d, ok := decisionCache.c.GetOk(p.decisionKey())
+	decisionCache.Unlock()
+	if !ok || d.headSHA != p.pr.GetHead().GetSHA() || d.cfg != p.cfg || d.inputs != p.decisionInputs() {
+		return prFailed, false
+	}
+	return d.status, true
+}
+
+// rememberDecision records status as the disposition of p, unless the cache
+// is disabled by --decision-cache-size.
+func (p pullRequest) rememberDecision(status pullRequestStatus) {
+	if *decisionCacheSize <= 0 || p.pr.GetHead().GetSHA() == "" {
+		return
+	}
+	d := &decision{
+		headSHA: p.pr.GetHead().GetSHA(),
+		cfg:     p.cfg,
+		inputs:  p.decisionInputs(),
+		status:  status,
+	}
+	decisionCache.Lock()
+	defer decisionCache.Unlock()
+	decisionCache.c.MaxEntries = *decisionCacheSize
+	decisionCache.c.Set(p.decisionKey(), d)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestLastDecision(t *testing.T) {
+	defer func() {
+		decisionCache.Lock()
+		decisionCache.c.Clear()
+		decisionCache.Unlock()
+	}()
+	cfg := new(repoConfig)
+	newPR := func() pullRequest {
+		return pullRequest{
+			repo: &github.Repository{FullName: github.Ptr("o/r")},
+			pr: &github.PullRequest{
+				Number: github.Ptr(1),
+				Head:   &github.PullRequestBranch{SHA: github.Ptr("abc")},
+				Base:   &github.PullRequestBranch{Ref: github.Ptr("main")},
+				Title:  github.Ptr("fix it"),
+				Labels: []*github.Label{{Name: github.Ptr("a")}, {Name: github.Ptr("b")}},
+			},
+			cfg: cfg,
+		}
+	}
+	newPR().rememberDecision(prLinked)
+
+	p := newPR()
+	p.pr.Assignee = &github.User{Login: github.Ptr("someone")}
+	p.pr.Labels[0], p.pr.Labels[1] = p.pr.Labels[1], p.pr.Labels[0]
+	if got, ok := p.lastDecision(); !ok || got != prLinked {
+		t.Errorf("lastDecision after assignment: got %v, %v, want %v, true", got, ok, prLinked)
+	}
+
+	tests := []struct {
+		name   string
+		change func(p *pullRequest)
+	}{
+		{"head", func(p *pullRequest) { p.pr.Head.SHA = github.Ptr("def") }},
+		{"settings", func(p *pullRequest) { p.cfg = new(repoConfig) }},
+		{"label", func(p *pullRequest) { p.pr.Labels = p.pr.Labels[:1] }},
+		{"milestone", func(p *pullRequest) { p.pr.Milestone = &github.Milestone{Title: github.Ptr("v1")} }},
+		{"base", func(p *pullRequest) { p.pr.Base.Ref = github.Ptr("release") }},
+		{"body", func(p *pullRequest) { p.pr.Body = github.Ptr("Fixes #2") }},
+		{"other PR", func(p *pullRequest) { p.pr.Number = github.Ptr(2) }},
+	}
+	for _, tc := range tests {
+		p := newPR()
+		tc.change(&p)
+		if got, ok := p.lastDecision(); ok {
+			t.Errorf("lastDecision after changing %s: got %v, want none", tc.name, got)
+		}
+	}
+}
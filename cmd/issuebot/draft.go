@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const (
+	// draftTimeout bounds a request to the language model at --draft-url,
+	// which can be slower than the other APIs issuebot calls.
+	draftTimeout = 2 * time.Minute
+
+	// maxDraftDescription and maxDraftFiles limit how much of a PR is sent
+	// to the language model.
+	maxDraftDescription = 4000
+	maxDraftFiles       = 100
+)
+
+// draftPrompt is the system prompt for drafting a stub issue body.
+const draftPrompt = `You write GitHub issues. Given a pull request, write the body of an issue that tracks the work it does: the problem or goal it addresses, and any follow-up that is evident from it. Use Markdown, do not include a title, and be brief. Do not invent details that the pull request does not support.`
+
+// draftStub asks the language model at --draft-url to draft the body of a
+// stub issue for p, from its title, description, and the files it changes.
+func (p pullRequest) draftStub(ctx context.Context, cli *github.Client) (string, error) {
+	if *draftURL == "" {
+		return "", errors.New("draft: --draft-url is not set")
+	}
+	files, _, err := retryCall(ctx, "ListFiles", func(ctx context.Context) ([]*github.CommitFile, *github.Response, error) {
+		return cli.PullRequests.ListFiles(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), &github.ListOptions{PerPage: maxDraftFiles})
+	})
+	if err != nil {
+		return "", fmt.Errorf("list files: %w", err)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Repository: %s\nPull request #%d: %s\n", p.repo.GetFullName(), p.pr.GetNumber(), p.pr.GetTitle())
+	if desc := strings.TrimSpace(stubLinkRE.ReplaceAllString(p.pr.GetBody(), "")); desc != "" {
+		if len(desc) > maxDraftDescription {
+			desc = desc[:maxDraftDescription] + "…"
+		}
+		fmt.Fprintf(&sb, "\nDescription:\n%s\n", desc)
+	}
+	fmt.Fprintf(&sb, "\nChanged files (%d):\n", p.pr.GetChangedFiles())
+	for _, f := range files {
+		fmt.Fprintf(&sb, "%s (%s, +%d -%d)\n", f.GetFilename(), f.GetStatus(), f.GetAdditions(), f.GetDeletions())
+	}
+	return draftRequest(ctx, draftPrompt, sb.String())
+}
+
+// draftRequest sends a chat completion request with the given system and
+// user messages to --draft-url, and returns the reply.
+func draftRequest(ctx context.Context, system, user string) (string, error) {
+	type message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+	data, err := json.Marshal(struct {
+		Model    string    `json:"model,omitempty"`
+		Messages []message `json:"messages"`
+	}{
+		Model:    *draftModel,
+		Messages: []message{{"system", system}, {"user", user}},
+	})
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, draftTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", *draftURL, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if tok := string(draftToken()); tok != "" {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("draft: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("draft: %s", resp.Status)
+	}
+	var out struct {
+		Choices []struct {
+			Message message `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", fmt.Errorf("draft: %w", err)
+	}
+	if len(out.Choices) == 0 {
+		return "", errors.New("draft: no reply")
+	}
+	return strings.TrimSpace(out.Choices[0].Message.Content), nil
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/tailscale/setec/client/setec"
+)
+
+func TestDraftStub(t *testing.T) {
+	var prompt string
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sekrit" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Messages []struct{ Content string }
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		prompt = req.Messages[len(req.Messages)-1].Content
+		w.Write([]byte(`{"choices": [{"message": {"role": "assistant", "content": "The frobnicator leaks.\n"}}]}`))
+	}))
+	t.Cleanup(llm.Close)
+	oldURL, oldToken := *draftURL, draftToken
+	*draftURL, draftToken = llm.URL, setec.StaticSecret("sekrit")
+	t.Cleanup(func() { *draftURL, draftToken = oldURL, oldToken })
+
+	var got github.IssueRequest
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/pulls/1/files":
+			w.Write([]byte(`[{"filename": "frob.go", "status": "modified", "additions": 3, "deletions": 1}]`))
+		case "/repos/o/r/issues":
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(`{"number": 7}`))
+		case "/repos/o/r/issues/1/comments":
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	newPR := func(config string) pullRequest {
+		cfg, err := parseRepoConfig([]byte(config))
+		if err != nil {
+			t.Fatalf("parseRepoConfig(%q): unexpected error: %v", config, err)
+		}
+		return pullRequest{
+			repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+			pr: &github.PullRequest{
+				Number: github.Ptr(1),
+				Title:  github.Ptr("Plug the frobnicator leak"),
+				Body:   github.Ptr("It leaked.\n\nCloses #7 <!-- issuebot:stub-link -->"),
+				User:   &github.User{Login: github.Ptr("alice")},
+			},
+			cfg: cfg,
+		}
+	}
+
+	p := newPR("draftStubs: true")
+	if _, err := p.createStubIssue(t.Context(), cli); err != nil {
+		t.Fatalf("createStubIssue: unexpected error: %v", err)
+	}
+	for _, want := range []string{"Plug the frobnicator leak", "It leaked.", "frob.go (modified, +3 -1)"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt: got %q, want it to contain %q", prompt, want)
+		}
+	}
+	if strings.Contains(prompt, "issuebot:stub-link") {
+		t.Errorf("prompt: got %q, want no stub link", prompt)
+	}
+	body := parseStubBody(got.GetBody()).text
+	if !strings.HasPrefix(body, "The frobnicator leaks.\n\n") || !strings.Contains(body, "@alice") {
+		t.Errorf("drafted stub body: got %q", body)
+	}
+	if ok, err := p.isPlaceholder(&github.Issue{Title: got.Title, Body: got.Body}); !ok || err != nil {
+		t.Errorf("isPlaceholder(drafted stub): got %v, %v; want true, nil", ok, err)
+	}
+
+	// Without the opt-in, or if drafting fails, the default body is used.
+	for _, config := range []string{"syncStubs: true", "draftStubs: true"} {
+		if config == "draftStubs: true" {
+			draftToken = setec.StaticSecret("wrong")
+		}
+		p := newPR(config)
+		if _, err := p.createStubIssue(t.Context(), cli); err != nil {
+			t.Fatalf("createStubIssue(%q): unexpected error: %v", config, err)
+		}
+		if got, want := parseStubBody(got.GetBody()).text, "TODO(@alice): Add details about PR #1"; got != want {
+			t.Errorf("stub body (%q): got %q, want %q", config, got, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+
+	"tailscale.com/util/lru"
+)
+
+// etagMaxBody is the size of the largest response body that etagTransport
+// caches.
+const etagMaxBody = 1 << 20
+
+// etagTransport is an HTTP transport for the GitHub API that makes GET
+// requests conditional on the ETag of the last response for the same URL.
+// GitHub answers those whose result has not changed with 304 Not Modified,
+// which does not count against the rate limit, and etagTransport replaces
+// such answers with the cached response.
+type etagTransport struct {
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	cache lru.Cache[etagKey, *etagEntry]
+}
+
+// etagKey identifies a cached response. The Accept header is part of it,
+// since it selects what a GitHub API URL returns, e.g., a diff.
+type etagKey struct {
+	url, accept string
+}
+
+// etagEntry is a cached response.
+type etagEntry struct {
+	etag   string
+	header http.Header
+	body   []byte
+}
+
+// newETagTransport returns an etagTransport caching up to --etag-cache-size
+// responses to requests sent by next. If the cache size is not positive, it
+// returns next.
+func newETagTransport(next http.RoundTripper) http.RoundTripper {
+	if *etagCacheSize <= 0 {
+		return next
+	}
+	t := &etagTransport{next: next}
+	t.cache.MaxEntries = *etagCacheSize
+	return t
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" || req.Header.Get("If-None-Match") != "" {
+		return t.next.RoundTrip(req)
+	}
+	key := etagKey{req.URL.String(), req.Header.Get("Accept")}
+	t.mu.Lock()
+	ent, ok := t.cache.GetOk(key)
+	t.mu.Unlock()
+	if ok {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", ent.etag)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ok && resp.StatusCode == http.StatusNotModified:
+		resp.Body.Close()
+		githubNotModified.Add(1)
+		// The rate limit headers of the 304 are current; the rest are the
+		// cached response's.
+		h := ent.header.Clone()
+		for k, v := range resp.Header {
+			h[k] = v
+		}
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Header = h
+		resp.Body = io.NopCloser(bytes.NewReader(ent.body))
+		resp.ContentLength = int64(len(ent.body))
+		return resp, nil
+
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		if resp.ContentLength > etagMaxBody {
+			return resp, nil
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, etagMaxBody+1))
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if len(body) > etagMaxBody {
+			// Too large to cache; hand back what was read with the rest.
+			resp.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+			return resp, nil
+		}
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		t.mu.Lock()
+		t.cache.Set(key, &etagEntry{etag: resp.Header.Get("ETag"), header: resp.Header.Clone(), body: body})
+		t.mu.Unlock()
+	}
+	return resp, nil
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestETagTransport(t *testing.T) {
+	version := 1
+	var requests, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		etag := fmt.Sprintf(`"v%d"`, version)
+		w.Header().Set("X-RateLimit-Remaining", fmt.Sprint(5000-requests))
+		if r.Header.Get("If-None-Match") == etag {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprintf(w, `[{"id": %d, "body": "version %d"}]`, version, version)
+	}))
+	defer srv.Close()
+
+	cli := github.NewClient(&http.Client{Transport: newETagTransport(http.DefaultTransport)})
+	cli.BaseURL, _ = url.Parse(srv.URL + "/")
+	list := func() (string, int) {
+		t.Helper()
+		cs, resp, err := cli.Issues.ListComments(context.Background(), "o", "r", 1, nil)
+		if err != nil {
+			t.Fatalf("ListComments: unexpected error: %v", err)
+		}
+		if len(cs) != 1 {
+			t.Fatalf("ListComments: got %d comments, want 1", len(cs))
+		}
+		return cs[0].GetBody(), resp.Rate.Remaining
+	}
+
+	tests := []struct {
+		version     int
+		want        string
+		notModified int
+	}{
+		{1, "version 1", 0},
+		{1, "version 1", 1}, // unchanged, so answered from the cache
+		{2, "version 2", 1}, // changed
+		{2, "version 2", 2},
+	}
+	for i, tc := range tests {
+		version = tc.version
+		got, remaining := list()
+		if got != tc.want {
+			t.Errorf("request %d: got %q, want %q", i+1, got, tc.want)
+		}
+		if notModified != tc.notModified {
+			t.Errorf("request %d: got %d Not Modified responses, want %d", i+1, notModified, tc.notModified)
+		}
+		if want := 5000 - requests; remaining != want {
+			t.Errorf("request %d: got %d requests remaining, want the current %d", i+1, remaining, want)
+		}
+	}
+}
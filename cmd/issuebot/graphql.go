@@ -0,0 +1,253 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// graphQL runs a GitHub GraphQL query (or mutation) with the given variables,
+// and decodes the "data" field of the response into out, if it is not nil.
+// The what argument names the query for logging, as with retryCall.
+func graphQL(ctx context.Context, cli *github.Client, what, query string, vars map[string]any, out any) error {
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	_, _, err := retryCall(ctx, what, func(ctx context.Context) (struct{}, *github.Response, error) {
+		req, err := cli.NewRequest("POST", "graphql", map[string]any{"query": query, "variables": vars})
+		if err != nil {
+			return struct{}{}, nil, err
+		}
+		resp, err := cli.Do(ctx, req, &result)
+		return struct{}{}, resp, err
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	if len(result.Errors) != 0 {
+		var msgs []string
+		for _, e := range result.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return fmt.Errorf("%s: %s", what, strings.Join(msgs, "; "))
+	}
+	if len(result.Data) == 0 || string(result.Data) == "null" {
+		return fmt.Errorf("%s: %w", what, errors.New("no data in response"))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(result.Data, out)
+}
+
+const closingIssuesQuery = `query($owner: String!, $name: String!, $number: Int!) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      closingIssuesReferences(first: 25) {
+        nodes { number repository { nameWithOwner } labels(first: 50) { nodes { name } } }
+      }
+    }
+  }
+}`
+
+// closingIssues returns the issues that GitHub considers linked to the pull
+// request, as shown in its Development sidebar: those named with closing
+// keywords in its description, and those linked manually. Stub issues filed
+// by issuebot are omitted.
+func (p pullRequest) closingIssues(ctx context.Context, cli *github.Client) ([]issueRef, error) {
+	var data struct {
+		Repository struct {
+			PullRequest struct {
+				ClosingIssuesReferences struct {
+					Nodes []struct {
+						Number     int `json:"number"`
+						Repository struct {
+							NameWithOwner string `json:"nameWithOwner"`
+						} `json:"repository"`
+						Labels struct {
+							Nodes []struct {
+								Name string `json:"name"`
+							} `json:"nodes"`
+						} `json:"labels"`
+					} `json:"nodes"`
+				} `json:"closingIssuesReferences"`
+			} `json:"pullRequest"`
+		} `json:"repository"`
+	}
+	if err := graphQL(ctx, cli, "closingIssuesReferences", closingIssuesQuery, map[string]any{
+		"owner":  p.repo.GetOwner().GetLogin(),
+		"name":   p.repo.GetName(),
+		"number": p.pr.GetNumber(),
+	}, &data); err != nil {
+		return nil, err
+	}
+	var refs []issueRef
+	for _, n := range data.Repository.PullRequest.ClosingIssuesReferences.Nodes {
+		stub := false
+		for _, l := range n.Labels.Nodes {
+			stub = stub || l.Name == issuebotStubLabel
+		}
+		if stub {
+			continue
+		}
+		refs = append(refs, issueRef{Repo: n.Repository.NameWithOwner, Number: n.Number})
+	}
+	return refs, nil
+}
+
+// checkClosingIssues reports whether GitHub shows an issue linked to the pull
+// request (see closingIssues) that meets the repository's requirements.
+func (p pullRequest) checkClosingIssues(ctx context.Context, cli *github.Client) (bool, error) {
+	refs, err := p.closingIssues(ctx, cli)
+	if err != nil {
+		return false, err
+	}
+	return p.checkLinkedIssues(ctx, cli, "GitHub shows linked issue", refs)
+}
+
+const pullCommitsQuery = `query($owner: String!, $name: String!, $number: Int!, $cursor: String) {
+  repository(owner: $owner, name: $name) {
+    pullRequest(number: $number) {
+      commits(first: 100, after: $cursor) {
+        nodes { commit { oid message additions deletions author { name email user { login } } } }
+        pageInfo { hasNextPage endCursor }
+        totalCount
+      }
+    }
+  }
+}`
+
+// pullCommits returns the commits of the pull request, with their messages,
+// authors, and diff stats (but not their files), up to the repository's
+// maxCommits. It makes one GraphQL query per 100 commits, where the REST API
+// needs one call per commit for their stats. If the query fails, it lists
+// the commits with the REST API instead, without their stats.
+func (p pullRequest) pullCommits(ctx context.Context, cli *github.Client) ([]*github.RepositoryCommit, error) {
+	limit := p.cfg.maxCommits()
+	commits, err := p.queryPullCommits(ctx, cli, limit)
+	if err == nil {
+		return commits, nil
+	}
+	p.logf("GraphQL commit query failed, using REST: %v", err)
+	opts := &github.ListOptions{PerPage: 100}
+	commits = nil
+	for {
+		page, resp, err := retryCall(ctx, "ListCommits", func(ctx context.Context) ([]*github.RepositoryCommit, *github.Response, error) {
+			return cli.PullRequests.ListCommits(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, page...)
+		if len(commits) >= limit {
+			total := len(commits)
+			if resp.NextPage != 0 {
+				total++ // at least
+			}
+			return p.truncateCommits(commits, limit, total), nil
+		}
+		if resp.NextPage == 0 {
+			return commits, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// queryPullCommits implements pullCommits with GraphQL.
+func (p pullRequest) queryPullCommits(ctx context.Context, cli *github.Client, limit int) ([]*github.RepositoryCommit, error) {
+	var commits []*github.RepositoryCommit
+	var cursor *string
+	for {
+		var data struct {
+			Repository struct {
+				PullRequest *struct {
+					Commits struct {
+						Nodes []struct {
+							Commit struct {
+								OID       string `json:"oid"`
+								Message   string `json:"message"`
+								Additions int    `json:"additions"`
+								Deletions int    `json:"deletions"`
+								Author    struct {
+									Name  string `json:"name"`
+									Email string `json:"email"`
+									User  *struct {
+										Login string `json:"login"`
+									} `json:"user"`
+								} `json:"author"`
+							} `json:"commit"`
+						} `json:"nodes"`
+						PageInfo struct {
+							HasNextPage bool   `json:"hasNextPage"`
+							EndCursor   string `json:"endCursor"`
+						} `json:"pageInfo"`
+						TotalCount int `json:"totalCount"`
+					} `json:"commits"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		}
+		if err := graphQL(ctx, cli, "pullRequestCommits", pullCommitsQuery, map[string]any{
+			"owner":  p.repo.GetOwner().GetLogin(),
+			"name":   p.repo.GetName(),
+			"number": p.pr.GetNumber(),
+			"cursor": cursor,
+		}, &data); err != nil {
+			return nil, err
+		}
+		pr := data.Repository.PullRequest
+		if pr == nil {
+			return nil, errors.New("pull request not found")
+		}
+		for _, n := range pr.Commits.Nodes {
+			c := n.Commit
+			rc := &github.RepositoryCommit{
+				SHA: github.Ptr(c.OID),
+				Commit: &github.Commit{
+					Message: github.Ptr(c.Message),
+					Author:  &github.CommitAuthor{Name: github.Ptr(c.Author.Name), Email: github.Ptr(c.Author.Email)},
+				},
+				Stats: &github.CommitStats{
+					Additions: github.Ptr(c.Additions),
+					Deletions: github.Ptr(c.Deletions),
+					Total:     github.Ptr(c.Additions + c.Deletions),
+				},
+			}
+			if u := c.Author.User; u != nil {
+				rc.Author = &github.User{Login: github.Ptr(u.Login)}
+			}
+			commits = append(commits, rc)
+		}
+		if len(commits) >= limit {
+			return p.truncateCommits(commits, limit, pr.Commits.TotalCount), nil
+		}
+		if !pr.Commits.PageInfo.HasNextPage {
+			return commits, nil
+		}
+		cursor = github.Ptr(pr.Commits.PageInfo.EndCursor)
+	}
+}
+
+// truncateCommits returns the first limit of the PR's commits, and notes
+// that it has at least total (if more than limit), so that it is known to be
+// too large to scan fully.
+func (p pullRequest) truncateCommits(commits []*github.RepositoryCommit, limit, total int) []*github.RepositoryCommit {
+	if p.pr.Commits == nil {
+		// Lists of PRs do not give the number of commits.
+		p.pr.Commits = github.Ptr(max(total, len(commits)))
+	}
+	if p.pr.GetCommits() > limit {
+		p.logf("scanning only the first %d of its %d commits", limit, p.pr.GetCommits())
+	}
+	return commits[:min(len(commits), limit)]
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestClosingIssues(t *testing.T) {
+	var nodes string
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Variables map[string]any `json:"variables"`
+		}
+		if r.URL.Path != "/graphql" {
+			http.NotFound(w, r)
+			return
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Variables["number"] != 10.0 {
+			w.Write([]byte(`{"errors": [{"message": "bad request"}]}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"repository": {"pullRequest": {"closingIssuesReferences": {"nodes": [` + nodes + `]}}}}}`))
+	}))
+
+	cfg, err := parseRepoConfig([]byte(`{closingIssues: true, linkURLs: ["github.com/o/*"]}`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(10)},
+		cfg:  cfg,
+	}
+	tests := []struct {
+		nodes string
+		want  bool
+	}{
+		{``, false},
+		{`{"number": 1, "repository": {"nameWithOwner": "o/r"}}`, true},
+		{`{"number": 1, "repository": {"nameWithOwner": "other/r"}}`, false},
+		{`{"number": 1, "repository": {"nameWithOwner": "o/r"}, "labels": {"nodes": [{"name": "issuebot-stub"}]}}`, false},
+		{`{"number": 1, "repository": {"nameWithOwner": "other/r"}}, {"number": 2, "repository": {"nameWithOwner": "o/x"}}`, true},
+	}
+	for _, tc := range tests {
+		nodes = tc.nodes
+		got, err := p.checkClosingIssues(context.Background(), cli)
+		if err != nil {
+			t.Errorf("checkClosingIssues(%s): unexpected error: %v", tc.nodes, err)
+		} else if got != tc.want {
+			t.Errorf("checkClosingIssues(%s): got %v, want %v", tc.nodes, got, tc.want)
+		}
+	}
+
+	// GraphQL errors are reported.
+	p.pr.Number = github.Ptr(11)
+	if _, err := p.closingIssues(context.Background(), cli); err == nil {
+		t.Error("closingIssues: got nil error for GraphQL error response")
+	}
+}
+
+func TestPullCommits(t *testing.T) {
+	graphQLWorks := true
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/graphql":
+			var req struct {
+				Variables map[string]any `json:"variables"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !graphQLWorks {
+				w.Write([]byte(`{"errors": [{"message": "bad request"}]}`))
+				return
+			}
+			if req.Variables["cursor"] == nil {
+				w.Write([]byte(`{"data": {"repository": {"pullRequest": {"commits": {
+  "nodes": [{"commit": {"oid": "a1", "message": "Fix it\n\nFixes #1", "additions": 3, "deletions": 2,
+    "author": {"name": "Kim", "email": "kim@example.com", "user": {"login": "kim"}}}}],
+  "pageInfo": {"hasNextPage": true, "endCursor": "c1"}}}}}}`))
+			} else if req.Variables["cursor"] == "c1" {
+				w.Write([]byte(`{"data": {"repository": {"pullRequest": {"commits": {
+  "nodes": [{"commit": {"oid": "b2", "message": "Bump deps", "additions": 1, "deletions": 0,
+    "author": {"name": "dependabot[bot]", "email": "bot@example.com", "user": null}}}],
+  "pageInfo": {"hasNextPage": false, "endCursor": "c2"}}}}}}`))
+			} else {
+				t.Errorf("pullCommits: unexpected cursor %v", req.Variables["cursor"])
+			}
+		case "/repos/o/r/pulls/10/commits":
+			w.Write([]byte(`[{"sha": "a1", "commit": {"message": "Fix it"}}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(10)},
+	}
+
+	commits, err := p.pullCommits(context.Background(), cli)
+	if err != nil {
+		t.Fatalf("pullCommits: unexpected error: %v", err)
+	}
+	if len(commits) != 2 {
+		t.Fatalf("pullCommits: got %d commits, want 2", len(commits))
+	}
+	if c := commits[0]; c.GetSHA() != "a1" || c.GetStats().GetTotal() != 5 || c.GetAuthor().GetLogin() != "kim" || c.GetCommit().GetAuthor().GetEmail() != "kim@example.com" {
+		t.Errorf("pullCommits: got first commit %v, want a1 by kim with 5 lines changed", c)
+	}
+	if c := commits[1]; c.GetSHA() != "b2" || c.Author != nil || p.checkCommitMetadata(c) != prBot {
+		t.Errorf("pullCommits: got second commit %v, want b2 by a bot", c)
+	}
+
+	// If the query fails, the commits are listed with REST.
+	graphQLWorks = false
+	commits, err = p.pullCommits(context.Background(), cli)
+	if err != nil {
+		t.Fatalf("pullCommits (REST): unexpected error: %v", err)
+	}
+	if len(commits) != 1 || commits[0].GetSHA() != "a1" || commits[0].Stats != nil {
+		t.Errorf("pullCommits (REST): got %v, want a1 without stats", commits)
+	}
+}
+
+func TestPullCommitsLimit(t *testing.T) {
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/o/r/pulls/10/commits" {
+			http.NotFound(w, r) // including GraphQL, so commits are listed with REST
+			return
+		}
+		w.Write([]byte(`[{"sha": "a1"}, {"sha": "b2"}, {"sha": "c3"}]`))
+	}))
+	cfg, err := parseRepoConfig([]byte(`{maxCommits: 2}`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(10)},
+		cfg:  cfg,
+	}
+	commits, err := p.pullCommits(context.Background(), cli)
+	if err != nil {
+		t.Fatalf("pullCommits: unexpected error: %v", err)
+	}
+	if len(commits) != 2 {
+		t.Errorf("pullCommits: got %d commits, want 2", len(commits))
+	}
+	if !p.tooLarge() {
+		t.Errorf("tooLarge: got false for %d commits, want true", p.pr.GetCommits())
+	}
+	if got, want := p.failureDescription(), "This PR has 3 commits, more than the 2"; !strings.HasPrefix(got, want) {
+		t.Errorf("failureDescription: got %q, want prefix %q", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v72/github"
+	"github.com/tailscale/setec/client/setec"
+)
+
+// readinessTimeout bounds the dependency checks of a readiness probe.
+const readinessTimeout = 10 * time.Second
+
+// serveHealthz answers liveness probes: the server is alive if it answers.
+func serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+// serveReadyz answers readiness probes, with 503 Service Unavailable unless
+// checkReady finds the server usable.
+func serveReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	if err := checkReady(ctx); err != nil {
+		ctxLogf(ctx, "not ready: %v", err)
+		http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+// checkReady reports why the server cannot check PRs, if it cannot: it is
+// shutting down, an installation token for the GitHub API cannot be minted,
+// or the secrets service, if it is used, cannot be reached.
+func checkReady(ctx context.Context) error {
+	if draining() {
+		return errShuttingDown
+	}
+	if clientUpdater == nil {
+		return errors.New("no GitHub API client")
+	}
+	// The token is cached until shortly before it expires, so this calls
+	// GitHub only when a new one is needed anyway.
+	if itr := installationTransport(apiClient()); itr != nil {
+		if _, err := itr.Token(ctx); err != nil {
+			return fmt.Errorf("minting installation token: %w", err)
+		}
+	}
+	if *useSecretsService != "" {
+		if _, err := (setec.Client{Server: *useSecretsService}).Get(ctx, appPrivateKeyName); err != nil {
+			return fmt.Errorf("secrets service: %w", err)
+		}
+	}
+	return nil
+}
+
+// installationTransport returns the transport that authenticates cli as the
+// app installation, or nil if it is not authenticated that way.
+func installationTransport(cli *github.Client) *ghinstallation.Transport {
+	rt := cli.Client().Transport
+	if et, ok := rt.(*etagTransport); ok {
+		rt = et.next
+	}
+	itr, _ := rt.(*ghinstallation.Transport)
+	return itr
+}
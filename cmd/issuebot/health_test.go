@@ -0,0 +1,74 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v72/github"
+	"github.com/tailscale/setec/client/setec"
+)
+
+func TestHealth(t *testing.T) {
+	oldClient := clientUpdater
+	defer func() { clientUpdater = oldClient }()
+
+	get := func(h http.HandlerFunc, path string) (int, string) {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+	if code, _ := get(serveHealthz, "/healthz"); code != http.StatusOK {
+		t.Errorf("/healthz: got %d, want 200", code)
+	}
+
+	clientUpdater = nil
+	if code, body := get(serveReadyz, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("/readyz without a client: got %d %q, want 503", code, body)
+	}
+
+	// Authenticated as an app installation, readiness depends on minting a
+	// token for it.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	mintable := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app/installations/2/access_tokens" || !mintable {
+			http.Error(w, "nope", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"token":"tok","expires_at":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer srv.Close()
+	itr, err := ghinstallation.New(http.DefaultTransport, 1, 2, pemKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	itr.BaseURL = srv.URL
+	clientUpdater = setec.StaticUpdater(github.NewClient(&http.Client{Transport: &etagTransport{next: itr}}))
+
+	mintable = false
+	if code, body := get(serveReadyz, "/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(body, "installation token") {
+		t.Errorf("/readyz when tokens cannot be minted: got %d %q, want 503", code, body)
+	}
+	mintable = true
+	if code, body := get(serveReadyz, "/readyz"); code != http.StatusOK {
+		t.Errorf("/readyz: got %d %q, want 200", code, body)
+	}
+}
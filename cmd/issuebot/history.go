@@ -0,0 +1,198 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxTrailLines bounds the log lines kept for each check in the history.
+const maxTrailLines = 50
+
+// A checkTrail collects the log lines of one check of a PR, as the reasons
+// for its decision.
+type checkTrail struct {
+	sync.Mutex
+	lines []string
+}
+
+func (t *checkTrail) add(line string) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	if len(t.lines) < maxTrailLines {
+		t.lines = append(t.lines, line)
+	} else if len(t.lines) == maxTrailLines {
+		t.lines = append(t.lines, "...")
+	}
+}
+
+// A decisionRecord is an entry in the history of recent checks.
+type decisionRecord struct {
+	repo     string
+	pr       int
+	author   string
+	headSHA  string
+	action   string // the pull_request event action, if any
+	start    time.Time
+	duration time.Duration
+	calls    int64  // GitHub API calls made
+	outcome  string // the disposition, or another result such as "draft"
+	reused   bool   // whether the last decision was reused
+	err      error
+	reasons  []string
+}
+
+// decisionHistory holds the last --decision-history checks, oldest first,
+// for the debug page.
+var decisionHistory struct {
+	sync.Mutex
+	recs []*decisionRecord
+}
+
+// recordDecision adds rec to decisionHistory, with the log lines of trail as
+// its reasons, and writes it to the decision log.
+func recordDecision(rec *decisionRecord, trail *checkTrail) {
+	rec.duration = time.Since(rec.start)
+	trail.Lock()
+	rec.reasons = trail.lines
+	trail.Unlock()
+	logDecision(rec)
+	if *decisionHistorySize <= 0 {
+		return
+	}
+
+	decisionHistory.Lock()
+	defer decisionHistory.Unlock()
+	decisionHistory.recs = append(decisionHistory.recs, rec)
+	if n := len(decisionHistory.recs) - *decisionHistorySize; n > 0 {
+		decisionHistory.recs = append(decisionHistory.recs[:0], decisionHistory.recs[n:]...)
+	}
+}
+
+// serveDecisions serves the history of recent checks on the debug page,
+// newest first. The repo and pr query parameters narrow it down to one
+// repository or PR.
+func serveDecisions(w http.ResponseWriter, r *http.Request) {
+	repo := r.FormValue("repo")
+	pr, _ := strconv.Atoi(r.FormValue("pr"))
+
+	decisionHistory.Lock()
+	recs := append([]*decisionRecord(nil), decisionHistory.recs...)
+	decisionHistory.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	n := 0
+	for i := len(recs) - 1; i >= 0; i-- {
+		rec := recs[i]
+		if (repo != "" && rec.repo != repo) || (pr != 0 && rec.pr != pr) {
+			continue
+		}
+		n++
+		outcome := rec.outcome
+		if rec.reused {
+			outcome += " (reused)"
+		}
+		if rec.err != nil {
+			outcome = fmt.Sprintf("error: %v", rec.err)
+		}
+		fmt.Fprintf(w, "%s#%d at %.12s: %s\n", rec.repo, rec.pr, rec.headSHA, outcome)
+		fmt.Fprintf(w, "  checked %s for %v with %d GitHub calls", rec.start.UTC().Format(time.RFC3339), rec.duration.Round(time.Millisecond), rec.calls)
+		if rec.action != "" {
+			fmt.Fprintf(w, " on %q", rec.action)
+		}
+		fmt.Fprintln(w)
+		for _, line := range rec.reasons {
+			fmt.Fprintf(w, "  | %s\n", line)
+		}
+		fmt.Fprintln(w)
+	}
+	if n == 0 {
+		fmt.Fprintln(w, "No checks recorded.")
+	}
+}
+
+// A decisionLogRecord is the form of a decisionRecord in the decision log.
+type decisionLogRecord struct {
+	Time        time.Time `json:"time"`
+	Repo        string    `json:"repo"`
+	PR          int       `json:"pr"`
+	Author      string    `json:"author"`
+	Head        string    `json:"head"`
+	Action      string    `json:"action,omitempty"`
+	Disposition string    `json:"disposition,omitempty"`
+	Reused      bool      `json:"reused,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  int64     `json:"durationMs"`
+	GitHubCalls int64     `json:"githubCalls"`
+	Reasons     []string  `json:"reasons"`
+}
+
+func (rec *decisionRecord) logRecord() decisionLogRecord {
+	r := decisionLogRecord{
+		Time:        rec.start.UTC(),
+		Repo:        rec.repo,
+		PR:          rec.pr,
+		Author:      rec.author,
+		Head:        rec.headSHA,
+		Action:      rec.action,
+		Disposition: rec.outcome,
+		Reused:      rec.reused,
+		DurationMS:  rec.duration.Milliseconds(),
+		GitHubCalls: rec.calls,
+		Reasons:     rec.reasons,
+	}
+	if rec.err != nil {
+		r.Error = rec.err.Error()
+	}
+	return r
+}
+
+// decisionLogFile is where logDecision writes, opened on first use.
+var decisionLogFile struct {
+	sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// logDecision writes rec to --decision-log, if it is set, as a line of JSON.
+func logDecision(rec *decisionRecord) {
+	if *decisionLog == "" {
+		return
+	}
+	line, err := json.Marshal(rec.logRecord())
+	if err != nil {
+		log.Printf("error encoding decision (continuing): %v", err)
+		return
+	}
+	decisionLogFile.Lock()
+	defer decisionLogFile.Unlock()
+	if decisionLogFile.w == nil && decisionLogFile.err == nil {
+		if *decisionLog == "-" {
+			decisionLogFile.w = os.Stderr
+		} else {
+			decisionLogFile.w, decisionLogFile.err = os.OpenFile(*decisionLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		}
+		if decisionLogFile.err != nil {
+			log.Printf("error opening --decision-log (not logging decisions): %v", decisionLogFile.err)
+		}
+	}
+	if decisionLogFile.err != nil {
+		return
+	}
+	if _, err := decisionLogFile.w.Write(append(line, '\n')); err != nil {
+		log.Printf("error writing decision log (continuing): %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestDecisionHistory(t *testing.T) {
+	defer func(n int) { *decisionHistorySize = n }(*decisionHistorySize)
+	defer func() {
+		decisionHistory.Lock()
+		decisionHistory.recs = nil
+		decisionHistory.Unlock()
+	}()
+	*decisionHistorySize = 3
+
+	for i, outcome := range []string{"linked", "failed", "bot", "cleanup"} {
+		p := pullRequest{
+			repo:  &github.Repository{FullName: github.Ptr("o/r")},
+			pr:    &github.PullRequest{Number: github.Ptr(i + 1)},
+			trail: new(checkTrail),
+		}
+		p.logf("reason for #%d", i+1)
+		rec := &decisionRecord{repo: "o/r", pr: i + 1, headSHA: "0123456789abcdef", start: time.Now(), outcome: outcome}
+		if outcome == "cleanup" {
+			rec.err = errors.New("load config: boom")
+		}
+		recordDecision(rec, p.trail)
+	}
+
+	get := func(query string) string {
+		rec := httptest.NewRecorder()
+		serveDecisions(rec, httptest.NewRequest("GET", "/debug/decisions"+query, nil))
+		return rec.Body.String()
+	}
+	got := get("")
+	if strings.Contains(got, "o/r#1 ") {
+		t.Errorf("history kept more than 3 decisions:\n%s", got)
+	}
+	for _, want := range []string{"o/r#2 at 0123456789ab: failed", "| reason for #2", "o/r#4 at 0123456789ab: error: load config: boom"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("history: missing %q in:\n%s", want, got)
+		}
+	}
+	if i, j := strings.Index(got, "o/r#4 "), strings.Index(got, "o/r#3 "); i > j {
+		t.Errorf("history is not newest first:\n%s", got)
+	}
+
+	got = get("?repo=o/r&pr=3")
+	if !strings.Contains(got, "o/r#3 ") || strings.Contains(got, "o/r#2 ") {
+		t.Errorf("history for o/r#3: got\n%s", got)
+	}
+	if got := get("?repo=o/other"); !strings.Contains(got, "No checks recorded.") {
+		t.Errorf("history for o/other: got\n%s", got)
+	}
+}
+
+func TestDecisionLog(t *testing.T) {
+	defer func(s string, n int) { *decisionLog, *decisionHistorySize = s, n }(*decisionLog, *decisionHistorySize)
+	*decisionHistorySize = 0
+	defer func() {
+		decisionLogFile.Lock()
+		if f, ok := decisionLogFile.w.(*os.File); ok && f != os.Stderr {
+			f.Close()
+		}
+		decisionLogFile.w, decisionLogFile.err = nil, nil
+		decisionLogFile.Unlock()
+	}()
+	path := filepath.Join(t.TempDir(), "decisions.jsonl")
+	*decisionLog = path
+
+	trail := new(checkTrail)
+	trail.add("accept: stub issue o/r#9 created")
+	recordDecision(&decisionRecord{repo: "o/r", pr: 1, author: "alice", headSHA: "abc", action: "opened", start: time.Now(), outcome: "skipped"}, trail)
+	recordDecision(&decisionRecord{repo: "o/r", pr: 2, headSHA: "def", start: time.Now(), outcome: "linked", reused: true}, new(checkTrail))
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("decision log has %d lines, want 2:\n%s", len(lines), data)
+	}
+	var got decisionLogRecord
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Fatalf("decision log line %q: %v", lines[0], err)
+	}
+	if got.Repo != "o/r" || got.PR != 1 || got.Author != "alice" || got.Head != "abc" || got.Action != "opened" || got.Disposition != "skipped" {
+		t.Errorf("decision log line: got %+v", got)
+	}
+	if len(got.Reasons) != 1 || got.Reasons[0] != "accept: stub issue o/r#9 created" {
+		t.Errorf("decision log reasons: got %q", got.Reasons)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil || !got.Reused {
+		t.Errorf("decision log line %q: got reused %v (%v), want true", lines[1], got.Reused, err)
+	}
+}
@@ -0,0 +1,269 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const hookUsage = `Usage: issuebot hook [-config file] [-v] [range ...]
+       issuebot hook -pre-push [-config file] [-v] [remote [url]]
+
+Check local commits before they are pushed, with the rules the server uses:
+each range of commits passes if the server would pass a PR made of them.
+Without -pre-push, each range (default: @{upstream}..HEAD) is given as to
+git log, e.g., origin/main..HEAD. With -pre-push, the ranges being pushed are
+read from stdin, as git gives them to a pre-push hook. To install it as one:
+
+	printf '#!/bin/sh\nexec issuebot hook -pre-push "$@"\n' > .git/hooks/pre-push
+	chmod +x .git/hooks/pre-push
+
+Settings are read from the repository's .github/issuebot.yml, unless -config
+is given. Organization defaults are not applied, and overrides are accepted
+from anyone, since who may use them cannot be checked locally.
+`
+
+// runHook implements the hook subcommand, and returns the process exit code.
+func runHook(args []string) int {
+	fs := flag.NewFlagSet("hook", flag.ContinueOnError)
+	prePush := fs.Bool("pre-push", false, "read the refs being pushed from stdin, as a pre-push hook")
+	configPath := fs.String("config", "", "settings file (default: .github/issuebot.yml in the working tree)")
+	verbose := fs.Bool("v", false, "log how each commit is classified")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), hookUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+
+	cfg, err := loadLocalConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+		return 2
+	}
+	// The remote's URL names the repository, which resolves references like
+	// "#123".
+	url := ""
+	if *prePush && fs.NArg() > 1 {
+		url = fs.Arg(1)
+	} else if out, err := git("remote", "get-url", "origin"); err == nil {
+		url = strings.TrimSpace(out)
+	}
+	p := pullRequest{
+		repo: &github.Repository{FullName: github.Ptr(githubRepoFromURL(url))},
+		pr:   new(github.PullRequest),
+		cfg:  cfg,
+	}
+
+	var ranges [][]string
+	switch {
+	case *prePush:
+		ranges, err = prePushRanges(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+			return 2
+		}
+	case fs.NArg() == 0:
+		ranges = [][]string{{"@{upstream}..HEAD"}}
+	default:
+		for _, r := range fs.Args() {
+			ranges = append(ranges, []string{r})
+		}
+	}
+
+	status := 0
+	for _, r := range ranges {
+		commits, err := localCommits(r...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+			return 2
+		}
+		disp, unlinked := p.checkLocalCommits(commits)
+		if disp != prFailed {
+			continue
+		}
+		status = 1
+		fmt.Fprintf(os.Stderr, "issuebot: no commit in %s links to an issue:\n", strings.Join(r, " "))
+		for _, c := range unlinked {
+			subject, _, _ := strings.Cut(c.GetCommit().GetMessage(), "\n")
+			fmt.Fprintf(os.Stderr, "  %.12s %s\n", c.GetSHA(), subject)
+		}
+		fmt.Fprintf(os.Stderr, "%s\n", p.statusDescription(failureStatusTemplate))
+	}
+	return status
+}
+
+// loadLocalConfig reads the settings for the hook subcommand from the file at
+// path, or if path is "", from the repository's own configuration file in the
+// working tree, if it has one.
+func loadLocalConfig(path string) (*repoConfig, error) {
+	if path == "" {
+		top, err := git("rev-parse", "--show-toplevel")
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(strings.TrimSpace(top), filepath.FromSlash(repoConfigPath))
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return nil, nil // no config, use defaults
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseRepoConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// githubRemoteRE matches the URL of a GitHub repository, as used by git. The
+// submatch is the full name of the repository, owner/repo.
+var githubRemoteRE = regexp.MustCompile(`github\.com[:/]([\w.-]+/[\w.-]+?)(?:\.git)?/?$`)
+
+// githubRepoFromURL returns the full name of the GitHub repository at url,
+// or "" if it is not one.
+func githubRepoFromURL(url string) string {
+	if m := githubRemoteRE.FindStringSubmatch(url); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// prePushRanges reads the lines git gives a pre-push hook on stdin, each
+// "<local ref> <local sha> <remote ref> <remote sha>", and returns the git log
+// arguments selecting the commits to be pushed for each.
+func prePushRanges(r io.Reader) ([][]string, error) {
+	var ranges [][]string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) != 4 {
+			return nil, fmt.Errorf("invalid pre-push line %q", sc.Text())
+		}
+		local, remote := f[1], f[3]
+		switch {
+		case strings.Trim(local, "0") == "":
+			// Deleting the remote ref pushes nothing. (Git names a missing
+			// object with zeros.)
+		case strings.Trim(remote, "0") == "":
+			// A new ref: push the commits not on the remote already.
+			ranges = append(ranges, []string{local, "--not", "--remotes"})
+		default:
+			ranges = append(ranges, []string{remote + ".." + local})
+		}
+	}
+	return ranges, sc.Err()
+}
+
+// localCommits returns the commits selected by the given git log arguments,
+// other than merges, with their messages, authors, and diff stats.
+func localCommits(args ...string) ([]*github.RepositoryCommit, error) {
+	out, err := git(append([]string{"log", "--no-merges", "--numstat", "--format=%x1e%H%x00%an%x00%ae%x00%B%x00"}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return parseLocalCommits(out)
+}
+
+// parseLocalCommits parses the output of localCommits.
+func parseLocalCommits(out string) ([]*github.RepositoryCommit, error) {
+	var commits []*github.RepositoryCommit
+	for _, rec := range strings.Split(out, "\x1e") {
+		if strings.TrimSpace(rec) == "" {
+			continue
+		}
+		f := strings.SplitN(rec, "\x00", 5)
+		if len(f) != 5 {
+			return nil, fmt.Errorf("invalid git log record %q", rec)
+		}
+		c := &github.RepositoryCommit{
+			SHA: github.Ptr(f[0]),
+			Commit: &github.Commit{
+				Message: github.Ptr(strings.TrimRight(f[3], "\n")),
+				Author:  &github.CommitAuthor{Name: github.Ptr(f[1]), Email: github.Ptr(f[2])},
+			},
+		}
+		total := 0
+		for _, line := range strings.Split(strings.TrimSpace(f[4]), "\n") {
+			nf := strings.SplitN(line, "\t", 3)
+			if len(nf) != 3 {
+				continue
+			}
+			// Binary files have "-" for their counts.
+			add, _ := strconv.Atoi(nf[0])
+			del, _ := strconv.Atoi(nf[1])
+			c.Files = append(c.Files, &github.CommitFile{Filename: github.Ptr(nf[2]), Changes: github.Ptr(add + del)})
+			total += add + del
+		}
+		c.Stats = &github.CommitStats{Total: github.Ptr(total)}
+		commits = append(commits, c)
+	}
+	return commits, nil
+}
+
+// checkLocalCommits returns the disposition of a PR made of commits, judged
+// by their messages, authors, and sizes, and the commits that do not link to
+// an issue.
+func (p pullRequest) checkLocalCommits(commits []*github.RepositoryCommit) (pullRequestStatus, []*github.RepositoryCommit) {
+	status := prFailed
+	totalDiff := 0
+	var unlinked []*github.RepositoryCommit
+	for _, c := range commits {
+		totalDiff += p.cfg.diffSize(c)
+		msg := c.GetCommit().GetMessage()
+		disp := p.checkCommitMessage(msg)
+		if disp == prFailed {
+			disp = p.checkOverride(msg)
+		}
+		if bot := p.checkCommitMetadata(c); bot > disp {
+			disp = bot
+		}
+		if disp <= prCleanup {
+			unlinked = append(unlinked, c)
+		}
+		status = max(status, disp)
+	}
+	if len(commits) != 0 && status <= prSkipped && totalDiff < p.cfg.minDiff() {
+		p.logf("accept: total diff is %d lines", totalDiff)
+		status = prSmall
+	}
+	if len(commits) == 0 {
+		status = prLinked // nothing to check
+	}
+	return status, unlinked
+}
+
+// git runs git with the given arguments in the current directory, and
+// returns its output.
+func git(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestGithubRepoFromURL(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"git@github.com:tailscale/issuebot.git", "tailscale/issuebot"},
+		{"https://github.com/tailscale/issuebot", "tailscale/issuebot"},
+		{"https://github.com/tailscale/issuebot.git/", "tailscale/issuebot"},
+		{"ssh://git@github.com/tailscale/go.dev.git", "tailscale/go.dev"},
+		{"https://gitlab.com/tailscale/issuebot", ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := githubRepoFromURL(tc.url); got != tc.want {
+			t.Errorf("githubRepoFromURL(%q): got %q, want %q", tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestPrePushRanges(t *testing.T) {
+	zero := strings.Repeat("0", 40)
+	in := strings.Join([]string{
+		"refs/heads/main aaaa refs/heads/main bbbb",
+		"refs/heads/new cccc refs/heads/new " + zero,
+		"(delete) " + zero + " refs/heads/old dddd",
+	}, "\n")
+	got, err := prePushRanges(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("prePushRanges: unexpected error: %v", err)
+	}
+	want := [][]string{{"bbbb..aaaa"}, {"cccc", "--not", "--remotes"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("prePushRanges: got %q, want %q", got, want)
+	}
+	if _, err := prePushRanges(strings.NewReader("bogus")); err == nil {
+		t.Error("prePushRanges(bogus): got nil error, want error")
+	}
+}
+
+func TestCheckLocalCommits(t *testing.T) {
+	commit := func(msg string, size int) *github.RepositoryCommit {
+		return &github.RepositoryCommit{
+			SHA:    github.Ptr("abc"),
+			Commit: &github.Commit{Message: github.Ptr(msg)},
+			Stats:  &github.CommitStats{Total: github.Ptr(size)},
+		}
+	}
+	tests := []struct {
+		name     string
+		commits  []*github.RepositoryCommit
+		want     pullRequestStatus
+		unlinked int
+	}{
+		{"empty", nil, prLinked, 0},
+		{"linked", []*github.RepositoryCommit{commit("Fix it\n\nFixes #1", 500), commit("Tweak it", 500)}, prLinked, 1},
+		{"unlinked", []*github.RepositoryCommit{commit("Fix it", 500)}, prFailed, 1},
+		{"small", []*github.RepositoryCommit{commit("Typo", 1)}, prSmall, 1},
+		{"cleanup", []*github.RepositoryCommit{commit("Tidy\n\n#cleanup", 500)}, prCleanup, 1},
+	}
+	p := pullRequest{repo: &github.Repository{FullName: github.Ptr("o/r")}, pr: new(github.PullRequest)}
+	for _, tc := range tests {
+		got, unlinked := p.checkLocalCommits(tc.commits)
+		if got != tc.want || len(unlinked) != tc.unlinked {
+			t.Errorf("checkLocalCommits(%s): got %v with %d unlinked, want %v with %d", tc.name, got, len(unlinked), tc.want, tc.unlinked)
+		}
+	}
+}
+
+func TestLocalCommits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	t.Chdir(dir)
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	run := func(args ...string) {
+		t.Helper()
+		if _, err := git(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("init", "-q")
+	run("config", "user.name", "Kim")
+	run("config", "user.email", "kim@example.com")
+	os.WriteFile("a.txt", []byte("one\ntwo\n"), 0644)
+	run("add", "a.txt")
+	run("commit", "-q", "-m", "Add a\n\nUpdates #12")
+	os.WriteFile("a.txt", []byte("one\n"), 0644)
+	run("commit", "-q", "-a", "-m", "Trim a")
+
+	commits, err := localCommits("HEAD")
+	if err != nil {
+		t.Fatalf("localCommits: %v", err)
+	}
+	var got []string
+	for _, c := range commits {
+		got = append(got, c.GetCommit().GetMessage()+"|"+c.GetCommit().GetAuthor().GetName()+"|"+c.Files[0].GetFilename())
+	}
+	want := []string{"Trim a|Kim|a.txt", "Add a\n\nUpdates #12|Kim|a.txt"}
+	if !slices.Equal(got, want) {
+		t.Errorf("localCommits: got %q, want %q", got, want)
+	}
+	if n := commits[1].GetStats().GetTotal(); n != 2 {
+		t.Errorf("localCommits: got %d lines changed by the first commit, want 2", n)
+	}
+}
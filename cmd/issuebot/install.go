@@ -0,0 +1,117 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// installationRepos returns the repositories listed in an installation event,
+// which GitHub gives only by name, filled in with their owners.
+func installationRepos(repos []*github.Repository) []*github.Repository {
+	var out []*github.Repository
+	for _, r := range repos {
+		owner, name, ok := strings.Cut(r.GetFullName(), "/")
+		if !ok {
+			continue
+		}
+		out = append(out, &github.Repository{
+			ID:       r.ID,
+			Owner:    &github.User{Login: github.Ptr(owner)},
+			Name:     github.Ptr(name),
+			FullName: r.FullName,
+			Private:  r.Private,
+		})
+	}
+	return out
+}
+
+// addRepos starts covering repos, newly added to the app installation: it
+// logs which of them are enabled, drops any cached settings for them, and, if
+// --startup-scan is set, checks their open PRs that have no status yet.
+func addRepos(ctx context.Context, repos []*github.Repository) {
+	var enabled []string
+	for _, repo := range repos {
+		invalidateRepoConfig(repo.GetOwner().GetLogin(), repo.GetName())
+		if repoEnabled(repo.GetFullName()) {
+			enabled = append(enabled, repo.GetFullName())
+		}
+	}
+	log.Printf("installation: %d repos added, %d of them enabled: %s", len(repos), len(enabled), strings.Join(enabled, ", "))
+	countInstalledRepos(ctx)
+	if *startupScan && len(enabled) != 0 {
+		go func() {
+			nc := reconcileRepos(ctx, repos)
+			log.Printf("installation: checked %d unchecked PRs in %d new repos", nc, len(enabled))
+		}()
+	}
+}
+
+// removeRepos stops covering repos, removed from the app installation.
+func removeRepos(ctx context.Context, repos []*github.Repository) {
+	var names []string
+	for _, repo := range repos {
+		invalidateRepoConfig(repo.GetOwner().GetLogin(), repo.GetName())
+		names = append(names, repo.GetFullName())
+	}
+	log.Printf("installation: %d repos removed: %s", len(repos), strings.Join(names, ", "))
+	countInstalledRepos(ctx)
+}
+
+// countInstalledRepos sets installedRepos to the number of repositories in
+// the app installation, as GitHub lists them.
+func countInstalledRepos(ctx context.Context) {
+	repos, err := listInstallationRepos(ctx)
+	if err != nil {
+		log.Printf("installation: counting repos: %v", err)
+		return
+	}
+	installedRepos.Set(int64(len(repos)))
+}
+
+// ourInstallation reports whether inst is the app installation this instance
+// serves. Events for other installations of the app are logged and ignored.
+func ourInstallation(inst *github.Installation, what string) bool {
+	if inst.GetID() == appInstall {
+		return true
+	}
+	log.Printf("ignoring %s event for installation %d (serving %d)", what, inst.GetID(), appInstall)
+	return false
+}
+
+// handleInstallationEvent handles the app being uninstalled, suspended, or
+// given new permissions. (An installation that was just created cannot be
+// the one this instance serves.)
+func handleInstallationEvent(ctx context.Context, e *github.InstallationEvent) {
+	if !ourInstallation(e.GetInstallation(), "installation") {
+		return
+	}
+	account := e.GetInstallation().GetAccount().GetLogin()
+	switch e.GetAction() {
+	case "deleted":
+		log.Printf("installation: uninstalled from %s by @%s; no repos are covered", account, e.GetSender().GetLogin())
+		installedRepos.Set(0)
+	default:
+		log.Printf("installation: %s on %s by @%s", e.GetAction(), account, e.GetSender().GetLogin())
+	}
+}
+
+// handleInstallationReposEvent handles repositories being added to or removed
+// from the app installation.
+func handleInstallationReposEvent(ctx context.Context, e *github.InstallationRepositoriesEvent) {
+	if !ourInstallation(e.GetInstallation(), "installation_repositories") {
+		return
+	}
+	if len(e.RepositoriesAdded) != 0 {
+		addRepos(ctx, installationRepos(e.RepositoriesAdded))
+	}
+	if len(e.RepositoriesRemoved) != 0 {
+		removeRepos(ctx, installationRepos(e.RepositoriesRemoved))
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestInstallationRepos(t *testing.T) {
+	got := installationRepos([]*github.Repository{
+		{ID: github.Ptr(int64(1)), Name: github.Ptr("r"), FullName: github.Ptr("o/r")},
+		{ID: github.Ptr(int64(2)), Name: github.Ptr("bogus")},
+	})
+	if len(got) != 1 {
+		t.Fatalf("installationRepos: got %d repos, want 1", len(got))
+	}
+	if r := got[0]; r.GetOwner().GetLogin() != "o" || r.GetName() != "r" || r.GetID() != 1 {
+		t.Errorf("installationRepos: got %v, want o/r with ID 1", r)
+	}
+
+	// Events for other installations are ignored.
+	defer func(id int64) { appInstall = id }(appInstall)
+	appInstall = 5
+	if ourInstallation(&github.Installation{ID: github.Ptr(int64(6))}, "test") {
+		t.Error("ourInstallation(6): got true, want false")
+	}
+	if !ourInstallation(&github.Installation{ID: github.Ptr(int64(5))}, "test") {
+		t.Error("ourInstallation(5): got false, want true")
+	}
+}
+
+func TestCountInstalledRepos(t *testing.T) {
+	fakeCheckGitHub(t, &fakePulls{t: t})
+	defer func(id int64) { appInstall = id }(appInstall)
+	appInstall = 5
+	inst := &github.Installation{ID: github.Ptr(int64(5))}
+
+	// Whatever the gauge said before, an event sets it to the repositories
+	// GitHub lists.
+	installedRepos.Set(0)
+	handleInstallationReposEvent(t.Context(), &github.InstallationRepositoriesEvent{
+		Installation:        inst,
+		RepositoriesRemoved: []*github.Repository{{Name: github.Ptr("gone"), FullName: github.Ptr("o/gone")}},
+	})
+	if got := installedRepos.Value(); got != 1 {
+		t.Errorf("installed repos after removing one: got %d, want 1", got)
+	}
+	handleInstallationEvent(t.Context(), &github.InstallationEvent{Action: github.Ptr("deleted"), Installation: inst})
+	if got := installedRepos.Value(); got != 0 {
+		t.Errorf("installed repos after uninstalling: got %d, want 0", got)
+	}
+}
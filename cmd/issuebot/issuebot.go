@@ -28,15 +28,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
-	"strconv"
+	"runtime/debug"
+	"slices"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
@@ -47,101 +54,306 @@ import (
 
 var (
 	// Metrics
-	pullsChecked   = expvar.NewInt("issuebot_pull_requests_checked")
-	webhookWakeups = expvar.NewInt("issuebot_webhook_wakeups")
+	pullsChecked      = expvar.NewInt("issuebot_pull_requests_checked")
+	webhookWakeups    = expvar.NewInt("issuebot_webhook_wakeups")
+	githubRetries     = expvar.NewInt("issuebot_github_retries")
+	githubRateLimited = expvar.NewInt("issuebot_github_rate_limited")
+	checksRescheduled = expvar.NewInt("issuebot_checks_rescheduled")
+	breakerTrips      = expvar.NewInt("issuebot_github_breaker_trips")
+	duplicateHooks    = expvar.NewInt("issuebot_webhook_duplicates")
+	queueDrained      = expvar.NewInt("issuebot_queue_drained")
+
+	deliveriesRequested = expvar.NewInt("issuebot_redeliveries_requested")
+	reconcileChecks     = expvar.NewInt("issuebot_reconcile_checks")
+	stubReminders       = expvar.NewInt("issuebot_stub_reminders")
+	stubEscalations     = expvar.NewInt("issuebot_stub_escalations")
+	previousSecretUsed  = expvar.NewInt("issuebot_webhook_previous_secret_used")
+	webhooksRejected    = expvar.NewMap("issuebot_webhooks_rejected")
+	skippedActions      = expvar.NewMap("issuebot_pr_actions_skipped")
+	pings               = expvar.NewInt("issuebot_webhook_pings")
+	installedRepos      = expvar.NewInt("issuebot_installation_repos")
+	squashAudits        = expvar.NewInt("issuebot_squash_audit_failures")
+	commitCacheHits     = expvar.NewInt("issuebot_commit_cache_hits")
+	githubNotModified   = expvar.NewInt("issuebot_github_not_modified")
+	decisionsReused     = expvar.NewInt("issuebot_decisions_reused")
+	checkQueueLength    = expvar.NewInt("issuebot_check_queue_length")
+	checkWorkersBusy    = expvar.NewInt("issuebot_check_workers_busy")
+	checksShed          = expvar.NewInt("issuebot_checks_shed")
+	spansExported       = expvar.NewInt("issuebot_spans_exported")
+	spansDropped        = expvar.NewInt("issuebot_spans_dropped")
+	githubCalls         = expvar.NewInt("issuebot_github_calls")
+	checkGitHubCalls    = expvar.NewInt("issuebot_check_github_calls")
+
+	// GitHub API rate limits by resource, as of the latest response; the
+	// reset time is in seconds since the Unix epoch.
+	rateLimitLimit     = expvar.NewMap("issuebot_github_rate_limit")
+	rateLimitRemaining = expvar.NewMap("issuebot_github_rate_limit_remaining")
+	rateLimitReset     = expvar.NewMap("issuebot_github_rate_limit_reset")
 
 	// Flags
+	showVersion = flag.Bool("version", false,
+		"Print the version of issuebot and how it was built, and exit")
+	configFile = flag.String("config", "",
+		"If set, read settings from this HuJSON file (command-line flags take precedence)")
+	appIDFlag = flag.Int64("app-id", 0,
+		"GitHub App ID (if zero, read from $ISSUEBOT_APP_ID)")
+	appInstallFlag = flag.Int64("app-install", 0,
+		"GitHub App installation ID (if zero, read from $ISSUEBOT_APP_INSTALL)")
+	listenAddr = flag.String("listen", ":8080",
+		"Address to listen on for webhooks")
+	allowRepos = flag.String("allow-repos", "",
+		"If set, only check PRs in repositories matching these comma-separated owner/repo globs")
+	denyRepos = flag.String("deny-repos", "",
+		"If set, do not check PRs in repositories matching these comma-separated owner/repo globs")
+	debounceInterval = flag.Duration("debounce-interval", 5*time.Second,
+		"How long after checking a PR to ignore further events for it, to avoid duplicate stubbing")
+	shadowMode = flag.Bool("shadow", false,
+		"Evaluate PRs and log decisions, but make no changes on GitHub (statuses, comments, issues)")
+	statusContext = flag.String("status-context", "issuebot",
+		"Context label for the commit statuses we post (distinct instances need distinct labels)")
+	prActions = flag.String("pr-actions", "opened,synchronize,reopened,ready_for_review",
+		"Comma-separated pull_request event actions that trigger a check; other actions do only if the repository's settings make them matter (e.g., adding an override label)")
+	recheckOnPush = flag.Bool("recheck-on-push", false,
+		"On push events, check open PRs whose head is the pushed branch again, and after a forced push, those whose base it is")
+	checkRuns = flag.Bool("check-runs", false,
+		"Report results as check runs named by --status-context, with buttons to check again or file a stub issue, instead of commit statuses")
+	templateDir = flag.String("template-dir", "",
+		"If set, a directory of text/template files replacing the default stub issue and comment text")
 	enableStubIssues = flag.Bool("enable-stub-issues", true,
 		"Create stub issues when 'skip-issuebot' is used and no issue is found.")
 	useSecretsService = flag.String("use-secrets-service", "",
 		"If set, fetch secrets from this service (https://hostname)")
 	botAuthorEmail = flag.String("bot-author-regexp", "",
 		"If set, a regexp matching author e-mails to be treated as automation bots (RE2)")
+	queueDir = flag.String("queue-dir", "",
+		"If set, persist pending pull request events in this directory so they survive restarts")
+	catchUpWindow = flag.Duration("catch-up-window", 0,
+		"If positive, at startup request redelivery of webhooks that failed within this window")
+	catchUpInterval = flag.Duration("catch-up-interval", 0,
+		"If positive (and --catch-up-window is set), repeat the catch-up scan at this interval")
+	reconcileInterval = flag.Duration("reconcile-interval", 0,
+		"If positive, periodically check open PRs whose head commit has no issuebot status")
+	stubReminderInterval = flag.Duration("stub-reminder-interval", 0,
+		"If positive, periodically remind the assignees of stub issues that are still placeholders, and escalate old ones (see the stubReminderAfter and stubEscalation settings)")
+	summaryRepo = flag.String("summary-repo", "",
+		"If set, periodically post a summary of the use of skip-issuebot, #cleanup, and stub issues to this repository (owner/repo)")
+	summaryIssue = flag.Int("summary-issue", 0,
+		"If positive, post summaries as comments on this issue in --summary-repo, rather than as new issues")
+	summaryInterval = flag.Duration("summary-interval", 7*24*time.Hour,
+		"How often to post summaries, if --summary-repo is set")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 25*time.Second,
+		"How long to wait for in-flight checks to finish when shutting down")
+	maxCommits = flag.Int("max-commits", 250,
+		"The most commits of a pull request to scan; a PR with more fails if none of those scanned links to an issue")
+	checkWorkers = flag.Int("check-workers", 16,
+		"How many webhook deliveries that may start checks to handle at once; 0 means no limit")
+	checkQueueDepth = flag.Int("check-queue-depth", 64,
+		"How many webhook deliveries may wait for one of --check-workers; more are answered with 503 Service Unavailable, so that they can be redelivered")
+	commitConcurrency = flag.Int("commit-concurrency", 8,
+		"How many commits of a pull request to check at once")
+	commitCacheSize = flag.Int("commit-cache-size", 10000,
+		"How many results of checking commits to cache, so that they are not checked again when their PR is; 0 disables the cache")
+	decisionCacheSize = flag.Int("decision-cache-size", 10000,
+		"How many pull requests to remember the last decision about, so that events that do not change their commits need not check them again; 0 disables reuse")
+	etagCacheSize = flag.Int("etag-cache-size", 1000,
+		"How many GitHub API responses to cache, so that requests for them are conditional and do not count against the rate limit if nothing changed; 0 disables the cache")
+	decisionLog = flag.String("decision-log", "",
+		"If set, a file to which each check decision is appended as a line of JSON, with its reasons; - means standard error")
+	decisionHistorySize = flag.Int("decision-history", 200,
+		"How many recent check decisions to show on the /debug/decisions page")
+	otlpEndpoint = flag.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"If set, the base URL of an OpenTelemetry collector (e.g., http://localhost:4318) to which trace spans of checks are exported with OTLP over HTTP; defaults to $OTEL_EXPORTER_OTLP_ENDPOINT")
+	jiraURL = flag.String("jira-url", "",
+		"If set, the base URL of a Jira instance against which Jira issue keys in commits are validated")
+	jiraUser = flag.String("jira-user", "",
+		"If set, the Jira user for basic authentication with the Jira token; otherwise the token is a bearer token")
+	jiraIssueType = flag.String("jira-issue-type", "Task",
+		"The type of the stub issues filed in Jira, for repositories whose stubTracker is jira")
+	draftURL = flag.String("draft-url", "",
+		"If set, the URL of an OpenAI-compatible chat completions endpoint used to draft stub issue bodies, for repositories with draftStubs set")
+	draftModel = flag.String("draft-model", "",
+		"If set, the model to request from --draft-url")
+	startupScan = flag.Bool("startup-scan", false,
+		"At startup, check open PRs in all installation repos whose head commit has no issuebot status")
 
 	// Access tokens
 	//
 	// TODO(creachadair): Remove the environment fallback once we are
 	// comfortably deployed against the secrets server.
-	appPrivateKey       = setec.StaticSecret(os.Getenv("ISSUEBOT_APP_PRIVATE_KEY"))
-	githubWebhookSecret = setec.StaticSecret(os.Getenv("WEBHOOK_SECRET"))
-	appId               int64
-	appInstall          int64
+	appPrivateKey         = setec.StaticSecret(os.Getenv("ISSUEBOT_APP_PRIVATE_KEY"))
+	githubWebhookSecret   = setec.StaticSecret(os.Getenv("WEBHOOK_SECRET"))
+	previousWebhookSecret = setec.StaticSecret(os.Getenv("WEBHOOK_SECRET_PREVIOUS"))
+	jiraToken             = setec.StaticSecret(os.Getenv("JIRA_TOKEN"))
+	draftToken            = setec.StaticSecret(os.Getenv("DRAFT_TOKEN"))
+	appId                 int64
+	appInstall            int64
 
-	client      *github.Client
-	botAuthorRE *regexp.Regexp
+	// clientUpdater vends the GitHub API client for the app installation. It
+	// is rebuilt when the app private key is rotated.
+	clientUpdater *setec.Updater[*github.Client]
+	botAuthorRE   *regexp.Regexp
 )
 
 const (
 	appPrivateKeyName       = "prod/issuebot/app-private-key"
 	githubWebhookSecretName = "prod/issuebot/github-webhook-secret"
 
-	// The description is limited to 140 characters, so be brief.
-	missingCommitExplanation = `Any non-trivial git commit must link to a GitHub issue tracking the work. Edit each commit with a tag like "Updates #nn", and update the PR.`
+	// previousWebhookSecretName is an optional secret holding the previous
+	// webhook secret, which is also accepted while a rotation is in progress.
+	previousWebhookSecretName = "prod/issuebot/github-webhook-secret-previous"
+
+	// jiraTokenName is an optional secret holding the API token used to
+	// validate Jira issue keys (see --jira-url).
+	jiraTokenName = "prod/issuebot/jira-token"
+
+	// draftTokenName is an optional secret holding the API token for the
+	// language model used to draft stub issues (see --draft-url).
+	draftTokenName = "prod/issuebot/draft-token"
 )
 
 // Return an HTTP client suitable to use with the GitHub API, initialized with
-// our API keys and certificate.
+// our API keys and the given app private key.
 //
 // This bot expects to run as an organization-level GitHub app, as seen in
 // https://github.com/organizations/<name>/settings/installations
 // This gives it permission to access private repos without using an individual's
 // Personal Access Token.
-func getGitHubApiClient() *github.Client {
-	itr, err := ghinstallation.New(http.DefaultTransport, appId, appInstall, appPrivateKey())
+func newGitHubApiClient(privateKey []byte) (*github.Client, error) {
+	itr, err := ghinstallation.New(http.DefaultTransport, appId, appInstall, privateKey)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return github.NewClient(&http.Client{Transport: itr})
+	return github.NewClient(&http.Client{Transport: newETagTransport(itr)}), nil
 }
 
+// apiClient returns the current GitHub API client for the app installation.
+func apiClient() *github.Client { return clientUpdater.Get() }
+
 // A pullRequest bundles a pull request and its affiliated repository.
 type pullRequest struct {
 	repo *github.Repository
 	pr   *github.PullRequest
+	cfg  *repoConfig // settings for repo; nil means defaults
+
+	trail *checkTrail // collects the log lines of a check, if not nil
+	reqID string      // the ID of the request that started the check, if any
 }
 
 func (p pullRequest) logf(msg string, args ...any) {
-	log.Printf(fmt.Sprintf("PR %s#%d ", p.repo.GetFullName(), p.pr.GetNumber())+msg, args...)
+	line := fmt.Sprintf(msg, args...)
+	if p.reqID != "" {
+		log.Printf("[%s] PR %s#%d %s", p.reqID, p.repo.GetFullName(), p.pr.GetNumber(), line)
+	} else {
+		log.Printf("PR %s#%d %s", p.repo.GetFullName(), p.pr.GetNumber(), line)
+	}
+	p.trail.add(line)
 }
 
 func (p pullRequest) checkCommitMessage(message string) pullRequestStatus {
-	var verbs = []string{"close", "closes", "closed", "fix", "fixes", "fixed",
-		"resolve", "resolves", "resolved", "updates", "for"}
-
 	lines := strings.Split(message, "\n")
+	if strings.HasPrefix(lines[0], "Revert") {
+		// If the commit being reverted did not contain an issue link, we
+		// don't want to encourage editing the revert message to add one.
+		p.logf("accept: found revert commit")
+		return prRevert
+	}
+	if refs := p.matchRefs(nil, message); len(refs) != 0 {
+		p.logf("accept: links %v", refs)
+		return prLinked
+	}
 
-	for idx, line := range lines {
-		if idx == 0 && strings.HasPrefix(line, "Revert") {
-			// If the commit being reverted did not contain an issue link, we
-			// don't want to encourage editing the revert message to add one.
-			p.logf("accept: found revert commit")
-			return prRevert
+	for _, line := range lines {
+		if !p.hasLinkVerb(line) || p.cfg.strictLinks() || len(parseIssueRefs(line)) != 0 {
+			// Lines with recognizable references were judged above.
+			continue
 		}
 		lower := strings.ToLower(line)
-		for _, verb := range verbs {
-			if !strings.HasPrefix(lower, verb) {
+		if strings.Contains(lower, "#") || (strings.Contains(lower, "github.com") && len(p.cfg.linkURLs()) == 0) {
+			// This isn't a perfect check, determined miscreants could sneak
+			// something through like "Updates github.com to be more fabulous"
+			// or "Fixes #nothing-whatsoever", but we'll trust the team to
+			// keep such malappropriate impulses under control. Repositories
+			// that don't can set strictLinks.
+			p.logf("accept: %q (no recognized issue reference)", line)
+			return prLinked
+		}
+	}
+
+	if m := cherryPickRE.FindStringSubmatch(message); m != nil {
+		// The original commit was checked when it landed, so a backport
+		// need not repeat its link.
+		p.logf("accept: cherry-picked from %s", m[1])
+		return prBackport
+	}
+
+	return p.checkOverride(message)
+}
+
+// cherryPickRE matches the line "git cherry-pick -x" adds to a commit message,
+// naming the original commit.
+var cherryPickRE = regexp.MustCompile(`(?m)^\(cherry picked from commit ([0-9a-f]{7,40})\)\s*$`)
+
+// checkBackport reports whether one of the commits that message says it was
+// cherry-picked from links to an issue.
+func (p pullRequest) checkBackport(ctx context.Context, cli *github.Client, message string) (bool, error) {
+	for _, m := range cherryPickRE.FindAllStringSubmatch(message, -1) {
+		sha := m[1]
+		commit, resp, err := retryCall(ctx, "GetCommit", func(ctx context.Context) (*github.RepositoryCommit, *github.Response, error) {
+			return cli.Repositories.GetCommit(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), sha, nil)
+		})
+		if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity) {
+			p.logf("backport of %s: no such commit", sha)
+			continue
+		} else if err != nil {
+			return false, fmt.Errorf("get commit %s: %w", sha, err)
+		}
+		orig := commit.GetCommit().GetMessage()
+		refs := p.matchRefs(cli, orig)
+		if len(refs) == 0 {
+			p.logf("backport of %s: original does not link an issue", sha)
+			continue
+		}
+		if p.cfg.verifiesLinks() {
+			if ok, err := p.verifyLinks(ctx, cli, orig); err != nil {
+				return false, err
+			} else if !ok {
 				continue
 			}
-			if strings.Contains(lower, "#") || strings.Contains(lower, "github.com") {
-				// This isn't a perfect check, determined miscreants could sneak
-				// something through like "Updates github.com to be more fabulous"
-				// or "Fixes #nothing-whatsoever", but we'll trust the team to
-				// keep such malappropriate impulses under control.
-				p.logf("accept: %q", line)
-				return prLinked
-			}
 		}
+		p.logf("accept: backport of %s, which links %v", sha, refs)
+		return true, nil
 	}
+	return false, nil
+}
 
-	if strings.Contains(message, "skip-issuebot") {
-		p.logf("accept: manual override (skip-issuebot)")
-		return prSkipped
-	} else if strings.Contains(message, "#cleanup") {
-		p.logf("accept: manual override (#cleanup)")
-		return prCleanup
+// checkOverride returns the disposition of message according to the override
+// keywords it contains, if any.
+func (p pullRequest) checkOverride(message string) pullRequestStatus {
+	status, keyword := p.cfg.override(message)
+	if status != prFailed {
+		p.logf("accept: manual override (%s)", keyword)
 	}
+	return status
+}
 
-	return prFailed
+// checkDescription reports whether the title or description of the PR links
+// to an issue. Only recognized issue references count, and if the repository
+// verifies links, at least one of them must be valid.
+func (p pullRequest) checkDescription(ctx context.Context, cli *github.Client) (bool, error) {
+	// The link to a stub issue (see linkStubs) does not count.
+	body := stubLinkRE.ReplaceAllString(p.pr.GetBody(), "")
+	text := p.pr.GetTitle() + "\n\n" + strings.ReplaceAll(body, "\r\n", "\n")
+	refs := p.matchRefs(cli, text)
+	if len(refs) == 0 {
+		return false, nil
+	}
+	if p.cfg.verifiesLinks() {
+		if ok, err := p.verifyLinks(ctx, cli, text); !ok {
+			return false, err
+		}
+	}
+	p.logf("accept: description links %v", refs)
+	return true, nil
 }
 
 func (p pullRequest) checkCommitMetadata(repoCommit *github.RepositoryCommit) pullRequestStatus {
@@ -198,25 +410,52 @@ func isAutomationBotAuthor(u *github.CommitAuthor) bool {
 	return true
 }
 
-func (p pullRequest) annotateCommitStatus(headSHA string, failed bool) {
+// annotateCommitStatus posts a commit status with the given state (e.g.,
+// "success" or "failure") and optional description on the head commit of p.
+func (p pullRequest) annotateCommitStatus(ctx context.Context, headSHA, state, description string) (err error) {
+	ctx, sp := startSpan(ctx, "post status", spanInternal, "issuebot.state", state, "issuebot.check_runs", *checkRuns)
+	defer func() { sp.finish(err) }()
+	if *shadowMode {
+		p.logf("shadow: would post status %q on %s", state, headSHA)
+		return nil
+	} else if *checkRuns {
+		return p.postCheckRun(ctx, headSHA, state, description)
+	}
 	now := time.Now()
 	status := &github.RepoStatus{
-		Context:   github.Ptr("issuebot"),
+		Context:   statusContext,
+		State:     github.Ptr(state),
 		UpdatedAt: &github.Timestamp{Time: now},
 	}
-	if failed {
-		status.State = github.Ptr("failure")
-		status.Description = github.Ptr(missingCommitExplanation)
-	} else {
-		status.State = github.Ptr("success")
+	if description != "" {
+		status.Description = github.Ptr(description)
 	}
 
-	ctx := context.Background()
-	_, _, err := client.Repositories.CreateStatus(ctx, *p.repo.Owner.Login, *p.repo.Name, headSHA, status)
+	_, _, err = retryCall(ctx, "CreateStatus", func(ctx context.Context) (*github.RepoStatus, *github.Response, error) {
+		return apiClient().Repositories.CreateStatus(ctx, *p.repo.Owner.Login, *p.repo.Name, headSHA, status)
+	})
 	if err != nil {
-		log.Fatalf("annotateCommitStatus: err=%v", err)
+		return fmt.Errorf("annotateCommitStatus: %w", err)
 	}
+	return nil
+}
+
+// maxStatusDescription is the length limit GitHub imposes on commit status
+// descriptions, in characters.
+const maxStatusDescription = 140
 
+// statusDescription renders the named message template as a commit status
+// description for p, truncated to fit if necessary.
+func (p pullRequest) statusDescription(name string) string {
+	desc, err := p.render(name, p.data())
+	if err != nil {
+		p.logf("error rendering status description: %v", err)
+		return ""
+	}
+	if r := []rune(desc); len(r) > maxStatusDescription {
+		desc = string(r[:maxStatusDescription-1]) + "…"
+	}
+	return desc
 }
 
 // pullRequestStatus indicates the disposition of a PR.
@@ -224,164 +463,882 @@ type pullRequestStatus byte
 
 // These disposition values are ordered, with higher values being "better".
 const (
-	prFailed  pullRequestStatus = iota // failed, post a notice
-	prSkipped                          // manually skipped (skip-issuebot)
-	prCleanup                          // manually skipped (#cleanup)
-	prSmall                            // diff is small
-	prRevert                           // found a revert commit
-	prBot                              // author is a well-known bot
-	prLinked                           // found a linked issue
+	prFailed   pullRequestStatus = iota // failed, post a notice
+	prSkipped                           // manually skipped (skip-issuebot, or another "skip" override)
+	prCleanup                           // manually skipped (#cleanup, or another "accept" override)
+	prSmall                             // diff is small
+	prDocsOnly                          // all changed files are exempt (e.g., docs)
+	prRevert                            // found a revert commit
+	prBackport                          // found a cherry-picked commit
+	prBot                               // author is a well-known bot
+	prLinked                            // found a linked issue
 )
 
-func checkPullRequest(pr *github.PullRequest, repo *github.Repository) {
-	p := pullRequest{repo: repo, pr: pr}
+var statusNames = [...]string{
+	prFailed:   "failed",
+	prSkipped:  "skipped",
+	prCleanup:  "cleanup",
+	prSmall:    "small",
+	prDocsOnly: "docs-only",
+	prRevert:   "revert",
+	prBackport: "backport",
+	prBot:      "bot",
+	prLinked:   "linked",
+}
+
+func (s pullRequestStatus) String() string {
+	if int(s) < len(statusNames) {
+		return statusNames[s]
+	}
+	return fmt.Sprintf("pullRequestStatus(%d)", s)
+}
+
+// parseStatus returns the disposition with the given name, and reports
+// whether it was found.
+func parseStatus(name string) (pullRequestStatus, bool) {
+	i := slices.Index(statusNames[:], name)
+	return pullRequestStatus(i), i >= 0
+}
+
+// prActionMatters reports whether the pull_request event e should trigger a
+// check of its PR: if its action is one of --pr-actions, or the settings of
+// the repository make it matter. If e adds or removes an override label, the
+// PR is also exempted from debouncing, so that the label takes effect.
+func prActionMatters(ctx context.Context, e *github.PullRequestEvent) bool {
+	action := e.GetAction()
+	listed := slices.Contains(strings.Split(*prActions, ","), action)
+	switch action {
+	case "labeled", "unlabeled", "milestoned", "demilestoned", "edited", "converted_to_draft":
+	default:
+		return listed
+	}
+	cfg, err := loadRepoConfig(ctx, apiClient(), e.GetRepo())
+	if err != nil {
+		ctxLogf(ctx, "PR %s#%d: error loading config (checking anyway): %v", e.GetRepo().GetFullName(), e.GetPullRequest().GetNumber(), err)
+		return true
+	}
+	if (action == "labeled" || action == "unlabeled") && cfg.overrideLabel(e.GetLabel().GetName()) != "" {
+		undebounce(e.PullRequest, e.Repo)
+		return true
+	}
+	// Policies may use labels, milestones, and the base branch, the
+	// description may link to an issue, and a draft may need a pending status.
+	return listed || (cfg != nil && len(cfg.Policy) != 0) || (action == "edited" && cfg.prDescription()) ||
+		(action == "converted_to_draft" && cfg.skipDrafts())
+}
+
+// checkTimeout bounds the total time spent checking a single pull request.
+const checkTimeout = 5 * time.Minute
+
+func checkPullRequest(ctx context.Context, pr *github.PullRequest, repo *github.Repository) error {
+	return checkPullRequestEvent(ctx, pr, repo, "")
+}
+
+// checkPullRequestEvent checks pr as triggered by a pull_request event with
+// the given action, or "" for any other reason. If the action does not change
+// the PR's commits, and neither its head commit nor anything else the last
+// decision about it depended on has changed, that decision is reported again
+// rather than checking its commits anew.
+func checkPullRequestEvent(ctx context.Context, pr *github.PullRequest, repo *github.Repository, action string) (err error) {
+	p := pullRequest{repo: repo, pr: pr, reqID: requestID(ctx)}
+	ctx, sp := startSpan(ctx, "check", spanInternal, "github.repository", repo.GetFullName(), "github.pull_request", pr.GetNumber(), "git.commit.sha", pr.GetHead().GetSHA())
+	ctx, calls := withCallCount(ctx)
+	defer func() {
+		checkGitHubCalls.Add(calls.Load())
+		sp.set("issuebot.github_calls", calls.Load())
+		sp.finish(err)
+	}()
+	if !repoEnabled(repo.GetFullName()) {
+		p.logf("skipping because the repository is not enabled")
+		return nil
+	}
+	if err := beginCheck(); err != nil {
+		return err
+	}
+	defer endCheck()
+
 	p.logf("begin check")
-	if debounce(pr, repo) {
+	// Keep what was decided, and why, for the debug page.
+	rec := &decisionRecord{
+		repo:    repo.GetFullName(),
+		pr:      pr.GetNumber(),
+		author:  pr.GetUser().GetLogin(),
+		headSHA: pr.GetHead().GetSHA(),
+		action:  action,
+		start:   time.Now(),
+	}
+	p.trail = new(checkTrail)
+	defer func() {
+		if rec.outcome != "" || err != nil {
+			rec.err, rec.calls = err, calls.Load()
+			recordDecision(rec, p.trail)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	client := apiClient()
+
+	cfg, err := loadRepoConfig(ctx, client, repo)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	p.cfg = cfg
+
+	if debounce(pr, repo, cfg.debounceInterval()) {
 		p.logf("skipping because it was recently checked")
-		return
+		return nil
+	}
+	if pr.GetDraft() && cfg.skipDrafts() {
+		p.logf("skipping draft until it is ready for review")
+		rec.outcome = "draft"
+		return p.annotateCommitStatus(ctx, pr.GetHead().GetSHA(), "pending", p.statusDescription(draftStatusTemplate))
 	}
+	if slices.Contains(reusableActions, action) {
+		if status, ok := p.lastDecision(); ok {
+			p.logf("reusing decision %v, since only %q changed", status, action)
+			decisionsReused.Add(1)
+			sp.set("issuebot.disposition", status.String(), "issuebot.reused", true)
+			rec.outcome, rec.reused = status.String(), true
+			return p.reportStatus(ctx, client, status)
+		}
+	}
+	status, err := p.evaluate(ctx, client)
+	if err != nil {
+		return err
+	}
+	p.rememberDecision(status)
+	sp.set("issuebot.disposition", status.String())
+	rec.outcome = status.String()
+	return p.reportStatus(ctx, client, status)
+}
 
-	ctx := context.Background()
-	opts := github.ListOptions{PerPage: 100}
+// reportStatus posts the status of the head commit of p for the disposition
+// status, with an advisory comment if it failed in an advisory repository.
+func (p pullRequest) reportStatus(ctx context.Context, client *github.Client, status pullRequestStatus) error {
+	pr, cfg := p.pr, p.cfg
+
+	// Post a status either way, so that the reconciler can tell which PRs
+	// have been checked.
+	switch {
+	case status != prFailed:
+		return p.annotateCommitStatus(ctx, *pr.Head.SHA, "success", "")
+	case cfg.advisory():
+		// Advisory repositories get the explanation, but not a failing check.
+		p.logf("reject (advisory)")
+		if err := p.postAdvisoryComment(ctx, client); err != nil {
+			p.logf("error adding advisory comment (continuing): %v", err)
+		}
+		return p.annotateCommitStatus(ctx, *pr.Head.SHA, "success", p.statusDescription(advisoryStatusTemplate))
+	default:
+		p.logf("reject")
+		return p.annotateCommitStatus(ctx, *pr.Head.SHA, "failure", p.failureDescription())
+	}
+}
+
+// tooLarge reports whether p has more commits than are scanned.
+func (p pullRequest) tooLarge() bool {
+	return p.pr.GetCommits() > p.cfg.maxCommits()
+}
+
+// failureDescription returns the status description for p when it fails,
+// which explains if it was too large to scan fully.
+func (p pullRequest) failureDescription() string {
+	if p.tooLarge() {
+		return p.statusDescription(tooLargeStatusTemplate)
+	}
+	return p.statusDescription(failureStatusTemplate)
+}
+
+// evaluate decides the disposition of p, whose settings are loaded, filing
+// or closing a stub issue and explaining an ignored override as needed, but
+// does not report the result.
+func (p pullRequest) evaluate(ctx context.Context, client *github.Client) (pullRequestStatus, error) {
+	pr, repo, cfg := p.pr, p.repo, p.cfg
+	if cfg == nil {
+		cfg = new(repoConfig) // defaults; the accessors allow nil, but not all fields are read through them
+	}
+	var err error
 
 	// A PR is initially "failed". Scan as many commits as necessary to find a
-	// reason better than prSkipped (skip-issuebot), if there is one.
+	// reason better than prSkipped (skip-issuebot), if there is one. If the
+	// repository has a policy, scan them all so the policy can see them.
 	status := prFailed
-	totalDiff := 0
-	for status <= prSkipped {
-		repoCommits, resp, err := client.PullRequests.ListCommits(
-			ctx, *repo.Owner.Login, *repo.Name, *pr.Number, &opts)
-		if err != nil {
-			log.Fatalf("checkPullRequest: err=%v", err)
-		}
-
-		for _, rc := range repoCommits {
-			// You may be wondering why we are looking up a RepositoryCommit when
-			// we already have one in hand (rc).
-			//
-			// We do so because the RepositoryCommit messages we get from the
-			// ListCommits API are not complete -- in particular they lack diff
-			// stats.  GetCommit returns the same result type, but all the fields
-			// are populated.
-			commit, _, err := client.Repositories.GetCommit(ctx, *repo.Owner.Login, *repo.Name, *rc.SHA, &opts)
-			if err != nil {
-				log.Fatalf("GetCommit: sha=%s err=%v", rc.GetSHA(), err)
+	scanAll := len(cfg.Policy) != 0
+	var in policyInput
+	var link string           // an issue linked by a commit, if any
+	var denied deniedOverride // the first override ignored, if any
+	lctx, sp := startSpan(ctx, "list commits", spanInternal)
+	commits, err := p.pullCommits(lctx, client)
+	sp.set("issuebot.commits", len(commits))
+	sp.finish(err)
+	if err != nil {
+		return prFailed, fmt.Errorf("list commits: %w", err)
+	}
+	results, err := p.checkCommits(ctx, client, commits, scanAll)
+	if err != nil {
+		return prFailed, err
+	}
+	for _, r := range results {
+		if r == nil {
+			break // not checked, since an earlier commit settled it
+		}
+		msg := r.commit.GetCommit().GetMessage()
+		in.addCommit(r.commit, r.size, p.matchRefs(client, msg), repo.GetFullName())
+		if r.denied.user != "" && denied.user == "" {
+			denied = r.denied
+		}
+		if r.disp == prLinked && link == "" {
+			if refs := p.matchRefs(client, msg); len(refs) != 0 {
+				link = refs[0].String()
 			}
-			totalDiff += commit.GetStats().GetTotal()
+		}
+		status = max(status, r.disp, r.bot)
+		if status > prSkipped && !scanAll {
+			break
+		}
+	}
 
-			// Check the commit message for tags.
-			if disp := p.checkCommitMessage(*commit.Commit.Message); disp > status {
-				status = disp
-			}
-			// Check commit metadata for well-known bots.
-			if disp := p.checkCommitMetadata(commit); disp > status {
-				status = disp
-			}
+	// GitHub's own record of the issues linked to the PR counts too, if the
+	// repository wants it.
+	if status <= prSkipped && cfg.closingIssues() {
+		ok, err := p.checkClosingIssues(ctx, client)
+		if err != nil {
+			return prFailed, fmt.Errorf("check closing issues: %w", err)
+		} else if ok {
+			status = prLinked
+		}
+	}
 
-			if status > prSkipped {
-				break
-			}
+	// As does its timeline, which records issues linked by hand and issues
+	// that mention the PR.
+	if status <= prSkipped && cfg.timelineLinks() {
+		ok, err := p.checkTimelineLinks(ctx, client)
+		if err != nil {
+			return prFailed, fmt.Errorf("check timeline: %w", err)
+		} else if ok {
+			status = prLinked
 		}
-		if resp.NextPage == 0 {
-			break
+	}
+
+	// So does a link in the PR title or description, if the repository
+	// squashes PRs.
+	if status <= prSkipped && cfg.prDescription() {
+		ok, err := p.checkDescription(ctx, client)
+		if err != nil {
+			return prFailed, fmt.Errorf("check description: %w", err)
+		} else if ok {
+			status = prLinked
 		}
-		opts.Page = resp.NextPage
+	}
+
+	// Overrides can also be given with slash commands in PR comments.
+	if status <= prSkipped {
+		cs, err := p.checkCommands(ctx, client)
+		if err != nil {
+			return prFailed, fmt.Errorf("check commands: %w", err)
+		} else if cs > status {
+			status = cs
+		}
+	}
+
+	// As can override labels on the PR.
+	if status <= prSkipped && len(cfg.OverrideLabels) != 0 {
+		ls, d, err := p.checkOverrideLabels(ctx, client)
+		if err != nil {
+			return prFailed, fmt.Errorf("check override labels: %w", err)
+		} else if ls > status {
+			status = ls
+		}
+		if denied.user == "" {
+			denied = d
+		}
+	}
+
+	// And so can an approving review that says so.
+	if status <= prSkipped && cfg.approvalPhrase() != "" {
+		as, d, err := p.checkApproval(ctx, client)
+		if err != nil {
+			return prFailed, fmt.Errorf("check approval: %w", err)
+		} else if as > status {
+			status = as
+		}
+		if denied.user == "" {
+			denied = d
+		}
+	}
+
+	// Changes that only touch exempt files (e.g., documentation) need not be
+	// linked to an issue.
+	var files []*github.CommitFile
+	listed := false
+	needExempt := status <= prSkipped && len(cfg.ExemptPaths) != 0
+	if needExempt || len(cfg.Policy) != 0 {
+		files, err = p.listPullFiles(ctx, client)
+		if err != nil {
+			return prFailed, fmt.Errorf("list files: %w", err)
+		}
+		listed = true
+		for _, f := range files {
+			in.files = append(in.files, f.GetFilename())
+		}
+	}
+	if needExempt && len(in.files) != 0 && !slices.ContainsFunc(in.files, func(f string) bool {
+		return !matchAnyGlob(cfg.ExemptPaths, f)
+	}) {
+		p.logf("accept: all %d changed files are exempt", len(in.files))
+		status = prDocsOnly
 	}
 
 	// Very small diffs are typically small cleanup changes and need not be
 	// subjected to strict scrutiny (assuming we didn't find a better reason).
-	if status <= prSkipped && totalDiff < 5 {
-		p.logf("accept: total diff is %d lines", totalDiff)
-		status = prSmall
+	if status <= prSkipped && cfg.minDiff() > 0 {
+		var totalDiff int
+		switch {
+		case scanAll:
+			totalDiff = in.size // the commits were looked up in full
+		case len(cfg.DiffExcludePaths) == 0 && pr.Additions != nil && pr.Deletions != nil:
+			// Webhooks and GetPullRequest give the size of the PR's diff,
+			// though lists of PRs do not.
+			totalDiff = pr.GetAdditions() + pr.GetDeletions()
+		default:
+			if !listed {
+				if files, err = p.listPullFiles(ctx, client); err != nil {
+					return prFailed, fmt.Errorf("list files: %w", err)
+				}
+			}
+			totalDiff = cfg.filesDiffSize(files)
+		}
+		if totalDiff < cfg.minDiff() {
+			p.logf("accept: total diff is %d lines", totalDiff)
+			status = prSmall
+		}
+	}
+
+	// Give the repository policy, if any, the final say.
+	if ps, err := p.applyPolicy(status, &in); err != nil {
+		p.logf("error applying policy (ignored): %v", err)
+	} else {
+		status = ps
 	}
 
 	// If the best-available reason to accept the PR was a commit with a manual
 	// skip-issuebot tag, (maybe) create a stub issue and attach it to the PR.
-	if status == prSkipped && *enableStubIssues {
+	if status == prSkipped && cfg.stubIssues() {
 		// First check whether we have already created an issue for this PR.
-		issue, err := p.checkStubIssue(ctx, client)
-		if issue > 0 {
-			p.logf("accept: stub issue #%d found", issue)
-		} else if issue, err = p.createStubIssue(ctx, client); issue > 0 {
-			p.logf("accept: stub issue #%d created", issue)
+		t := p.stubTracker(client)
+		issue, err := t.findStub(ctx, p)
+		if issue != nil {
+			p.logf("accept: stub issue %v found", issue)
+		} else if claim, cerr := p.claimStub(ctx, client); cerr != nil {
+			err = cerr
+		} else if claim == 0 {
+			p.logf("accept: stub issue being filed by another replica")
+		} else {
+			// Look again, in case another replica filed the stub just before
+			// we claimed the PR.
+			if issue, err = t.findStub(ctx, p); issue != nil {
+				p.logf("accept: stub issue %v found", issue)
+			} else {
+				sctx, sp := startSpan(ctx, "create stub", spanInternal, "issuebot.tracker", cfg.stubTracker())
+				if issue, err = t.createStub(sctx, p); issue != nil {
+					p.logf("accept: stub issue %v created", issue)
+					stubsCreated.Add(repo.GetFullName(), 1)
+					sp.set("issuebot.stub", issue.String())
+				}
+				sp.finish(err)
+			}
+			p.releaseStubClaim(ctx, client, claim)
 		}
-		if err != nil {
+		switch {
+		case err == nil:
+		case issue == nil && cfg.stubsMode() == stubsRequired:
+			p.logf("reject: error adding required stub issue: %v", err)
+			status = prFailed
+		default:
 			p.logf("error adding stub issue (accepting anyway): %v", err)
 		}
 	}
 
-	if status == prFailed {
-		p.logf("reject")
-		p.annotateCommitStatus(*pr.Head.SHA, true)
+	// If the PR now links to an issue, a stub filed for it earlier is no
+	// longer needed.
+	if status == prLinked && cfg.stubIssues() && cfg.stubTracker() == trackerGitHub {
+		if err := p.closeStubIssue(ctx, client, link); err != nil {
+			p.logf("error closing stub issue (continuing): %v", err)
+		}
+	}
+
+	// Explain an ignored override, if it made the difference.
+	if status == prFailed && denied.user != "" {
+		if err := p.postOverrideDenied(ctx, client, denied); err != nil {
+			p.logf("error explaining ignored override (continuing): %v", err)
+		}
 	}
+
+	p.recordOverride(status)
+	p.countDisposition(status)
+	return status, nil
 }
 
-func handleWebhook(w http.ResponseWriter, r *http.Request) {
-	webhookWakeups.Add(1)
-	if r.Method != "POST" && r.Method != "PUT" {
-		log.Printf("method not allowed: %s\n", r.Method)
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+// settleCheck handles the outcome err of a check of pr, whose entry in the
+// event queue is that of the webhook delivery id ("" if it has none). If
+// GitHub was not available, the check is rescheduled or deferred, along with
+// its entry, and settleCheck reports true. If the server is shutting down,
+// settleCheck reports true, so that the check is left in the event queue.
+// Otherwise, the check is complete, and settleCheck reports false.
+func settleCheck(pr *github.PullRequest, repo *github.Repository, err error, id string) bool {
+	if errors.Is(err, errShuttingDown) || rootCtx.Err() != nil {
+		return true
+	} else if d, ok := rateLimitDelay(err); ok {
+		scheduleRecheck(pr, repo, d, id)
+		return true
+	} else if errors.Is(err, errBreakerOpen) {
+		deferCheck(pr, repo, id)
+		return true
+	}
+	return false
+}
+
+// recheck runs a check of pr outside of a webhook request. Once it completes
+// without error, the event queue entry of the webhook delivery id, if any, is
+// removed.
+func recheck(ctx context.Context, pr *github.PullRequest, repo *github.Repository, id string) {
+	err := checkPullRequest(ctx, pr, repo)
+	if settleCheck(pr, repo, err, id) {
 		return
+	} else if err != nil {
+		pullRequest{repo: repo, pr: pr}.logf("check failed: %v", err)
+		return
+	}
+	dequeueEvent(id)
+}
+
+// botVersion returns the version of issuebot, as recorded in the binary: the
+// module version if it was installed with go install, or else the VCS
+// revision it was built from, if known.
+func botVersion() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if v := bi.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	var rev, modified string
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			if s.Value == "true" {
+				modified = "+dirty"
+			}
+		}
+	}
+	if rev == "" {
+		return "devel"
+	}
+	return rev + modified
+}
+
+// pingResponse returns the reply to a ping event, which GitHub shows in the
+// settings of the webhook, to confirm that it reaches this instance and
+// summarize how it is configured.
+func pingResponse() map[string]any {
+	return map[string]any{
+		"version":        botVersion(),
+		"status_context": *statusContext,
+		"check_runs":     *checkRuns,
+		"shadow":         *shadowMode,
+		"allow_repos":    *allowRepos,
+		"deny_repos":     *denyRepos,
+		"pr_actions":     *prActions,
+		"stub_issues":    *enableStubIssues,
+	}
+}
+
+// maxWebhookBytes is the largest webhook request body we will accept.
+// GitHub caps webhook payloads at 25 MiB.
+const maxWebhookBytes = 25 << 20
+
+// validateSignature checks the signature of a webhook payload against the
+// current and (if set) previous webhook secrets, and reports an error unless
+// either of them matches.
+func validateSignature(signature string, payload []byte) error {
+	err := github.ValidateSignature(signature, payload, githubWebhookSecret())
+	if err == nil {
+		return nil
+	}
+	if prev := previousWebhookSecret(); len(prev) != 0 {
+		if github.ValidateSignature(signature, payload, prev) == nil {
+			previousSecretUsed.Add(1)
+			return nil
+		}
+	}
+	return err
+}
+
+// A webhookDelivery is a webhook as GitHub sent it, however it reached us.
+type webhookDelivery struct {
+	event       string // event type, e.g., "pull_request"
+	id          string // delivery ID, if any
+	contentType string
+	signature   string // X-Hub-Signature-256, or X-Hub-Signature if that is absent
+	payload     []byte
+}
+
+// webhookRequestDelivery returns the delivery sent by the webhook request r,
+// whose body is payload.
+func webhookRequestDelivery(r *http.Request, payload []byte) webhookDelivery {
+	signature := r.Header.Get(github.SHA256SignatureHeader)
+	if signature == "" {
+		signature = r.Header.Get(github.SHA1SignatureHeader)
+	}
+	return webhookDelivery{
+		event:       github.WebHookType(r),
+		id:          github.DeliveryID(r),
+		contentType: r.Header.Get("Content-Type"),
+		signature:   signature,
+		payload:     payload,
 	}
+}
+
+// A webhookResult is our response to a webhook delivery.
+type webhookResult struct {
+	code        int // HTTP status code; 0 means 200 OK
+	contentType string
+	body        []byte
+}
+
+// errorResult returns a plain text error response, as http.Error would write.
+func errorResult(code int, msg string) webhookResult {
+	return webhookResult{code: code, contentType: "text/plain; charset=utf-8", body: []byte(msg + "\n")}
+}
 
-	payload, err := github.ValidatePayload(r, githubWebhookSecret())
+// jsonResult returns a response with v encoded as JSON.
+func jsonResult(v any) webhookResult {
+	body, err := json.Marshal(v)
 	if err != nil {
-		log.Printf("error validating request body: err=%s\n", err)
-		http.Error(w, "webhook signature bad", http.StatusUnauthorized)
+		return errorResult(http.StatusInternalServerError, "encoding response failed")
+	}
+	return webhookResult{contentType: "application/json", body: append(body, '\n')}
+}
+
+// statusCode returns the HTTP status code of res.
+func (res webhookResult) statusCode() int {
+	if res.code == 0 {
+		return http.StatusOK
+	}
+	return res.code
+}
+
+// write sends res as the response to a webhook request.
+func (res webhookResult) write(w http.ResponseWriter) {
+	if res.contentType != "" {
+		w.Header().Set("Content-Type", res.contentType)
+	}
+	if res.contentType == "text/plain; charset=utf-8" {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	w.WriteHeader(res.statusCode())
+	w.Write(res.body)
+}
+
+// rejectWebhook returns an error response with the given HTTP status code to
+// the sender of a webhook, and counts it in the rejection metrics under
+// reason.
+func rejectWebhook(reason string, code int, msg string, args ...any) webhookResult {
+	log.Printf("rejecting webhook (%s): %s", reason, fmt.Sprintf(msg, args...))
+	webhooksRejected.Add(reason, 1)
+	return errorResult(code, http.StatusText(code))
+}
+
+// handleWebhook serves webhook requests from GitHub.
+func handleWebhook(w http.ResponseWriter, r *http.Request) {
+	webhookWakeups.Add(1)
+	if r.Method != "POST" && r.Method != "PUT" {
+		rejectWebhook("method", http.StatusMethodNotAllowed, "method not allowed: %s", r.Method).write(w)
 		return
 	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBytes)
 	defer r.Body.Close()
+	payload, err := io.ReadAll(r.Body)
+	if mbe := (*http.MaxBytesError)(nil); errors.As(err, &mbe) {
+		rejectWebhook("too-large", http.StatusRequestEntityTooLarge, "body exceeds %d bytes", mbe.Limit).write(w)
+		return
+	} else if err != nil {
+		rejectWebhook("read", http.StatusBadRequest, "error reading request body: %v", err).write(w)
+		return
+	}
+	// Checks are not bound to the request context, since GitHub may give up
+	// waiting for our response before the check is done.
+	ctx := withRequestID(rootCtx, requestID(r.Context()))
+	processWebhook(ctx, webhookRequestDelivery(r, payload)).write(w)
+}
 
-	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+// processWebhook handles a webhook delivery, and returns the response to
+// send. Checks it starts run synchronously, bound to ctx.
+func processWebhook(ctx context.Context, d webhookDelivery) (res webhookResult) {
+	ctx, sp := startSpan(ctx, "webhook "+d.event, spanServer, "github.event", d.event, "github.delivery", d.id)
+	defer func() {
+		sp.set("http.response.status_code", res.statusCode())
+		if res.statusCode() >= 500 {
+			sp.finish(errors.New(http.StatusText(res.statusCode())))
+		} else {
+			sp.finish(nil)
+		}
+	}()
+	if ct, _, _ := mime.ParseMediaType(d.contentType); ct != "application/json" {
+		return rejectWebhook("content-type", http.StatusUnsupportedMediaType, "unsupported content type %q", ct)
+	}
+	if err := validateSignature(d.signature, d.payload); err != nil {
+		return rejectWebhook("signature", http.StatusUnauthorized, "error validating request body: %v", err)
+	}
+
+	// GitHub may deliver the same event more than once, e.g., if it did not
+	// see our response in time. Skip deliveries we have already handled.
+	if d.id != "" && seenDelivery(d.id) {
+		ctxLogf(ctx, "ignoring duplicate delivery %q", d.id)
+		duplicateHooks.Add(1)
+		return webhookResult{}
+	}
+
+	event, err := github.ParseWebHook(d.event, d.payload)
 	if err != nil {
-		log.Printf("could not parse webhook: err=%s\n", err)
-		http.Error(w, "could not parse payload", http.StatusBadRequest)
-		return
+		return rejectWebhook("parse", http.StatusBadRequest, "could not parse webhook: %v", err)
+	}
+
+	// Deliveries that may start a check wait for a worker. If too many are
+	// waiting already, shed this one; GitHub records the failure, so that it
+	// can be redelivered once we have caught up.
+	if slices.Contains(checkEvents, d.event) {
+		if err := checkPool().acquire(ctx); err != nil {
+			ctxLogf(ctx, "shedding %s delivery %q: %v", d.event, d.id, err)
+			forgetDelivery(d.id)
+			return errorResult(http.StatusServiceUnavailable, "overloaded")
+		}
+		defer checkPool().release()
 	}
 
 	switch e := event.(type) {
 	case *github.PullRequestEvent:
+		if e.GetAction() == "closed" && !e.GetPullRequest().GetMerged() {
+			// An abandoned PR needs no check, but its stub issue may need
+			// tidying up.
+			if err := retireAbandonedStub(ctx, e.PullRequest, e.Repo); err != nil {
+				ctxLogf(ctx, "PR %s#%d: error retiring stub issue: %v", e.Repo.GetFullName(), e.PullRequest.GetNumber(), err)
+				forgetDelivery(d.id) // allow a redelivery to try again
+				return errorResult(http.StatusInternalServerError, "stub cleanup failed")
+			}
+			return webhookResult{}
+		}
+		if e.GetAction() == "closed" {
+			if err := auditMergedPull(ctx, e.PullRequest, e.Repo); err != nil {
+				ctxLogf(ctx, "PR %s#%d: error auditing merge commit (continuing): %v", e.Repo.GetFullName(), e.PullRequest.GetNumber(), err)
+			}
+		}
+		switch e.GetAction() {
+		case "labeled", "unlabeled", "milestoned", "demilestoned":
+			if err := syncStubIssue(ctx, e); err != nil {
+				ctxLogf(ctx, "PR %s#%d: error syncing stub issue (continuing): %v", e.Repo.GetFullName(), e.PullRequest.GetNumber(), err)
+			}
+		}
+		if e.GetAction() == "ready_for_review" {
+			// A skipped draft may have been checked moments ago.
+			undebounce(e.PullRequest, e.Repo)
+		}
+		if !prActionMatters(ctx, e) {
+			ctxLogf(ctx, "PR %s#%d: ignoring %q event", e.Repo.GetFullName(), e.PullRequest.GetNumber(), e.GetAction())
+			skippedActions.Add(e.GetAction(), 1)
+			return webhookResult{}
+		}
 		pullsChecked.Add(1)
-		checkPullRequest(e.PullRequest, e.Repo)
+		if err := enqueueEvent(d.id, d.payload); err != nil {
+			ctxLogf(ctx, "error queueing event (continuing): %v", err)
+		}
+		err := checkPullRequestEvent(ctx, e.PullRequest, e.Repo, e.GetAction())
+		if errors.Is(err, errShuttingDown) {
+			// Report failure, so that the delivery can be retried later.
+			forgetDelivery(d.id)
+			return errorResult(http.StatusServiceUnavailable, "shutting down")
+		} else if settleCheck(e.PullRequest, e.Repo, err, d.id) {
+			return webhookResult{code: http.StatusAccepted} // deferred
+		} else if err != nil {
+			// The entry is kept, for a redelivery or the next startup.
+			ctxLogf(ctx, "PR %s#%d check failed: %v", e.Repo.GetFullName(), e.PullRequest.GetNumber(), err)
+			forgetDelivery(d.id) // allow a redelivery to try again
+			return errorResult(http.StatusInternalServerError, "check failed")
+		}
+		// Only this delivery's own entry is removed: if the check was
+		// debounced, the check in progress still has its own.
+		dequeueEvent(d.id)
+
+	case *github.IssueCommentEvent:
+		if !e.GetIssue().IsPullRequest() || (e.GetAction() != "created" && e.GetAction() != "edited") {
+			return webhookResult{}
+		} else if e.GetComment().GetUser().GetType() == "Bot" {
+			return webhookResult{} // e.g., our own record of a stub request
+		}
+		if len(parseCommands(e.GetComment().GetBody())) == 0 {
+			return webhookResult{}
+		}
+		err := handleCommandEvent(ctx, e)
+		if errors.Is(err, errShuttingDown) {
+			forgetDelivery(d.id)
+			return errorResult(http.StatusServiceUnavailable, "shutting down")
+		} else if err != nil {
+			ctxLogf(ctx, "PR %s#%d: error handling commands: %v", e.Repo.GetFullName(), e.GetIssue().GetNumber(), err)
+			forgetDelivery(d.id) // allow a redelivery to try again
+			return errorResult(http.StatusInternalServerError, "command failed")
+		}
+
+	case *github.PullRequestReviewEvent:
+		if e.GetPullRequest().GetState() != "open" {
+			return webhookResult{}
+		}
+		err := handleReviewEvent(ctx, e)
+		if errors.Is(err, errShuttingDown) {
+			forgetDelivery(d.id)
+			return errorResult(http.StatusServiceUnavailable, "shutting down")
+		} else if err != nil {
+			ctxLogf(ctx, "PR %s#%d: error handling review: %v", e.Repo.GetFullName(), e.GetPullRequest().GetNumber(), err)
+			forgetDelivery(d.id) // allow a redelivery to try again
+			return errorResult(http.StatusInternalServerError, "review check failed")
+		}
+
+	case *github.CheckRunEvent:
+		if e.GetCheckRun().GetName() != *statusContext || e.GetCheckRun().GetApp().GetID() != appId {
+			return webhookResult{}
+		}
+		var err error
+		switch e.GetAction() {
+		case "requested_action":
+			err = handleCheckRunAction(ctx, e)
+		case "rerequested":
+			err = handleRerequest(ctx, e.Repo, e.GetCheckRun().GetHeadSHA(), e.GetCheckRun().PullRequests, e.GetSender().GetLogin())
+		default:
+			return webhookResult{}
+		}
+		if errors.Is(err, errShuttingDown) {
+			forgetDelivery(d.id)
+			return errorResult(http.StatusServiceUnavailable, "shutting down")
+		} else if err != nil {
+			ctxLogf(ctx, "check run %d in %s: error handling action: %v", e.GetCheckRun().GetID(), e.Repo.GetFullName(), err)
+			forgetDelivery(d.id) // allow a redelivery to try again
+			return errorResult(http.StatusInternalServerError, "action failed")
+		}
+
+	case *github.MergeGroupEvent:
+		if e.GetAction() != "checks_requested" {
+			return webhookResult{}
+		}
+		err := handleMergeGroupEvent(ctx, e)
+		if errors.Is(err, errShuttingDown) {
+			forgetDelivery(d.id)
+			return errorResult(http.StatusServiceUnavailable, "shutting down")
+		} else if err != nil {
+			ctxLogf(ctx, "merge group %s in %s: error reporting status: %v", e.GetMergeGroup().GetHeadRef(), e.Repo.GetFullName(), err)
+			forgetDelivery(d.id) // allow a redelivery to try again
+			return errorResult(http.StatusInternalServerError, "merge group check failed")
+		}
+
+	case *github.CheckSuiteEvent:
+		// GitHub sends these only to the app that owns the suite.
+		if e.GetAction() != "rerequested" {
+			return webhookResult{}
+		}
+		err := handleRerequest(ctx, e.Repo, e.GetCheckSuite().GetHeadSHA(), e.GetCheckSuite().PullRequests, e.GetSender().GetLogin())
+		if errors.Is(err, errShuttingDown) {
+			forgetDelivery(d.id)
+			return errorResult(http.StatusServiceUnavailable, "shutting down")
+		} else if err != nil {
+			ctxLogf(ctx, "check suite %d in %s: error handling re-run: %v", e.GetCheckSuite().GetID(), e.Repo.GetFullName(), err)
+			forgetDelivery(d.id) // allow a redelivery to try again
+			return errorResult(http.StatusInternalServerError, "re-run failed")
+		}
+
+	case *github.InstallationEvent:
+		handleInstallationEvent(ctx, e)
+
+	case *github.InstallationRepositoriesEvent:
+		handleInstallationReposEvent(ctx, e)
+
+	case *github.PingEvent:
+		ctxLogf(ctx, "ping from hook %d: %s", e.GetHookID(), e.GetZen())
+		pings.Add(1)
+		return jsonResult(pingResponse())
+
+	case *github.PushEvent:
+		// Pick up configuration changes without waiting for the cache to expire.
+		if pushTouchesConfig(e) {
+			owner, name := e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName()
+			ctxLogf(ctx, "config changed in %s/%s, invalidating cache", owner, name)
+			invalidateRepoConfig(owner, name)
+		}
+		if *recheckOnPush {
+			// Check in the background, since there may be many PRs, and
+			// the checks must outlive this request.
+			go recheckPushedPulls(withRequestID(rootCtx, requestID(ctx)), e)
+		}
 
 	default:
 		// not something we need to respond to
-		log.Printf("ignoring webhook event\n")
-		return
+		ctxLogf(ctx, "ignoring webhook event\n")
+		return webhookResult{}
 	}
+	return webhookResult{}
 }
 
 func main() {
-	flag.Parse()
-	log.Print("IssueBot is starting")
+	os.Exit(runMain(os.Args[1:]))
+}
 
-	appIdString := os.Getenv("ISSUEBOT_APP_ID")
-	appInstallString := os.Getenv("ISSUEBOT_APP_INSTALL")
+// runServe implements the serve subcommand, which is also the default: it
+// serves webhooks until interrupted. It exits the process on failure.
+func runServe(args []string) int {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: issuebot [serve] [flags]\n\nServe GitHub webhooks. Run \"issuebot help\" for the other commands.\n\n")
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
+	if *showVersion {
+		readVersionInfo().writeVersion(os.Stdout)
+		return 0
+	}
+	log.Print("IssueBot is starting")
 
-	if appIdString == "" || appInstallString == "" {
-		log.Fatal("ISSUEBOT_APP_ID and ISSUEBOT_APP_INSTALL are required environment variables")
+	if err := loadServerConfig(*configFile); err != nil {
+		log.Fatal(err)
+	}
+	if *configFile != "" {
+		log.Printf("Loaded config from %q", *configFile)
+	}
+	if *templateDir != "" {
+		log.Printf("Loaded message templates from %q", *templateDir)
+	}
+	if botAuthorRE != nil {
+		log.Printf("Enabled bot regexp matching: %q", botAuthorRE)
 	}
 
 	var err error
-	appId, err = strconv.ParseInt(appIdString, 10, 64)
+	appId, err = flagOrEnvInt64(*appIDFlag, "ISSUEBOT_APP_ID")
 	if err != nil {
-		log.Fatalf("Cannot parse ISSUEBOT_APP_ID as integer: %v", appIdString)
+		log.Fatalf("Missing or invalid --app-id: %v", err)
 	}
-	appInstall, err = strconv.ParseInt(appInstallString, 10, 64)
+	appInstall, err = flagOrEnvInt64(*appInstallFlag, "ISSUEBOT_APP_INSTALL")
 	if err != nil {
-		log.Fatalf("Cannot parse ISSUEBOT_APP_INSTALL as integer: %v", appInstallString)
-	}
-	if *botAuthorEmail != "" {
-		botAuthorRE = regexp.MustCompile(*botAuthorEmail)
-		log.Printf("Enabled bot regexp matching: %q", botAuthorRE)
+		log.Fatalf("Missing or invalid --app-install: %v", err)
 	}
-
 	// Fetch secrets from the secrets service, if configured.
+	//
+	// Secrets from the store are updated in place when they are rotated, so
+	// the webhook secret needs no special handling. The API client is rebuilt
+	// when the app private key changes.
 	if *useSecretsService != "" {
 		log.Printf("Fetching secrets from %q", *useSecretsService)
 		st, err := setec.NewStore(context.Background(), setec.StoreConfig{
-			Client:  setec.Client{Server: *useSecretsService},
-			Secrets: []string{appPrivateKeyName, githubWebhookSecretName},
+			Client:      setec.Client{Server: *useSecretsService},
+			Secrets:     []string{appPrivateKeyName, githubWebhookSecretName},
+			AllowLookup: true, // for the optional previous webhook secret
 		})
 		if err != nil {
 			log.Fatalf("Fetching secrets failed: %v", err)
@@ -389,22 +1346,126 @@ func main() {
 		log.Print("Secret store is ready")
 		appPrivateKey = st.Secret(appPrivateKeyName)
 		githubWebhookSecret = st.Secret(githubWebhookSecretName)
+		if prev, err := st.LookupSecret(context.Background(), previousWebhookSecretName); err == nil {
+			log.Printf("Also accepting webhooks signed with %q", previousWebhookSecretName)
+			previousWebhookSecret = prev
+		}
+		if *jiraURL != "" {
+			if tok, err := st.LookupSecret(context.Background(), jiraTokenName); err == nil {
+				jiraToken = tok
+			}
+		}
+		if *draftURL != "" {
+			if tok, err := st.LookupSecret(context.Background(), draftTokenName); err == nil {
+				draftToken = tok
+			}
+		}
+		clientUpdater, err = setec.NewUpdater(context.Background(), st, appPrivateKeyName, func(key []byte) (*github.Client, error) {
+			log.Print("Creating GitHub API client")
+			return newGitHubApiClient(key)
+		})
+		if err != nil {
+			log.Fatalf("Creating GitHub API client: %v", err)
+		}
 	} else if len(appPrivateKey()) == 0 {
 		log.Fatalf("Missing required %q", appPrivateKeyName)
 	} else if len(githubWebhookSecret()) == 0 {
 		log.Fatalf("Missing required %q", githubWebhookSecretName)
+	} else {
+		cli, err := newGitHubApiClient(appPrivateKey())
+		if err != nil {
+			log.Fatalf("Creating GitHub API client: %v", err)
+		}
+		clientUpdater = setec.StaticUpdater(cli)
 	}
 
-	// TODO(creachadair): This currently only runs once; plumb in a Watcher and
-	// refresh the client when necessary.
-	client = getGitHubApiClient()
+	// Export trace spans of checks.
+	if *otlpEndpoint != "" {
+		log.Printf("Exporting trace spans to %q", *otlpEndpoint)
+		go runSpanExport(rootCtx, spanExportInterval)
+	}
+
+	// Re-run any checks that were interrupted by a previous shutdown.
+	if *queueDir != "" {
+		if err := os.MkdirAll(*queueDir, 0700); err != nil {
+			log.Fatalf("Creating queue directory: %v", err)
+		}
+		go drainQueue(rootCtx)
+	}
+
+	// Request redelivery of webhooks we missed while we were not running.
+	if *catchUpWindow > 0 {
+		go runCatchUp(*catchUpWindow, *catchUpInterval)
+	}
+
+	// Count the repositories covered, which installation events then update.
+	go countInstalledRepos(rootCtx)
+
+	// Pick up PRs that webhooks did not tell us about, e.g., because they
+	// predate the installation.
+	if *startupScan || *reconcileInterval > 0 {
+		go runReconcile(*startupScan, *reconcileInterval)
+	}
+
+	// Nudge authors to fill in their stub issues.
+	if *stubReminderInterval > 0 {
+		go runStubReminders(*stubReminderInterval)
+	}
+
+	// Tell managers how often the escape hatches are used.
+	if *summaryRepo != "" && *summaryInterval > 0 {
+		if _, _, ok := strings.Cut(*summaryRepo, "/"); !ok {
+			log.Fatalf("Invalid --summary-repo %q, want owner/repo", *summaryRepo)
+		}
+		go runSummary(*summaryInterval)
+	}
+
+	// As an AWS Lambda function, take webhooks from the runtime API instead
+	// of serving HTTP.
+	if api := os.Getenv("AWS_LAMBDA_RUNTIME_API"); api != "" {
+		log.Print("Running as an AWS Lambda function")
+		log.Fatal(runLambda(rootCtx, api))
+	}
 
 	mux := http.NewServeMux()
-	tsweb.Debugger(mux)
+	dbg := tsweb.Debugger(mux)
+	dbg.HandleFunc("dispositions", "Checks by disposition and repository", serveDispositions)
+	dbg.HandleFunc("decisions", "Recent check decisions, and why", serveDecisions)
 	mux.HandleFunc("/webhook", handleWebhook)
+	mux.HandleFunc("/healthz", serveHealthz)
+	mux.HandleFunc("/readyz", serveReadyz)
+	mux.HandleFunc("/version", serveVersion)
 	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+		Addr:    *listenAddr,
+		Handler: logRequests(mux),
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	<-ctx.Done()
+	stop()
+
+	// Stop accepting webhooks, then wait for checks already in progress.
+	// Checks that do not finish in time remain in the event queue (if there
+	// is one) and will be run at the next startup.
+	log.Print("IssueBot is shutting down")
+	sctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		log.Printf("Shutting down server: %v", err)
+	}
+	if err := drainChecks(sctx); err != nil {
+		log.Printf("Waiting for checks to finish: %v", err)
+	}
+	if err := flushSpans(sctx); err != nil {
+		log.Printf("Exporting trace spans: %v", err)
 	}
-	log.Fatal(srv.ListenAndServe())
+	cancelRoot()
+	log.Print("IssueBot has stopped")
+	return 0
 }
@@ -5,10 +5,26 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-github/v72/github"
+	"github.com/tailscale/setec/client/setec"
 )
 
 func TestIsAutmationBotAuthor(t *testing.T) {
@@ -75,6 +91,9 @@ func TestCheckCommitMessage(t *testing.T) {
 		{"prLinked Linear number\nUpdates XXX-123", prFailed}, // https://github.com/tailscale/corp/issues/21347
 
 		{"Revert 0123456789abcdef", prRevert},
+		{"Fix the frobnicator\n\n(cherry picked from commit 0123456789abcdef)", prBackport},
+		{"Fix the frobnicator\n\nUpdates #1\n(cherry picked from commit 0123456789abcdef)", prLinked},
+		{"Fix the frobnicator\n\nNot (cherry picked from commit 0123456789abcdef)", prFailed},
 		{"prCleanup\nJust a #cleanup", prCleanup},
 		{"prSkipped\nskip-issuebot", prSkipped},
 		{"", prFailed},
@@ -88,3 +107,458 @@ func TestCheckCommitMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckDescription(t *testing.T) {
+	tests := []struct {
+		title, body string
+		want        bool
+	}{
+		{"Frobnicate less (#1)", "", false},
+		{"Fix the frobnicator", "It was broken.\r\n\r\nFixes #1\r\n", true},
+		{"Fix the frobnicator", "Updates https://github.com/tailscale/example/issues/1", true},
+		{"Fixes #1", "", true},
+		{"Fix the frobnicator", "Fixes #nothing-whatsoever", false},
+		{"Fix the frobnicator", "skip-issuebot", false},
+		{"Fix the frobnicator", "It was broken.\n\nCloses #2 <!-- issuebot:stub-link -->", false},
+		{"", "", false},
+	}
+	for _, tc := range tests {
+		p := pullRequest{pr: &github.PullRequest{Title: github.Ptr(tc.title), Body: github.Ptr(tc.body)}}
+		got, err := p.checkDescription(t.Context(), nil)
+		if err != nil {
+			t.Errorf("checkDescription(%q, %q): unexpected error: %v", tc.title, tc.body, err)
+		} else if got != tc.want {
+			t.Errorf("checkDescription(%q, %q): got %v, want %v", tc.title, tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestCheckBackport(t *testing.T) {
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/commits/aaaaaaa":
+			w.Write([]byte(`{"sha": "aaaaaaa", "commit": {"message": "Fix the frobnicator\n\nUpdates #1"}}`))
+		case "/repos/o/r/commits/bbbbbbb":
+			w.Write([]byte(`{"sha": "bbbbbbb", "commit": {"message": "Fix the frobnicator"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(10)},
+	}
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		{"x\n\n(cherry picked from commit aaaaaaa)", true},
+		{"x\n\n(cherry picked from commit bbbbbbb)", false},
+		{"x\n\n(cherry picked from commit ccccccc)", false},
+		{"x\n\n(cherry picked from commit ccccccc)\n(cherry picked from commit aaaaaaa)", true},
+	}
+	for _, tc := range tests {
+		got, err := p.checkBackport(t.Context(), cli, tc.message)
+		if err != nil {
+			t.Errorf("checkBackport(%q): unexpected error: %v", tc.message, err)
+		} else if got != tc.want {
+			t.Errorf("checkBackport(%q): got %v, want %v", tc.message, got, tc.want)
+		}
+	}
+}
+
+func TestValidateSignature(t *testing.T) {
+	// Setup: Install current and previous secrets for the tests to use.
+	old, oldPrev := githubWebhookSecret, previousWebhookSecret
+	t.Cleanup(func() { githubWebhookSecret, previousWebhookSecret = old, oldPrev })
+	githubWebhookSecret = setec.StaticSecret("current")
+	previousWebhookSecret = setec.StaticSecret("previous")
+
+	sign := func(secret, body string) string {
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(h.Sum(nil))
+	}
+	const body = `{"zen":"Keep it logically awesome."}`
+	tests := []struct {
+		secret string
+		ok     bool
+	}{
+		{"current", true},
+		{"previous", true},
+		{"other", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		err := validateSignature(sign(tc.secret, body), []byte(body))
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("validateSignature(secret %q): got err=%v, want ok=%v", tc.secret, err, tc.ok)
+		}
+	}
+}
+
+// newFakeGitHub returns a GitHub API client that sends its requests to h.
+func newFakeGitHub(t *testing.T, h http.Handler) *github.Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	cli := github.NewClient(srv.Client())
+	cli.BaseURL, _ = url.Parse(srv.URL + "/")
+	return cli
+}
+
+// readOnlyTransport fails the test, and the request, on anything but a GET.
+type readOnlyTransport struct {
+	t    *testing.T
+	base http.RoundTripper
+}
+
+func (rt readOnlyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method != "GET" {
+		rt.t.Errorf("unexpected write: %s %s", r.Method, r.URL.Path)
+		return nil, fmt.Errorf("read-only client: %s %s", r.Method, r.URL.Path)
+	}
+	return rt.base.RoundTrip(r)
+}
+
+// newReadOnlyGitHub is like newFakeGitHub, but the client may only read.
+func newReadOnlyGitHub(t *testing.T, h http.Handler) *github.Client {
+	t.Helper()
+	fake := newFakeGitHub(t, h)
+	hc := fake.Client()
+	hc.Transport = readOnlyTransport{t, hc.Transport}
+	cli := github.NewClient(hc)
+	cli.BaseURL = fake.BaseURL
+	return cli
+}
+
+// testRepo is the repository of the PRs served by fakeCheckGitHub.
+var testRepo = &github.Repository{
+	Name:     github.Ptr("r"),
+	Owner:    &github.User{Login: github.Ptr("o")},
+	FullName: github.Ptr("o/r"),
+}
+
+// testPR returns open PR number n in testRepo, as served by fakeCheckGitHub.
+func testPR(n int) *github.PullRequest {
+	return &github.PullRequest{
+		Number:  github.Ptr(n),
+		State:   github.Ptr("open"),
+		Title:   github.Ptr(fmt.Sprintf("PR %d", n)),
+		User:    &github.User{Login: github.Ptr("alice")},
+		Head:    &github.PullRequestBranch{SHA: github.Ptr(fmt.Sprintf("%040x", n)), Ref: github.Ptr(fmt.Sprintf("pr%d", n))},
+		Base:    &github.PullRequestBranch{Ref: github.Ptr("main")},
+		Commits: github.Ptr(1),
+	}
+}
+
+// fakeCheckGitHub makes the API client a fake GitHub, with which a check of
+// testPR(n) passes, its one commit linking to an issue. Other requests go to
+// next, if it is not nil. It returns a function reporting the numbers of the
+// PRs whose status was posted so far, in order. The configuration and recent
+// checks of other tests are forgotten.
+func fakeCheckGitHub(t *testing.T, next http.Handler) (checked func() []int) {
+	t.Helper()
+	var mu sync.Mutex
+	var prs []int
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n, ok := strings.CutPrefix(r.URL.Path, "/repos/o/r/statuses/"); ok && r.Method == "POST" {
+			n, _ := strconv.ParseInt(n, 16, 0)
+			mu.Lock()
+			prs = append(prs, int(n))
+			mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+			return
+		}
+		if n, ok := strings.CutPrefix(r.URL.Path, "/repos/o/r/pulls/"); ok && strings.HasSuffix(n, "/commits") {
+			fmt.Fprintf(w, `[{"sha":%q,"commit":{"message":"Fix it\n\nFixes #5"}}]`, fakeSHA(n))
+			return
+		}
+		if next != nil {
+			next.ServeHTTP(w, r)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	reset := func() {
+		repoConfigCache.Lock()
+		delete(repoConfigCache.m, testRepo.GetFullName())
+		repoConfigCache.Unlock()
+		debounceCache.Lock()
+		clear(debounceCache.m)
+		debounceCache.Unlock()
+	}
+	oldClient := clientUpdater
+	clientUpdater = setec.StaticUpdater(cli)
+	reset()
+	t.Cleanup(func() {
+		clientUpdater = oldClient
+		reset()
+	})
+	return func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), prs...)
+	}
+}
+
+// fakeSHA returns the hash of a fake commit with the given message, so that,
+// as in git, commits with different messages have different hashes.
+func fakeSHA(message string) string {
+	h := sha1.Sum([]byte(message))
+	return hex.EncodeToString(h[:])
+}
+
+func TestPRActionMatters(t *testing.T) {
+	// Actions that never depend on the repository's settings.
+	tests := []struct {
+		action string
+		want   bool
+	}{
+		{"opened", true},
+		{"synchronize", true},
+		{"reopened", true},
+		{"ready_for_review", true},
+		{"assigned", false},
+		{"review_requested", false},
+		{"closed", false},
+	}
+	for _, tc := range tests {
+		e := &github.PullRequestEvent{Action: github.Ptr(tc.action)}
+		if got := prActionMatters(t.Context(), e); got != tc.want {
+			t.Errorf("prActionMatters(%q): got %v, want %v", tc.action, got, tc.want)
+		}
+	}
+}
+
+func TestPingWebhook(t *testing.T) {
+	old, oldPrev := githubWebhookSecret, previousWebhookSecret
+	t.Cleanup(func() { githubWebhookSecret, previousWebhookSecret = old, oldPrev })
+	githubWebhookSecret = setec.StaticSecret("current")
+
+	const body = `{"zen":"Design for failure.","hook_id":42}`
+	h := hmac.New(sha256.New, []byte("current"))
+	h.Write([]byte(body))
+	req := httptest.NewRequest("POST", "/webhook", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(github.EventTypeHeader, "ping")
+	req.Header.Set(github.SHA256SignatureHeader, "sha256="+hex.EncodeToString(h.Sum(nil)))
+	rec := httptest.NewRecorder()
+	before := pings.Value()
+	handleWebhook(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("ping: got status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("ping: invalid response %q: %v", rec.Body, err)
+	}
+	if got["version"] == "" || got["status_context"] != *statusContext {
+		t.Errorf("ping: got %v, want the version and status context", got)
+	}
+	if n := pings.Value() - before; n != 1 {
+		t.Errorf("ping: counted %d pings, want 1", n)
+	}
+}
+
+func TestRejectWebhook(t *testing.T) {
+	old, oldPrev := githubWebhookSecret, previousWebhookSecret
+	t.Cleanup(func() { githubWebhookSecret, previousWebhookSecret = old, oldPrev })
+	githubWebhookSecret, previousWebhookSecret = setec.StaticSecret("current"), setec.StaticSecret("")
+
+	sign := func(body string) string {
+		h := hmac.New(sha256.New, []byte("current"))
+		h.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(h.Sum(nil))
+	}
+	const ping = `{"zen":"Mind your words, they are important.","hook_id":42}`
+	tests := []struct {
+		name        string
+		method      string
+		body        string
+		contentType string
+		signature   string
+		wantCode    int
+		wantReason  string
+	}{
+		{"method", "GET", "", "application/json", "", http.StatusMethodNotAllowed, "method"},
+		{"too large", "POST", strings.Repeat(" ", maxWebhookBytes+1), "application/json", "", http.StatusRequestEntityTooLarge, "too-large"},
+		{"form", "POST", ping, "application/x-www-form-urlencoded", sign(ping), http.StatusUnsupportedMediaType, "content-type"},
+		{"no content type", "POST", ping, "", sign(ping), http.StatusUnsupportedMediaType, "content-type"},
+		{"signature", "POST", ping, "application/json", "sha256=00", http.StatusUnauthorized, "signature"},
+		{"charset", "POST", ping, "application/json; charset=utf-8", sign(ping), http.StatusOK, ""},
+	}
+	for _, tc := range tests {
+		req := httptest.NewRequest(tc.method, "/webhook", strings.NewReader(tc.body))
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		req.Header.Set(github.EventTypeHeader, "ping")
+		req.Header.Set(github.SHA256SignatureHeader, tc.signature)
+		before := map[string]int64{}
+		webhooksRejected.Do(func(kv expvar.KeyValue) { before[kv.Key] = kv.Value.(*expvar.Int).Value() })
+		rec := httptest.NewRecorder()
+		handleWebhook(rec, req)
+
+		if rec.Code != tc.wantCode {
+			t.Errorf("%s: got status %d, want %d", tc.name, rec.Code, tc.wantCode)
+		}
+		webhooksRejected.Do(func(kv expvar.KeyValue) {
+			n := kv.Value.(*expvar.Int).Value() - before[kv.Key]
+			if kv.Key == tc.wantReason && n != 1 {
+				t.Errorf("%s: counted %d rejections for %q, want 1", tc.name, n, kv.Key)
+			} else if kv.Key != tc.wantReason && n != 0 {
+				t.Errorf("%s: counted %d rejections for %q, want 0", tc.name, n, kv.Key)
+			}
+		})
+		if tc.wantReason != "" && webhooksRejected.Get(tc.wantReason) == nil {
+			t.Errorf("%s: counted no rejections for %q, want 1", tc.name, tc.wantReason)
+		}
+	}
+}
+
+func TestEvaluateDiffSize(t *testing.T) {
+	var fileLists int
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/o/r/pulls/1/commits":
+			w.Write([]byte(`[{"sha":"abc","commit":{"message":"Tweak the frobnicator"}}]`))
+		case r.URL.Path == "/repos/o/r/pulls/1/files":
+			fileLists++
+			w.Write([]byte(`[{"filename":"main.go","changes":3},{"filename":"vendor/x.go","changes":1000}]`))
+		case r.URL.Path == "/repos/o/r/issues/1/comments":
+			w.Write([]byte("[]"))
+		case r.URL.Path == "/graphql":
+			http.NotFound(w, r) // commits are listed with REST instead
+		default:
+			// In particular, commits are not looked up one by one.
+			t.Errorf("unexpected request for %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	repo := &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")}
+	excludeVendor := &repoConfig{DiffExcludePaths: []string{"vendor/**"}}
+
+	tests := []struct {
+		name      string
+		additions *int
+		cfg       *repoConfig
+		want      pullRequestStatus
+		fileLists int
+	}{
+		{"small PR", github.Ptr(3), nil, prSmall, 0},
+		{"large PR", github.Ptr(1003), nil, prFailed, 0},
+		{"size unknown", nil, nil, prFailed, 1},
+		{"size unknown, excludes", nil, excludeVendor, prSmall, 1},
+		{"large PR, excludes", github.Ptr(1003), excludeVendor, prSmall, 1},
+	}
+	for _, tc := range tests {
+		fileLists = 0
+		pr := &github.PullRequest{Number: github.Ptr(1), Additions: tc.additions}
+		if tc.additions != nil {
+			pr.Deletions = github.Ptr(0)
+		}
+		p := pullRequest{repo: repo, pr: pr, cfg: tc.cfg}
+		got, err := p.evaluate(t.Context(), cli)
+		if err != nil {
+			t.Errorf("evaluate (%s): unexpected error: %v", tc.name, err)
+		} else if got != tc.want {
+			t.Errorf("evaluate (%s): got %v, want %v", tc.name, got, tc.want)
+		}
+		if fileLists != tc.fileLists {
+			t.Errorf("evaluate (%s): listed files %d times, want %d", tc.name, fileLists, tc.fileLists)
+		}
+	}
+}
+
+func TestShadowModeWritesNothing(t *testing.T) {
+	defer func(v bool) { *shadowMode = v }(*shadowMode)
+	*shadowMode = true
+
+	// PR #1 has an open placeholder stub, #7.
+	cli := newReadOnlyGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/issues/1/comments":
+			w.Write([]byte(`[{"body": "IssueBot here. I have filed issue #7 for you.\n\n<!-- issuebot:stub #7 -->"}]`))
+		case "/repos/o/r/issues/7":
+			json.NewEncoder(w).Encode(github.Issue{
+				Number: github.Ptr(7),
+				State:  github.Ptr("open"),
+				Title:  github.Ptr("Placeholder issue for PR #1"),
+				Body:   github.Ptr("TODO(@): Add details about PR #1"),
+				Labels: []*github.Label{{Name: github.Ptr(issuebotStubLabel)}},
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	oldClient := clientUpdater
+	clientUpdater = setec.StaticUpdater(cli)
+	setConfig := func(text string) {
+		t.Helper()
+		cfg, err := parseRepoConfig([]byte(text))
+		if err != nil {
+			t.Fatalf("parseRepoConfig: %v", err)
+		}
+		repoConfigCache.Lock()
+		repoConfigCache.m[testRepo.GetFullName()] = cachedConfig{cfg: cfg, fetched: time.Now()}
+		repoConfigCache.Unlock()
+	}
+	t.Cleanup(func() {
+		clientUpdater = oldClient
+		repoConfigCache.Lock()
+		delete(repoConfigCache.m, testRepo.GetFullName())
+		repoConfigCache.Unlock()
+	})
+
+	pr := &github.PullRequest{Number: github.Ptr(1), State: github.Ptr("closed")}
+	p := pullRequest{repo: testRepo, pr: pr, trail: new(checkTrail)}
+	if n, err := p.createStubIssue(t.Context(), cli); n != 0 || err != nil {
+		t.Errorf("createStubIssue: got %d, %v; want 0, nil", n, err)
+	}
+	if err := p.closeStubIssue(t.Context(), cli, "#3"); err != nil {
+		t.Errorf("closeStubIssue: %v", err)
+	}
+	if err := p.postOverrideDenied(t.Context(), cli, deniedOverride{user: "mallory", override: "skip-issuebot"}); err != nil {
+		t.Errorf("postOverrideDenied: %v", err)
+	}
+	for _, line := range []string{
+		"shadow: would create stub issue",
+		"shadow: would close stub issue #7",
+		"shadow: would explain ignored override skip-issuebot by @mallory",
+	} {
+		if !slices.Contains(p.trail.lines, line) {
+			t.Errorf("shadow mode: log %q, want %q", p.trail.lines, line)
+		}
+	}
+
+	for _, cfg := range []string{"abandonedStubs: close", "abandonedStubs: label"} {
+		setConfig(cfg)
+		if err := retireAbandonedStub(t.Context(), pr, testRepo); err != nil {
+			t.Errorf("retireAbandonedStub(%s): %v", cfg, err)
+		}
+	}
+	setConfig("syncStubs: true")
+	for _, action := range []string{"labeled", "unlabeled", "milestoned"} {
+		e := &github.PullRequestEvent{
+			Action:      github.Ptr(action),
+			Repo:        testRepo,
+			PullRequest: pr,
+			Label:       &github.Label{Name: github.Ptr("area/dns")},
+		}
+		if err := syncStubIssue(t.Context(), e); err != nil {
+			t.Errorf("syncStubIssue(%s): %v", action, err)
+		}
+	}
+
+	e := &github.IssueCommentEvent{
+		Repo:    testRepo,
+		Issue:   &github.Issue{Number: github.Ptr(1)},
+		Comment: &github.IssueComment{ID: github.Ptr(int64(7))},
+	}
+	if id := reactToComment(t.Context(), cli, e, cmdWorkingReaction); id != 0 {
+		t.Errorf("reactToComment: got ID %d, want 0", id)
+	}
+}
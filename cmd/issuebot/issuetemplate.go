@@ -0,0 +1,165 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+	"sigs.k8s.io/yaml"
+)
+
+// issueTemplateDir is the directory holding a repository's issue templates.
+const issueTemplateDir = ".github/ISSUE_TEMPLATE"
+
+// An issueForm is the part of a GitHub issue form (a YAML issue template)
+// that matters for filling it in. See
+// https://docs.github.com/en/communities/using-templates-to-encourage-useful-issues-and-pull-requests/syntax-for-issue-forms.
+type issueForm struct {
+	Labels labelList     `json:"labels,omitempty"`
+	Body   []formElement `json:"body"`
+}
+
+// A formElement is one element of the body of an issue form.
+type formElement struct {
+	Type       string `json:"type"`
+	ID         string `json:"id,omitempty"`
+	Attributes struct {
+		Label   string       `json:"label,omitempty"`
+		Value   string       `json:"value,omitempty"`
+		Options []formOption `json:"options,omitempty"`
+	} `json:"attributes"`
+}
+
+// A formOption is an option of a dropdown (a string) or checkboxes element
+// (an object with a label) of an issue form.
+type formOption struct {
+	Label string `json:"label"`
+}
+
+func (o *formOption) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &o.Label); err == nil {
+		return nil
+	}
+	type plain formOption
+	return json.Unmarshal(data, (*plain)(o))
+}
+
+// issueTemplateFront is the front matter of a Markdown issue template.
+type issueTemplateFront struct {
+	Labels labelList `json:"labels,omitempty"`
+}
+
+// A labelList is a list of labels in an issue template, which may be written
+// either as a list or as a comma-separated string.
+type labelList []string
+
+func (l *labelList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return json.Unmarshal(data, (*[]string)(l))
+	}
+	*l = nil
+	for label := range strings.SplitSeq(s, ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			*l = append(*l, label)
+		}
+	}
+	return nil
+}
+
+// stubFromTemplate fills in the repository's stub issue template for p, and
+// returns the resulting issue body and the labels the template asks for.
+func (p pullRequest) stubFromTemplate(ctx context.Context, cli *github.Client, st *stubTemplate, data messageData) (body string, labels []string, err error) {
+	text, err := fetchIssueTemplate(ctx, cli, p.repo.GetOwner().GetLogin(), p.repo.GetName(), st.Name)
+	if err != nil {
+		return "", nil, err
+	}
+	if path.Ext(st.Name) == ".md" {
+		return fillMarkdownTemplate(text)
+	}
+	return fillIssueForm(text, st, data)
+}
+
+// fetchIssueTemplate returns the text of the named issue template of the
+// specified repository, or of the organization's .github repository if the
+// repository has none of that name.
+func fetchIssueTemplate(ctx context.Context, cli *github.Client, owner, repo, name string) (string, error) {
+	filePath := issueTemplateDir + "/" + name
+	for _, r := range []string{repo, orgConfigRepo} {
+		fc, resp, err := getContents(ctx, cli, owner, r, filePath)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		} else if err != nil {
+			return "", fmt.Errorf("get %s/%s: %w", r, filePath, err)
+		}
+		text, err := fc.GetContent()
+		if err != nil {
+			return "", fmt.Errorf("decode %s/%s: %w", r, filePath, err)
+		}
+		return text, nil
+	}
+	return "", fmt.Errorf("issue template %s not found", name)
+}
+
+// fillMarkdownTemplate returns the body of a Markdown issue template, without
+// its front matter, and the labels given in the front matter.
+func fillMarkdownTemplate(text string) (string, []string, error) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	rest, ok := strings.CutPrefix(text, "---\n")
+	if !ok {
+		return strings.TrimSpace(text), nil, nil
+	}
+	front, body, ok := strings.Cut(rest, "\n---\n")
+	if !ok {
+		return "", nil, fmt.Errorf("unterminated front matter")
+	}
+	var fm issueTemplateFront
+	if err := yaml.Unmarshal([]byte(front), &fm); err != nil {
+		return "", nil, fmt.Errorf("front matter: %w", err)
+	}
+	return strings.TrimSpace(body), fm.Labels, nil
+}
+
+// fillIssueForm renders the issue form in text the way GitHub does when an
+// issue is filed with it: a "### Label" heading for each field, followed by
+// its value. Field values come from the templates in st, or else from the
+// form's defaults.
+func fillIssueForm(text string, st *stubTemplate, data messageData) (string, []string, error) {
+	var form issueForm
+	if err := yaml.Unmarshal([]byte(text), &form); err != nil {
+		return "", nil, fmt.Errorf("issue form: %w", err)
+	}
+	var sections []string
+	for _, e := range form.Body {
+		if e.Type == "markdown" {
+			continue // shown on the form only
+		}
+		value := e.Attributes.Value
+		if t, ok := st.fields[e.ID]; ok {
+			var sb strings.Builder
+			if err := t.Execute(&sb, data); err != nil {
+				return "", nil, fmt.Errorf("field %s: %w", e.ID, err)
+			}
+			value = sb.String()
+		} else if e.Type == "checkboxes" {
+			var boxes []string
+			for _, o := range e.Attributes.Options {
+				boxes = append(boxes, "- [ ] "+o.Label)
+			}
+			value = strings.Join(boxes, "\n")
+		}
+		if strings.TrimSpace(value) == "" {
+			value = "_No response_"
+		}
+		sections = append(sections, fmt.Sprintf("### %s\n\n%s", e.Attributes.Label, strings.TrimSpace(value)))
+	}
+	return strings.Join(sections, "\n\n"), form.Labels, nil
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestStubTemplate(t *testing.T) {
+	templates := map[string]string{
+		"/repos/o/r/contents/.github/ISSUE_TEMPLATE/bug.md": `---
+name: Bug report
+labels: bug, needs-triage
+---
+**What happened?**
+`,
+		"/repos/o/.github/contents/.github/ISSUE_TEMPLATE/bug.yml": `
+name: Bug report
+labels: [bug]
+body:
+  - type: markdown
+    attributes:
+      value: Thanks for filing a bug!
+  - type: textarea
+    id: what-happened
+    attributes:
+      label: What happened?
+  - type: input
+    id: version
+    attributes:
+      label: Version
+      value: unknown
+  - type: dropdown
+    id: os
+    attributes:
+      label: OS
+      options: [Linux, macOS]
+  - type: checkboxes
+    id: terms
+    attributes:
+      label: Checklist
+      options:
+        - label: I searched for duplicates
+`,
+	}
+	var got github.IssueRequest
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if text, ok := templates[r.URL.Path]; ok {
+			json.NewEncoder(w).Encode(map[string]string{
+				"type":     "file",
+				"encoding": "base64",
+				"content":  base64.StdEncoding.EncodeToString([]byte(text)),
+			})
+			return
+		}
+		switch r.URL.Path {
+		case "/repos/o/r/issues":
+			json.NewDecoder(r.Body).Decode(&got)
+			w.Write([]byte(`{"number": 7}`))
+		case "/repos/o/r/issues/1/comments":
+			w.Write([]byte(`{}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	tests := []struct {
+		config string
+		body   string
+		labels []string
+	}{
+		{
+			config: "stubTemplate: {name: bug.md}",
+			body:   "TODO(@alice): Add details about PR #1\n\n**What happened?**",
+			labels: []string{issuebotStubLabel, "bug", "needs-triage"},
+		},
+		{
+			config: "stubTemplate: {name: bug.yml, fields: {what-happened: 'See {{.URL}}'}}",
+			body: "TODO(@alice): Add details about PR #1\n\n" +
+				"### What happened?\n\nSee https://github.com/o/r/pull/1\n\n" +
+				"### Version\n\nunknown\n\n" +
+				"### OS\n\n_No response_\n\n" +
+				"### Checklist\n\n- [ ] I searched for duplicates",
+			labels: []string{issuebotStubLabel, "bug"},
+		},
+		{
+			// A missing template falls back to the default body.
+			config: "stubTemplate: {name: feature.yml}",
+			body:   "TODO(@alice): Add details about PR #1",
+			labels: []string{issuebotStubLabel},
+		},
+	}
+	for _, tc := range tests {
+		cfg, err := parseRepoConfig([]byte(tc.config))
+		if err != nil {
+			t.Fatalf("parseRepoConfig(%q): unexpected error: %v", tc.config, err)
+		}
+		p := pullRequest{
+			repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+			pr: &github.PullRequest{
+				Number:  github.Ptr(1),
+				User:    &github.User{Login: github.Ptr("alice")},
+				HTMLURL: github.Ptr("https://github.com/o/r/pull/1"),
+			},
+			cfg: cfg,
+		}
+		got = github.IssueRequest{}
+		if n, err := p.createStubIssue(t.Context(), cli); n != 7 || err != nil {
+			t.Fatalf("createStubIssue(%q): got %d, %v; want 7, nil", tc.config, n, err)
+		}
+		sb := parseStubBody(got.GetBody())
+		if sb.text != tc.body {
+			t.Errorf("stub body (%q): got %q, want %q", tc.config, sb.text, tc.body)
+		}
+		if ok, err := p.isPlaceholder(&github.Issue{Title: got.Title, Body: got.Body}); !ok || err != nil {
+			t.Errorf("isPlaceholder(%q): got %v, %v; want true, nil", tc.config, ok, err)
+		}
+		if !slices.Equal(got.GetLabels(), tc.labels) {
+			t.Errorf("stub labels (%q): got %q, want %q", tc.config, got.GetLabels(), tc.labels)
+		}
+	}
+}
+
+func TestStubTemplateErrors(t *testing.T) {
+	for _, config := range []string{
+		"stubTemplate: {name: bug.txt}",
+		"stubTemplate: {name: ../bug.md}",
+		"stubTemplate: {name: bug.yml, fields: {version: '{{.Nope'}}",
+	} {
+		if _, err := parseRepoConfig([]byte(config)); err == nil {
+			t.Errorf("parseRepoConfig(%q): got nil error", config)
+		}
+	}
+}
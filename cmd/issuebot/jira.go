@@ -0,0 +1,153 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// jiraProjectRE matches valid Jira project keys.
+var jiraProjectRE = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// jiraKeyRE returns a regexp matching issue keys in the given Jira projects,
+// e.g., "PROJ-123".
+func jiraKeyRE(projects []string) *regexp.Regexp {
+	return regexp.MustCompile(`\b(?:` + strings.Join(projects, "|") + `)-[1-9]\d*\b`)
+}
+
+// jiraKeys returns the Jira issue keys in message that count as links (see
+// linkSpans).
+func (p pullRequest) jiraKeys(message string) []string {
+	if p.cfg == nil || p.cfg.jiraRE == nil {
+		return nil
+	}
+	var keys []string
+	for _, span := range p.linkSpans(message) {
+		keys = append(keys, p.cfg.jiraRE.FindAllString(span, -1)...)
+	}
+	return keys
+}
+
+// A jiraKey is the key of a Jira issue, e.g., "PROJ-123".
+type jiraKey string
+
+func (k jiraKey) String() string { return string(k) }
+
+// jiraRequest sends a request to the Jira REST API at --jira-url, with the
+// JSON encoding of body, if not nil. If out is not nil, the JSON response is
+// decoded into it. It returns the HTTP status code of the response. Only
+// status 404 and success statuses are reported without an error.
+func jiraRequest(ctx context.Context, method, path string, body, out any) (int, error) {
+	if *jiraURL == "" {
+		return 0, errors.New("jira: --jira-url is not set")
+	}
+	var rbody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		rbody = bytes.NewReader(data)
+	}
+	ctx, cancel := context.WithTimeout(ctx, apiCallTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(*jiraURL, "/")+path, rbody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if tok := string(jiraToken()); tok == "" {
+		// No credentials; this works for public instances.
+	} else if *jiraUser != "" {
+		req.SetBasicAuth(*jiraUser, tok)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("jira: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	} else if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("jira: %s %s: %s", method, path, resp.Status)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("jira: %s %s: %w", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// checkJiraKey reports whether key is an existing issue in the Jira instance
+// at --jira-url; if not, the error wraps errInvalidRef. If no Jira instance
+// is configured, any key is accepted.
+func checkJiraKey(ctx context.Context, key string) error {
+	if *jiraURL == "" {
+		return nil
+	}
+	code, err := jiraRequest(ctx, "GET", "/rest/api/2/issue/"+url.PathEscape(key)+"?fields=status", nil, nil)
+	if err != nil {
+		return err
+	} else if code == http.StatusNotFound {
+		return fmt.Errorf("%w: no such Jira issue", errInvalidRef)
+	}
+	return nil
+}
+
+// createJiraStub files a stub issue for p in the first of the repository's
+// Jira projects, and mentions it in a comment on p.
+func (p pullRequest) createJiraStub(ctx context.Context, cli *github.Client) (trackerRef, error) {
+	if *shadowMode {
+		p.logf("shadow: would create Jira stub issue")
+		return nil, nil
+	}
+	data := p.data()
+	title, err := p.render(stubTitleTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.render(stubBodyTemplate, data)
+	if err != nil {
+		return nil, err
+	}
+	req := map[string]any{
+		"fields": map[string]any{
+			"project":     map[string]string{"key": p.cfg.JiraProjects[0]},
+			"issuetype":   map[string]string{"name": *jiraIssueType},
+			"summary":     title,
+			"description": body,
+		},
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if code, err := jiraRequest(ctx, "POST", "/rest/api/2/issue", req, &created); err != nil {
+		return nil, fmt.Errorf("creating Jira issue: %w", err)
+	} else if code == http.StatusNotFound || created.Key == "" {
+		return nil, errors.New("creating Jira issue: no issue key returned")
+	}
+	ref := jiraKey(created.Key)
+	if err := p.postStubComment(ctx, cli, ref, data); err != nil {
+		p.logf("error adding comment (continuing): %v", err)
+	}
+	return ref, nil
+}
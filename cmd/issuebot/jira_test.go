@@ -0,0 +1,156 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestJiraKeys(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte("jiraProjects: [PROJ, ENG]\nlinkTrailers: [Jira]\n"))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	tests := []struct {
+		commit string
+		keys   []string
+		result pullRequestStatus
+	}{
+		{"x\nFixes PROJ-1234", []string{"PROJ-1234"}, prLinked},
+		{"x\nUpdates ENG-1 and PROJ-2", []string{"ENG-1", "PROJ-2"}, prLinked},
+		{"x\nUpdates XXX-123", nil, prFailed},     // not a configured project
+		{"x\nUpdates SUBPROJ-123", nil, prFailed}, // not a whole word
+		{"x\nMentions PROJ-5 in passing", nil, prFailed},
+		{"x\n\nJira: PROJ-7", []string{"PROJ-7"}, prLinked},
+	}
+	for _, tc := range tests {
+		p := pullRequest{cfg: cfg}
+		if got := p.jiraKeys(tc.commit); !slices.Equal(got, tc.keys) {
+			t.Errorf("jiraKeys(%q): got %q, want %q", tc.commit, got, tc.keys)
+		}
+		if got := p.checkCommitMessage(tc.commit); got != tc.result {
+			t.Errorf("checkCommitMessage(%q): got %v, want %v", tc.commit, got, tc.result)
+		}
+	}
+
+	if _, err := parseRepoConfig([]byte("jiraProjects: [proj]\n")); err == nil {
+		t.Error("parseRepoConfig: got nil error for invalid project key")
+	}
+}
+
+func TestCheckJiraKeys(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sekrit" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		} else if r.URL.Path == "/rest/api/2/issue/PROJ-1" {
+			w.Write([]byte(`{"key": "PROJ-1"}`))
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	oldURL, oldToken := *jiraURL, jiraToken
+	t.Cleanup(func() { *jiraURL, jiraToken = oldURL, oldToken })
+	*jiraURL = srv.URL
+	jiraToken = func() []byte { return []byte("sekrit") }
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"PROJ-1", true},
+		{"PROJ-2", false},
+	}
+	for _, tc := range tests {
+		err := checkJiraKey(context.Background(), tc.key)
+		if err != nil && !errors.Is(err, errInvalidRef) {
+			t.Errorf("checkJiraKey(%q): unexpected error: %v", tc.key, err)
+		} else if got := err == nil; got != tc.want {
+			t.Errorf("checkJiraKey(%q): got valid %v, want %v", tc.key, got, tc.want)
+		}
+	}
+
+	jiraToken = func() []byte { return []byte("wrong") }
+	if err := checkJiraKey(context.Background(), "PROJ-1"); err == nil || errors.Is(err, errInvalidRef) {
+		t.Errorf("checkJiraKey: got %v, want error for unauthorized request", err)
+	}
+}
+
+func TestJiraStub(t *testing.T) {
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Fields struct {
+				Project struct{ Key string } `json:"project"`
+				Summary string               `json:"summary"`
+			} `json:"fields"`
+		}
+		if r.Method != "POST" || r.URL.Path != "/rest/api/2/issue" {
+			http.NotFound(w, r)
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else if req.Fields.Project.Key != "PROJ" || req.Fields.Summary != "Placeholder issue for PR #1" {
+			http.Error(w, fmt.Sprintf("unexpected request %+v", req), http.StatusBadRequest)
+		} else {
+			w.Write([]byte(`{"key": "PROJ-9"}`))
+		}
+	}))
+	defer jira.Close()
+	old := *jiraURL
+	t.Cleanup(func() { *jiraURL = old })
+	*jiraURL = jira.URL
+
+	// A fake GitHub that stores PR comments.
+	var comments []*github.IssueComment
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/o/r/issues/1/comments" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method == "POST" {
+			var c github.IssueComment
+			json.NewDecoder(r.Body).Decode(&c)
+			comments = append(comments, &c)
+		}
+		json.NewEncoder(w).Encode(comments)
+	}))
+
+	cfg, err := parseRepoConfig([]byte("jiraProjects: [PROJ]\nstubTracker: jira\n"))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(1)},
+		cfg:  cfg,
+	}
+	tr := p.stubTracker(cli)
+	if ref, err := tr.findStub(context.Background(), p); ref != nil || err != nil {
+		t.Fatalf("findStub: got %v, %v; want nil, nil", ref, err)
+	}
+	ref, err := tr.createStub(context.Background(), p)
+	if err != nil || ref != jiraKey("PROJ-9") {
+		t.Fatalf("createStub: got %v, %v; want PROJ-9", ref, err)
+	}
+	if ref, err := tr.findStub(context.Background(), p); ref != jiraKey("PROJ-9") || err != nil {
+		t.Errorf("findStub: got %v, %v; want PROJ-9", ref, err)
+	}
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "I have filed issue PROJ-9") {
+		t.Errorf("comments: got %v, want one announcing PROJ-9", comments)
+	}
+
+	if _, err := parseRepoConfig([]byte("stubTracker: jira\n")); err == nil {
+		t.Error("parseRepoConfig: got nil error for jira stubs without jiraProjects")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// lambdaHTTPEvent is the part of an AWS Lambda invocation event for an HTTP
+// request that we need. It covers function URLs and API Gateway HTTP APIs
+// (payload format 2.0), whose method is in RequestContext, and API Gateway
+// REST APIs (format 1.0), whose method is HTTPMethod.
+type lambdaHTTPEvent struct {
+	HTTPMethod      string            `json:"httpMethod"`
+	Path            string            `json:"path"`
+	RawPath         string            `json:"rawPath"`
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+	RequestContext  struct {
+		HTTP struct {
+			Method string `json:"method"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+// lambdaHTTPResponse is the response to a lambdaHTTPEvent.
+type lambdaHTTPResponse struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body"`
+}
+
+// handleLambdaEvent handles the Lambda invocation event for a webhook
+// request, and returns the response to it. Any check it starts is finished
+// before it returns, since the execution environment may be frozen after
+// that.
+func handleLambdaEvent(ctx context.Context, event []byte) ([]byte, error) {
+	var e lambdaHTTPEvent
+	if err := json.Unmarshal(event, &e); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+	webhookWakeups.Add(1)
+	start := time.Now()
+	var res webhookResult
+	var delivery string
+	method := cmp.Or(e.RequestContext.HTTP.Method, e.HTTPMethod)
+	if method != "POST" && method != "PUT" {
+		res = rejectWebhook("method", http.StatusMethodNotAllowed, "method not allowed: %s", method)
+	} else {
+		payload := []byte(e.Body)
+		if e.IsBase64Encoded {
+			var err error
+			if payload, err = base64.StdEncoding.DecodeString(e.Body); err != nil {
+				return nil, fmt.Errorf("invalid event body: %w", err)
+			}
+		}
+		// Header names are case-insensitive, and some event formats give
+		// them in lower case.
+		r := &http.Request{Header: make(http.Header)}
+		for k, v := range e.Headers {
+			r.Header.Set(k, v)
+		}
+		delivery = github.DeliveryID(r)
+		res = processWebhook(ctx, webhookRequestDelivery(r, payload))
+	}
+	logAccess(ctx, method, cmp.Or(e.RawPath, e.Path), res.statusCode(), delivery, time.Since(start))
+	// The execution environment may be frozen before the next export.
+	if err := flushSpans(ctx); err != nil {
+		log.Printf("error exporting spans (continuing): %v", err)
+	}
+	resp := lambdaHTTPResponse{StatusCode: res.statusCode(), Headers: map[string]string{}, Body: string(res.body)}
+	if res.contentType != "" {
+		resp.Headers["Content-Type"] = res.contentType
+	}
+	if id := requestID(ctx); id != "" {
+		resp.Headers[requestIDHeader] = id
+	}
+	return json.Marshal(resp)
+}
+
+// lambdaRuntimePath is the path of the AWS Lambda runtime API for
+// invocations.
+const lambdaRuntimePath = "/2018-06-01/runtime/invocation/"
+
+// runLambda handles webhook requests as an AWS Lambda function, taking
+// invocations one at a time from the runtime API at the given host:port, until
+// ctx ends or the runtime API fails.
+func runLambda(ctx context.Context, api string) error {
+	base := "http://" + api + lambdaRuntimePath
+	for {
+		req, err := http.NewRequestWithContext(ctx, "GET", base+"next", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("next invocation: %w", err)
+		}
+		event, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("next invocation: %w", err)
+		} else if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("next invocation: %s", resp.Status)
+		}
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+
+		ictx, cancel := ctx, context.CancelFunc(func() {})
+		if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+			ictx, cancel = context.WithDeadline(ctx, time.UnixMilli(ms))
+		}
+		// The invocation's ID serves as the ID of the request it carries.
+		out, err := handleLambdaEvent(withRequestID(ictx, id), event)
+		cancel()
+		path := base + id + "/response"
+		if err != nil {
+			path = base + id + "/error"
+			out, _ = json.Marshal(map[string]string{"errorMessage": err.Error(), "errorType": "InvalidEvent"})
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", path, bytes.NewReader(out))
+		if err != nil {
+			return err
+		}
+		resp, err = http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("invocation %s: %w", id, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("invocation %s: %s", id, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tailscale/setec/client/setec"
+)
+
+func TestLambda(t *testing.T) {
+	old, oldPrev := githubWebhookSecret, previousWebhookSecret
+	t.Cleanup(func() { githubWebhookSecret, previousWebhookSecret = old, oldPrev })
+	githubWebhookSecret = setec.StaticSecret("current")
+	previousWebhookSecret = setec.StaticSecret("")
+
+	const body = `{"zen":"Design for failure.","hook_id":42}`
+	h := hmac.New(sha256.New, []byte("current"))
+	h.Write([]byte(body))
+	signature := "sha256=" + hex.EncodeToString(h.Sum(nil))
+	event := func(method, signature string) string {
+		e, _ := json.Marshal(map[string]any{
+			"requestContext":  map[string]any{"http": map[string]any{"method": method}},
+			"headers":         map[string]string{"content-type": "application/json", "x-github-event": "ping", "x-hub-signature-256": signature},
+			"body":            base64.StdEncoding.EncodeToString([]byte(body)),
+			"isBase64Encoded": true,
+		})
+		return string(e)
+	}
+	tests := []struct {
+		event string
+		code  int
+	}{
+		{event("POST", signature), http.StatusOK},
+		{event("POST", "sha256=0000"), http.StatusUnauthorized},
+		{event("GET", signature), http.StatusMethodNotAllowed},
+	}
+
+	// Serve each event in turn from a fake runtime API, and collect the
+	// responses.
+	var responses []lambdaHTTPResponse
+	next := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == lambdaRuntimePath+"next":
+			if next == len(tests) {
+				http.Error(w, "no more events", http.StatusGone)
+				return
+			}
+			w.Header().Set("Lambda-Runtime-Aws-Request-Id", "req")
+			io.WriteString(w, tests[next].event)
+			next++
+		case r.URL.Path == lambdaRuntimePath+"req/response":
+			var resp lambdaHTTPResponse
+			if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+				t.Errorf("invalid response: %v", err)
+			}
+			responses = append(responses, resp)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	err := runLambda(t.Context(), strings.TrimPrefix(srv.URL, "http://"))
+	if err == nil || !strings.Contains(err.Error(), "410") {
+		t.Errorf("runLambda: got %v, want the error ending the fake runtime", err)
+	}
+	if len(responses) != len(tests) {
+		t.Fatalf("runLambda: got %d responses, want %d", len(responses), len(tests))
+	}
+	for i, tc := range tests {
+		if got := responses[i].StatusCode; got != tc.code {
+			t.Errorf("event %d: got status %d, want %d", i, got, tc.code)
+		}
+	}
+	if !strings.Contains(responses[0].Body, `"status_context"`) {
+		t.Errorf("ping: got %q, want the ping response", responses[0].Body)
+	}
+}
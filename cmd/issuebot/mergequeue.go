@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// mergeGroupRefRE matches the head ref of a merge group, which names the PR
+// it was created for, e.g., "refs/heads/gh-readonly-queue/main/pr-123-<sha>".
+// The submatch is the number of the PR.
+var mergeGroupRefRE = regexp.MustCompile(`/pr-(\d+)-[0-9a-f]+$`)
+
+// mergeGroupPull returns the number of the PR a merge group with the given
+// head ref was created for, or 0 if it cannot be told.
+func mergeGroupPull(headRef string) int {
+	m := mergeGroupRefRE.FindStringSubmatch(headRef)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// handleMergeGroupEvent reports the result of the check on the head commit of
+// a merge group in a merge queue, so that a required check does not stall
+// the queue. The PR the group was created for was checked already; the group
+// passes if the PR did. If the PR has not been checked, it is checked first.
+func handleMergeGroupEvent(ctx context.Context, e *github.MergeGroupEvent) error {
+	repo, mg := e.GetRepo(), e.GetMergeGroup()
+	if !repoEnabled(repo.GetFullName()) {
+		return nil
+	}
+	number := mergeGroupPull(mg.GetHeadRef())
+	if number == 0 {
+		ctxLogf(ctx, "merge group %s in %s: unknown PR, ignoring", mg.GetHeadRef(), repo.GetFullName())
+		return nil
+	}
+	cli := apiClient()
+	pr, _, err := retryCall(ctx, "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+		return cli.PullRequests.Get(ctx, repo.GetOwner().GetLogin(), repo.GetName(), number)
+	})
+	if err != nil {
+		return fmt.Errorf("get PR: %w", err)
+	}
+	cfg, err := loadRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	p := pullRequest{repo: repo, pr: pr, cfg: cfg}
+
+	state, err := checkState(ctx, repo, pr.GetHead().GetSHA())
+	if err != nil {
+		return fmt.Errorf("get PR status: %w", err)
+	} else if state == "" {
+		p.logf("merge group %s: PR not checked yet, checking it now", mg.GetHeadSHA())
+		undebounce(pr, repo)
+		if err := checkPullRequest(ctx, pr, repo); err != nil {
+			return err
+		}
+		if state, err = checkState(ctx, repo, pr.GetHead().GetSHA()); err != nil {
+			return fmt.Errorf("get PR status: %w", err)
+		}
+	}
+	if state == "success" {
+		p.logf("merge group %s: PR passed, passing it", mg.GetHeadSHA())
+		return p.annotateCommitStatus(ctx, mg.GetHeadSHA(), "success", "")
+	}
+	p.logf("merge group %s: PR did not pass (%q), failing it", mg.GetHeadSHA(), state)
+	return p.annotateCommitStatus(ctx, mg.GetHeadSHA(), "failure", p.statusDescription(failureStatusTemplate))
+}
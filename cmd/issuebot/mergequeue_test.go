@@ -0,0 +1,25 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestMergeGroupPull(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want int
+	}{
+		{"refs/heads/gh-readonly-queue/main/pr-123-0123456789abcdef0123456789abcdef01234567", 123},
+		{"refs/heads/gh-readonly-queue/release/1.2/pr-7-abc123", 7},
+		{"refs/heads/main", 0},
+		{"refs/heads/gh-readonly-queue/main/pr-x-abc123", 0},
+		{"", 0},
+	}
+	for _, tc := range tests {
+		if got := mergeGroupPull(tc.ref); got != tc.want {
+			t.Errorf("mergeGroupPull(%q): got %d, want %d", tc.ref, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// matchGlob reports whether the slash-separated file path name matches the
+// glob pattern. Patterns use the syntax of path.Match, plus:
+//
+//   - A pattern with no slash matches the base name of a file in any
+//     directory, e.g., "*.md" matches "README.md" and "docs/intro.md".
+//
+//   - A "**" path component matches zero or more directories, e.g.,
+//     "docs/**" matches every file under docs, and "**/testdata/*" matches
+//     files in a testdata directory at any depth.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchParts(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			if len(pat) == 1 {
+				// A trailing ** matches everything below, but not the
+				// directory itself.
+				return len(name) > 0
+			}
+			// Try matching the rest of the pattern at every suffix of name.
+			for i := range len(name) + 1 {
+				if matchParts(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchAnyGlob reports whether name matches any of the given patterns.
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pat := range patterns {
+		if matchGlob(pat, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// listPullFiles returns the files changed by the pull request.
+func (p pullRequest) listPullFiles(ctx context.Context, cli *github.Client) ([]*github.CommitFile, error) {
+	var all []*github.CommitFile
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		files, resp, err := retryCall(ctx, "ListFiles", func(ctx context.Context) ([]*github.CommitFile, *github.Response, error) {
+			return cli.PullRequests.ListFiles(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, files...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		match         bool
+	}{
+		// Patterns without a slash match base names anywhere.
+		{"*.md", "README.md", true},
+		{"*.md", "docs/guide/intro.md", true},
+		{"*.md", "main.go", false},
+
+		// Patterns with a slash match the whole path.
+		{"docs/*.txt", "docs/a.txt", true},
+		{"docs/*.txt", "docs/sub/a.txt", false},
+		{"docs/*.txt", "other/docs/a.txt", false},
+
+		// ** matches zero or more directories.
+		{"docs/**", "docs/a.txt", true},
+		{"docs/**", "docs/sub/dir/a.txt", true},
+		{"docs/**", "docs", false},
+		{"docs/**", "src/docs/a.txt", false},
+		{"**/testdata/*", "testdata/x.json", true},
+		{"**/testdata/*", "pkg/foo/testdata/x.json", true},
+		{"**/testdata/*", "pkg/foo/x.json", false},
+		{"vendor/**/*.go", "vendor/a.go", true},
+		{"vendor/**/*.go", "vendor/x/y/a.go", true},
+		{"vendor/**/*.go", "vendor/x/y/a.s", false},
+	}
+	for _, tc := range tests {
+		if got := matchGlob(tc.pattern, tc.name); got != tc.match {
+			t.Errorf("matchGlob(%q, %q): got %v, want %v", tc.pattern, tc.name, got, tc.match)
+		}
+	}
+}
+
+func TestDiffSize(t *testing.T) {
+	file := func(name string, changes int) *github.CommitFile {
+		return &github.CommitFile{Filename: &name, Changes: &changes}
+	}
+	commit := &github.RepositoryCommit{
+		Stats: &github.CommitStats{Total: github.Ptr(1003)},
+		Files: []*github.CommitFile{
+			file("main.go", 3),
+			file("vendor/x/y.go", 500),
+			file("api/api_generated.go", 500),
+		},
+	}
+	var noConfig *repoConfig
+	if got := noConfig.diffSize(commit); got != 1003 {
+		t.Errorf("diffSize (no excludes): got %d, want 1003", got)
+	}
+	cfg := &repoConfig{DiffExcludePaths: []string{"vendor/**", "*_generated.go"}}
+	if got := cfg.diffSize(commit); got != 3 {
+		t.Errorf("diffSize (with excludes): got %d, want 3", got)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/go-github/v72/github"
+)
+
+// A policyRule is a repository-defined rule that decides the disposition of a
+// pull request. The When expression is written in CEL (https://cel.dev), and
+// must evaluate to a bool. It has access to these variables:
+//
+//	pr       map: number, title, body, author, draft (bool),
+//	         labels (list of string), files (list of string), size (int)
+//	commits  list of map: sha, message, author, email, login, size (int),
+//	         refs (list of string, the issues linked, as "owner/repo#123"
+//	         or, for Jira, "PROJ-123")
+//	status   the disposition chosen by the built-in rules, e.g., "failed"
+//
+// For example:
+//
+//	policy:
+//	  - when: 'pr.author == "renovate[bot]"'
+//	    status: bot
+//	  - when: 'status == "small" && pr.files.exists(f, f.endsWith(".go"))'
+//	    status: failed
+//	    rollout: 10
+//
+// If Rollout is set, the rule applies only to that percentage of pull
+// requests, so that a new rule can be introduced gradually (see inRollout).
+type policyRule struct {
+	When    string `json:"when"`
+	Status  string `json:"status"`
+	Rollout *int   `json:"rollout,omitempty"`
+
+	prog cel.Program       // compiled from When
+	disp pullRequestStatus // parsed from Status
+}
+
+// policyEnv is the CEL environment in which policy rules are evaluated.
+var policyEnv = func() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Variable("pr", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("commits", cel.ListType(cel.MapType(cel.StringType, cel.DynType))),
+		cel.Variable("status", cel.StringType),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("policy environment: %v", err))
+	}
+	return env
+}()
+
+// compile checks and compiles the rule.
+func (r *policyRule) compile() error {
+	disp, ok := parseStatus(r.Status)
+	if !ok {
+		return fmt.Errorf("invalid status %q", r.Status)
+	}
+	if r.Rollout != nil && (*r.Rollout < 0 || *r.Rollout > 100) {
+		return fmt.Errorf("rollout %d is not a percentage", *r.Rollout)
+	}
+	ast, iss := policyEnv.Compile(r.When)
+	if iss.Err() != nil {
+		return iss.Err()
+	}
+	if ast.OutputType() != cel.BoolType {
+		return fmt.Errorf("expression has type %v, want bool", ast.OutputType())
+	}
+	prog, err := policyEnv.Program(ast)
+	if err != nil {
+		return err
+	}
+	r.prog, r.disp = prog, disp
+	return nil
+}
+
+// policyInput holds the facts about a pull request that policy rules can
+// inspect. Fields are populated as the check proceeds.
+type policyInput struct {
+	files   []string
+	commits []map[string]any
+	size    int
+}
+
+// addCommit records the facts about commit for policy evaluation. Its issue
+// references refs are resolved relative to repo, the full name of the
+// repository containing the commit.
+func (in *policyInput) addCommit(commit *github.RepositoryCommit, size int, refs []trackerRef, repo string) {
+	refNames := []string{}
+	for _, r := range refs {
+		if ir, ok := r.(issueRef); ok {
+			r = ir.resolve(repo)
+		}
+		refNames = append(refNames, r.String())
+	}
+	in.commits = append(in.commits, map[string]any{
+		"sha":     commit.GetSHA(),
+		"message": commit.GetCommit().GetMessage(),
+		"author":  commit.GetCommit().GetAuthor().GetName(),
+		"email":   commit.GetCommit().GetAuthor().GetEmail(),
+		"login":   commit.GetAuthor().GetLogin(),
+		"size":    size,
+		"refs":    refNames,
+	})
+	in.size += size
+}
+
+// applyPolicy evaluates the policy rules for p in order, and returns the
+// disposition of the first rule that matches. If none matches, it returns
+// status, the disposition chosen by the built-in rules.
+func (p pullRequest) applyPolicy(status pullRequestStatus, in *policyInput) (pullRequestStatus, error) {
+	if p.cfg == nil || len(p.cfg.Policy) == 0 {
+		return status, nil
+	}
+	labels := []string{}
+	for _, lb := range p.pr.Labels {
+		labels = append(labels, lb.GetName())
+	}
+	vars := map[string]any{
+		"pr": map[string]any{
+			"number": p.pr.GetNumber(),
+			"title":  p.pr.GetTitle(),
+			"body":   p.pr.GetBody(),
+			"author": p.pr.GetUser().GetLogin(),
+			"draft":  p.pr.GetDraft(),
+			"labels": labels,
+			"files":  append([]string{}, in.files...),
+			"size":   in.size,
+		},
+		"commits": append([]map[string]any{}, in.commits...),
+		"status":  status.String(),
+	}
+	for i, rule := range p.cfg.Policy {
+		if !p.inRollout(rule.Rollout) {
+			continue
+		}
+		out, _, err := rule.prog.Eval(vars)
+		if err != nil {
+			return status, fmt.Errorf("policy rule %d: %w", i+1, err)
+		}
+		if out.Value() == true {
+			p.logf("policy: rule %d (%s) matched, status %v", i+1, rule.When, rule.disp)
+			return rule.disp, nil
+		}
+	}
+	return status, nil
+}
+
+// inRollout reports whether p falls within a rollout of the given percentage.
+// A nil percentage means the rule is fully rolled out. PRs are bucketed by
+// repository and number, so the outcome for a PR is stable, and a PR that is
+// within a rollout remains so when the percentage is increased.
+func (p pullRequest) inRollout(percent *int) bool {
+	if percent == nil {
+		return true
+	}
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s#%d", strings.ToLower(p.repo.GetFullName()), p.pr.GetNumber())
+	return int(h.Sum32()%100) < *percent
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte(`
+policy:
+  - when: 'pr.author == "renovate[bot]"'
+    status: bot
+  - when: 'status == "small" && pr.files.exists(f, f.endsWith(".go"))'
+    status: failed
+  - when: 'commits.all(c, c.message.contains("#trivial")) && pr.size < 50'
+    status: cleanup
+  - when: 'commits.exists(c, c.refs.exists(r, r.startsWith("example/private#")))'
+    status: failed
+`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+
+	newPR := func(author string) pullRequest {
+		return pullRequest{
+			repo: &github.Repository{FullName: github.Ptr("example/repo")},
+			pr:   &github.PullRequest{Number: github.Ptr(1), User: &github.User{Login: &author}},
+			cfg:  cfg,
+		}
+	}
+	newInput := func(files []string, messages ...string) *policyInput {
+		in := &policyInput{files: files}
+		for _, msg := range messages {
+			in.addCommit(&github.RepositoryCommit{Commit: &github.Commit{Message: github.Ptr(msg)}}, 10, pullRequest{}.matchRefs(nil, msg), "example/repo")
+		}
+		return in
+	}
+
+	tests := []struct {
+		name   string
+		p      pullRequest
+		status pullRequestStatus
+		in     *policyInput
+		want   pullRequestStatus
+	}{
+		{"bot author", newPR("renovate[bot]"), prFailed, newInput(nil, "bump"), prBot},
+		{"small go change", newPR("alice"), prSmall, newInput([]string{"main.go"}, "x"), prFailed},
+		{"small doc change", newPR("alice"), prSmall, newInput([]string{"README.md"}, "x"), prSmall},
+		{"trivial commits", newPR("alice"), prFailed, newInput(nil, "a #trivial", "b #trivial"), prCleanup},
+		{"some trivial", newPR("alice"), prFailed, newInput(nil, "a #trivial", "b"), prFailed},
+		{"no match", newPR("alice"), prLinked, newInput(nil, "Fixes #1"), prLinked},
+		{"private ref", newPR("alice"), prLinked, newInput(nil, "Fixes example/private#1"), prFailed},
+	}
+	for _, tc := range tests {
+		got, err := tc.p.applyPolicy(tc.status, tc.in)
+		if err != nil {
+			t.Errorf("%s: applyPolicy: unexpected error: %v", tc.name, err)
+		} else if got != tc.want {
+			t.Errorf("%s: applyPolicy: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPolicyErrors(t *testing.T) {
+	for _, bad := range []string{
+		`policy: [{when: "pr.author ==", status: bot}]`,          // syntax error
+		`policy: [{when: "pr.number + 1", status: bot}]`,         // not a bool
+		`policy: [{when: "true", status: maybe}]`,                // unknown status
+		`policy: [{when: "undefined_var == 1", status: failed}]`, // unknown variable
+		`policy: [{when: "true", status: bot, rollout: 101}]`,    // bad percentage
+	} {
+		if _, err := parseRepoConfig([]byte(bad)); err == nil {
+			t.Errorf("parseRepoConfig(%q): got nil error", bad)
+		}
+	}
+}
+
+func TestPolicyRollout(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte(`
+policy:
+  - when: 'true'
+    status: failed
+    rollout: 30
+`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	const numPRs = 1000
+	var applied []int
+	for n := 1; n <= numPRs; n++ {
+		p := pullRequest{
+			repo: &github.Repository{FullName: github.Ptr("example/repo")},
+			pr:   &github.PullRequest{Number: github.Ptr(n)},
+			cfg:  cfg,
+		}
+		got, err := p.applyPolicy(prLinked, &policyInput{})
+		if err != nil {
+			t.Fatalf("applyPolicy: unexpected error: %v", err)
+		}
+		if got == prFailed {
+			applied = append(applied, n)
+		}
+	}
+	if len(applied) < numPRs/5 || len(applied) > numPRs*2/5 {
+		t.Errorf("rollout 30: applied to %d of %d PRs", len(applied), numPRs)
+	}
+
+	// Raising the percentage keeps the PRs that were already in the rollout.
+	*cfg.Policy[0].Rollout = 60
+	for _, n := range applied {
+		p := pullRequest{
+			repo: &github.Repository{FullName: github.Ptr("example/repo")},
+			pr:   &github.PullRequest{Number: github.Ptr(n)},
+		}
+		if !p.inRollout(cfg.Policy[0].Rollout) {
+			t.Errorf("rollout 60: PR %d dropped out", n)
+		}
+	}
+}
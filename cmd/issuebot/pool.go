@@ -0,0 +1,110 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"sync/atomic"
+)
+
+// errOverloaded is reported for work that was shed because all the workers
+// were busy and too much work was already waiting for them.
+var errOverloaded = errors.New("too many checks waiting")
+
+// checkEvents are the webhook events whose deliveries may start a check, and
+// so must wait for a worker of checkPool.
+var checkEvents = []string{
+	"pull_request",
+	"pull_request_review",
+	"issue_comment",
+	"check_run",
+	"check_suite",
+	"merge_group",
+}
+
+// checkPool bounds how many webhook deliveries that may start checks are
+// handled at once, to --check-workers, with up to --check-queue-depth more
+// waiting for a turn.
+var checkPool = sync.OnceValue(func() *workerPool {
+	return newWorkerPool(*checkWorkers, *checkQueueDepth)
+})
+
+func init() {
+	expvar.Publish("issuebot_check_worker_utilization", expvar.Func(func() any {
+		return checkPool().utilization()
+	}))
+}
+
+// A workerPool admits work up to a number of workers at once, and makes more
+// wait for one of them, up to a queue depth. Work beyond that is shed, so
+// that a saturated server fails fast rather than piling up requests.
+type workerPool struct {
+	slots   chan struct{} // one per busy worker; nil if unlimited
+	depth   int64
+	waiting atomic.Int64
+}
+
+// newWorkerPool returns a pool of size workers, with up to depth waiting for
+// them. If size is not positive, the pool admits any amount of work.
+func newWorkerPool(size, depth int) *workerPool {
+	wp := &workerPool{depth: int64(max(depth, 0))}
+	if size > 0 {
+		wp.slots = make(chan struct{}, size)
+	}
+	return wp
+}
+
+// acquire waits for a worker, and reports errOverloaded if too much work is
+// waiting already, or the error of ctx if it ends first. Unless it reports an
+// error, the caller must call release when its work is done.
+func (wp *workerPool) acquire(ctx context.Context) error {
+	if wp.slots == nil {
+		return nil
+	}
+	select {
+	case wp.slots <- struct{}{}:
+		checkWorkersBusy.Add(1)
+		return nil
+	default:
+	}
+	if wp.waiting.Add(1) > wp.depth {
+		wp.waiting.Add(-1)
+		checksShed.Add(1)
+		return errOverloaded
+	}
+	checkQueueLength.Add(1)
+	defer func() {
+		wp.waiting.Add(-1)
+		checkQueueLength.Add(-1)
+	}()
+	select {
+	case wp.slots <- struct{}{}:
+		checkWorkersBusy.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release returns the worker acquired by acquire.
+func (wp *workerPool) release() {
+	if wp.slots == nil {
+		return
+	}
+	<-wp.slots
+	checkWorkersBusy.Add(-1)
+}
+
+// utilization returns the fraction of the workers that are busy, or 0 if
+// the pool is unlimited.
+func (wp *workerPool) utilization() float64 {
+	if wp.slots == nil {
+		return 0
+	}
+	return float64(len(wp.slots)) / float64(cap(wp.slots))
+}
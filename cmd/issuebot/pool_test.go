@@ -0,0 +1,61 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	ctx := t.Context()
+	wp := newWorkerPool(2, 1)
+	for i := range 2 {
+		if err := wp.acquire(ctx); err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+	}
+	if got := wp.utilization(); got != 1 {
+		t.Errorf("utilization: got %v, want 1", got)
+	}
+
+	// With both workers busy, one more may wait for a turn...
+	acquired := make(chan error)
+	go func() { acquired <- wp.acquire(ctx) }()
+	for wp.waiting.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// ...but any more are shed.
+	if err := wp.acquire(ctx); !errors.Is(err, errOverloaded) {
+		t.Errorf("acquire when saturated: got %v, want %v", err, errOverloaded)
+	}
+	wp.release()
+	if err := <-acquired; err != nil {
+		t.Errorf("acquire after release: %v", err)
+	}
+
+	// Waiting ends with the context.
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := wp.acquire(cctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire with expired context: got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if got := wp.waiting.Load(); got != 0 {
+		t.Errorf("waiting after context ended: got %d, want 0", got)
+	}
+
+	// A pool without a size admits everything.
+	wp = newWorkerPool(0, 0)
+	for i := range 100 {
+		if err := wp.acquire(ctx); err != nil {
+			t.Fatalf("unlimited acquire %d: %v", i, err)
+		}
+	}
+	if got := wp.utilization(); got != 0 {
+		t.Errorf("unlimited utilization: got %v, want 0", got)
+	}
+}
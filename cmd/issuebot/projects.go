@@ -0,0 +1,109 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const projectQuery = `query($owner: String!, $number: Int!, $field: String!) {
+  repositoryOwner(login: $owner) {
+    ... on ProjectV2Owner {
+      projectV2(number: $number) {
+        id
+        field(name: $field) {
+          ... on ProjectV2SingleSelectField { id options { id name } }
+        }
+      }
+    }
+  }
+}`
+
+const addProjectItemMutation = `mutation($project: ID!, $content: ID!) {
+  addProjectV2ItemById(input: {projectId: $project, contentId: $content}) {
+    item { id }
+  }
+}`
+
+const setProjectFieldMutation = `mutation($project: ID!, $item: ID!, $field: ID!, $option: String!) {
+  updateProjectV2ItemFieldValue(input: {projectId: $project, itemId: $item, fieldId: $field, value: {singleSelectOptionId: $option}}) {
+    projectV2Item { id }
+  }
+}`
+
+// addToStubProject adds the stub issue to the repository's stub project, if
+// it has one, and sets its status there.
+func (p pullRequest) addToStubProject(ctx context.Context, cli *github.Client, issue *github.Issue) error {
+	sp := p.cfg.StubProject
+	if sp == nil {
+		return nil
+	}
+	owner := cmp.Or(sp.Owner, p.repo.GetOwner().GetLogin())
+	field := cmp.Or(sp.Field, "Status")
+
+	var project struct {
+		RepositoryOwner struct {
+			ProjectV2 *struct {
+				ID    string `json:"id"`
+				Field struct {
+					ID      string `json:"id"`
+					Options []struct {
+						ID   string `json:"id"`
+						Name string `json:"name"`
+					} `json:"options"`
+				} `json:"field"`
+			} `json:"projectV2"`
+		} `json:"repositoryOwner"`
+	}
+	if err := graphQL(ctx, cli, "projectV2", projectQuery, map[string]any{
+		"owner":  owner,
+		"number": sp.Number,
+		"field":  field,
+	}, &project); err != nil {
+		return err
+	}
+	proj := project.RepositoryOwner.ProjectV2
+	if proj == nil {
+		return fmt.Errorf("project %s/%d not found", owner, sp.Number)
+	}
+	var option string
+	if sp.Status != "" {
+		for _, o := range proj.Field.Options {
+			if o.Name == sp.Status {
+				option = o.ID
+			}
+		}
+		if option == "" {
+			return fmt.Errorf("project %s/%d has no %s option %q", owner, sp.Number, field, sp.Status)
+		}
+	}
+
+	var added struct {
+		AddProjectV2ItemByID struct {
+			Item struct {
+				ID string `json:"id"`
+			} `json:"item"`
+		} `json:"addProjectV2ItemById"`
+	}
+	if err := graphQL(ctx, cli, "addProjectV2ItemById", addProjectItemMutation, map[string]any{
+		"project": proj.ID,
+		"content": issue.GetNodeID(),
+	}, &added); err != nil {
+		return err
+	}
+	if option == "" {
+		return nil
+	}
+	return graphQL(ctx, cli, "updateProjectV2ItemFieldValue", setProjectFieldMutation, map[string]any{
+		"project": proj.ID,
+		"item":    added.AddProjectV2ItemByID.Item.ID,
+		"field":   proj.Field.ID,
+		"option":  option,
+	}, nil)
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestAddToStubProject(t *testing.T) {
+	var calls []string
+	var option any
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query     string         `json:"query"`
+			Variables map[string]any `json:"variables"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case strings.Contains(req.Query, "repositoryOwner"):
+			calls = append(calls, "project")
+			if req.Variables["owner"] != "o" || req.Variables["number"] != 4.0 || req.Variables["field"] != "Status" {
+				w.Write([]byte(`{"data": {"repositoryOwner": {}}}`))
+				return
+			}
+			w.Write([]byte(`{"data": {"repositoryOwner": {"projectV2": {"id": "P", "field": {"id": "F", "options": [{"id": "O1", "name": "Todo"}, {"id": "O2", "name": "Triage"}]}}}}}`))
+		case strings.Contains(req.Query, "addProjectV2ItemById"):
+			calls = append(calls, "add "+req.Variables["content"].(string))
+			w.Write([]byte(`{"data": {"addProjectV2ItemById": {"item": {"id": "I"}}}}`))
+		case strings.Contains(req.Query, "updateProjectV2ItemFieldValue"):
+			calls = append(calls, "set")
+			option = req.Variables["option"]
+			w.Write([]byte(`{"data": {"updateProjectV2ItemFieldValue": {"projectV2Item": {"id": "I"}}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	tests := []struct {
+		config  string
+		calls   string
+		wantErr bool
+	}{
+		{`{}`, "", false},
+		{`stubProject: {number: 4}`, "project,add N", false},
+		{`stubProject: {number: 4, status: Triage}`, "project,add N,set", false},
+		{`stubProject: {number: 4, status: Done}`, "project", true},
+		{`stubProject: {number: 5}`, "project", true},
+	}
+	for _, tc := range tests {
+		cfg, err := parseRepoConfig([]byte(tc.config))
+		if err != nil {
+			t.Fatalf("parseRepoConfig(%q): unexpected error: %v", tc.config, err)
+		}
+		p := pullRequest{
+			repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+			pr:   &github.PullRequest{Number: github.Ptr(1)},
+			cfg:  cfg,
+		}
+		calls, option = nil, nil
+		err = p.addToStubProject(t.Context(), cli, &github.Issue{NodeID: github.Ptr("N")})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("addToStubProject(%s): got error %v, want error %v", tc.config, err, tc.wantErr)
+		}
+		if got := strings.Join(calls, ","); got != tc.calls {
+			t.Errorf("addToStubProject(%s): got calls %q, want %q", tc.config, got, tc.calls)
+		}
+		if strings.HasSuffix(tc.calls, "set") && option != "O2" {
+			t.Errorf("addToStubProject(%s): set option %v, want O2", tc.config, option)
+		}
+	}
+
+	if _, err := parseRepoConfig([]byte(`stubProject: {owner: o}`)); err == nil {
+		t.Error("parseRepoConfig: got nil error for stubProject without number")
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// The event queue is a directory containing one file per pull_request webhook
+// delivery whose check is pending, named for its delivery ID and holding its
+// payload. A file is written when the webhook arrives, and removed once its
+// check completes without error, or is debounced (the check that debounced it
+// covers the PR). A check that is rescheduled or deferred takes its file
+// along, and removes it once it completes. A check that fails leaves its file
+// for a redelivery, which replaces it, or for the next startup. So any files
+// present at startup denote checks that were interrupted or failed. Those are
+// checked again, and removed once that check completes, even if it fails, so
+// that an event that can never be checked is not replayed forever.
+
+// queuePath returns the path of the queue file for the webhook delivery id, or
+// "" if id cannot name one.
+func queuePath(id string) string {
+	if id == "" || strings.ContainsFunc(id, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-')
+	}) {
+		return ""
+	}
+	return filepath.Join(*queueDir, id+".json")
+}
+
+// enqueueEvent durably records payload, the body of the pull_request webhook
+// delivery id, so that its check will be run again after a restart if it does
+// not complete first. It is a no-op if no queue directory is configured.
+func enqueueEvent(id string, payload []byte) error {
+	if *queueDir == "" {
+		return nil
+	}
+	path := queuePath(id)
+	if path == "" {
+		return fmt.Errorf("invalid delivery ID %q", id)
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(payload)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Make the rename itself durable.
+	dir, err := os.Open(*queueDir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// dequeueEvent removes the queue entry for the webhook delivery id, if there
+// is one.
+func dequeueEvent(id string) {
+	path := queuePath(id)
+	if *queueDir == "" || path == "" {
+		return
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("removing queue entry: %v", err)
+	}
+}
+
+// drainQueue re-runs the checks for all events remaining in the queue,
+// removing each once its check completes.
+func drainQueue(ctx context.Context) {
+	if *queueDir == "" {
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(*queueDir, "*.json"))
+	if err != nil {
+		log.Printf("listing event queue: %v", err)
+		return
+	}
+	log.Printf("Draining %d queued events from %q", len(paths), *queueDir)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("reading queued event: %v", err)
+			continue
+		}
+		event, err := github.ParseWebHook("pull_request", data)
+		e, ok := event.(*github.PullRequestEvent)
+		if err != nil || !ok || e.GetPullRequest() == nil || e.GetRepo() == nil {
+			log.Printf("discarding invalid queued event %q: %v", filepath.Base(path), err)
+			os.Remove(path)
+			continue
+		}
+		queueDrained.Add(1)
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		err = checkPullRequest(ctx, e.PullRequest, e.Repo)
+		if settleCheck(e.PullRequest, e.Repo, err, id) {
+			continue // removed when it completes, or left for the next startup
+		} else if err != nil {
+			pullRequest{repo: e.Repo, pr: e.PullRequest}.logf("check failed: %v", err)
+		}
+		dequeueEvent(id)
+	}
+}
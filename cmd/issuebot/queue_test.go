@@ -0,0 +1,123 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestQueue(t *testing.T) {
+	defer func(v string) { *queueDir = v }(*queueDir)
+	*queueDir = t.TempDir()
+	checked := fakeCheckGitHub(t, nil)
+
+	payload := func(n int) []byte {
+		b, err := json.Marshal(github.PullRequestEvent{Action: github.Ptr("opened"), PullRequest: testPR(n), Repo: testRepo})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	entries := func() []string {
+		paths, err := filepath.Glob(filepath.Join(*queueDir, "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, path := range paths {
+			paths[i] = filepath.Base(path)
+		}
+		return paths
+	}
+
+	if err := enqueueEvent("../escape", payload(1)); err == nil {
+		t.Errorf("enqueueEvent(../escape): got nil error")
+	}
+	for i, id := range []string{"a-1", "b-2", "c-3"} {
+		if err := enqueueEvent(id, payload(i+1)); err != nil {
+			t.Fatalf("enqueueEvent(%s): %v", id, err)
+		}
+	}
+	if got, want := entries(), []string{"a-1.json", "b-2.json", "c-3.json"}; !slices.Equal(got, want) {
+		t.Fatalf("after enqueueEvent: got entries %q, want %q", got, want)
+	}
+	dequeueEvent("c-3")
+	dequeueEvent("d-4") // not there
+	if err := os.WriteFile(filepath.Join(*queueDir, "e-5.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// At startup, the checks that remain are run again, and their entries
+	// removed, along with invalid ones.
+	drainQueue(t.Context())
+	if got, want := checked(), []int{1, 2}; !slices.Equal(got, want) {
+		t.Errorf("drainQueue: checked PRs %v, want %v", got, want)
+	}
+	if got := entries(); len(got) != 0 {
+		t.Errorf("after drainQueue: got entries %q, want none", got)
+	}
+}
+
+func TestQueueRecheck(t *testing.T) {
+	defer func(v string) { *queueDir = v }(*queueDir)
+	*queueDir = t.TempDir()
+	var failing atomic.Bool
+	fakeCheckGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "no", http.StatusBadRequest)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(func() {
+		breaker.Lock()
+		clear(breaker.deferred)
+		breaker.Unlock()
+	})
+	queued := func(id string) bool {
+		_, err := os.Stat(queuePath(id))
+		return err == nil
+	}
+	for _, id := range []string{"a-1", "b-2", "c-3"} {
+		if err := enqueueEvent(id, []byte("{}")); err != nil {
+			t.Fatalf("enqueueEvent(%s): %v", id, err)
+		}
+	}
+
+	// A check that fails keeps its entry; one that completes removes it.
+	failing.Store(true)
+	recheck(t.Context(), testPR(1), testRepo, "a-1")
+	if !queued("a-1") {
+		t.Errorf("after a failed recheck: entry a-1 removed, want it kept")
+	}
+	failing.Store(false)
+	undebounce(testPR(1), testRepo)
+	recheck(t.Context(), testPR(1), testRepo, "a-1")
+	if queued("a-1") {
+		t.Errorf("after a recheck: entry a-1 kept, want it removed")
+	}
+
+	// A deferred check replaces the entry of the one it supersedes, unless
+	// it has none.
+	deferCheck(testPR(2), testRepo, "b-2")
+	deferCheck(testPR(2), testRepo, "c-3")
+	deferCheck(testPR(2), testRepo, "")
+	if queued("b-2") || !queued("c-3") {
+		t.Errorf("after deferring checks: entries b-2 %v, c-3 %v; want false, true", queued("b-2"), queued("c-3"))
+	}
+	breaker.Lock()
+	dc := breaker.deferred["o/r#2"]
+	breaker.Unlock()
+	if dc.id != "c-3" {
+		t.Errorf("deferred check: got entry %q, want c-3", dc.id)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const (
+	// maxRateLimitWait is the longest a check will block waiting for a rate
+	// limit to clear. If GitHub asks us to wait longer, the check is
+	// rescheduled instead.
+	maxRateLimitWait = 30 * time.Second
+
+	// defaultSecondaryWait is how long to wait after a secondary rate limit
+	// response that does not include a Retry-After header. GitHub recommends
+	// waiting at least a minute in that case.
+	defaultSecondaryWait = time.Minute
+)
+
+// rateLimitDelay reports whether err indicates that GitHub is rate limiting
+// us, and if so how long we should wait before trying again.
+func rateLimitDelay(err error) (time.Duration, bool) {
+	var abuse *github.AbuseRateLimitError
+	if errors.As(err, &abuse) {
+		if d := abuse.GetRetryAfter(); d > 0 {
+			return d, true
+		}
+		return defaultSecondaryWait, true
+	}
+	var limit *github.RateLimitError
+	if errors.As(err, &limit) {
+		return max(time.Until(limit.Rate.Reset.Time), time.Second), true
+	}
+	return 0, false
+}
+
+// scheduleRecheck arranges for pr to be checked again after d has elapsed,
+// taking over the event queue entry of the webhook delivery id, if any.
+// This is used when GitHub tells us to back off for longer than we are
+// willing to hold up a webhook request.
+func scheduleRecheck(pr *github.PullRequest, repo *github.Repository, d time.Duration, id string) {
+	p := pullRequest{repo: repo, pr: pr}
+	p.logf("rate limited, rescheduling check in %v", d.Round(time.Second))
+	checksRescheduled.Add(1)
+	time.AfterFunc(d, func() { recheck(rootCtx, pr, repo, id) })
+}
+
+// recordRateLimit updates the rate limit gauges from the headers of resp, a
+// response from the GitHub API, if it has them. Limits are kept by resource
+// (e.g., "core", "search", or "graphql"), each of which has its own quota.
+func recordRateLimit(resp *github.Response) {
+	if resp == nil || resp.Rate.Limit == 0 {
+		return
+	}
+	res := resp.Rate.Resource
+	if res == "" {
+		res = "core"
+	}
+	setGauge(rateLimitLimit, res, int64(resp.Rate.Limit))
+	setGauge(rateLimitRemaining, res, int64(resp.Rate.Remaining))
+	setGauge(rateLimitReset, res, resp.Rate.Reset.Unix())
+}
+
+// setGauge sets the value of key in m to v.
+func setGauge(m *expvar.Map, key string, v int64) {
+	g, ok := m.Get(key).(*expvar.Int)
+	if !ok {
+		g = new(expvar.Int)
+		m.Set(key, g)
+	}
+	g.Set(v)
+}
+
+type callCountKey struct{}
+
+// withCallCount returns a context that counts the GitHub API calls made in
+// it, and the count.
+func withCallCount(ctx context.Context) (context.Context, *atomic.Int64) {
+	n := new(atomic.Int64)
+	return context.WithValue(ctx, callCountKey{}, n), n
+}
+
+// countCall counts a GitHub API call made in ctx.
+func countCall(ctx context.Context) {
+	githubCalls.Add(1)
+	if n, ok := ctx.Value(callCountKey{}).(*atomic.Int64); ok {
+		n.Add(1)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestRateLimitGauges(t *testing.T) {
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "30")
+		w.Header().Set("X-RateLimit-Remaining", "27")
+		w.Header().Set("X-RateLimit-Reset", "1700000000")
+		w.Header().Set("X-RateLimit-Resource", "search")
+		fmt.Fprint(w, `{"total_count":0,"items":[]}`)
+	}))
+	ctx, calls := withCallCount(t.Context())
+	for range 2 {
+		if _, _, err := retryCall(ctx, "SearchIssues", func(ctx context.Context) (*github.IssuesSearchResult, *github.Response, error) {
+			return cli.Search.Issues(ctx, "repo:o/r", nil)
+		}); err != nil {
+			t.Fatalf("SearchIssues: %v", err)
+		}
+	}
+	if got := calls.Load(); got != 2 {
+		t.Errorf("calls counted: got %d, want 2", got)
+	}
+	for _, tc := range []struct {
+		m    *expvar.Map
+		want int64
+	}{
+		{rateLimitLimit, 30},
+		{rateLimitRemaining, 27},
+		{rateLimitReset, 1700000000},
+	} {
+		if g, ok := tc.m.Get("search").(*expvar.Int); !ok || g.Value() != tc.want {
+			t.Errorf("%v for search: got %v, want %d", tc.m, tc.m.Get("search"), tc.want)
+		}
+	}
+}
+
+func TestRateLimitDelay(t *testing.T) {
+	reset := time.Now().Add(90 * time.Second).Truncate(time.Second)
+	tests := []struct {
+		name     string
+		status   int
+		header   map[string]string
+		body     string
+		want     time.Duration
+		wantSlop time.Duration // how much less than want is acceptable
+		wantOK   bool
+	}{
+		{
+			name:   "primary",
+			status: http.StatusForbidden,
+			header: map[string]string{
+				"X-RateLimit-Limit":     "5000",
+				"X-RateLimit-Remaining": "0",
+				"X-RateLimit-Reset":     strconv.FormatInt(reset.Unix(), 10),
+			},
+			body:     `{"message": "API rate limit exceeded"}`,
+			want:     time.Until(reset),
+			wantSlop: 5 * time.Second,
+			wantOK:   true,
+		},
+		{
+			name:   "primary, already reset",
+			status: http.StatusForbidden,
+			header: map[string]string{
+				"X-RateLimit-Limit":     "5000",
+				"X-RateLimit-Remaining": "0",
+				"X-RateLimit-Reset":     strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10),
+			},
+			body:   `{"message": "API rate limit exceeded"}`,
+			want:   time.Second,
+			wantOK: true,
+		},
+		{
+			name:   "secondary with Retry-After",
+			status: http.StatusForbidden,
+			header: map[string]string{"Retry-After": "42"},
+			body:   `{"message": "You have exceeded a secondary rate limit", "documentation_url": "https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits"}`,
+			want:   42 * time.Second,
+			wantOK: true,
+		},
+		{
+			name:   "secondary without Retry-After",
+			status: http.StatusForbidden,
+			body:   `{"message": "You have exceeded a secondary rate limit", "documentation_url": "https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits"}`,
+			want:   defaultSecondaryWait,
+			wantOK: true,
+		},
+		{
+			name:   "forbidden",
+			status: http.StatusForbidden,
+			body:   `{"message": "Resource not accessible by integration"}`,
+		},
+		{
+			name:   "server error",
+			status: http.StatusBadGateway,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tc.header {
+					w.Header().Set(k, v)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, tc.body)
+			}))
+			_, _, err := cli.PullRequests.Get(t.Context(), "o", "r", 1)
+			if err == nil {
+				t.Fatal("PullRequests.Get: got nil error")
+			}
+			got, ok := rateLimitDelay(err)
+			if ok != tc.wantOK || got > tc.want || got < tc.want-tc.wantSlop {
+				t.Errorf("rateLimitDelay(%v): got %v, %v; want %v, %v", err, got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+	if _, ok := rateLimitDelay(fmt.Errorf("get PR: %w", &github.AbuseRateLimitError{})); !ok {
+		t.Errorf("rateLimitDelay(wrapped secondary limit): got false, want true")
+	}
+	if _, ok := rateLimitDelay(errors.New("oops")); ok {
+		t.Errorf("rateLimitDelay(oops): got true, want false")
+	}
+}
+
+func TestScheduleRecheck(t *testing.T) {
+	checked := fakeCheckGitHub(t, nil)
+	rescheduled := checksRescheduled.Value()
+	scheduleRecheck(testPR(1), testRepo, 10*time.Millisecond, "")
+	if got := checksRescheduled.Value() - rescheduled; got != 1 {
+		t.Errorf("checks rescheduled: got %d, want 1", got)
+	}
+	if got := checked(); len(got) != 0 {
+		t.Errorf("checked PRs %v at once, want none until the delay passes", got)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if slices.Equal(checked(), []int{1}) {
+			return
+		}
+	}
+	t.Errorf("after the delay: checked PRs %v, want [1]", checked())
+}
+
+func TestRetryCallRateLimited(t *testing.T) {
+	// Waits longer than maxRateLimitWait are left to the caller, to
+	// reschedule the check.
+	calls := 0
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", strconv.Itoa(int(2*maxRateLimitWait/time.Second)))
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"message": "You have exceeded a secondary rate limit", "documentation_url": "https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits"}`)
+	}))
+	_, _, err := retryCall(t.Context(), "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+		return cli.PullRequests.Get(ctx, "o", "r", 1)
+	})
+	if d, ok := rateLimitDelay(err); !ok || d != 2*maxRateLimitWait {
+		t.Errorf("retryCall: got error %v, want a secondary rate limit of %v", err, 2*maxRateLimitWait)
+	}
+	if calls != 1 {
+		t.Errorf("retryCall: got %d calls, want 1", calls)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// listInstallationRepos returns all the repositories the app installation
+// has access to.
+func listInstallationRepos(ctx context.Context) ([]*github.Repository, error) {
+	var repos []*github.Repository
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		rs, resp, err := retryCall(ctx, "ListRepos", func(ctx context.Context) (*github.ListRepositories, *github.Response, error) {
+			return apiClient().Apps.ListRepos(ctx, opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		repos = append(repos, rs.Repositories...)
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// listOpenPulls returns the open pull requests in repo, filtered by the head
+// and base branches given in filter, if any.
+func listOpenPulls(ctx context.Context, repo *github.Repository, filter github.PullRequestListOptions) ([]*github.PullRequest, error) {
+	var pulls []*github.PullRequest
+	opts := &filter
+	opts.State = "open"
+	opts.ListOptions = github.ListOptions{PerPage: 100}
+	for {
+		ps, resp, err := retryCall(ctx, "ListPulls", func(ctx context.Context) ([]*github.PullRequest, *github.Response, error) {
+			return apiClient().PullRequests.List(ctx, repo.GetOwner().GetLogin(), repo.GetName(), opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		pulls = append(pulls, ps...)
+		if resp.NextPage == 0 {
+			return pulls, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// hasCheckStatus reports whether the commit sha in repo has a status (or check
+// run, with --check-runs) posted by this instance of issuebot.
+func hasCheckStatus(ctx context.Context, repo *github.Repository, sha string) (bool, error) {
+	state, err := checkState(ctx, repo, sha)
+	return state != "", err
+}
+
+// checkState returns the state of the latest status posted by this instance
+// of issuebot on the commit sha in repo (or, with --check-runs, the
+// conclusion of its latest check run), or "" if there is none.
+func checkState(ctx context.Context, repo *github.Repository, sha string) (string, error) {
+	if *checkRuns {
+		return checkRunConclusion(ctx, repo, sha)
+	}
+	statuses, _, err := retryCall(ctx, "ListStatuses", func(ctx context.Context) ([]*github.RepoStatus, *github.Response, error) {
+		return apiClient().Repositories.ListStatuses(ctx, repo.GetOwner().GetLogin(), repo.GetName(), sha,
+			&github.ListOptions{PerPage: 100})
+	})
+	if err != nil {
+		return "", err
+	}
+	// Statuses are listed newest first.
+	for _, st := range statuses {
+		if st.GetContext() == *statusContext {
+			return st.GetState(), nil
+		}
+	}
+	return "", nil
+}
+
+// reconcile checks every open pull request in the installation's repositories
+// whose head commit does not already have an issuebot status. This catches
+// PRs whose webhook deliveries were lost.
+func reconcile(ctx context.Context) error {
+	repos, err := listInstallationRepos(ctx)
+	if err != nil {
+		return fmt.Errorf("list repos: %w", err)
+	}
+	installedRepos.Set(int64(len(repos)))
+	nc := reconcileRepos(ctx, repos)
+	log.Printf("Reconcile: checked %d unchecked PRs in %d repos", nc, len(repos))
+	return nil
+}
+
+// reconcileRepos checks every open pull request in the given repositories
+// whose head commit does not already have an issuebot status, and returns
+// how many it checked.
+func reconcileRepos(ctx context.Context, repos []*github.Repository) int {
+	var nc int
+	for _, repo := range repos {
+		if !repoEnabled(repo.GetFullName()) {
+			continue
+		}
+		pulls, err := listOpenPulls(ctx, repo, github.PullRequestListOptions{})
+		if err != nil {
+			log.Printf("reconcile: listing PRs in %s (skipped): %v", repo.GetFullName(), err)
+			continue
+		}
+		for _, pr := range pulls {
+			ok, err := hasCheckStatus(ctx, repo, pr.GetHead().GetSHA())
+			if err != nil {
+				log.Printf("reconcile: statuses for %s#%d (skipped): %v", repo.GetFullName(), pr.GetNumber(), err)
+				continue
+			} else if ok {
+				continue
+			}
+			nc++
+			reconcileChecks.Add(1)
+			recheck(ctx, pr, repo, "")
+		}
+	}
+	return nc
+}
+
+// recheckPushedPulls checks again the open PRs whose head is the branch
+// updated by the push event e, since a push outside the usual flow of a PR
+// (e.g., by another tool) may leave their statuses stale. If the push was
+// forced, the PRs whose base is the branch are checked again too. The checks
+// take a worker from checkPool, like those of other webhooks, and are skipped
+// if the server is overloaded.
+func recheckPushedPulls(ctx context.Context, e *github.PushEvent) {
+	branch, ok := strings.CutPrefix(e.GetRef(), "refs/heads/")
+	if !ok || e.GetDeleted() || !repoEnabled(e.GetRepo().GetFullName()) {
+		return
+	}
+	owner := e.GetRepo().GetOwner().GetLogin()
+	repo := &github.Repository{
+		Owner:    &github.User{Login: github.Ptr(owner)},
+		Name:     github.Ptr(e.GetRepo().GetName()),
+		FullName: github.Ptr(e.GetRepo().GetFullName()),
+	}
+	filters := []github.PullRequestListOptions{{Head: owner + ":" + branch}}
+	if e.GetForced() {
+		filters = append(filters, github.PullRequestListOptions{Base: branch})
+	}
+	if err := checkPool().acquire(ctx); err != nil {
+		ctxLogf(ctx, "push to %s %s: not checking PRs again: %v", repo.GetFullName(), branch, err)
+		return
+	}
+	defer checkPool().release()
+	var nc int
+	for _, f := range filters {
+		pulls, err := listOpenPulls(ctx, repo, f)
+		if err != nil {
+			ctxLogf(ctx, "push to %s %s: listing PRs (skipped): %v", repo.GetFullName(), branch, err)
+			continue
+		}
+		for _, pr := range pulls {
+			nc++
+			recheck(ctx, pr, cmp.Or(pr.GetBase().GetRepo(), repo), "")
+		}
+	}
+	if nc != 0 {
+		ctxLogf(ctx, "push to %s %s: checked %d PRs again", repo.GetFullName(), branch, nc)
+	}
+}
+
+// runReconcile calls reconcile immediately if atStartup is true, and then
+// periodically at the given interval if it is positive.
+func runReconcile(atStartup bool, interval time.Duration) {
+	if atStartup {
+		log.Print("Scanning for unchecked open PRs")
+		if err := reconcile(rootCtx); err != nil {
+			log.Printf("Startup scan failed: %v", err)
+		}
+	}
+	if interval <= 0 {
+		return
+	}
+	for {
+		select {
+		case <-rootCtx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if err := reconcile(rootCtx); err != nil {
+			log.Printf("Reconcile failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// fakePulls serves the open PRs of testRepo, and of an installation that has
+// only it, for the reconciler. PRs with their number in statuses have those
+// statuses on their head commit, newest first.
+type fakePulls struct {
+	t        *testing.T
+	pulls    []*github.PullRequest
+	statuses map[int][]*github.RepoStatus
+	queries  []string // the queries listing PRs
+}
+
+func (f *fakePulls) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/installation/repositories":
+		json.NewEncoder(w).Encode(github.ListRepositories{TotalCount: github.Ptr(1), Repositories: []*github.Repository{testRepo}})
+	case r.URL.Path == "/repos/o/r/pulls":
+		if r.FormValue("state") != "open" {
+			f.t.Errorf("listing PRs in state %q, want open", r.FormValue("state"))
+		}
+		f.queries = append(f.queries, r.URL.RawQuery)
+		json.NewEncoder(w).Encode(f.pulls)
+	case strings.HasPrefix(r.URL.Path, "/repos/o/r/commits/") && strings.HasSuffix(r.URL.Path, "/statuses"):
+		sha := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/o/r/commits/"), "/statuses")
+		for _, pr := range f.pulls {
+			if pr.GetHead().GetSHA() == sha {
+				json.NewEncoder(w).Encode(f.statuses[pr.GetNumber()])
+				return
+			}
+		}
+		w.Write([]byte(`[]`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	status := func(context, state string) *github.RepoStatus {
+		return &github.RepoStatus{Context: github.Ptr(context), State: github.Ptr(state)}
+	}
+	f := &fakePulls{
+		t:     t,
+		pulls: []*github.PullRequest{testPR(1), testPR(2), testPR(3), testPR(4)},
+		statuses: map[int][]*github.RepoStatus{
+			2: {status(*statusContext, "success")},
+			3: {status("ci/build", "success")}, // not ours
+			4: {status("ci/build", "failure"), status(*statusContext, "failure")},
+		},
+	}
+	checked := fakeCheckGitHub(t, f)
+
+	before := reconcileChecks.Value()
+	if err := reconcile(t.Context()); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got, want := checked(), []int{1, 3}; !slices.Equal(got, want) {
+		t.Errorf("reconcile: checked PRs %v, want %v", got, want)
+	}
+	if got := reconcileChecks.Value() - before; got != 2 {
+		t.Errorf("reconcile checks: got %d, want 2", got)
+	}
+	if got := installedRepos.Value(); got != 1 {
+		t.Errorf("installed repos: got %d, want 1", got)
+	}
+
+	// Repositories that are not enabled are left alone.
+	defer func(v string) { *denyRepos = v }(*denyRepos)
+	*denyRepos = "o/*"
+	if err := reconcile(t.Context()); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	if got, want := checked(), []int{1, 3}; !slices.Equal(got, want) {
+		t.Errorf("reconcile with o/r denied: checked PRs %v, want %v", got, want)
+	}
+}
+
+func TestRecheckPushedPulls(t *testing.T) {
+	f := &fakePulls{t: t, pulls: []*github.PullRequest{testPR(1)}}
+	checked := fakeCheckGitHub(t, f)
+	push := func(ref string, forced, deleted bool) *github.PushEvent {
+		return &github.PushEvent{
+			Ref:     github.Ptr(ref),
+			Forced:  github.Ptr(forced),
+			Deleted: github.Ptr(deleted),
+			Repo: &github.PushEventRepository{
+				Name:     github.Ptr("r"),
+				FullName: github.Ptr("o/r"),
+				Owner:    &github.User{Login: github.Ptr("o")},
+			},
+		}
+	}
+
+	recheckPushedPulls(t.Context(), push("refs/heads/pr1", false, false))
+	if got, want := checked(), []int{1}; !slices.Equal(got, want) {
+		t.Errorf("after a push: checked PRs %v, want %v", got, want)
+	}
+	if want := []string{"head=o%3Apr1&per_page=100&state=open"}; !slices.Equal(f.queries, want) {
+		t.Errorf("after a push: listed PRs with %q, want %q", f.queries, want)
+	}
+
+	// A forced push also checks the PRs into the branch again.
+	f.queries = nil
+	undebounce(testPR(1), testRepo)
+	recheckPushedPulls(t.Context(), push("refs/heads/main", true, false))
+	if want := []string{"head=o%3Amain&per_page=100&state=open", "base=main&per_page=100&state=open"}; !slices.Equal(f.queries, want) {
+		t.Errorf("after a forced push: listed PRs with %q, want %q", f.queries, want)
+	}
+
+	// Tags and deleted branches have no PRs.
+	f.queries = nil
+	recheckPushedPulls(t.Context(), push("refs/tags/v1", false, false))
+	recheckPushedPulls(t.Context(), push("refs/heads/pr1", false, true))
+	if len(f.queries) != 0 {
+		t.Errorf("after pushing a tag and deleting a branch: listed PRs with %q, want none", f.queries)
+	}
+}
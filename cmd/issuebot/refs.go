@@ -0,0 +1,247 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// An issueRef is a reference to a GitHub issue (or pull request) found in a
+// commit message.
+type issueRef struct {
+	Repo   string // owner/repo, or "" for the repository containing the commit
+	Number int
+}
+
+func (r issueRef) String() string {
+	return fmt.Sprintf("%s#%d", r.Repo, r.Number)
+}
+
+// resolve returns r with an empty Repo replaced by repo, the full name of the
+// repository in which the reference appears.
+func (r issueRef) resolve(repo string) issueRef {
+	if r.Repo == "" {
+		r.Repo = repo
+	}
+	return r
+}
+
+var (
+	// issueURLRE matches links to GitHub issues and pull requests.
+	issueURLRE = regexp.MustCompile(`https?://github\.com/([\w.-]+/[\w.-]+)/(?:issues|pull)/(\d+)\b`)
+
+	// shortRefRE matches GitHub short references, "#123" and
+	// "owner/repo#123", that are not part of a longer word or path.
+	shortRefRE = regexp.MustCompile(`(?:^|[^\w./-])((?:[\w.-]+/[\w.-]+)?)#(\d+)\b`)
+)
+
+// parseIssueRefs returns the issue references in text, in the order they
+// appear. References may be written as "#123", "owner/repo#123", or as the
+// URL of the issue or pull request on GitHub.
+func parseIssueRefs(text string) []issueRef {
+	type match struct {
+		pos int
+		ref issueRef
+	}
+	var ms []match
+	for _, m := range issueURLRE.FindAllStringSubmatchIndex(text, -1) {
+		n, _ := strconv.Atoi(text[m[4]:m[5]])
+		ms = append(ms, match{m[0], issueRef{Repo: text[m[2]:m[3]], Number: n}})
+	}
+	for _, m := range shortRefRE.FindAllStringSubmatchIndex(text, -1) {
+		n, _ := strconv.Atoi(text[m[4]:m[5]])
+		ms = append(ms, match{m[2], issueRef{Repo: text[m[2]:m[3]], Number: n}})
+	}
+	slices.SortFunc(ms, func(a, b match) int { return a.pos - b.pos })
+	refs := make([]issueRef, len(ms))
+	for i, m := range ms {
+		refs[i] = m.ref
+	}
+	return refs
+}
+
+// linkSpans returns the parts of message in which issue references count as
+// links. Normally these are the lines beginning with one of the repository's
+// link verbs. If the repository allows lenient links, they are instead the
+// references that immediately follow a link verb anywhere in a line. In
+// either case, the values of the repository's link trailers are included.
+func (p pullRequest) linkSpans(message string) []string {
+	var spans []string
+	for line := range strings.SplitSeq(message, "\n") {
+		if p.cfg != nil && p.cfg.lenientRE != nil {
+			for _, m := range p.cfg.lenientRE.FindAllStringSubmatch(line, -1) {
+				spans = append(spans, m[1])
+			}
+		} else if p.hasLinkVerb(line) {
+			spans = append(spans, line)
+		}
+	}
+	if p.cfg != nil && len(p.cfg.LinkTrailers) != 0 {
+		for _, t := range parseTrailers(message) {
+			if p.cfg.isLinkTrailer(t.key) {
+				spans = append(spans, t.value)
+			}
+		}
+	}
+	return spans
+}
+
+// linkedRefs returns the GitHub issue references in message that count as
+// links (see linkSpans), without duplicates. References to repositories not
+// permitted by the repository's linkURLs or linkRepos settings are omitted.
+func (p pullRequest) linkedRefs(message string) []issueRef {
+	var refs []issueRef
+	for _, span := range p.linkSpans(message) {
+		for _, r := range parseIssueRefs(span) {
+			if slices.Contains(refs, r) {
+				continue
+			}
+			if !p.cfg.allowedRepo(r.resolve(p.repo.GetFullName()).Repo, p.repo.GetFullName()) {
+				p.logf("link %v: repository not allowed", r)
+				continue
+			}
+			refs = append(refs, r)
+		}
+	}
+	return refs
+}
+
+// urlRE matches http and https URLs.
+var urlRE = regexp.MustCompile(`https?://[^\s<>()"']+[^\s<>()"'.,;:!?]`)
+
+// linkedURLs returns the URLs in message that count as links (see
+// linkSpans), other than those of GitHub, and that are permitted by the
+// repository's linkURLs setting.
+func (p pullRequest) linkedURLs(message string) []string {
+	if p.cfg == nil || len(p.cfg.LinkURLs) == 0 {
+		return nil
+	}
+	var urls []string
+	for _, span := range p.linkSpans(message) {
+		for _, u := range urlRE.FindAllString(span, -1) {
+			_, rest, _ := strings.Cut(u, "://")
+			if strings.HasPrefix(strings.ToLower(rest), "github.com/") {
+				continue // handled by linkedRefs
+			}
+			if p.cfg.allowedURL(rest) && !slices.Contains(urls, u) {
+				urls = append(urls, u)
+			}
+		}
+	}
+	return urls
+}
+
+// A trailer is a "Key: value" line at the end of a commit message, as
+// described in git-interpret-trailers(1).
+type trailer struct {
+	key, value string
+}
+
+// trailerRE matches the first line of a trailer.
+var trailerRE = regexp.MustCompile(`^([A-Za-z0-9][A-Za-z0-9-]*)\s*:\s*(.*)$`)
+
+// parseTrailers returns the trailers of message. The trailers are the last
+// paragraph of the message, provided that it is not the only paragraph and
+// that each of its lines is either a trailer or the continuation of one
+// (indented by whitespace).
+func parseTrailers(message string) []trailer {
+	paras := strings.Split(strings.TrimSpace(strings.ReplaceAll(message, "\r\n", "\n")), "\n\n")
+	if len(paras) < 2 {
+		return nil
+	}
+	var ts []trailer
+	for line := range strings.SplitSeq(paras[len(paras)-1], "\n") {
+		if m := trailerRE.FindStringSubmatch(line); m != nil {
+			ts = append(ts, trailer{m[1], strings.TrimSpace(m[2])})
+		} else if len(ts) != 0 && strings.TrimLeft(line, " \t") != line {
+			ts[len(ts)-1].value += " " + strings.TrimSpace(line)
+		} else {
+			return nil
+		}
+	}
+	return ts
+}
+
+// hasLinkVerb reports whether line begins with one of the repository's link
+// verbs.
+func (p pullRequest) hasLinkVerb(line string) bool {
+	lower := strings.ToLower(line)
+	for _, verb := range p.cfg.linkVerbs() {
+		if strings.HasPrefix(lower, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLink reports whether the GitHub issue r meets the repository's link
+// checks (see linkChecks); if not, the error wraps errInvalidRef. A reference
+// to an issue in another repository that cannot be fetched, typically because
+// the app is not installed there, is given the benefit of the doubt only if
+// linkRepos names that repository; otherwise it does not count.
+func (p pullRequest) checkLink(ctx context.Context, cli *github.Client, r issueRef) error {
+	checks := p.cfg.linkChecks()
+	self := issueRef{p.repo.GetFullName(), p.pr.GetNumber()}
+	r = r.resolve(self.Repo)
+	if checks.NotSelf && strings.EqualFold(r.Repo, self.Repo) && r.Number == self.Number {
+		return fmt.Errorf("%w: refers to the PR itself", errInvalidRef)
+	}
+	if !checks.RequireOpen && !checks.IssuesOnly {
+		return nil
+	}
+	owner, name, _ := strings.Cut(r.Repo, "/")
+	issue, resp, err := retryCall(ctx, "GetIssue", func(ctx context.Context) (*github.Issue, *github.Response, error) {
+		return cli.Issues.Get(ctx, owner, name, r.Number)
+	})
+	if resp != nil && (resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusGone) {
+		if strings.EqualFold(r.Repo, self.Repo) {
+			return fmt.Errorf("%w: no such issue", errInvalidRef)
+		} else if len(p.cfg.LinkRepos) == 0 || !p.cfg.allowedRepo(r.Repo, self.Repo) {
+			return fmt.Errorf("%w: cannot verify: %v", errInvalidRef, err)
+		}
+		p.logf("link %v: cannot verify (accepted, in linkRepos): %v", r, err)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("get issue %v: %w", r, err)
+	}
+	if checks.IssuesOnly && issue.IsPullRequest() {
+		return fmt.Errorf("%w: is a pull request", errInvalidRef)
+	}
+	if checks.RequireOpen && issue.GetState() != "open" {
+		return fmt.Errorf("%w: is %s", errInvalidRef, issue.GetState())
+	}
+	return nil
+}
+
+// checkLinkedIssues reports whether any of refs, which GitHub records as
+// linked to the pull request, is in an allowed repository (see allowedRepo) and
+// meets the repository's requirements. An accepted reference is logged with
+// the given description.
+func (p pullRequest) checkLinkedIssues(ctx context.Context, cli *github.Client, what string, refs []issueRef) (bool, error) {
+	for _, r := range refs {
+		if !p.cfg.allowedRepo(r.Repo, p.repo.GetFullName()) {
+			p.logf("linked issue %v: repository not allowed", r)
+			continue
+		}
+		if err := p.checkLink(ctx, cli, r); errors.Is(err, errInvalidRef) {
+			p.logf("linked issue %v: %v", r, err)
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		p.logf("accept: %s %v", what, r)
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestParseIssueRefs(t *testing.T) {
+	tests := []struct {
+		text string
+		want []issueRef
+	}{
+		{"Updates #123", []issueRef{{"", 123}}},
+		{"Updates tailscale/corp#1234", []issueRef{{"tailscale/corp", 1234}}},
+		{"Fixes #1, #2 and other/repo#3", []issueRef{{"", 1}, {"", 2}, {"other/repo", 3}}},
+		{"Updates https://github.com/tailscale/tailscale/issues/42", []issueRef{{"tailscale/tailscale", 42}}},
+		{"See https://github.com/o/r/pull/7#issuecomment-1", []issueRef{{"o/r", 7}}},
+		{"Updates #nothing-whatsoever", nil},
+		{"Updates github.com to be more fabulous", nil},
+		{"Fixes #12abc", nil},
+		{"Updates foo#12", nil},
+		{"Updates a/b/c#12", nil},
+	}
+	for _, tc := range tests {
+		if got := parseIssueRefs(tc.text); !slices.Equal(got, tc.want) {
+			t.Errorf("parseIssueRefs(%q): got %v, want %v", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestLinkedRefs(t *testing.T) {
+	p := pullRequest{}
+	msg := "Mention #1 in passing\n\nUpdates tailscale/corp#2\nFixes #3"
+	want := []issueRef{{"tailscale/corp", 2}, {"", 3}}
+	if got := p.linkedRefs(msg); !slices.Equal(got, want) {
+		t.Errorf("linkedRefs(%q): got %v, want %v", msg, got, want)
+	}
+	if got, want := (issueRef{"", 3}).resolve("o/r").String(), "o/r#3"; got != want {
+		t.Errorf("resolve: got %q, want %q", got, want)
+	}
+}
+
+func TestTrailers(t *testing.T) {
+	msg := "Subject\n\nBody text: not a trailer.\n\nIssue: #12\nBug: tailscale/corp#456,\n  tailscale/corp#457\nSigned-off-by: A <a@example.com>\n"
+	want := []trailer{
+		{"Issue", "#12"},
+		{"Bug", "tailscale/corp#456, tailscale/corp#457"},
+		{"Signed-off-by", "A <a@example.com>"},
+	}
+	if got := parseTrailers(msg); !slices.Equal(got, want) {
+		t.Errorf("parseTrailers: got %q, want %q", got, want)
+	}
+	for _, bad := range []string{
+		"Issue: #12",                       // only paragraph
+		"Subject\n\nIssue: #12\nand prose", // not all trailers
+	} {
+		if got := parseTrailers(bad); got != nil {
+			t.Errorf("parseTrailers(%q): got %q, want none", bad, got)
+		}
+	}
+
+	cfg, err := parseRepoConfig([]byte(`linkTrailers: [issue, bug]`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	p := pullRequest{cfg: cfg}
+	wantRefs := []issueRef{{"", 12}, {"tailscale/corp", 456}, {"tailscale/corp", 457}}
+	if got := p.linkedRefs(msg); !slices.Equal(got, wantRefs) {
+		t.Errorf("linkedRefs: got %v, want %v", got, wantRefs)
+	}
+	if got := p.checkCommitMessage(msg); got != prLinked {
+		t.Errorf("checkCommitMessage: got %v, want %v", got, prLinked)
+	}
+	if got := (pullRequest{}).checkCommitMessage(msg); got != prFailed {
+		t.Errorf("checkCommitMessage without linkTrailers: got %v, want %v", got, prFailed)
+	}
+}
+
+func TestCheckLink(t *testing.T) {
+	mux := http.NewServeMux()
+	for path, body := range map[string]string{
+		"/repos/o/r/issues/1": `{"number": 1, "state": "open"}`,
+		"/repos/o/r/issues/2": `{"number": 2, "state": "closed"}`,
+		"/repos/o/r/issues/3": `{"number": 3, "state": "open", "pull_request": {"url": "x"}}`,
+	} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, body) })
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) })
+	cli := newFakeGitHub(t, mux)
+
+	cfg, err := parseRepoConfig([]byte(`linkChecks: {requireOpen: true, issuesOnly: true, notSelf: true}`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	p := pullRequest{
+		repo: &github.Repository{FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(10)},
+		cfg:  cfg,
+	}
+	tests := []struct {
+		ref  issueRef
+		want bool
+	}{
+		{issueRef{"", 1}, true},
+		{issueRef{"", 2}, false},            // closed
+		{issueRef{"", 3}, false},            // pull request
+		{issueRef{"", 10}, false},           // the PR itself
+		{issueRef{"", 99}, false},           // does not exist
+		{issueRef{"other/repo", 5}, false},  // cannot verify
+		{issueRef{"nosuch/repo", 1}, false}, // cannot verify
+		{issueRef{"o/r", 1}, true},          // explicit repo
+	}
+	for _, tc := range tests {
+		err := p.checkLink(context.Background(), cli, tc.ref)
+		if err != nil && !errors.Is(err, errInvalidRef) {
+			t.Errorf("checkLink(%v): unexpected error: %v", tc.ref, err)
+		} else if got := err == nil; got != tc.want {
+			t.Errorf("checkLink(%v): got valid %v (%v), want %v", tc.ref, got, err, tc.want)
+		}
+	}
+
+	// References that cannot be verified count only in repositories that
+	// linkRepos names.
+	lp := p
+	if lp.cfg, err = parseRepoConfig([]byte(`{linkChecks: {requireOpen: true}, linkRepos: [o/r, other/*]}`)); err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	for ref, want := range map[issueRef]bool{
+		{"other/repo", 5}:  true,
+		{"nosuch/repo", 1}: false,
+	} {
+		if err := lp.checkLink(context.Background(), cli, ref); (err == nil) != want {
+			t.Errorf("checkLink(%v) with linkRepos: got %v, want valid %v", ref, err, want)
+		}
+	}
+
+	// A commit is linked if any of its references is valid.
+	for msg, want := range map[string]bool{
+		"x\nFixes #2, #1": true,
+		"x\nFixes #2, #3": false,
+	} {
+		got, err := p.verifyLinks(context.Background(), cli, msg)
+		if err != nil {
+			t.Errorf("verifyLinks(%q): unexpected error: %v", msg, err)
+		} else if got != want {
+			t.Errorf("verifyLinks(%q): got %v, want %v", msg, got, want)
+		}
+	}
+}
+
+func TestLinkPatterns(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte(`linkPatterns: ['\bb/\d+\b', 'TICKET=[a-z]+-\d+']`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	tests := []struct {
+		commit string
+		result pullRequestStatus
+	}{
+		{"x\nSee b/12345 for details", prLinked},
+		{"x\n\nTICKET=abc-99", prLinked},
+		{"x\nAdd lib/123", prFailed},
+		{"x\nTICKET=ABC-99", prFailed},
+	}
+	for _, tc := range tests {
+		if got := (pullRequest{cfg: cfg}).checkCommitMessage(tc.commit); got != tc.result {
+			t.Errorf("checkCommitMessage(%q): got %v, want %v", tc.commit, got, tc.result)
+		}
+	}
+	for _, bad := range []string{`linkPatterns: ['(']`, `linkPatterns: ['x*']`} {
+		if _, err := parseRepoConfig([]byte(bad)); err == nil {
+			t.Errorf("parseRepoConfig(%q): got nil error", bad)
+		}
+	}
+}
+
+func TestLinkURLs(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte(`linkURLs: ["github.com/tailscale/*", "tracker.example.com/issues/**"]`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	tests := []struct {
+		commit string
+		result pullRequestStatus
+	}{
+		{"x\nUpdates https://github.com/tailscale/corp/issues/1", prLinked},
+		{"x\nUpdates https://github.com/Tailscale/Corp/issues/1", prLinked},
+		{"x\nUpdates tailscale/corp#1", prLinked},
+		{"x\nUpdates #1", prLinked}, // same repository
+		{"x\nUpdates https://tracker.example.com/issues/T123.", prLinked},
+		{"x\nUpdates https://github.com/other/repo/issues/1", prFailed},
+		{"x\nUpdates other/repo#1", prFailed},
+		{"x\nUpdates https://tracker.example.com/wiki/Home", prFailed},
+		{"x\nUpdates https://example.org/issues/1", prFailed},
+		{"x\nUpdates github.com to be more fabulous", prFailed},
+	}
+	for _, tc := range tests {
+		if got := (pullRequest{cfg: cfg}).checkCommitMessage(tc.commit); got != tc.result {
+			t.Errorf("checkCommitMessage(%q): got %v, want %v", tc.commit, got, tc.result)
+		}
+	}
+}
+
+func TestLinkRepos(t *testing.T) {
+	cfg, err := parseRepoConfig([]byte(`linkRepos: ["tailscale/corp", "tailscale/*-private"]`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	p := pullRequest{
+		repo: &github.Repository{FullName: github.Ptr("tailscale/tailscale")},
+		pr:   &github.PullRequest{Number: github.Ptr(10)},
+		cfg:  cfg,
+	}
+	tests := []struct {
+		commit string
+		result pullRequestStatus
+	}{
+		{"x\nUpdates tailscale/corp#1", prLinked},
+		{"x\nUpdates https://github.com/Tailscale/Corp/issues/1", prLinked},
+		{"x\nUpdates tailscale/ops-private#1", prLinked},
+		{"x\nUpdates #1", prFailed}, // the repository itself
+		{"x\nUpdates tailscale/tailscale#1", prFailed},
+		{"x\nUpdates other/corp#1", prFailed},
+		{"x\nUpdates #nothing-whatsoever", prFailed},
+	}
+	for _, tc := range tests {
+		if got := p.checkCommitMessage(tc.commit); got != tc.result {
+			t.Errorf("checkCommitMessage(%q): got %v, want %v", tc.commit, got, tc.result)
+		}
+	}
+
+	if _, err := parseRepoConfig([]byte(`linkRepos: [corp]`)); err == nil {
+		t.Error("parseRepoConfig(linkRepos: [corp]): got nil error")
+	}
+}
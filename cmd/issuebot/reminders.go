@@ -0,0 +1,263 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// listOpenStubs returns the open stub issues in repo that have not been
+// updated since before, least recently updated first.
+func listOpenStubs(ctx context.Context, cli *github.Client, repo *github.Repository, before time.Time) ([]*github.Issue, error) {
+	var stubs []*github.Issue
+	opts := &github.IssueListByRepoOptions{
+		Labels:      []string{issuebotStubLabel},
+		State:       "open",
+		Sort:        "updated",
+		Direction:   "asc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		issues, resp, err := retryCall(ctx, "ListByRepo", func(ctx context.Context) ([]*github.Issue, *github.Response, error) {
+			return cli.Issues.ListByRepo(ctx, repo.GetOwner().GetLogin(), repo.GetName(), opts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range issues {
+			if !issue.GetUpdatedAt().Before(before) {
+				return stubs, nil
+			}
+			if !issue.IsPullRequest() {
+				stubs = append(stubs, issue)
+			}
+		}
+		if resp.NextPage == 0 {
+			return stubs, nil
+		}
+		opts.ListOptions.Page = resp.NextPage
+	}
+}
+
+// remindStubs comments on the stub issues in repo that are still placeholders
+// and have not been touched for the repository's stubReminderAfter period,
+// asking their assignees to fill them in. Since the comment counts as a
+// touch, a stub is reminded about at most once per period.
+func remindStubs(ctx context.Context, cli *github.Client, repo *github.Repository, cfg *repoConfig) (int, error) {
+	after := cfg.stubReminderAfter()
+	if after <= 0 {
+		return 0, nil
+	}
+	stubs, err := listOpenStubs(ctx, cli, repo, time.Now().Add(-after))
+	if err != nil {
+		return 0, fmt.Errorf("list stubs: %w", err)
+	}
+	var n int
+	for _, issue := range stubs {
+		prNum := parseStubBody(issue.GetBody()).pr
+		if prNum == 0 {
+			continue // filed before stubs were marked with their PR
+		}
+		pr, _, err := retryCall(ctx, "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+			return cli.PullRequests.Get(ctx, repo.GetOwner().GetLogin(), repo.GetName(), prNum)
+		})
+		if err != nil {
+			return n, fmt.Errorf("get PR #%d: %w", prNum, err)
+		}
+		p := pullRequest{repo: repo, pr: pr, cfg: cfg}
+		if ok, err := p.isPlaceholder(issue); err != nil {
+			return n, err
+		} else if !ok {
+			continue
+		}
+
+		data := p.data()
+		data.Issue, data.Ref = issue.GetNumber(), fmt.Sprintf("#%d", issue.GetNumber())
+		comment, err := p.render(stubReminderTemplate, data)
+		if err != nil {
+			return n, err
+		}
+		if *shadowMode {
+			p.logf("shadow: would remind about stub issue #%d", issue.GetNumber())
+			continue
+		}
+		if _, _, err := createCall(ctx, "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+			return cli.Issues.CreateComment(ctx, repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber(), &github.IssueComment{
+				Body: github.Ptr(comment),
+			})
+		}, findComment(cli, repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber(), comment)); err != nil {
+			return n, fmt.Errorf("comment on stub issue #%d: %w", issue.GetNumber(), err)
+		}
+		p.logf("reminded about stub issue #%d", issue.GetNumber())
+		stubReminders.Add(1)
+		n++
+	}
+	return n, nil
+}
+
+// escalationMarker is added to escalation comments on stub issues, so that
+// each stub is escalated only once.
+const escalationMarker = "<!-- issuebot:escalated -->"
+
+// escalateStubs escalates the stub issues in repo that are still
+// placeholders, as the repository's stubEscalation setting says, once they
+// are old enough.
+func escalateStubs(ctx context.Context, cli *github.Client, repo *github.Repository, cfg *repoConfig) (int, error) {
+	esc := cfg.StubEscalation
+	if esc == nil {
+		return 0, nil
+	}
+	owner, repoName := repo.GetOwner().GetLogin(), repo.GetName()
+	stubs, err := listOpenStubs(ctx, cli, repo, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("list stubs: %w", err)
+	}
+	var n int
+	for _, issue := range stubs {
+		prNum := parseStubBody(issue.GetBody()).pr
+		if prNum == 0 || time.Since(issue.GetCreatedAt().Time) < time.Duration(esc.After) {
+			continue
+		}
+		pr, _, err := retryCall(ctx, "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+			return cli.PullRequests.Get(ctx, owner, repoName, prNum)
+		})
+		if err != nil {
+			return n, fmt.Errorf("get PR #%d: %w", prNum, err)
+		}
+		p := pullRequest{repo: repo, pr: pr, cfg: cfg}
+		if ok, err := p.isPlaceholder(issue); err != nil {
+			return n, err
+		} else if !ok {
+			continue
+		}
+		comments, _, err := retryCall(ctx, "ListComments", func(ctx context.Context) ([]*github.IssueComment, *github.Response, error) {
+			return cli.Issues.ListComments(ctx, owner, repoName, issue.GetNumber(), &github.IssueListCommentsOptions{
+				ListOptions: github.ListOptions{PerPage: 100},
+			})
+		})
+		if err != nil {
+			return n, fmt.Errorf("list comments: %w", err)
+		}
+		if slices.ContainsFunc(comments, func(c *github.IssueComment) bool {
+			return strings.Contains(c.GetBody(), escalationMarker)
+		}) {
+			continue // already escalated
+		}
+		if err := p.escalateStub(ctx, cli, issue, esc); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// escalateStub carries out the escalation esc for the stub issue filed for
+// the PR.
+func (p pullRequest) escalateStub(ctx context.Context, cli *github.Client, issue *github.Issue, esc *stubEscalation) error {
+	owner, repoName, num := p.repo.GetOwner().GetLogin(), p.repo.GetName(), issue.GetNumber()
+	data := p.data()
+	data.Issue, data.Ref = num, fmt.Sprintf("#%d", num)
+	comment, err := p.render(stubEscalationTemplate, data)
+	if err != nil {
+		return err
+	}
+	if len(esc.CC) != 0 {
+		comment += "\n\ncc " + strings.Join(esc.CC, " ")
+	}
+	comment += "\n\n" + escalationMarker
+	var prComment string
+	if esc.CommentOnPR {
+		if prComment, err = p.render(prEscalationTemplate, data); err != nil {
+			return err
+		}
+	}
+	if *shadowMode {
+		p.logf("shadow: would escalate stub issue #%d", num)
+		return nil
+	}
+
+	if esc.Label != "" {
+		if _, _, err := retryCall(ctx, "AddLabelsToIssue", func(ctx context.Context) ([]*github.Label, *github.Response, error) {
+			return cli.Issues.AddLabelsToIssue(ctx, owner, repoName, num, []string{esc.Label})
+		}); err != nil {
+			return fmt.Errorf("label stub issue #%d: %w", num, err)
+		}
+	}
+	if prComment != "" {
+		if _, _, err := createCall(ctx, "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+			return cli.Issues.CreateComment(ctx, owner, repoName, p.pr.GetNumber(), &github.IssueComment{Body: github.Ptr(prComment)})
+		}, findComment(cli, owner, repoName, p.pr.GetNumber(), prComment)); err != nil {
+			return fmt.Errorf("comment on PR: %w", err)
+		}
+	}
+	// Comment on the stub last, since the marker records that the
+	// escalation is done.
+	if _, _, err := createCall(ctx, "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+		return cli.Issues.CreateComment(ctx, owner, repoName, num, &github.IssueComment{Body: github.Ptr(comment)})
+	}, findComment(cli, owner, repoName, num, comment)); err != nil {
+		return fmt.Errorf("comment on stub issue #%d: %w", num, err)
+	}
+	p.logf("escalated stub issue #%d", num)
+	stubEscalations.Add(1)
+	return nil
+}
+
+// sendStubReminders runs remindStubs and escalateStubs for every enabled repository of the
+// installation that files stub issues in GitHub.
+func sendStubReminders(ctx context.Context) error {
+	repos, err := listInstallationRepos(ctx)
+	if err != nil {
+		return fmt.Errorf("list repos: %w", err)
+	}
+	var total, escalated int
+	for _, repo := range repos {
+		if !repoEnabled(repo.GetFullName()) {
+			continue
+		}
+		cli := apiClient()
+		cfg, err := loadRepoConfig(ctx, cli, repo)
+		if err != nil {
+			log.Printf("reminders: config for %s (skipped): %v", repo.GetFullName(), err)
+			continue
+		}
+		if !cfg.stubIssues() || cfg.stubTracker() != trackerGitHub {
+			continue
+		}
+		n, err := remindStubs(ctx, cli, repo, cfg)
+		total += n
+		if err != nil {
+			log.Printf("reminders: %s (skipped): %v", repo.GetFullName(), err)
+		}
+		n, err = escalateStubs(ctx, cli, repo, cfg)
+		escalated += n
+		if err != nil {
+			log.Printf("escalations: %s (skipped): %v", repo.GetFullName(), err)
+		}
+	}
+	log.Printf("Reminders: sent %d stub reminders and %d escalations in %d repos", total, escalated, len(repos))
+	return nil
+}
+
+// runStubReminders calls sendStubReminders periodically at the given
+// interval.
+func runStubReminders(interval time.Duration) {
+	for {
+		select {
+		case <-rootCtx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if err := sendStubReminders(rootCtx); err != nil {
+			log.Printf("Stub reminders failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestRemindStubs(t *testing.T) {
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	stub := func(num, pr int, body string, updated time.Time) *github.Issue {
+		return &github.Issue{
+			Number:    github.Ptr(num),
+			Title:     github.Ptr(fmt.Sprintf("Placeholder issue for PR #%d", pr)),
+			Body:      github.Ptr(body + stubBodyMarker(pr, body)),
+			UpdatedAt: &github.Timestamp{Time: updated},
+		}
+	}
+	edited := func(issue *github.Issue, text string) *github.Issue {
+		issue.Body = github.Ptr(text + strings.TrimPrefix(issue.GetBody(), parseStubBody(issue.GetBody()).text))
+		return issue
+	}
+	stubs := []*github.Issue{
+		stub(7, 1, "TODO(@alice): Add details about PR #1", old),
+		edited(stub(8, 2, "TODO(@alice): Add details about PR #2", old), "The frobnicator is broken."),
+		{Number: github.Ptr(9), Title: github.Ptr("Placeholder issue for PR #3"), Body: github.Ptr("TODO(@bob): Add details about PR #3"), UpdatedAt: &github.Timestamp{Time: old}},
+		stub(10, 4, "TODO(@carol): Add details about PR #4", time.Now()),
+	}
+	reminded := map[string]string{}
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; {
+		case path == "/repos/o/r/issues":
+			json.NewEncoder(w).Encode(stubs)
+		case strings.HasPrefix(path, "/repos/o/r/pulls/"):
+			n := strings.TrimPrefix(path, "/repos/o/r/pulls/")
+			author := map[string]string{"1": "alice", "2": "alice", "4": "carol"}[n]
+			fmt.Fprintf(w, `{"number": %s, "user": {"login": %q}}`, n, author)
+		case strings.HasSuffix(path, "/comments") && r.Method == "POST":
+			var c github.IssueComment
+			json.NewDecoder(r.Body).Decode(&c)
+			reminded[path] = c.GetBody()
+			json.NewEncoder(w).Encode(c)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	repo := &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")}
+	n, err := remindStubs(t.Context(), cli, repo, nil)
+	if err != nil {
+		t.Fatalf("remindStubs: unexpected error: %v", err)
+	}
+	if n != 1 || len(reminded) != 1 || !strings.Contains(reminded["/repos/o/r/issues/7/comments"], "@alice") {
+		t.Errorf("remindStubs: got %d reminders %q, want one for #7 mentioning @alice", n, reminded)
+	}
+
+	// Reminders can be turned off.
+	cfg, err := parseRepoConfig([]byte(`stubReminderAfter: 0s`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	clear(reminded)
+	if n, err := remindStubs(t.Context(), cli, repo, cfg); n != 0 || err != nil || len(reminded) != 0 {
+		t.Errorf("remindStubs (disabled): got %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestEscalateStubs(t *testing.T) {
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	newStub := func(num, pr int, created time.Time) *github.Issue {
+		body := fmt.Sprintf("TODO(@alice): Add details about PR #%d", pr)
+		return &github.Issue{
+			Number:    github.Ptr(num),
+			Title:     github.Ptr(fmt.Sprintf("Placeholder issue for PR #%d", pr)),
+			Body:      github.Ptr(body + stubBodyMarker(pr, body)),
+			CreatedAt: &github.Timestamp{Time: created},
+			UpdatedAt: &github.Timestamp{Time: created},
+		}
+	}
+	stubs := []*github.Issue{
+		newStub(7, 1, old),
+		newStub(8, 2, old), // already escalated
+		newStub(9, 3, time.Now()),
+	}
+	var actions []string
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := r.URL.Path; {
+		case path == "/repos/o/r/issues":
+			json.NewEncoder(w).Encode(stubs)
+		case strings.HasPrefix(path, "/repos/o/r/pulls/"):
+			fmt.Fprintf(w, `{"number": %s, "user": {"login": "alice"}}`, strings.TrimPrefix(path, "/repos/o/r/pulls/"))
+		case path == "/repos/o/r/issues/8/comments" && r.Method == "GET":
+			fmt.Fprintf(w, `[{"body": "Escalated.\n\n%s"}]`, escalationMarker)
+		case strings.HasSuffix(path, "/comments") && r.Method == "GET":
+			w.Write([]byte(`[]`))
+		case strings.HasSuffix(path, "/comments"):
+			var c github.IssueComment
+			json.NewDecoder(r.Body).Decode(&c)
+			actions = append(actions, "comment "+path+" "+c.GetBody())
+			json.NewEncoder(w).Encode(c)
+		case strings.HasSuffix(path, "/labels"):
+			var labels []string
+			json.NewDecoder(r.Body).Decode(&labels)
+			actions = append(actions, "label "+path+" "+strings.Join(labels, ","))
+			w.Write([]byte(`[]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	cfg, err := parseRepoConfig([]byte(`stubEscalation: {after: 720h, label: stale, cc: ["@o/triage"], commentOnPR: true}`))
+	if err != nil {
+		t.Fatalf("parseRepoConfig: unexpected error: %v", err)
+	}
+	repo := &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")}
+	n, err := escalateStubs(t.Context(), cli, repo, cfg)
+	if err != nil {
+		t.Fatalf("escalateStubs: unexpected error: %v", err)
+	}
+	if n != 1 || len(actions) != 3 {
+		t.Fatalf("escalateStubs: got %d escalations, actions %q; want 1 with 3 actions", n, actions)
+	}
+	if !strings.HasPrefix(actions[0], "label /repos/o/r/issues/7/labels stale") {
+		t.Errorf("escalateStubs: action %q, want label on #7", actions[0])
+	}
+	if !strings.HasPrefix(actions[1], "comment /repos/o/r/issues/1/comments ") {
+		t.Errorf("escalateStubs: action %q, want comment on PR #1", actions[1])
+	}
+	if a := actions[2]; !strings.HasPrefix(a, "comment /repos/o/r/issues/7/comments ") || !strings.Contains(a, "cc @o/triage") || !strings.Contains(a, escalationMarker) {
+		t.Errorf("escalateStubs: action %q, want comment on #7 with cc and marker", a)
+	}
+
+	if _, err := parseRepoConfig([]byte(`stubEscalation: {label: stale}`)); err == nil {
+		t.Error("parseRepoConfig: got nil error for stubEscalation without after")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/google/go-github/v72/github"
+	"github.com/tailscale/setec/client/setec"
+)
+
+const replayUsage = `Usage: issuebot replay [-event type] [-secret s | -keep-signatures] [-post] file-or-dir ...
+
+Feed saved webhook deliveries through the webhook handler, in the order
+given (files in a directory in name order), and print the response to each.
+How each is handled is logged to stderr. Nothing is changed on GitHub unless
+-post is given.
+
+Each file holds either a delivery as the GitHub API returns it, e.g., from
+"gh api /app/hook/deliveries/ID" (with the event type and headers), or a bare
+payload, whose event type is given by -event.
+
+Payloads are signed again with the -secret, since a saved delivery's payload
+may not match the bytes that were signed. With -keep-signatures, the saved
+signatures are checked against $WEBHOOK_SECRET instead.
+
+GitHub is accessed as "issuebot help" describes.
+`
+
+// runReplay implements the replay subcommand, and returns the process exit
+// code.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	event := fs.String("event", "", "event type of bare payloads, e.g., pull_request")
+	secret := fs.String("secret", "issuebot-replay", "webhook secret to sign payloads with")
+	keepSignatures := fs.Bool("keep-signatures", false, "check the saved signatures against $WEBHOOK_SECRET, rather than signing payloads again")
+	post := fs.Bool("post", false, "make the changes on GitHub that handling the deliveries calls for")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), replayUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	var files []string
+	for _, arg := range fs.Args() {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+			return 2
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, _ := filepath.Glob(filepath.Join(arg, "*.json"))
+		slices.Sort(matches)
+		files = append(files, matches...)
+	}
+
+	cli, err := toolClient()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+		return 2
+	}
+	clientUpdater = setec.StaticUpdater(cli)
+	*shadowMode = !*post
+	if !*keepSignatures {
+		githubWebhookSecret = setec.StaticSecret(*secret)
+		previousWebhookSecret = setec.StaticSecret("")
+	}
+
+	status := 0
+	for _, file := range files {
+		d, err := readSavedDelivery(file, *event)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			status = 1
+			continue
+		}
+		if !*keepSignatures {
+			d.signature = signPayload([]byte(*secret), d.payload)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+		res := processWebhook(ctx, d)
+		cancel()
+		fmt.Printf("%s: %s: %d %s\n", file, d.event, res.statusCode(), bytes.TrimSpace(res.body))
+		if res.statusCode() >= 400 {
+			status = 1
+		}
+	}
+	return status
+}
+
+// readSavedDelivery reads a saved webhook delivery from file. If it holds a
+// bare payload, its event type is event.
+func readSavedDelivery(file, event string) (webhookDelivery, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return webhookDelivery{}, err
+	}
+	var hd github.HookDelivery
+	if err := json.Unmarshal(data, &hd); err != nil {
+		return webhookDelivery{}, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if hd.GetEvent() == "" || hd.Request == nil || hd.Request.RawPayload == nil {
+		// A bare payload.
+		if event == "" {
+			return webhookDelivery{}, errors.New("bare payload, but no -event given")
+		}
+		return webhookDelivery{event: event, contentType: "application/json", payload: data}, nil
+	}
+	signature := hd.Request.GetHeader(github.SHA256SignatureHeader)
+	if signature == "" {
+		signature = hd.Request.GetHeader(github.SHA1SignatureHeader)
+	}
+	return webhookDelivery{
+		event:       hd.GetEvent(),
+		id:          hd.GetGUID(),
+		contentType: "application/json",
+		signature:   signature,
+		payload:     *hd.Request.RawPayload,
+	}, nil
+}
+
+// signPayload returns the signature of a webhook payload made with secret,
+// as GitHub gives it in X-Hub-Signature-256.
+func signPayload(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tailscale/setec/client/setec"
+)
+
+func TestReplay(t *testing.T) {
+	oldShadow, oldSecret, oldPrevious, oldClient := *shadowMode, githubWebhookSecret, previousWebhookSecret, clientUpdater
+	t.Cleanup(func() {
+		*shadowMode, githubWebhookSecret, previousWebhookSecret, clientUpdater = oldShadow, oldSecret, oldPrevious, oldClient
+	})
+
+	dir := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	delivery := write("1.json", `{
+  "guid": "0b989ba4-242f-11e5-81e1-c7b6966d2516",
+  "event": "ping",
+  "request": {
+    "headers": {"X-Hub-Signature-256": "sha256=stale"},
+    "payload": {"zen": "Design for failure.", "hook_id": 42}
+  }
+}`)
+	bare := write("2.json", `{"zen": "Speak like a human.", "hook_id": 42}`)
+
+	d, err := readSavedDelivery(delivery, "")
+	if err != nil {
+		t.Fatalf("readSavedDelivery(delivery): %v", err)
+	}
+	if d.event != "ping" || d.id == "" || d.signature != "sha256=stale" {
+		t.Errorf("readSavedDelivery(delivery): got %+v, want a ping with its ID and signature", d)
+	}
+	if _, err := readSavedDelivery(bare, ""); err == nil {
+		t.Error("readSavedDelivery(bare) with no event: got nil error, want error")
+	}
+	if d, err := readSavedDelivery(bare, "ping"); err != nil || d.event != "ping" {
+		t.Errorf("readSavedDelivery(bare, ping): got %+v, %v; want a ping", d, err)
+	}
+
+	// Both are accepted once signed again, the bare payload given its type.
+	if got := runReplay([]string{"-event", "ping", dir}); got != 0 {
+		t.Errorf("runReplay: got exit code %d, want 0", got)
+	}
+	// The stale signature is rejected if kept.
+	githubWebhookSecret = setec.StaticSecret("current")
+	if got := runReplay([]string{"-keep-signatures", delivery}); got != 1 {
+		t.Errorf("runReplay(-keep-signatures): got exit code %d, want 1", got)
+	}
+}
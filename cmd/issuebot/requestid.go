@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// requestIDHeader is the header that carries the ID of a request, both from a
+// proxy in front of us, if it sets one, and back to the client.
+const requestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// withRequestID returns a context carrying the request ID id, which ctxLogf
+// adds to the log lines for the work done in it.
+func withRequestID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestID returns the request ID of ctx, or "" if it has none.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ctxLogf logs like log.Printf, prefixed with the request ID of ctx, if any.
+func ctxLogf(ctx context.Context, format string, args ...any) {
+	if id := requestID(ctx); id != "" {
+		format = "[" + id + "] " + format
+	}
+	log.Printf(format, args...)
+}
+
+// logRequests returns a handler that gives each request to h an ID, taken
+// from the request if a proxy has set one, and logs its method, path,
+// status, and latency, with its webhook delivery ID, if any. Successful
+// health probes are not logged.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := withRequestID(r.Context(), id)
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.status() == http.StatusOK && (r.URL.Path == "/healthz" || r.URL.Path == "/readyz") {
+			return // probes are frequent, and of interest only when they fail
+		}
+		logAccess(ctx, r.Method, r.URL.Path, sw.status(), github.DeliveryID(r), time.Since(start))
+	})
+}
+
+// logAccess logs a request handled in ctx.
+func logAccess(ctx context.Context, method, path string, status int, delivery string, latency time.Duration) {
+	if delivery != "" {
+		ctxLogf(ctx, "%s %s %d %v delivery=%s", method, path, status, latency.Round(time.Millisecond), delivery)
+	} else {
+		ctxLogf(ctx, "%s %s %d %v", method, path, status, latency.Round(time.Millisecond))
+	}
+}
+
+// A statusWriter records the status code of the response written to it.
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// status returns the status code of the response, which is 200 OK if the
+// handler wrote nothing.
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
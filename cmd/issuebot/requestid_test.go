@@ -0,0 +1,51 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogRequests(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+
+	var seen string
+	h := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = requestID(r.Context())
+		ctxLogf(r.Context(), "handling")
+		http.Error(w, "nope", http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest("POST", "/webhook", nil)
+	req.Header.Set("X-GitHub-Delivery", "d-1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	id := rec.Header().Get(requestIDHeader)
+	if id == "" || id != seen {
+		t.Errorf("request ID: got %q in the response, %q in the context; want the same, not empty", id, seen)
+	}
+	got := buf.String()
+	for _, want := range []string{"[" + id + "] handling", "[" + id + "] POST /webhook 418 ", "delivery=d-1"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log: missing %q in:\n%s", want, got)
+		}
+	}
+
+	// A request ID set by a proxy is kept.
+	req = httptest.NewRequest("GET", "/debug/", nil)
+	req.Header.Set(requestIDHeader, "from-proxy")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get(requestIDHeader); got != "from-proxy" || seen != "from-proxy" {
+		t.Errorf("proxy request ID: got %q in the response, %q in the context; want from-proxy", got, seen)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const (
+	// apiCallTimeout bounds the duration of each individual attempt at a
+	// GitHub API call.
+	apiCallTimeout = 30 * time.Second
+
+	// retryAttempts is the maximum number of times a GitHub API call is
+	// attempted before giving up on a transient error.
+	retryAttempts = 5
+
+	// retryBaseDelay is the upper bound on the delay before the first retry.
+	// The bound doubles after each subsequent attempt.
+	retryBaseDelay = 250 * time.Millisecond
+
+	// retryMaxDelay caps the delay between any two attempts.
+	retryMaxDelay = 10 * time.Second
+)
+
+// retryCall calls f, retrying with jittered exponential backoff as long as it
+// reports a transient error (see isTransient), the retry budget is not
+// exhausted, and ctx has not ended. The what argument labels the call in log
+// messages. Each call to f is given a context derived from ctx, bounded by
+// apiCallTimeout.
+//
+// If GitHub reports that we are rate limited, retryCall waits as long as it
+// asks, provided that is no longer than maxRateLimitWait. Longer waits are
+// reported to the caller as an error (see rateLimitDelay).
+//
+// Calls are not attempted while the circuit breaker is open; in that case
+// retryCall reports errBreakerOpen.
+//
+// The results from the last call to f are returned.
+//
+// The calls are traced as a span named for what, with the number of attempts
+// and the rate limit remaining after the last.
+//
+// Since a call that fails may nonetheless have taken effect, f must be
+// idempotent: a GET, PUT, or DELETE, or a POST that sets a commit status or
+// check run, or that GitHub ignores if repeated, such as adding a label or a
+// reaction. Calls that create issues or comments use createCall instead.
+func retryCall[T any](ctx context.Context, what string, f func(context.Context) (T, *github.Response, error)) (T, *github.Response, error) {
+	return callWithRetries(ctx, what, f, nil)
+}
+
+// createClockSkew is how far before its first attempt createCall looks for
+// what a failed attempt may have created, to allow for the difference
+// between our clock and GitHub's.
+const createClockSkew = time.Minute
+
+// createCall is like retryCall, for a call that creates an issue or comment.
+// An attempt that fails with a transient error may have created it anyway, so
+// before each retry, createCall calls find to look for anything like it
+// created since the first attempt. If find finds it, createCall returns it
+// rather than creating another.
+func createCall[T any](ctx context.Context, what string, f func(context.Context) (T, *github.Response, error), find func(ctx context.Context, since time.Time) (T, bool, error)) (T, *github.Response, error) {
+	return callWithRetries(ctx, what, f, find)
+}
+
+// callWithRetries implements retryCall and createCall. If find is nil, f is
+// retried without looking for the results of failed attempts.
+func callWithRetries[T any](ctx context.Context, what string, f func(context.Context) (T, *github.Response, error), find func(context.Context, time.Time) (T, bool, error)) (v T, resp *github.Response, err error) {
+	ctx, sp := startSpan(ctx, "GitHub "+what, spanClient)
+	defer func() {
+		if resp != nil && resp.Response != nil {
+			sp.set("http.response.status_code", resp.StatusCode, "github.rate_limit.remaining", resp.Rate.Remaining)
+		}
+		sp.finish(err)
+	}()
+	since := time.Now().Add(-createClockSkew)
+	for attempt := 1; ; attempt++ {
+		probe, berr := breakerAllow()
+		if berr != nil {
+			var zero T
+			return zero, nil, berr
+		}
+		sp.set("github.attempts", attempt)
+		cctx, cancel := context.WithTimeout(ctx, apiCallTimeout)
+		countCall(ctx)
+		v, resp, err = f(cctx)
+		cancel()
+		recordRateLimit(resp)
+		if ctx.Err() != nil {
+			if probe {
+				breakerAbandon()
+			}
+			return v, resp, err // the caller is no longer interested
+		}
+		breakerRecord(resp, err)
+		if err == nil || attempt >= retryAttempts {
+			return v, resp, err
+		}
+		var delay time.Duration
+		mayHaveTakenEffect := false
+		if d, ok := rateLimitDelay(err); ok {
+			if d > maxRateLimitWait {
+				return v, resp, err
+			}
+			delay = d
+			ctxLogf(ctx, "%s: rate limited (attempt %d of %d, retrying in %v): %v",
+				what, attempt, retryAttempts, delay.Round(time.Millisecond), err)
+			githubRateLimited.Add(1)
+		} else if isTransient(resp, err) {
+			delay = backoff(attempt)
+			ctxLogf(ctx, "%s: transient error (attempt %d of %d, retrying in %v): %v",
+				what, attempt, retryAttempts, delay.Round(time.Millisecond), err)
+			githubRetries.Add(1)
+			mayHaveTakenEffect = true
+		} else {
+			return v, resp, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return v, resp, err
+		case <-time.After(delay):
+		}
+		if find != nil && mayHaveTakenEffect {
+			found, ok, ferr := find(ctx, since)
+			if ferr != nil {
+				ctxLogf(ctx, "%s: not retrying, since looking for the result of the failed attempt failed: %v", what, ferr)
+				return v, resp, err
+			}
+			if ok {
+				ctxLogf(ctx, "%s: the failed attempt succeeded after all", what)
+				return found, nil, nil
+			}
+		}
+	}
+}
+
+// findComment returns a function for createCall that looks for a comment with
+// the given body on the issue or PR number in owner/repo.
+func findComment(cli *github.Client, owner, repo string, number int, body string) func(context.Context, time.Time) (*github.IssueComment, bool, error) {
+	return func(ctx context.Context, since time.Time) (*github.IssueComment, bool, error) {
+		opts := &github.IssueListCommentsOptions{Since: &since, ListOptions: github.ListOptions{PerPage: 100}}
+		for {
+			comments, resp, err := retryCall(ctx, "ListComments", func(ctx context.Context) ([]*github.IssueComment, *github.Response, error) {
+				return cli.Issues.ListComments(ctx, owner, repo, number, opts)
+			})
+			if err != nil {
+				return nil, false, err
+			}
+			for _, c := range comments {
+				if strings.TrimSpace(c.GetBody()) == strings.TrimSpace(body) {
+					return c, true, nil
+				}
+			}
+			if resp.NextPage == 0 {
+				return nil, false, nil
+			}
+			opts.Page = resp.NextPage
+		}
+	}
+}
+
+// findIssue returns a function for createCall that looks for an issue in
+// owner/repo with the title and body of req.
+func findIssue(cli *github.Client, owner, repo string, req *github.IssueRequest) func(context.Context, time.Time) (*github.Issue, bool, error) {
+	return func(ctx context.Context, since time.Time) (*github.Issue, bool, error) {
+		opts := &github.IssueListByRepoOptions{
+			State:       "all",
+			Since:       since,
+			ListOptions: github.ListOptions{PerPage: 100},
+		}
+		for {
+			issues, resp, err := retryCall(ctx, "ListByRepo", func(ctx context.Context) ([]*github.Issue, *github.Response, error) {
+				return cli.Issues.ListByRepo(ctx, owner, repo, opts)
+			})
+			if err != nil {
+				return nil, false, err
+			}
+			for _, issue := range issues {
+				if !issue.IsPullRequest() && issue.GetTitle() == req.GetTitle() &&
+					strings.TrimSpace(issue.GetBody()) == strings.TrimSpace(req.GetBody()) {
+					return issue, true, nil
+				}
+			}
+			if resp.NextPage == 0 {
+				return nil, false, nil
+			}
+			opts.ListOptions.Page = resp.NextPage
+		}
+	}
+}
+
+// backoff returns a randomized delay to wait before the next call, following
+// the given number of failed attempts (≥ 1).
+func backoff(attempt int) time.Duration {
+	bound := retryBaseDelay << (attempt - 1)
+	if bound <= 0 || bound > retryMaxDelay {
+		bound = retryMaxDelay
+	}
+	return rand.N(bound) + 1
+}
+
+// isTransient reports whether a GitHub API call that returned resp and err is
+// worth retrying. Server errors (5xx) and failures that did not produce an
+// HTTP response at all (e.g., a dropped connection or a timeout) are
+// considered transient.
+func isTransient(resp *github.Response, err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if resp == nil || resp.Response == nil {
+		return true
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
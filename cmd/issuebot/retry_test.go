@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestRetryCall(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int // the status of each response, then 200
+		wantCalls int
+		wantErr   bool
+	}{
+		{"success", nil, 1, false},
+		{"bad gateway once", []int{502}, 2, false},
+		{"server errors", []int{500, 503, 502}, 4, false},
+		{"not found", []int{404}, 1, true},
+		{"always failing", []int{502, 502, 502, 502, 502, 502}, retryAttempts, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeBreaker(t)
+			calls := 0
+			cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls <= len(tc.statuses) {
+					http.Error(w, "oops", tc.statuses[calls-1])
+					return
+				}
+				fmt.Fprint(w, `{"number":1}`)
+			}))
+			pr, _, err := retryCall(t.Context(), "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+				return cli.PullRequests.Get(ctx, "o", "r", 1)
+			})
+			if (err != nil) != tc.wantErr {
+				t.Errorf("retryCall: got error %v, want error %v", err, tc.wantErr)
+			} else if err == nil && pr.GetNumber() != 1 {
+				t.Errorf("retryCall: got PR %d, want 1", pr.GetNumber())
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("retryCall: got %d calls, want %d", calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryCallContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	calls := 0
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		cancel()
+		http.Error(w, "oops", http.StatusBadGateway)
+	}))
+	_, _, err := retryCall(ctx, "GetPullRequest", func(ctx context.Context) (*github.PullRequest, *github.Response, error) {
+		return cli.PullRequests.Get(ctx, "o", "r", 1)
+	})
+	if err == nil {
+		t.Errorf("retryCall: got no error, want one")
+	}
+	if calls != 1 {
+		t.Errorf("retryCall: got %d calls, want 1", calls)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, retryBaseDelay},
+		{2, 2 * retryBaseDelay},
+		{3, 4 * retryBaseDelay},
+		{10, retryMaxDelay},
+		{100, retryMaxDelay}, // the shifted bound overflows
+	}
+	for _, tc := range tests {
+		seen := map[time.Duration]bool{}
+		for range 100 {
+			d := backoff(tc.attempt)
+			if d <= 0 || d > tc.max {
+				t.Fatalf("backoff(%d): got %v, want in (0, %v]", tc.attempt, d, tc.max)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("backoff(%d): got the same delay 100 times, want jitter", tc.attempt)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	response := func(code int) *github.Response {
+		return &github.Response{Response: &http.Response{StatusCode: code}}
+	}
+	tests := []struct {
+		name string
+		resp *github.Response
+		err  error
+		want bool
+	}{
+		{"no response", nil, errors.New("connection reset"), true},
+		{"empty response", &github.Response{}, errors.New("timeout"), true},
+		{"canceled", nil, context.Canceled, false},
+		{"wrapped canceled", nil, fmt.Errorf("get: %w", context.Canceled), false},
+		{"500", response(500), errors.New("oops"), true},
+		{"502", response(502), errors.New("oops"), true},
+		{"404", response(404), errors.New("oops"), false},
+		{"422", response(422), errors.New("oops"), false},
+	}
+	for _, tc := range tests {
+		if got := isTransient(tc.resp, tc.err); got != tc.want {
+			t.Errorf("isTransient(%s): got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCreateCall(t *testing.T) {
+	tests := []struct {
+		name      string
+		created   bool // whether the failed POST creates the comment anyway
+		wantPosts int
+	}{
+		{"lost response", true, 1},
+		{"not created", false, 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var comments []*github.IssueComment
+			posts := 0
+			cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.URL.Path != "/repos/o/r/issues/1/comments" {
+					http.NotFound(w, r)
+					return
+				}
+				if r.Method == "GET" {
+					if r.FormValue("since") == "" {
+						t.Errorf("ListComments without since")
+					}
+					json.NewEncoder(w).Encode(comments)
+					return
+				}
+				posts++
+				var c github.IssueComment
+				json.NewDecoder(r.Body).Decode(&c)
+				if posts == 1 {
+					if tc.created {
+						comments = append(comments, &c)
+					}
+					http.Error(w, "bad gateway", http.StatusBadGateway)
+					return
+				}
+				comments = append(comments, &c)
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(c)
+			}))
+
+			const body = "hello\n\n<!-- marker -->"
+			c, _, err := createCall(t.Context(), "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+				return cli.Issues.CreateComment(ctx, "o", "r", 1, &github.IssueComment{Body: github.Ptr(body)})
+			}, findComment(cli, "o", "r", 1, body))
+			if err != nil {
+				t.Fatalf("createCall: %v", err)
+			}
+			if c.GetBody() != body {
+				t.Errorf("createCall: got comment %q, want %q", c.GetBody(), body)
+			}
+			if posts != tc.wantPosts {
+				t.Errorf("createCall: got %d POSTs, want %d", posts, tc.wantPosts)
+			}
+			if len(comments) != 1 {
+				t.Errorf("createCall: got %d comments, want 1", len(comments))
+			}
+		})
+	}
+}
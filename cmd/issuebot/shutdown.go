@@ -0,0 +1,69 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// rootCtx governs all the work done by the server, including checks run in
+// the background. It is canceled if checks do not finish in time during
+// shutdown.
+var rootCtx, cancelRoot = context.WithCancel(context.Background())
+
+// errShuttingDown is reported for checks that were not started because the
+// server is shutting down.
+var errShuttingDown = errors.New("server is shutting down")
+
+// inflight tracks the checks currently running, so that shutdown can wait for
+// them to finish.
+var inflight struct {
+	sync.Mutex
+	closing bool
+	wg      sync.WaitGroup
+}
+
+// beginCheck registers the start of a check. It reports errShuttingDown if
+// the server is shutting down, in which case the check must not be run.
+// Otherwise the caller must call endCheck when the check is finished.
+func beginCheck() error {
+	inflight.Lock()
+	defer inflight.Unlock()
+	if inflight.closing {
+		return errShuttingDown
+	}
+	inflight.wg.Add(1)
+	return nil
+}
+
+// endCheck registers the end of a check started with beginCheck.
+func endCheck() { inflight.wg.Done() }
+
+// draining reports whether the server is shutting down, so that no new
+// checks may start.
+func draining() bool {
+	inflight.Lock()
+	defer inflight.Unlock()
+	return inflight.closing
+}
+
+// drainChecks prevents new checks from starting, and waits until all the
+// checks in progress have finished or ctx ends.
+func drainChecks(ctx context.Context) error {
+	inflight.Lock()
+	inflight.closing = true
+	inflight.Unlock()
+
+	done := make(chan struct{})
+	go func() { defer close(done); inflight.wg.Wait() }()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
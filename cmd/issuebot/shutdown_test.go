@@ -0,0 +1,75 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDrainChecks(t *testing.T) {
+	t.Cleanup(func() {
+		inflight.Lock()
+		inflight.closing = false
+		inflight.Unlock()
+	})
+
+	// The check in progress is held up fetching the configuration.
+	started, unblock := make(chan bool, 1), make(chan bool)
+	checked := fakeCheckGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- true:
+		default:
+		}
+		<-unblock
+		http.NotFound(w, r)
+	}))
+	checkErr := make(chan error, 1)
+	go func() { checkErr <- checkPullRequest(context.Background(), testPR(1), testRepo) }()
+	<-started
+
+	drained := make(chan error, 1)
+	go func() { drained <- drainChecks(context.Background()) }()
+	for !draining() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := checkPullRequest(t.Context(), testPR(2), testRepo); !errors.Is(err, errShuttingDown) {
+		t.Errorf("check while draining: got %v, want %v", err, errShuttingDown)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("drainChecks returned %v before the check in progress finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(unblock)
+	if err := <-checkErr; err != nil {
+		t.Errorf("check in progress: %v", err)
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("drainChecks: %v", err)
+	}
+	if got, want := checked(), []int{1}; !slices.Equal(got, want) {
+		t.Errorf("checked PRs %v, want %v", got, want)
+	}
+
+	// If the checks do not finish in time, drainChecks gives up.
+	inflight.Lock()
+	inflight.closing = false
+	inflight.Unlock()
+	if err := beginCheck(); err != nil {
+		t.Fatalf("beginCheck: %v", err)
+	}
+	defer endCheck()
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	if err := drainChecks(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("drainChecks with a hung check: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
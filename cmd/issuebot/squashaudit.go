@@ -0,0 +1,124 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// squashAuditMarker is appended to the comment reporting a merge commit that
+// lost the issue link of its PR, so that it is not posted twice.
+const squashAuditMarker = "\n\n<!-- issuebot:squash-audit -->"
+
+// auditMergedPull checks that the commit that merged pr into repo still links
+// to an issue, if a commit on the PR did, and comments on the PR if not.
+// Squash merges replace the messages of the PR's commits with one written
+// when the PR is merged, which can lose the link.
+func auditMergedPull(ctx context.Context, pr *github.PullRequest, repo *github.Repository) error {
+	if !repoEnabled(repo.GetFullName()) {
+		return nil
+	}
+	cli := apiClient()
+	cfg, err := loadRepoConfig(ctx, cli, repo)
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	} else if !cfg.auditSquashMerges() {
+		return nil
+	}
+	p := pullRequest{repo: repo, pr: pr, cfg: cfg}
+	owner, name, sha := repo.GetOwner().GetLogin(), repo.GetName(), pr.GetMergeCommitSHA()
+	merge, _, err := retryCall(ctx, "GetCommit", func(ctx context.Context) (*github.RepositoryCommit, *github.Response, error) {
+		return cli.Repositories.GetCommit(ctx, owner, name, sha, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("get merge commit %s: %w", sha, err)
+	}
+	if len(merge.Parents) > 1 {
+		return nil // a merge commit, which keeps the PR's commits
+	} else if p.checkCommitMessage(merge.GetCommit().GetMessage()) != prFailed {
+		return nil
+	}
+
+	link, err := p.pullCommitLink(ctx, cli)
+	if err != nil {
+		return err
+	} else if link == "" {
+		return nil // nothing was lost
+	}
+	p.logf("audit: merge commit %s lost the link to %s", sha, link)
+	squashAudits.Add(1)
+	return p.postSquashAudit(ctx, cli, sha, link)
+}
+
+// pullCommitLink returns the first issue linked by a commit on the PR, or ""
+// if there is none.
+func (p pullRequest) pullCommitLink(ctx context.Context, cli *github.Client) (string, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		commits, resp, err := retryCall(ctx, "ListCommits", func(ctx context.Context) ([]*github.RepositoryCommit, *github.Response, error) {
+			return cli.PullRequests.ListCommits(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), opts)
+		})
+		if err != nil {
+			return "", fmt.Errorf("list commits: %w", err)
+		}
+		for _, c := range commits {
+			if refs := p.matchRefs(cli, c.GetCommit().GetMessage()); len(refs) != 0 {
+				return refs[0].String(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return "", nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// postSquashAudit comments on the PR that its merge commit sha does not link
+// to the issue link, unless it has already.
+func (p pullRequest) postSquashAudit(ctx context.Context, cli *github.Client, sha, link string) error {
+	owner, repoName, prNumber := p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber()
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := retryCall(ctx, "ListComments", func(ctx context.Context) ([]*github.IssueComment, *github.Response, error) {
+			return cli.Issues.ListComments(ctx, owner, repoName, prNumber, opts)
+		})
+		if err != nil {
+			return fmt.Errorf("list comments: %w", err)
+		}
+		for _, comment := range comments {
+			if strings.HasSuffix(comment.GetBody(), squashAuditMarker) {
+				return nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if *shadowMode {
+		p.logf("shadow: would report merge commit %s lost the link to %s", sha, link)
+		return nil
+	}
+	data := p.data()
+	data.Link, data.Commit = link, sha
+	body, err := p.render(squashAuditTemplate, data)
+	if err != nil {
+		return err
+	}
+	body += squashAuditMarker
+	if _, _, err := createCall(ctx, "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+		return cli.Issues.CreateComment(ctx, owner, repoName, prNumber, &github.IssueComment{
+			Body: github.Ptr(body),
+		})
+	}, findComment(cli, owner, repoName, prNumber, body)); err != nil {
+		return fmt.Errorf("create comment: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestSquashAudit(t *testing.T) {
+	var (
+		messages []string
+		posted   []string
+	)
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/repos/o/r/pulls/1/commits":
+			var commits []*github.RepositoryCommit
+			for _, msg := range messages {
+				commits = append(commits, &github.RepositoryCommit{Commit: &github.Commit{Message: github.Ptr(msg)}})
+			}
+			json.NewEncoder(w).Encode(commits)
+		case r.URL.Path == "/repos/o/r/issues/1/comments" && r.Method == "POST":
+			var c github.IssueComment
+			json.NewDecoder(r.Body).Decode(&c)
+			posted = append(posted, c.GetBody())
+			json.NewEncoder(w).Encode(c)
+		case r.URL.Path == "/repos/o/r/issues/1/comments":
+			var comments []*github.IssueComment
+			for _, body := range posted {
+				comments = append(comments, &github.IssueComment{Body: github.Ptr(body)})
+			}
+			json.NewEncoder(w).Encode(comments)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(1)},
+	}
+
+	tests := []struct {
+		messages []string
+		want     string
+	}{
+		{nil, ""},
+		{[]string{"Fix the frobnicator"}, ""},
+		{[]string{"Fix the frobnicator", "Add a test\n\nUpdates #12"}, "#12"},
+		{[]string{"Fixes o/other#3", "Updates #12"}, "o/other#3"},
+	}
+	for _, tc := range tests {
+		messages = tc.messages
+		got, err := p.pullCommitLink(t.Context(), cli)
+		if err != nil {
+			t.Errorf("pullCommitLink(%q): unexpected error: %v", tc.messages, err)
+		} else if got != tc.want {
+			t.Errorf("pullCommitLink(%q): got %q, want %q", tc.messages, got, tc.want)
+		}
+	}
+
+	// The finding is reported once.
+	for range 2 {
+		if err := p.postSquashAudit(t.Context(), cli, "abc123", "#12"); err != nil {
+			t.Fatalf("postSquashAudit: unexpected error: %v", err)
+		}
+	}
+	if len(posted) != 1 {
+		t.Fatalf("postSquashAudit: posted %d comments, want 1", len(posted))
+	}
+	if !strings.Contains(posted[0], "abc123") || !strings.Contains(posted[0], "#12") {
+		t.Errorf("postSquashAudit: got %q, want it to name the commit and the issue", posted[0])
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// stubClaimReaction is the reaction with which issuebot claims a PR while it
+// files a stub issue for it.
+const stubClaimReaction = "eyes"
+
+// stubClaimTimeout is how long a claim is honored. An older claim was
+// probably left by a replica that stopped before it could release it.
+const stubClaimTimeout = 5 * time.Minute
+
+// claimStub claims the right to file the stub issue for the PR, so that when
+// several replicas of issuebot check the PR at once, only one of them files a
+// stub. It returns the ID of the claim, which the caller must release with
+// releaseStubClaim, or 0 if another replica holds the claim.
+//
+// A claim is a reaction on the PR. GitHub creates each reaction of a user
+// only once, and reports whether it did, so only one replica can succeed in
+// creating it.
+func (p pullRequest) claimStub(ctx context.Context, cli *github.Client) (int64, error) {
+	if *shadowMode {
+		return -1, nil // no claim needed, since no stub is filed
+	}
+	owner, repo, num := p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber()
+	for range 2 {
+		r, resp, err := retryCall(ctx, "CreateIssueReaction", func(ctx context.Context) (*github.Reaction, *github.Response, error) {
+			return cli.Reactions.CreateIssueReaction(ctx, owner, repo, num, stubClaimReaction)
+		})
+		if err != nil {
+			return 0, fmt.Errorf("claim stub: %w", err)
+		} else if resp.StatusCode == http.StatusCreated {
+			return r.GetID(), nil
+		} else if time.Since(r.GetCreatedAt().Time) < stubClaimTimeout {
+			return 0, nil
+		}
+		p.logf("removing stale stub claim from %v", r.GetCreatedAt())
+		p.releaseStubClaim(ctx, cli, r.GetID())
+	}
+	return 0, nil // someone else took over the stale claim
+}
+
+// releaseStubClaim releases the claim with the given ID made by claimStub.
+// Errors are logged, since a claim that is not released expires anyway.
+func (p pullRequest) releaseStubClaim(ctx context.Context, cli *github.Client, id int64) {
+	if id <= 0 {
+		return
+	}
+	_, _, err := retryCall(ctx, "DeleteIssueReaction", func(ctx context.Context) (struct{}, *github.Response, error) {
+		resp, err := cli.Reactions.DeleteIssueReaction(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), id)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			err = nil // already released
+		}
+		return struct{}{}, resp, err
+	})
+	if err != nil {
+		p.logf("error releasing stub claim (continuing): %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestClaimStub(t *testing.T) {
+	// The fake keeps at most one reaction, as GitHub does for each user.
+	var (
+		mu      sync.Mutex
+		claim   *github.Reaction
+		nextID  int64
+		deleted []int64
+	)
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/repos/o/r/issues/1/reactions":
+			if claim != nil {
+				json.NewEncoder(w).Encode(claim)
+				return
+			}
+			nextID++
+			claim = &github.Reaction{ID: github.Ptr(nextID), Content: github.Ptr(stubClaimReaction), CreatedAt: &github.Timestamp{Time: time.Now()}}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(claim)
+		case r.Method == "DELETE" && claim != nil && r.URL.Path == "/repos/o/r/issues/1/reactions/"+strconv.FormatInt(claim.GetID(), 10):
+			deleted = append(deleted, claim.GetID())
+			claim = nil
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	p := pullRequest{
+		repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+		pr:   &github.PullRequest{Number: github.Ptr(1)},
+	}
+
+	// Of several concurrent claims, exactly one succeeds.
+	var (
+		wg  sync.WaitGroup
+		won = make(chan int64, 5)
+	)
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := p.claimStub(t.Context(), cli)
+			if err != nil {
+				t.Errorf("claimStub: unexpected error: %v", err)
+			} else if id != 0 {
+				won <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(won)
+	var ids []int64
+	for id := range won {
+		ids = append(ids, id)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("claimStub: %d claims succeeded, want 1", len(ids))
+	}
+
+	// Once released, the PR can be claimed again.
+	p.releaseStubClaim(t.Context(), cli, ids[0])
+	id, err := p.claimStub(t.Context(), cli)
+	if err != nil || id == 0 {
+		t.Fatalf("claimStub after release: got %d, %v; want a claim", id, err)
+	}
+
+	// A stale claim is taken over.
+	mu.Lock()
+	claim.CreatedAt = &github.Timestamp{Time: time.Now().Add(-2 * stubClaimTimeout)}
+	mu.Unlock()
+	if got, err := p.claimStub(t.Context(), cli); err != nil || got == 0 || got == id {
+		t.Errorf("claimStub over stale claim %d: got %d, %v; want a new claim", id, got, err)
+	}
+	if len(deleted) != 2 || deleted[1] != id {
+		t.Errorf("deleted claims: got %v, want the stale claim %d removed", deleted, id)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/go-github/v72/github"
+)
+
+const mainUsage = `Usage: issuebot [serve] [flags]
+       issuebot <command> [arguments]
+
+With no command, or with serve, issuebot serves GitHub webhooks; its flags
+are listed by "issuebot serve -h". The other commands are:
+
+%s
+Run "issuebot help <command>" for more about a command.
+
+The commands share the server's settings: if $ISSUEBOT_CONFIG names a HuJSON
+settings file, as given to the server with --config, its message templates
+and bot author regexp apply to them as well. GitHub is accessed using
+$GITHUB_TOKEN, if set, or else as the app, if its ID, installation, and
+private key are given in the settings file or in $ISSUEBOT_APP_ID,
+$ISSUEBOT_APP_INSTALL, and $ISSUEBOT_APP_PRIVATE_KEY. $GITHUB_API_URL, if
+set, replaces the GitHub API URL, e.g., for GitHub Enterprise Server.
+`
+
+// A subcommand is an operation of the issuebot binary.
+type subcommand struct {
+	name     string
+	synopsis string
+	run      func(args []string) int // returns the process exit code
+}
+
+// subcommands lists the subcommands, in the order they are listed by help.
+var subcommands []subcommand
+
+func init() {
+	// Set here rather than in the declaration, since help refers to it.
+	subcommands = []subcommand{
+		{"serve", "serve GitHub webhooks (the default)", runServe},
+		{"check", "check pull requests as the server would", runCheck},
+		{"audit", "classify recently merged commits or pull requests", runAudit},
+		{"replay", "feed saved webhook deliveries through the webhook handler", runReplay},
+		{"actions", "check the pull request of a GitHub Actions workflow run", runActions},
+		{"hook", "check local commits before they are pushed", runHook},
+		{"validate-config", "check repository configuration files", runValidateConfig},
+		{"cleanup-stubs", "close stub issues of merged or closed pull requests", runCleanupStubs},
+		{"help", "describe the commands", runHelp},
+	}
+}
+
+// lookupSubcommand returns the subcommand with the given name, or nil if
+// there is none.
+func lookupSubcommand(name string) *subcommand {
+	for i, c := range subcommands {
+		if c.name == name {
+			return &subcommands[i]
+		}
+	}
+	return nil
+}
+
+// runMain runs the subcommand given by args, the command-line arguments
+// after the program name, and returns the process exit code. Without a
+// subcommand, it serves webhooks, as issuebot did before it had any.
+func runMain(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runServe(args)
+	}
+	c := lookupSubcommand(args[0])
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "issuebot: unknown command %q\n", args[0])
+		printMainUsage(os.Stderr)
+		return 2
+	}
+	if c.name != "serve" && c.name != "help" {
+		if err := loadServerConfig(os.Getenv("ISSUEBOT_CONFIG")); err != nil {
+			fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+			return 2
+		}
+	}
+	return c.run(args[1:])
+}
+
+// runHelp implements the help subcommand, and returns the process exit code.
+func runHelp(args []string) int {
+	if len(args) == 0 {
+		printMainUsage(os.Stdout)
+		return 0
+	}
+	c := lookupSubcommand(args[0])
+	if c == nil || len(args) > 1 {
+		printMainUsage(os.Stderr)
+		return 2
+	}
+	if c.name != "help" {
+		// Each command prints its usage when asked with -h.
+		c.run([]string{"-h"})
+	}
+	return 0
+}
+
+// printMainUsage writes the usage of the issuebot binary, with the list of
+// its subcommands, to w.
+func printMainUsage(w io.Writer) {
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 8, 2, ' ', 0)
+	for _, c := range subcommands {
+		fmt.Fprintf(tw, "\t%s\t%s\n", c.name, c.synopsis)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, mainUsage, sb.String())
+}
+
+// loadServerConfig applies the settings that the server and the other
+// subcommands share: the settings file at path, if path is not "", then the
+// message templates and bot author regexp it or the flags give.
+func loadServerConfig(path string) error {
+	if path != "" {
+		if err := loadDaemonConfig(path); err != nil {
+			return fmt.Errorf("loading config: %w", err)
+		}
+	}
+	if *templateDir != "" {
+		if err := loadMessageTemplates(*templateDir); err != nil {
+			return fmt.Errorf("loading templates: %w", err)
+		}
+	}
+	if *botAuthorEmail != "" {
+		re, err := regexp.Compile(*botAuthorEmail)
+		if err != nil {
+			return fmt.Errorf("invalid --bot-author-regexp: %w", err)
+		}
+		botAuthorRE = re
+	}
+	return nil
+}
+
+// toolClient returns the GitHub API client for a subcommand other than
+// serve: authenticated with $GITHUB_TOKEN, if it is set, or else as the app
+// installation, if the app's credentials are given, or else not at all.
+func toolClient() (*github.Client, error) {
+	var cli *github.Client
+	if tok := os.Getenv("GITHUB_TOKEN"); tok != "" {
+		cli = github.NewClient(nil).WithAuthToken(tok)
+	} else if key := appPrivateKey(); len(key) != 0 {
+		var err error
+		if appId, err = flagOrEnvInt64(*appIDFlag, "ISSUEBOT_APP_ID"); err != nil {
+			return nil, fmt.Errorf("app private key given, but %w", err)
+		}
+		if appInstall, err = flagOrEnvInt64(*appInstallFlag, "ISSUEBOT_APP_INSTALL"); err != nil {
+			return nil, fmt.Errorf("app private key given, but %w", err)
+		}
+		if cli, err = newGitHubApiClient(key); err != nil {
+			return nil, err
+		}
+	} else {
+		cli = github.NewClient(nil)
+	}
+	if u := os.Getenv("GITHUB_API_URL"); u != "" {
+		// GitHub Enterprise Server, or a test.
+		base, err := url.Parse(strings.TrimSuffix(u, "/") + "/")
+		if err != nil {
+			return nil, fmt.Errorf("invalid $GITHUB_API_URL: %w", err)
+		}
+		cli.BaseURL = base
+	}
+	return cli, nil
+}
+
+// getRepo fetches the repository with the given full name, owner/repo.
+func getRepo(ctx context.Context, cli *github.Client, fullName string) (*github.Repository, error) {
+	owner, name, ok := strings.Cut(fullName, "/")
+	if !ok || owner == "" || name == "" {
+		return nil, fmt.Errorf("invalid repository %q, want owner/repo", fullName)
+	}
+	repo, _, err := retryCall(ctx, "GetRepository", func(ctx context.Context) (*github.Repository, *github.Response, error) {
+		return cli.Repositories.Get(ctx, owner, name)
+	})
+	return repo, err
+}
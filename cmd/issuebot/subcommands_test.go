@@ -0,0 +1,94 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tailscale/setec/client/setec"
+)
+
+func TestLookupSubcommand(t *testing.T) {
+	for _, name := range []string{"serve", "check", "audit", "replay", "actions", "hook", "validate-config", "cleanup-stubs", "help"} {
+		if c := lookupSubcommand(name); c == nil || c.name != name {
+			t.Errorf("lookupSubcommand(%q): got %v, want %q", name, c, name)
+		}
+	}
+	if c := lookupSubcommand("bogus"); c != nil {
+		t.Errorf("lookupSubcommand(%q): got %q, want nil", "bogus", c.name)
+	}
+	var sb strings.Builder
+	printMainUsage(&sb)
+	for _, c := range subcommands {
+		if !strings.Contains(sb.String(), c.name+"  ") {
+			t.Errorf("usage does not list %q:\n%s", c.name, sb.String())
+		}
+	}
+}
+
+func TestRunMainUnknown(t *testing.T) {
+	if got := runMain([]string{"bogus"}); got != 2 {
+		t.Errorf("runMain(bogus): got %d, want 2", got)
+	}
+	if got := runHelp([]string{"bogus"}); got != 2 {
+		t.Errorf("runHelp(bogus): got %d, want 2", got)
+	}
+}
+
+func TestToolClient(t *testing.T) {
+	defer func(s setec.Secret) { appPrivateKey = s }(appPrivateKey)
+	appPrivateKey = setec.StaticSecret("")
+
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GITHUB_API_URL", "https://ghe.example.com/api/v3")
+	cli, err := toolClient()
+	if err != nil {
+		t.Fatalf("toolClient: %v", err)
+	}
+	if got, want := cli.BaseURL.String(), "https://ghe.example.com/api/v3/"; got != want {
+		t.Errorf("toolClient: got base URL %q, want %q", got, want)
+	}
+
+	// An app key without the app's ID is an error, rather than silently
+	// falling back to unauthenticated access.
+	appPrivateKey = setec.StaticSecret("key")
+	t.Setenv("ISSUEBOT_APP_ID", "")
+	if _, err := toolClient(); err == nil {
+		t.Error("toolClient with app key but no ID: got nil error")
+	}
+
+	// A token takes precedence over the app.
+	t.Setenv("GITHUB_TOKEN", "tok")
+	if _, err := toolClient(); err != nil {
+		t.Errorf("toolClient with token: %v", err)
+	}
+}
+
+func TestGetRepo(t *testing.T) {
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/o/r" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"full_name":"o/r","default_branch":"main"}`)
+	}))
+	ctx := context.Background()
+	repo, err := getRepo(ctx, cli, "o/r")
+	if err != nil {
+		t.Fatalf("getRepo(o/r): %v", err)
+	}
+	if got := repo.GetDefaultBranch(); got != "main" {
+		t.Errorf("getRepo(o/r): got default branch %q, want main", got)
+	}
+	for _, name := range []string{"o", "o/", "/r"} {
+		if _, err := getRepo(ctx, cli, name); err == nil {
+			t.Errorf("getRepo(%q): got nil error", name)
+		}
+	}
+}
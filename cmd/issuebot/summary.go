@@ -0,0 +1,275 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"cmp"
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// An activityKey identifies a count in the summary report: the uses of an
+// escape hatch, or the stubs filed, for one author in one repository.
+type activityKey struct {
+	repo, author string
+}
+
+// activityCounts are the counts reported for an activityKey.
+type activityCounts struct {
+	skipped int // PRs accepted with skip-issuebot (or another "skip" override)
+	cleanup int // PRs accepted with #cleanup (or another "accept" override)
+	stubs   int // stub issues filed
+}
+
+// overrideLog records the PRs accepted by override keywords since the last
+// summary report. It is not persistent, so after a restart the next report
+// covers a shorter period.
+var overrideLog = struct {
+	sync.Mutex
+	since time.Time
+	m     map[string]pullRequestStatus // :: string repo#PR → disposition
+	who   map[string]activityKey       // :: string repo#PR → repo and author
+}{
+	since: time.Now(),
+	m:     make(map[string]pullRequestStatus),
+	who:   make(map[string]activityKey),
+}
+
+// checkDispositions counts the checks of PRs by their final disposition (see
+// statusNames) and repository, so that we can see how often each escape hatch
+// is used, and where. Each value is an *expvar.Map keyed by repository.
+var (
+	checkDispositions   = expvar.NewMap("issuebot_dispositions")
+	checkDispositionsMu sync.Mutex // serializes adding a disposition
+
+	// The same checks, counted by repository and by disposition alone, for
+	// graphing without summing over the other.
+	checksByRepo        = expvar.NewMap("issuebot_checks_by_repo")
+	checksByDisposition = expvar.NewMap("issuebot_checks_by_disposition")
+
+	// stubsCreated counts the stub issues filed, by repository.
+	stubsCreated = expvar.NewMap("issuebot_stubs_created")
+)
+
+// countDisposition adds a check of the PR with the given final disposition to
+// checkDispositions, checksByRepo, and checksByDisposition.
+func (p pullRequest) countDisposition(status pullRequestStatus) {
+	checkDispositionsMu.Lock()
+	m, ok := checkDispositions.Get(status.String()).(*expvar.Map)
+	if !ok {
+		m = new(expvar.Map)
+		checkDispositions.Set(status.String(), m)
+	}
+	checkDispositionsMu.Unlock()
+	m.Add(p.repo.GetFullName(), 1)
+	checksByRepo.Add(p.repo.GetFullName(), 1)
+	checksByDisposition.Add(status.String(), 1)
+}
+
+// serveDispositions serves a table of checkDispositions, with a row for each
+// repository and a column for each disposition, on the debug page.
+func serveDispositions(w http.ResponseWriter, r *http.Request) {
+	counts := make(map[string][]int64) // :: repo → count by disposition
+	checkDispositions.Do(func(kv expvar.KeyValue) {
+		status, ok := parseStatus(kv.Key)
+		if !ok {
+			return
+		}
+		kv.Value.(*expvar.Map).Do(func(kv expvar.KeyValue) {
+			if counts[kv.Key] == nil {
+				counts[kv.Key] = make([]int64, len(statusNames))
+			}
+			counts[kv.Key][status] = kv.Value.(*expvar.Int).Value()
+		})
+	})
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "repository\t")
+	for _, name := range statusNames {
+		fmt.Fprintf(tw, "%s\t", name)
+	}
+	fmt.Fprintln(tw)
+	for _, repo := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Fprintf(tw, "%s\t", repo)
+		for _, n := range counts[repo] {
+			fmt.Fprintf(tw, "%d\t", n)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+// recordOverride notes the final disposition of a check of the PR, if it was
+// accepted by an override keyword. Each PR is counted once, with its latest
+// disposition.
+func (p pullRequest) recordOverride(status pullRequestStatus) {
+	key := fmt.Sprintf("%s#%d", p.repo.GetFullName(), p.pr.GetNumber())
+	overrideLog.Lock()
+	defer overrideLog.Unlock()
+	if status != prSkipped && status != prCleanup {
+		delete(overrideLog.m, key)
+		return
+	}
+	overrideLog.m[key] = status
+	overrideLog.who[key] = activityKey{p.repo.GetFullName(), p.pr.GetUser().GetLogin()}
+}
+
+// takeOverrides returns the override counts recorded since the time it
+// returns, and starts a new period.
+func takeOverrides() (map[activityKey]*activityCounts, time.Time) {
+	overrideLog.Lock()
+	defer overrideLog.Unlock()
+	counts := make(map[activityKey]*activityCounts)
+	for key, status := range overrideLog.m {
+		k := overrideLog.who[key]
+		if counts[k] == nil {
+			counts[k] = new(activityCounts)
+		}
+		if status == prSkipped {
+			counts[k].skipped++
+		} else {
+			counts[k].cleanup++
+		}
+	}
+	since := overrideLog.since
+	overrideLog.since = time.Now()
+	clear(overrideLog.m)
+	clear(overrideLog.who)
+	return counts, since
+}
+
+// countStubs adds the stub issues filed in repo since the given time to
+// counts, by assignee (the author of the PR).
+func countStubs(ctx context.Context, cli *github.Client, repo *github.Repository, since time.Time, counts map[activityKey]*activityCounts) error {
+	opts := &github.IssueListByRepoOptions{
+		Labels:      []string{issuebotStubLabel},
+		State:       "all",
+		Since:       since,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		issues, resp, err := retryCall(ctx, "ListByRepo", func(ctx context.Context) ([]*github.Issue, *github.Response, error) {
+			return cli.Issues.ListByRepo(ctx, repo.GetOwner().GetLogin(), repo.GetName(), opts)
+		})
+		if err != nil {
+			return err
+		}
+		for _, issue := range issues {
+			if issue.IsPullRequest() || issue.GetCreatedAt().Before(since) {
+				continue
+			}
+			k := activityKey{repo.GetFullName(), issue.GetAssignee().GetLogin()}
+			if counts[k] == nil {
+				counts[k] = new(activityCounts)
+			}
+			counts[k].stubs++
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opts.ListOptions.Page = resp.NextPage
+	}
+}
+
+// formatSummary renders counts as a Markdown report for the period from since
+// to until.
+func formatSummary(counts map[activityKey]*activityCounts, since, until time.Time) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Use of issuebot escape hatches from %s to %s.\n\n", since.UTC().Format(time.DateOnly), until.UTC().Format(time.DateOnly))
+	if len(counts) == 0 {
+		sb.WriteString("None.\n")
+		return sb.String()
+	}
+	keys := slices.SortedFunc(maps.Keys(counts), func(a, b activityKey) int {
+		return cmp.Or(cmp.Compare(a.repo, b.repo), cmp.Compare(a.author, b.author))
+	})
+	var total activityCounts
+	sb.WriteString("| Repository | Author | skip-issuebot | #cleanup | Stubs filed |\n")
+	sb.WriteString("| ---------- | ------ | ------------: | -------: | ----------: |\n")
+	for _, k := range keys {
+		c := counts[k]
+		author := "(unknown)"
+		if k.author != "" {
+			author = "@" + k.author
+		}
+		fmt.Fprintf(&sb, "| %s | %s | %d | %d | %d |\n", k.repo, author, c.skipped, c.cleanup, c.stubs)
+		total.skipped += c.skipped
+		total.cleanup += c.cleanup
+		total.stubs += c.stubs
+	}
+	fmt.Fprintf(&sb, "| **Total** | | %d | %d | %d |\n", total.skipped, total.cleanup, total.stubs)
+	return sb.String()
+}
+
+// postSummary reports the use of escape hatches across the installation's
+// repositories since the last report, as a new issue in the --summary-repo
+// repository, or as a comment on its issue --summary-issue if set.
+func postSummary(ctx context.Context) error {
+	counts, since := takeOverrides()
+	until := time.Now()
+	repos, err := listInstallationRepos(ctx)
+	if err != nil {
+		return fmt.Errorf("list repos: %w", err)
+	}
+	cli := apiClient()
+	for _, repo := range repos {
+		if !repoEnabled(repo.GetFullName()) {
+			continue
+		}
+		if err := countStubs(ctx, cli, repo, since, counts); err != nil {
+			log.Printf("summary: counting stubs in %s (skipped): %v", repo.GetFullName(), err)
+		}
+	}
+	report := formatSummary(counts, since, until)
+
+	owner, name, _ := strings.Cut(*summaryRepo, "/")
+	if *shadowMode {
+		log.Printf("shadow: would post summary to %s:\n%s", *summaryRepo, report)
+		return nil
+	}
+	if *summaryIssue > 0 {
+		_, _, err = createCall(ctx, "CreateComment", func(ctx context.Context) (*github.IssueComment, *github.Response, error) {
+			return cli.Issues.CreateComment(ctx, owner, name, *summaryIssue, &github.IssueComment{Body: github.Ptr(report)})
+		}, findComment(cli, owner, name, *summaryIssue, report))
+	} else {
+		req := &github.IssueRequest{
+			Title: github.Ptr("issuebot summary for the week of " + since.UTC().Format(time.DateOnly)),
+			Body:  github.Ptr(report),
+		}
+		_, _, err = createCall(ctx, "CreateIssue", func(ctx context.Context) (*github.Issue, *github.Response, error) {
+			return cli.Issues.Create(ctx, owner, name, req)
+		}, findIssue(cli, owner, name, req))
+	}
+	if err != nil {
+		return fmt.Errorf("post summary: %w", err)
+	}
+	log.Printf("Summary: posted to %s", *summaryRepo)
+	return nil
+}
+
+// runSummary calls postSummary periodically at the given interval.
+func runSummary(interval time.Duration) {
+	for {
+		select {
+		case <-rootCtx.Done():
+			return
+		case <-time.After(interval):
+		}
+		if err := postSummary(rootCtx); err != nil {
+			log.Printf("Summary failed: %v", err)
+		}
+	}
+}
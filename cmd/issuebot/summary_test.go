@@ -0,0 +1,115 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestSummary(t *testing.T) {
+	repo := &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")}
+	pr := func(n int, author string) pullRequest {
+		return pullRequest{repo: repo, pr: &github.PullRequest{Number: github.Ptr(n), User: &github.User{Login: github.Ptr(author)}}}
+	}
+	takeOverrides() // start afresh
+	pr(1, "alice").recordOverride(prSkipped)
+	pr(1, "alice").recordOverride(prSkipped) // rechecks count once
+	pr(2, "alice").recordOverride(prCleanup)
+	pr(3, "bob").recordOverride(prSkipped)
+	pr(3, "bob").recordOverride(prLinked) // later linked after all
+	pr(4, "bob").recordOverride(prLinked)
+	counts, since := takeOverrides()
+
+	now := time.Now()
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/o/r/issues" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]*github.Issue{
+			{Number: github.Ptr(10), Assignee: &github.User{Login: github.Ptr("alice")}, CreatedAt: &github.Timestamp{Time: now}},
+			{Number: github.Ptr(11), Assignee: &github.User{Login: github.Ptr("carol")}, CreatedAt: &github.Timestamp{Time: now}},
+			{Number: github.Ptr(12), Assignee: &github.User{Login: github.Ptr("carol")}, CreatedAt: &github.Timestamp{Time: since.Add(-time.Hour)}},
+		})
+	}))
+	if err := countStubs(t.Context(), cli, repo, since, counts); err != nil {
+		t.Fatalf("countStubs: unexpected error: %v", err)
+	}
+
+	got := formatSummary(counts, since, now)
+	for _, want := range []string{
+		"| o/r | @alice | 1 | 1 | 1 |\n",
+		"| o/r | @carol | 0 | 0 | 1 |\n",
+		"| **Total** | | 1 | 1 | 2 |\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("formatSummary: missing %q in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "@bob") {
+		t.Errorf("formatSummary: unexpected @bob in:\n%s", got)
+	}
+
+	if got := formatSummary(nil, since, now); !strings.Contains(got, "None.") {
+		t.Errorf("formatSummary(nil): got %q, want None", got)
+	}
+}
+
+func TestCountDisposition(t *testing.T) {
+	p := func(repo string) pullRequest {
+		return pullRequest{repo: &github.Repository{FullName: github.Ptr(repo)}, pr: &github.PullRequest{Number: github.Ptr(1)}}
+	}
+	count := func(m *expvar.Map, key string) int64 {
+		if v, ok := m.Get(key).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	cleanupBefore := count(checksByDisposition, "cleanup")
+	for _, c := range []struct {
+		repo   string
+		status pullRequestStatus
+	}{
+		{"o/counted", prSkipped}, {"o/counted", prSkipped}, {"o/counted", prBot}, {"o/other", prCleanup},
+	} {
+		p(c.repo).countDisposition(c.status)
+	}
+
+	if got := count(checksByRepo, "o/counted"); got != 3 {
+		t.Errorf("checks by repo for o/counted: got %d, want 3", got)
+	}
+	if got := count(checksByDisposition, "cleanup") - cleanupBefore; got != 1 {
+		t.Errorf("checks by disposition for cleanup: got %d more, want 1", got)
+	}
+
+	rec := httptest.NewRecorder()
+	serveDispositions(rec, httptest.NewRequest("GET", "/debug/dispositions", nil))
+	var header, row []string
+	for line := range strings.Lines(rec.Body.String()) {
+		switch f := strings.Fields(line); f[0] {
+		case "repository":
+			header = f
+		case "o/counted":
+			row = f
+		}
+	}
+	want := map[string]string{"skipped": "2", "bot": "1", "cleanup": "0", "linked": "0"}
+	for i, name := range header {
+		if w, ok := want[name]; ok && (i >= len(row) || row[i] != w) {
+			t.Errorf("dispositions for o/counted: got %q under %q, want %q\n%s", row, name, w, rec.Body)
+		}
+	}
+	if len(header) != len(statusNames)+1 {
+		t.Errorf("dispositions header: got %q, want a column for each disposition", header)
+	}
+}
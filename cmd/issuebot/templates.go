@@ -0,0 +1,221 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// messageData holds the fields available to message templates.
+type messageData struct {
+	Repo   string // full name of the repository, owner/repo
+	Number int    // pull request number
+	Title  string // pull request title
+	Author string // login of the pull request author
+	URL    string // pull request URL
+	Merged bool   // whether the pull request has been merged
+	Issue  int    // stub issue number in GitHub, if any
+	Ref    string // stub issue reference, e.g., "#123" or "PROJ-123", if any
+	Link   string // issue the pull request links to, if known, e.g., "#123"
+	Draft  string // drafted stub issue body, if any (see --draft-url)
+	Commit string // SHA of the commit that merged the pull request, if any
+
+	// For too-large-status messages:
+	Commits    int // number of commits on the pull request
+	MaxCommits int // the most commits that are scanned (see maxCommits)
+
+	// For override-denied messages:
+	User       string   // login of the user whose override was ignored
+	Override   string   // the override keyword or command
+	Permission string   // the permission needed to override, e.g., "write"
+	Teams      []string // teams whose members may override, as "org/team"
+}
+
+// data returns the template fields describing p.
+func (p pullRequest) data() messageData {
+	return messageData{
+		Repo:   p.repo.GetFullName(),
+		Number: p.pr.GetNumber(),
+		Title:  p.pr.GetTitle(),
+		Author: p.pr.GetUser().GetLogin(),
+		URL:    p.pr.GetHTMLURL(),
+		Merged: p.pr.GetMerged(),
+
+		Commits:    p.pr.GetCommits(),
+		MaxCommits: p.cfg.maxCommits(),
+	}
+}
+
+// Names of the message templates. An operator may replace any of them by
+// putting a file with the same name in the --template-dir directory.
+const (
+	stubTitleTemplate       = "stub-title.tmpl"
+	stubBodyTemplate        = "stub-body.tmpl"
+	stubDraftTemplate       = "stub-draft.tmpl"
+	stubCommentTemplate     = "stub-comment.tmpl"
+	advisoryCommentTemplate = "advisory-comment.tmpl"
+	stubClosedTemplate      = "stub-closed.tmpl"
+	stubAbandonedTemplate   = "stub-abandoned.tmpl"
+	stubReminderTemplate    = "stub-reminder.tmpl"
+	stubEscalationTemplate  = "stub-escalation.tmpl"
+	prEscalationTemplate    = "pr-escalation.tmpl"
+	overrideDeniedTemplate  = "override-denied.tmpl"
+	stubRequestedTemplate   = "stub-requested.tmpl"
+	squashAuditTemplate     = "squash-audit.tmpl"
+
+	// Status descriptions are limited to 140 characters, so be brief.
+	failureStatusTemplate  = "failure-status.tmpl"
+	advisoryStatusTemplate = "advisory-status.tmpl"
+	draftStatusTemplate    = "draft-status.tmpl"
+	tooLargeStatusTemplate = "too-large-status.tmpl"
+)
+
+// defaultTemplates holds the text of the default message templates.
+var defaultTemplates = map[string]string{
+	stubTitleTemplate:       `Placeholder issue for PR #{{.Number}}`,
+	stubBodyTemplate:        `TODO(@{{.Author}}): Add details about PR #{{.Number}}`,
+	stubDraftTemplate:       "{{.Draft}}\n\n_Drafted by IssueBot from PR #{{.Number}}. @{{.Author}}, please check this description and edit it as needed._",
+	stubCommentTemplate:     `:robot: IssueBot here. I noticed none of the commits on this PR has an issue attached. I have filed issue {{.Ref}} for you. Please update it at your convenience.`,
+	stubClosedTemplate:      `:robot: IssueBot here. PR #{{.Number}} now links to {{with .Link}}{{.}}{{else}}an issue{{end}}, so this placeholder is no longer needed.`,
+	stubAbandonedTemplate:   `:robot: IssueBot here. PR #{{.Number}} was closed{{if .Merged}} without this placeholder being filled in{{else}} without being merged{{end}}, so it is no longer needed.`,
+	stubReminderTemplate:    `:robot: IssueBot here. @{{.Author}}, this placeholder issue for PR #{{.Number}} still needs details. Please describe the work it tracks, or close it if it is no longer needed.`,
+	stubEscalationTemplate:  `:robot: IssueBot here. This placeholder issue for PR #{{.Number}} by @{{.Author}} has still not been filled in.`,
+	prEscalationTemplate:    `:robot: IssueBot here. The stub issue {{.Ref}} filed for this PR has still not been filled in. Please describe the work it tracks, or close it if it is no longer needed.`,
+	overrideDeniedTemplate:  `:robot: IssueBot here. @{{.User}}, I ignored {{.Override}}, because it can only be used by people with {{.Permission}} access to this repository{{with .Teams}}, or members of {{range $i, $t := .}}{{if $i}} or {{end}}@{{$t}}{{end}}{{end}}. Please ask one of them to use it for you, or link the PR to an issue.`,
+	stubRequestedTemplate:   `:robot: IssueBot here. @{{.User}} asked me to file a stub issue for this PR.`,
+	squashAuditTemplate:     ":robot: IssueBot here. The commit that merged this PR, {{.Commit}}, does not link to an issue, although commits on the PR linked to {{.Link}}. Please keep the link when squashing PRs, so that the history of the default branch records what each change was for.",
+	advisoryCommentTemplate: `:robot: IssueBot here. I noticed none of the commits on this PR has an issue attached. This repository does not require one, but it helps reviewers and future readers to know what the change is for. Consider adding a line like "Updates #nn" to each commit message.`,
+	failureStatusTemplate:   `Any non-trivial git commit must link to a GitHub issue tracking the work. Edit each commit with a tag like "Updates #nn", and update the PR.`,
+	advisoryStatusTemplate:  `Advisory: no commit links to a GitHub issue. This is not required here, but please consider adding "Updates #nn".`,
+	draftStatusTemplate:     `Draft PR: it will be checked for a linked issue once it is ready for review.`,
+	tooLargeStatusTemplate:  `This PR has {{.Commits}} commits, more than the {{.MaxCommits}} IssueBot scans, and none of those links to an issue. Link one early, or split the PR.`,
+}
+
+// A catalog holds a complete set of parsed message templates, by name.
+type catalog map[string]*template.Template
+
+// messageCatalogs holds the message catalogs, by locale. The default catalog
+// has the locale "".
+var messageCatalogs = map[string]catalog{"": mustParseCatalog(defaultTemplates)}
+
+func mustParseCatalog(texts map[string]string) catalog {
+	c, err := parseCatalog(texts)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func parseCatalog(texts map[string]string) (catalog, error) {
+	c := make(catalog)
+	for name, text := range texts {
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		c[name] = t
+	}
+	return c, nil
+}
+
+// loadMessageTemplates replaces the default message templates with those
+// found in dir. Templates that are not present in dir keep their defaults.
+//
+// Each subdirectory of dir holds the message catalog for the locale it is
+// named after (e.g., "de" or "pt-BR"), which repositories can select with
+// the locale setting. Templates missing from a locale directory are taken
+// from dir.
+//
+// Each template is executed once with sample data, so that mistakes are
+// reported at startup rather than when a message is first needed.
+func loadMessageTemplates(dir string) error {
+	base, err := readTemplates(dir, defaultTemplates)
+	if err != nil {
+		return err
+	}
+	texts := map[string]map[string]string{"": base}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		texts[e.Name()], err = readTemplates(filepath.Join(dir, e.Name()), base)
+		if err != nil {
+			return err
+		}
+	}
+
+	catalogs := make(map[string]catalog)
+	sample := messageData{Repo: "owner/repo", Number: 1, Title: "title", Author: "author", Issue: 2, Ref: "#2", Link: "#3", Draft: "draft", Commit: "0123abc", User: "user", Override: "skip-issuebot", Permission: "write", Teams: []string{"org/team"}, Commits: 300, MaxCommits: 250}
+	for locale, t := range texts {
+		c, err := parseCatalog(t)
+		if err != nil {
+			return err
+		}
+		for _, tmpl := range c {
+			if err := tmpl.Execute(new(strings.Builder), sample); err != nil {
+				return err
+			}
+		}
+		catalogs[locale] = c
+	}
+	messageCatalogs = catalogs
+	return nil
+}
+
+// readTemplates reads the message templates in dir. Templates missing from
+// dir are taken from fallback.
+func readTemplates(dir string, fallback map[string]string) (map[string]string, error) {
+	texts := make(map[string]string)
+	for name, text := range fallback {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			texts[name] = text
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		texts[name] = strings.TrimSpace(string(data))
+	}
+	return texts, nil
+}
+
+// findCatalog returns the message catalog for locale. If there is no catalog
+// for a regional locale like "pt-BR", the catalog for its language ("pt") is
+// used. If neither exists, the default catalog is used.
+func findCatalog(locale string) catalog {
+	if c, ok := messageCatalogs[locale]; ok {
+		return c
+	}
+	lang, _, _ := strings.Cut(locale, "-")
+	if c, ok := messageCatalogs[lang]; ok {
+		return c
+	}
+	return messageCatalogs[""]
+}
+
+// renderMessage executes the named message template for locale with data.
+func renderMessage(locale, name string, data messageData) (string, error) {
+	var sb strings.Builder
+	if err := findCatalog(locale)[name].Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("template %s: %w", name, err)
+	}
+	return sb.String(), nil
+}
+
+// render executes the named message template for p, in the locale of its
+// repository.
+func (p pullRequest) render(name string, data messageData) (string, error) {
+	return renderMessage(p.cfg.locale(), name, data)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMessageTemplates(t *testing.T) {
+	old := messageCatalogs
+	t.Cleanup(func() { messageCatalogs = old })
+
+	data := messageData{Repo: "example/repo", Number: 5, Author: "alice", Issue: 7, Ref: "#7"}
+	if got, want := mustRender(t, stubTitleTemplate, data), "Placeholder issue for PR #5"; got != want {
+		t.Errorf("default title: got %q, want %q", got, want)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, stubTitleTemplate), []byte("[{{.Repo}}] Follow up on #{{.Number}}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, stubCommentTemplate), []byte("See #{{.Issue}}, @{{.Author}}."), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadMessageTemplates(dir); err != nil {
+		t.Fatalf("loadMessageTemplates: unexpected error: %v", err)
+	}
+	if got, want := mustRender(t, stubTitleTemplate, data), "[example/repo] Follow up on #5"; got != want {
+		t.Errorf("custom title: got %q, want %q", got, want)
+	}
+	if got, want := mustRender(t, stubBodyTemplate, data), "TODO(@alice): Add details about PR #5"; got != want {
+		t.Errorf("default body: got %q, want %q", got, want)
+	}
+
+	// Customized comments are still recognized, by their marker.
+	comment := mustRender(t, stubCommentTemplate, data) + fmt.Sprintf(stubCommentMarker, issueRef{Number: 7})
+	if m := stubMarkerRE.FindStringSubmatch(comment); m == nil || m[1] != "#7" {
+		t.Errorf("stubMarkerRE(%q): got %q, want #7", comment, m)
+	}
+
+	// Locale directories override the base templates, and fall back to them.
+	if err := os.Mkdir(filepath.Join(dir, "de"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "de", stubBodyTemplate), []byte("TODO(@{{.Author}}): Details zu PR #{{.Number}} ergänzen"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadMessageTemplates(dir); err != nil {
+		t.Fatalf("loadMessageTemplates: unexpected error: %v", err)
+	}
+	localeTests := []struct {
+		locale, name, want string
+	}{
+		{"de", stubBodyTemplate, "TODO(@alice): Details zu PR #5 ergänzen"},
+		{"de-AT", stubBodyTemplate, "TODO(@alice): Details zu PR #5 ergänzen"},
+		{"de", stubTitleTemplate, "[example/repo] Follow up on #5"},
+		{"fr", stubBodyTemplate, "TODO(@alice): Add details about PR #5"},
+	}
+	for _, tc := range localeTests {
+		got, err := renderMessage(tc.locale, tc.name, data)
+		if err != nil {
+			t.Errorf("renderMessage(%q, %q): unexpected error: %v", tc.locale, tc.name, err)
+		} else if got != tc.want {
+			t.Errorf("renderMessage(%q, %q): got %q, want %q", tc.locale, tc.name, got, tc.want)
+		}
+	}
+
+	// Templates referring to unknown fields are rejected at load time.
+	if err := os.WriteFile(filepath.Join(dir, stubBodyTemplate), []byte("{{.Nope}}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadMessageTemplates(dir); err == nil {
+		t.Error("loadMessageTemplates: got nil error for unknown field")
+	}
+}
+
+func mustRender(t *testing.T, name string, data messageData) string {
+	t.Helper()
+	s, err := renderMessage("", name, data)
+	if err != nil {
+		t.Fatalf("renderMessage(%q): unexpected error: %v", name, err)
+	}
+	return s
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// timelineLinks summarizes the links to issues recorded in the timeline of a
+// pull request.
+type timelineLinks struct {
+	// refs are the issues that mention the pull request, other than stub
+	// issues.
+	refs []issueRef
+
+	// manual is the number of issues linked to the pull request by hand in
+	// its Development sidebar, and not since unlinked. The timeline does not
+	// say which issues they are.
+	manual int
+}
+
+// timelineLinks returns the links to issues recorded in the timeline of the
+// pull request.
+func (p pullRequest) timelineLinks(ctx context.Context, cli *github.Client) (timelineLinks, error) {
+	var links timelineLinks
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		events, resp, err := retryCall(ctx, "ListIssueTimeline", func(ctx context.Context) ([]*github.Timeline, *github.Response, error) {
+			return cli.Issues.ListIssueTimeline(ctx, p.repo.GetOwner().GetLogin(), p.repo.GetName(), p.pr.GetNumber(), opts)
+		})
+		if err != nil {
+			return links, err
+		}
+		for _, e := range events {
+			switch e.GetEvent() {
+			case "connected":
+				links.manual++
+			case "disconnected":
+				links.manual--
+			case "cross-referenced":
+				issue := e.GetSource().GetIssue()
+				if issue == nil || issue.IsPullRequest() || slices.ContainsFunc(issue.Labels, func(l *github.Label) bool {
+					return l.GetName() == issuebotStubLabel
+				}) {
+					// Stub issues filed by issuebot mention the PR too.
+					continue
+				}
+				repo := issue.GetRepository().GetFullName()
+				if repo == "" {
+					// The repository is not always included, but its URL is.
+					repo, _ = strings.CutPrefix(issue.GetRepositoryURL(), "https://api.github.com/repos/")
+				}
+				links.refs = append(links.refs, issueRef{Repo: repo, Number: issue.GetNumber()})
+			}
+		}
+		if resp.NextPage == 0 {
+			return links, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// checkTimelineLinks reports whether the timeline of the pull request shows
+// it linked to an issue that meets the repository's requirements, either by
+// hand or because the issue mentions the pull request.
+//
+// Issues linked by hand cannot be identified from the timeline, so they are
+// not counted if the repository verifies links. The closingIssues setting
+// covers them in that case.
+func (p pullRequest) checkTimelineLinks(ctx context.Context, cli *github.Client) (bool, error) {
+	links, err := p.timelineLinks(ctx, cli)
+	if err != nil {
+		return false, fmt.Errorf("list timeline: %w", err)
+	}
+	if links.manual > 0 {
+		if !p.cfg.verifiesLinks() {
+			p.logf("accept: issue linked manually")
+			return true, nil
+		}
+		p.logf("issue linked manually: cannot verify (ignored)")
+	}
+	return p.checkLinkedIssues(ctx, cli, "mentioned by issue", links.refs)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v72/github"
+)
+
+func TestTimelineLinks(t *testing.T) {
+	var events string
+	cli := newFakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/o/r/issues/10/timeline":
+			w.Write([]byte("[" + events + "]"))
+		case "/repos/o/r/issues/1":
+			w.Write([]byte(`{"number": 1, "state": "closed"}`))
+		case "/repos/o/r/issues/2":
+			w.Write([]byte(`{"number": 2, "state": "open"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	const (
+		connected        = `{"event": "connected"}`
+		disconnected     = `{"event": "disconnected"}`
+		mentionedBy1     = `{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 1, "repository_url": "https://api.github.com/repos/o/r"}}}`
+		mentionedBy2     = `{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 2, "repository": {"full_name": "o/r"}}}}`
+		mentionedByStub  = `{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 5, "repository_url": "https://api.github.com/repos/o/r", "labels": [{"name": "issuebot-stub"}]}}}`
+		mentionedByPR    = `{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 3, "repository_url": "https://api.github.com/repos/o/r", "pull_request": {}}}}`
+		mentionedByOther = `{"event": "cross-referenced", "source": {"type": "issue", "issue": {"number": 4, "repository_url": "https://api.github.com/repos/other/r"}}}`
+	)
+	tests := []struct {
+		config string
+		events string
+		want   bool
+	}{
+		{`{}`, ``, false},
+		{`{}`, connected, true},
+		{`{}`, connected + "," + disconnected, false},
+		{`{}`, mentionedBy1, true},
+		{`{}`, mentionedByPR, false},
+		{`{}`, mentionedByStub, false},
+		{`{}`, mentionedByOther, true},
+		{`linkURLs: ["github.com/o/*"]`, mentionedByOther, false},
+		{`linkChecks: {requireOpen: true}`, connected, false},
+		{`linkChecks: {requireOpen: true}`, mentionedBy1, false},
+		{`linkChecks: {requireOpen: true}`, mentionedBy1 + "," + mentionedBy2, true},
+	}
+	for _, tc := range tests {
+		cfg, err := parseRepoConfig([]byte(tc.config))
+		if err != nil {
+			t.Fatalf("parseRepoConfig(%q): unexpected error: %v", tc.config, err)
+		}
+		p := pullRequest{
+			repo: &github.Repository{Name: github.Ptr("r"), Owner: &github.User{Login: github.Ptr("o")}, FullName: github.Ptr("o/r")},
+			pr:   &github.PullRequest{Number: github.Ptr(10)},
+			cfg:  cfg,
+		}
+		events = tc.events
+		got, err := p.checkTimelineLinks(t.Context(), cli)
+		if err != nil {
+			t.Errorf("checkTimelineLinks(%s, %s): unexpected error: %v", tc.config, tc.events, err)
+		} else if got != tc.want {
+			t.Errorf("checkTimelineLinks(%s, %s): got %v, want %v", tc.config, tc.events, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checks are traced with OpenTelemetry spans, exported to the collector at
+// --otlp-endpoint with OTLP over HTTP, in its JSON encoding. That is simple
+// enough that issuebot does it itself, rather than depend on the SDK.
+
+const (
+	// spanExportInterval is how often buffered spans are exported.
+	spanExportInterval = 5 * time.Second
+
+	// maxBufferedSpans bounds the spans waiting to be exported. Spans ended
+	// while the buffer is full are dropped.
+	maxBufferedSpans = 4096
+
+	// spanExportTimeout bounds each request to the collector.
+	spanExportTimeout = 10 * time.Second
+)
+
+// OpenTelemetry span kinds.
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+)
+
+// A span is a timed operation of a trace, such as a check or one GitHub API
+// call. The methods of a nil *span do nothing, so that code need not check
+// whether tracing is enabled.
+type span struct {
+	traceID [16]byte
+	id      [8]byte
+	parent  [8]byte // zero for the root of a trace
+	name    string
+	kind    int
+	start   time.Time
+	end     time.Time
+	err     error
+
+	mu    sync.Mutex
+	attrs map[string]any // :: key → string, int, or bool
+}
+
+type spanContextKey struct{}
+
+// startSpan starts a span with the given name and kind, as a child of the
+// span of ctx, if any, and returns a context carrying it. The attrs are pairs
+// of keys and values, as given to set. If tracing is disabled, startSpan
+// returns ctx and a nil span.
+func startSpan(ctx context.Context, name string, kind int, attrs ...any) (context.Context, *span) {
+	if *otlpEndpoint == "" {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now(), attrs: make(map[string]any)}
+	if parent, ok := ctx.Value(spanContextKey{}).(*span); ok {
+		s.traceID, s.parent = parent.traceID, parent.id
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.id[:])
+	s.set(attrs...)
+	return context.WithValue(ctx, spanContextKey{}, s), s
+}
+
+// set sets attributes of s, given as pairs of keys and values.
+func (s *span) set(kv ...any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		s.attrs[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+}
+
+// finish ends s, as failed if err is not nil, and queues it for export.
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	spanBuffer.Lock()
+	defer spanBuffer.Unlock()
+	if len(spanBuffer.spans) >= maxBufferedSpans {
+		spansDropped.Add(1)
+		return
+	}
+	spanBuffer.spans = append(spanBuffer.spans, s)
+}
+
+// spanBuffer holds the spans ended since the last export.
+var spanBuffer struct {
+	sync.Mutex
+	spans []*span
+}
+
+// runSpanExport exports the spans ended every interval, until ctx ends.
+func runSpanExport(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if err := flushSpans(ctx); err != nil {
+			log.Printf("error exporting spans (continuing): %v", err)
+		}
+	}
+}
+
+// flushSpans exports the spans ended since the last export, if any. Spans
+// that the collector did not accept are dropped.
+func flushSpans(ctx context.Context) error {
+	spanBuffer.Lock()
+	spans := spanBuffer.spans
+	spanBuffer.spans = nil
+	spanBuffer.Unlock()
+	if len(spans) == 0 || *otlpEndpoint == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, spanExportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(*otlpEndpoint, "/")+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// As the OpenTelemetry SDKs do, take extra headers (e.g., for
+	// authentication) from the environment, as k1=v1,k2=v2.
+	for _, h := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if k, v, ok := strings.Cut(h, "="); ok {
+			req.Header.Set(strings.TrimSpace(k), strings.TrimSpace(v))
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		spansDropped.Add(int64(len(spans)))
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		spansDropped.Add(int64(len(spans)))
+		return fmt.Errorf("collector: %s", resp.Status)
+	}
+	spansExported.Add(int64(len(spans)))
+	return nil
+}
+
+// otlpRequest returns the OTLP/JSON export request for spans.
+func otlpRequest(spans []*span) map[string]any {
+	var out []map[string]any
+	for _, s := range spans {
+		o := map[string]any{
+			"traceId":           hex.EncodeToString(s.traceID[:]),
+			"spanId":            hex.EncodeToString(s.id[:]),
+			"name":              s.name,
+			"kind":              s.kind,
+			"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			o["parentSpanId"] = hex.EncodeToString(s.parent[:])
+		}
+		s.mu.Lock()
+		o["attributes"] = otlpAttributes(s.attrs)
+		s.mu.Unlock()
+		if s.err != nil {
+			o["status"] = map[string]any{"code": 2, "message": s.err.Error()} // STATUS_CODE_ERROR
+		}
+		out = append(out, o)
+	}
+	resource := map[string]any{
+		"service.name":    "issuebot",
+		"service.version": botVersion(),
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": otlpAttributes(resource)},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github.com/tailscale/issuebot"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+// otlpAttributes returns attrs as OTLP/JSON key-value pairs.
+func otlpAttributes(attrs map[string]any) []any {
+	var out []any
+	for k, v := range attrs {
+		var val map[string]any
+		switch v := v.(type) {
+		case bool:
+			val = map[string]any{"boolValue": v}
+		case int:
+			val = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			val = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		default:
+			val = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, map[string]any{"key": k, "value": val})
+	}
+	return out
+}
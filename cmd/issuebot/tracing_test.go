@@ -0,0 +1,95 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpans(t *testing.T) {
+	defer func(s string) { *otlpEndpoint = s }(*otlpEndpoint)
+
+	// Disabled, spans are nil and do nothing.
+	*otlpEndpoint = ""
+	ctx, sp := startSpan(t.Context(), "disabled", spanInternal)
+	if sp != nil || ctx != t.Context() {
+		t.Errorf("startSpan when disabled: got %v, want nil span and the same context", sp)
+	}
+	sp.set("k", "v")
+	sp.finish(nil)
+
+	type otlpSpan struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+		Attributes   []struct {
+			Key   string            `json:"key"`
+			Value map[string]string `json:"value"`
+		} `json:"attributes"`
+		Status *struct {
+			Code int `json:"code"`
+		} `json:"status"`
+	}
+	var got []otlpSpan
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []otlpSpan `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decoding export: %v", err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				got = append(got, ss.Spans...)
+			}
+		}
+	}))
+	defer srv.Close()
+	*otlpEndpoint = srv.URL + "/"
+
+	ctx, parent := startSpan(t.Context(), "check", spanInternal, "github.pull_request", 7)
+	_, child := startSpan(ctx, "GitHub GetCommit", spanClient)
+	child.finish(errors.New("boom"))
+	parent.finish(nil)
+	if err := flushSpans(t.Context()); err != nil {
+		t.Fatalf("flushSpans: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("exported %d spans, want 2: %+v", len(got), got)
+	}
+	c, p := got[0], got[1]
+	if c.Name != "GitHub GetCommit" || p.Name != "check" {
+		t.Errorf("exported spans %q, %q; want child then parent", c.Name, p.Name)
+	}
+	if c.TraceID != p.TraceID || c.ParentSpanID != p.SpanID || p.ParentSpanID != "" {
+		t.Errorf("child %+v is not a child of %+v", c, p)
+	}
+	if c.Status == nil || c.Status.Code != 2 {
+		t.Errorf("failed span status: got %+v, want error", c.Status)
+	}
+	if len(p.Attributes) != 1 || p.Attributes[0].Key != "github.pull_request" || p.Attributes[0].Value["intValue"] != "7" {
+		t.Errorf("parent attributes: got %+v, want github.pull_request=7", p.Attributes)
+	}
+
+	// With nothing to export, nothing is sent.
+	got = nil
+	if err := flushSpans(t.Context()); err != nil || got != nil {
+		t.Errorf("flushSpans with no spans: got %v, %v; want nothing sent", got, err)
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/go-github/v72/github"
+)
+
+// A tracker is an issue tracker that commits can link to, and in which stub
+// issues can be filed. GitHub is always available; other trackers are
+// enabled by the repository configuration.
+type tracker interface {
+	// match returns the references to issues in this tracker that are
+	// linked by a commit message in p.
+	match(p pullRequest, message string) []trackerRef
+
+	// validate reports whether ref, returned by match, refers to an issue
+	// that meets the requirements of p's repository. If not, the error wraps
+	// errInvalidRef and gives the reason.
+	validate(ctx context.Context, p pullRequest, ref trackerRef) error
+
+	// findStub returns the stub issue previously filed for p, or nil.
+	findStub(ctx context.Context, p pullRequest) (trackerRef, error)
+
+	// createStub files a new stub issue for p, and mentions it in a comment
+	// on p. If the issue is filed, it is returned whether or not commenting
+	// fails.
+	createStub(ctx context.Context, p pullRequest) (trackerRef, error)
+}
+
+// A trackerRef is a reference to an issue in a tracker, such as an issueRef.
+type trackerRef interface {
+	String() string
+}
+
+// errInvalidRef is reported by tracker.validate for references to issues
+// that do not meet the repository's requirements.
+var errInvalidRef = errors.New("invalid issue reference")
+
+// trackers returns the issue trackers enabled for p, using cli for GitHub.
+func (p pullRequest) trackers(cli *github.Client) []tracker {
+	ts := []tracker{githubTracker{cli}}
+	if p.cfg != nil && p.cfg.jiraRE != nil {
+		ts = append(ts, jiraTracker{cli})
+	}
+	if p.cfg != nil && (len(p.cfg.linkREs) != 0 || len(p.cfg.LinkURLs) != 0) {
+		ts = append(ts, patternTracker{})
+	}
+	return ts
+}
+
+// stubTracker returns the tracker in which stub issues are filed for p.
+func (p pullRequest) stubTracker(cli *github.Client) tracker {
+	if p.cfg.stubTracker() == trackerJira {
+		return jiraTracker{cli}
+	}
+	return githubTracker{cli}
+}
+
+// matchRefs returns the issue references linked by message, in all the
+// trackers enabled for p. Matching does not use the GitHub client, so cli may
+// be nil.
+func (p pullRequest) matchRefs(cli *github.Client, message string) []trackerRef {
+	var refs []trackerRef
+	for _, t := range p.trackers(cli) {
+		refs = append(refs, t.match(p, message)...)
+	}
+	return refs
+}
+
+// verifyLinks reports whether message links to at least one issue that its
+// tracker considers valid.
+func (p pullRequest) verifyLinks(ctx context.Context, cli *github.Client, message string) (bool, error) {
+	for _, t := range p.trackers(cli) {
+		for _, ref := range t.match(p, message) {
+			err := t.validate(ctx, p, ref)
+			if err == nil {
+				return true, nil
+			} else if !errors.Is(err, errInvalidRef) {
+				return false, err
+			}
+			p.logf("link %v: %v", ref, err)
+		}
+	}
+	return false, nil
+}
+
+// githubTracker is the tracker for GitHub issues.
+type githubTracker struct {
+	cli *github.Client
+}
+
+func (t githubTracker) match(p pullRequest, message string) []trackerRef {
+	var refs []trackerRef
+	for _, r := range p.linkedRefs(message) {
+		refs = append(refs, r)
+	}
+	return refs
+}
+
+func (t githubTracker) validate(ctx context.Context, p pullRequest, ref trackerRef) error {
+	return p.checkLink(ctx, t.cli, ref.(issueRef))
+}
+
+func (t githubTracker) findStub(ctx context.Context, p pullRequest) (trackerRef, error) {
+	n, err := p.checkStubIssue(ctx, t.cli)
+	if n == 0 {
+		return nil, err
+	}
+	return issueRef{Number: n}, err
+}
+
+func (t githubTracker) createStub(ctx context.Context, p pullRequest) (trackerRef, error) {
+	n, err := p.createStubIssue(ctx, t.cli)
+	if n == 0 {
+		return nil, err
+	}
+	return issueRef{Number: n}, err
+}
+
+// jiraTracker is the tracker for Jira issues, in the projects listed in the
+// repository's jiraProjects setting. The GitHub client is used to comment on
+// pull requests.
+type jiraTracker struct {
+	cli *github.Client
+}
+
+func (t jiraTracker) match(p pullRequest, message string) []trackerRef {
+	var refs []trackerRef
+	for _, key := range p.jiraKeys(message) {
+		refs = append(refs, jiraKey(key))
+	}
+	return refs
+}
+
+func (t jiraTracker) validate(ctx context.Context, p pullRequest, ref trackerRef) error {
+	return checkJiraKey(ctx, string(ref.(jiraKey)))
+}
+
+func (t jiraTracker) findStub(ctx context.Context, p pullRequest) (trackerRef, error) {
+	s, err := p.findStubComment(ctx, t.cli)
+	if err != nil || s == "" || !p.cfg.jiraRE.MatchString(s) {
+		return nil, err
+	}
+	return jiraKey(s), nil
+}
+
+func (t jiraTracker) createStub(ctx context.Context, p pullRequest) (trackerRef, error) {
+	return p.createJiraStub(ctx, t.cli)
+}
+
+// patternTracker stands for the trackers referred to by the repository's
+// linkPatterns and (other than GitHub) linkURLs. Their references are
+// accepted as written, and stub issues cannot be filed in them.
+type patternTracker struct{}
+
+// A patternRef is the text of a match for one of the linkPatterns, or a URL
+// permitted by linkURLs.
+type patternRef string
+
+func (r patternRef) String() string { return string(r) }
+
+func (patternTracker) match(p pullRequest, message string) []trackerRef {
+	var refs []trackerRef
+	for _, re := range p.cfg.linkREs {
+		for _, m := range re.FindAllString(message, -1) {
+			refs = append(refs, patternRef(m))
+		}
+	}
+	for _, u := range p.linkedURLs(message) {
+		refs = append(refs, patternRef(u))
+	}
+	return refs
+}
+
+func (patternTracker) validate(ctx context.Context, p pullRequest, ref trackerRef) error {
+	return nil
+}
+
+func (patternTracker) findStub(ctx context.Context, p pullRequest) (trackerRef, error) {
+	return nil, nil
+}
+
+func (patternTracker) createStub(ctx context.Context, p pullRequest) (trackerRef, error) {
+	return nil, errors.New("cannot file stub issues in a tracker given by linkPatterns or linkURLs")
+}
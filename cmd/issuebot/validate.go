@@ -0,0 +1,225 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v72/github"
+	"sigs.k8s.io/yaml"
+	yamlv3 "sigs.k8s.io/yaml/goyaml.v3"
+)
+
+const validateConfigUsage = `Usage: issuebot validate-config [-repo owner/repo] [file ...]
+
+Check repository configuration files, and print the effective configuration
+that results from layering them in order, with defaults filled in.
+
+With -repo, the organization and repository configuration are fetched from
+GitHub (see "issuebot help"), and any files given replace the
+repository's own configuration file, so that a proposed change can be checked
+before it is committed.
+`
+
+// runValidateConfig implements the validate-config subcommand, and returns
+// the process exit code.
+func runValidateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	repoName := fs.String("repo", "", "fetch configuration for this repository (owner/repo) from GitHub")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), validateConfigUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *repoName == "" && fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	type layer struct {
+		name string
+		data []byte
+	}
+	var layers []layer
+	if *repoName != "" {
+		owner, name, ok := strings.Cut(*repoName, "/")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "invalid -repo %q, want owner/repo\n", *repoName)
+			return 2
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		cli, err := toolClient()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "issuebot: %v\n", err)
+			return 2
+		}
+		names := []string{orgConfigRepo}
+		if fs.NArg() == 0 {
+			names = append(names, name)
+		}
+		for _, n := range names {
+			text, err := fetchConfigText(ctx, cli, owner, n)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "fetching %s/%s: %v\n", owner, n, err)
+				return 1
+			}
+			layers = append(layers, layer{fmt.Sprintf("%s/%s:%s", owner, n, repoConfigPath), []byte(text)})
+		}
+	}
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		layers = append(layers, layer{path, data})
+	}
+
+	// Check each layer separately, so errors can be attributed to a file.
+	failed := false
+	for _, l := range layers {
+		if _, err := parseRepoConfig(l.data); err != nil {
+			if line := configErrorLine(l.data, err); line > 0 {
+				fmt.Fprintf(os.Stderr, "%s:%d: %v\n", l.name, line, err)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", l.name, err)
+			}
+			failed = true
+		}
+	}
+	if failed {
+		return 1
+	}
+
+	var data [][]byte
+	for _, l := range layers {
+		data = append(data, l.data)
+	}
+	cfg, err := parseRepoConfig(data...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "merged configuration: %v\n", err)
+		return 1
+	}
+	out, err := yaml.Marshal(cfg.effective())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "printing configuration: %v\n", err)
+		return 1
+	}
+	os.Stdout.Write(out)
+	return 0
+}
+
+// effective returns a copy of c with the default settings filled in.
+func (c *repoConfig) effective() *repoConfig {
+	var e repoConfig
+	if c != nil {
+		e = *c
+	}
+	e.MinDiff = github.Ptr(c.minDiff())
+	e.Overrides = maps.Clone(defaultOverrides)
+	if c != nil {
+		maps.Copy(e.Overrides, c.Overrides)
+	}
+	e.LinkVerbs = c.linkVerbs()
+	e.DebounceInterval = github.Ptr(duration(c.debounceInterval()))
+	e.StubIssues = github.Ptr(c.stubsMode())
+	e.AbandonedStubs = c.abandonedStubs()
+	e.StubReminderAfter = github.Ptr(duration(c.stubReminderAfter()))
+	if e.Mode == "" {
+		e.Mode = modeEnforce
+	}
+	return &e
+}
+
+var (
+	// yamlLineRE matches the line number in YAML syntax errors.
+	yamlLineRE = regexp.MustCompile(`\bline (\d+)\b`)
+
+	// fieldNameRE matches the setting named in errors from encoding/json.
+	fieldNameRE = regexp.MustCompile(`unknown field "([^"]+)"|Go struct field [\w.]*?(\w+) of type`)
+)
+
+// configErrorLine returns the line number in the configuration file data to
+// which err, as reported by parseRepoConfig, refers. It returns 0 if the line
+// cannot be determined.
+func configErrorLine(data []byte, err error) int {
+	if m := yamlLineRE.FindStringSubmatch(err.Error()); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+	var doc yamlv3.Node
+	if yamlv3.Unmarshal(data, &doc) != nil || len(doc.Content) == 0 {
+		return 0
+	}
+	root := doc.Content[0]
+
+	var fe *fieldError
+	if errors.As(err, &fe) {
+		n := root
+		for _, key := range fe.path {
+			if n = yamlChild(n, key); n == nil {
+				return 0
+			}
+		}
+		return n.Line
+	}
+	if m := fieldNameRE.FindStringSubmatch(err.Error()); m != nil {
+		if n := findYAMLKey(root, m[1]+m[2]); n != nil {
+			return n.Line
+		}
+	}
+	return 0
+}
+
+// yamlChild returns the node for the given key of a mapping node n, or the
+// element at the given index of a sequence node n. For a mapping, it returns
+// the key node, which is on the line where the setting begins.
+func yamlChild(n *yamlv3.Node, key string) *yamlv3.Node {
+	switch n.Kind {
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				if len(n.Content[i+1].Content) > 0 {
+					// Descend into the value, but remember where the key is.
+					v := *n.Content[i+1]
+					v.Line = n.Content[i].Line
+					return &v
+				}
+				return n.Content[i]
+			}
+		}
+	case yamlv3.SequenceNode:
+		if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(n.Content) {
+			return n.Content[i]
+		}
+	}
+	return nil
+}
+
+// findYAMLKey returns the first mapping key node named key in the tree rooted
+// at n, or nil if there is none.
+func findYAMLKey(n *yamlv3.Node, key string) *yamlv3.Node {
+	for i, c := range n.Content {
+		if n.Kind == yamlv3.MappingNode && i%2 == 0 && c.Value == key {
+			return c
+		}
+		if found := findYAMLKey(c, key); found != nil {
+			return found
+		}
+	}
+	return nil
+}
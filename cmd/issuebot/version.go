@@ -0,0 +1,78 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// versionInfo describes the build of issuebot that is running, so that we can
+// tell which one is deployed.
+type versionInfo struct {
+	Version   string `json:"version"` // as botVersion reports it
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"buildDate,omitempty"` // of the commit, as recorded by the Go toolchain
+	Modified  bool   `json:"modified,omitempty"`  // whether the tree had uncommitted changes
+	GoVersion string `json:"goVersion,omitempty"`
+	GoGitHub  string `json:"goGitHub,omitempty"` // the go-github module and version, e.g., github.com/google/go-github/v72@v72.0.0
+}
+
+// readVersionInfo returns the versionInfo recorded in the binary.
+func readVersionInfo() versionInfo {
+	v := versionInfo{Version: botVersion()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v.GoVersion = bi.GoVersion
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v.Commit = s.Value
+		case "vcs.time":
+			v.BuildDate = s.Value
+		case "vcs.modified":
+			v.Modified = s.Value == "true"
+		}
+	}
+	for _, dep := range bi.Deps {
+		if strings.HasPrefix(dep.Path, "github.com/google/go-github/") {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			v.GoGitHub = dep.Path + "@" + dep.Version
+		}
+	}
+	return v
+}
+
+// writeVersion writes v for people, as --version prints it.
+func (v versionInfo) writeVersion(w io.Writer) {
+	fmt.Fprintf(w, "issuebot %s\n", v.Version)
+	for _, f := range []struct{ name, value string }{
+		{"commit", v.Commit},
+		{"built", v.BuildDate},
+		{"go", v.GoVersion},
+		{"go-github", v.GoGitHub},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(w, "  %-10s %s\n", f.name+":", f.value)
+		}
+	}
+	if v.Modified {
+		fmt.Fprintf(w, "  %-10s %s\n", "modified:", "yes")
+	}
+}
+
+// serveVersion serves the versionInfo of the running build as JSON.
+func serveVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readVersionInfo())
+}
@@ -0,0 +1,39 @@
+// Copyright (c) 2022 Tailscale Inc & AUTHORS All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVersion(t *testing.T) {
+	rec := httptest.NewRecorder()
+	serveVersion(rec, httptest.NewRequest("GET", "/version", nil))
+	var got versionInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("/version: %v in %q", err, rec.Body)
+	}
+	if got.Version != botVersion() || got.GoVersion == "" {
+		t.Errorf("/version: got %+v, want version %q and the Go version", got, botVersion())
+	}
+	if !strings.HasPrefix(got.GoGitHub, "github.com/google/go-github/") {
+		t.Errorf("/version: got go-github %q, want its module and version", got.GoGitHub)
+	}
+
+	v := versionInfo{Version: "abc123", Commit: "abc123", BuildDate: "2024-01-02T03:04:05Z", GoVersion: "go1.24", Modified: true}
+	var sb strings.Builder
+	v.writeVersion(&sb)
+	want := "issuebot abc123\n" +
+		"  commit:    abc123\n" +
+		"  built:     2024-01-02T03:04:05Z\n" +
+		"  go:        go1.24\n" +
+		"  modified:  yes\n"
+	if sb.String() != want {
+		t.Errorf("writeVersion: got\n%s\nwant\n%s", sb.String(), want)
+	}
+}